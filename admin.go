@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+)
+
+// adminKeyFromRequest extracts the admin key from X-Admin-Key or a bearer token
+func adminKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-Admin-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// requireAdmin only lets requests through that present the admin key. When no
+// admin key is configured every request is rejected.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		expected := cfg.AdminAPIKey
+		provided := adminKeyFromRequest(r)
+		if expected == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+			writeError(w, newAPIError(http.StatusUnauthorized, "Unauthorized"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// registerDebugRoutes mounts pprof and runtime stats behind admin auth. The
+// routes are only registered when enable_debug_endpoints is set, so they 404
+// otherwise.
+func registerDebugRoutes(rt *router) {
+	if !cfg.EnableDebugEndpoints {
+		return
+	}
+	rt.handle(http.MethodGet, "/debug/pprof/", requireAdmin(pprof.Index))
+	rt.handle(http.MethodGet, "/debug/pprof/cmdline", requireAdmin(pprof.Cmdline))
+	rt.handle(http.MethodGet, "/debug/pprof/profile", requireAdmin(pprof.Profile))
+	rt.handle(http.MethodGet, "/debug/pprof/symbol", requireAdmin(pprof.Symbol))
+	rt.handle(http.MethodPost, "/debug/pprof/symbol", requireAdmin(pprof.Symbol))
+	rt.handle(http.MethodGet, "/debug/pprof/trace", requireAdmin(pprof.Trace))
+	rt.handle(http.MethodGet, "/debug/stats", requireAdmin(debugStats))
+}
+
+// Runtime statistics returned by /debug/stats
+type DebugStats struct {
+	Goroutines int            `json:"goroutines"`
+	Heap       HeapStats      `json:"heap"`
+	Jobs       map[string]int `json:"jobs"`
+	Stores     map[string]int `json:"stores"`
+	Breaker    breakerState   `json:"upstream_breaker"`
+	Limiter    limiterStats   `json:"upstream_limiter"`
+	// Token budget by upstream profile
+	RateLimit map[string]tokenBudgetState `json:"upstream_rate_limit"`
+}
+
+type HeapStats struct {
+	AllocBytes   uint64 `json:"alloc_bytes"`
+	InuseBytes   uint64 `json:"inuse_bytes"`
+	Objects      uint64 `json:"objects"`
+	SysBytes     uint64 `json:"sys_bytes"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"pause_total_ns"`
+}
+
+// Handler for the /debug/stats endpoint
+func debugStats(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := DebugStats{
+		Goroutines: runtime.NumGoroutine(),
+		Heap: HeapStats{
+			AllocBytes:   mem.HeapAlloc,
+			InuseBytes:   mem.HeapInuse,
+			Objects:      mem.HeapObjects,
+			SysBytes:     mem.Sys,
+			NumGC:        mem.NumGC,
+			PauseTotalNs: mem.PauseTotalNs,
+		},
+		Jobs: jobs.countByStatus(),
+		Stores: map[string]int{
+			"jobs":           jobs.size(),
+			"response_cache": responses.size(),
+		},
+		Breaker:   upstreamBreaker.currentState(),
+		Limiter:   upstreamLimiter.stats(),
+		RateLimit: budgetStates(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
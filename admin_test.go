@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDebugEndpointsGated(t *testing.T) {
+	paths := []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/cmdline", "/debug/stats"}
+	admin := http.Header{"X-Admin-Key": {"admin-secret"}}
+
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		key     string
+		header  http.Header
+		want    int
+	}{
+		{"flag off, admin key", false, "admin-secret", admin, http.StatusNotFound},
+		{"flag on, no key", true, "admin-secret", nil, http.StatusUnauthorized},
+		{"flag on, wrong key", true, "admin-secret", http.Header{"X-Admin-Key": {"guess"}}, http.StatusUnauthorized},
+		{"flag on, no admin key configured", true, "", http.Header{"X-Admin-Key": {""}}, http.StatusUnauthorized},
+		{"flag on, admin key", true, "admin-secret", admin, http.StatusOK},
+		{"flag on, bearer admin key", true, "admin-secret", http.Header{"Authorization": {"Bearer admin-secret"}}, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupServer(t, func(c *Config) {
+				c.EnableDebugEndpoints = tc.enabled
+				c.AdminAPIKey = tc.key
+			})
+			for _, path := range paths {
+				if rec := serve(t, http.MethodGet, path, nil, tc.header); rec.Code != tc.want {
+					t.Errorf("GET %s: status %d, want %d", path, rec.Code, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugStats(t *testing.T) {
+	setupServer(t, func(c *Config) {
+		c.EnableDebugEndpoints = true
+		c.AdminAPIKey = "admin-secret"
+	})
+	rec := serve(t, http.MethodGet, "/debug/stats", nil, http.Header{"X-Admin-Key": {"admin-secret"}})
+	stats := decodeBody[DebugStats](t, rec)
+	if stats.Goroutines <= 0 || stats.Heap.SysBytes == 0 || stats.Jobs == nil || stats.Stores == nil {
+		t.Errorf("stats %+v lack runtime figures", stats)
+	}
+}
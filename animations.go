@@ -0,0 +1,353 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+const animationCollection = "animations"
+
+const (
+	maxAnimationTags   = 32
+	maxAnimationTagLen = 64
+	defaultPageSize    = 50
+	maxPageSize        = 200
+)
+
+// normalizeTags trims tags and drops empty and repeated ones, comparing
+// without case
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		if len([]rune(tag)) > maxAnimationTagLen {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", snippet(tag, 16), maxAnimationTagLen)
+		}
+		seen[strings.ToLower(tag)] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxAnimationTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxAnimationTags)
+	}
+	return normalized, nil
+}
+
+func summarizeAnimation(a Animation) animationSummary {
+	points := 0
+	for _, frame := range a.Frames {
+		points = max(points, len(frame))
+	}
+	return animationSummary{
+		Name:           a.Name,
+		Prompt:         a.Prompt,
+		Tags:           a.Tags,
+		FrameCount:     len(a.Frames),
+		PointCount:     points,
+		GenerationHash: a.GenerationHash,
+		Score:          a.Score,
+		CreatedAt:      a.CreatedAt,
+		UpdatedAt:      a.UpdatedAt,
+	}
+}
+
+// In-memory index of the library's metadata for search. Entries are replaced
+// whole under the lock, so a search sees each clip either before or after a
+// concurrent write, never half way.
+type animationIndex struct {
+	mu      sync.RWMutex
+	entries map[string]animationSummary
+	// Serializes writes so the store and the index agree and edits of the
+	// same clip do not overwrite each other
+	writes sync.Mutex
+}
+
+var animations = &animationIndex{entries: make(map[string]animationSummary)}
+
+// rebuild loads the metadata of every stored clip
+func (idx *animationIndex) rebuild(s Store) error {
+	names, err := s.List(animationCollection)
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]animationSummary, len(names))
+	for _, name := range names {
+		var a Animation
+		found, err := s.Get(animationCollection, name, &a)
+		if err != nil {
+			return fmt.Errorf("load animation %s: %w", name, err)
+		}
+		if found {
+			entries[name] = summarizeAnimation(a)
+		}
+	}
+	idx.mu.Lock()
+	idx.entries = entries
+	idx.mu.Unlock()
+	return nil
+}
+
+func (idx *animationIndex) put(a Animation) {
+	summary := summarizeAnimation(a)
+	summary.Tags = slices.Clone(summary.Tags)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.entries[a.Name] = summary
+}
+
+// Search parameters of GET /animations
+type animationQuery struct {
+	Tags   []string
+	Text   string
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+}
+
+func parseAnimationQuery(r *http.Request) (animationQuery, error) {
+	query := r.URL.Query()
+	q := animationQuery{Tags: query["tag"], Text: strings.TrimSpace(query.Get("q")), Sort: query.Get("sort"), Limit: defaultPageSize}
+	switch q.Sort {
+	case "", "created", "frames", "name":
+	default:
+		return q, fmt.Errorf("invalid sort %q, expected created, frames or name", q.Sort)
+	}
+	switch query.Get("order") {
+	case "":
+		// Newest and longest clips first, names in alphabetical order
+		q.Desc = q.Sort != "name"
+	case "desc":
+		q.Desc = true
+	case "asc":
+	default:
+		return q, fmt.Errorf("invalid order %q, expected asc or desc", query.Get("order"))
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.Limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("offset must be a non-negative integer")
+		}
+		q.Offset = n
+	}
+	return q, nil
+}
+
+// matches reports whether a clip carries every requested tag and, with a
+// search text, has it in its name or prompt. Both compare without case.
+func (q animationQuery) matches(s animationSummary) bool {
+	for _, want := range q.Tags {
+		if !slices.ContainsFunc(s.Tags, func(tag string) bool { return strings.EqualFold(tag, want) }) {
+			return false
+		}
+	}
+	if q.Text == "" {
+		return true
+	}
+	text := strings.ToLower(q.Text)
+	return strings.Contains(strings.ToLower(s.Name), text) || strings.Contains(strings.ToLower(s.Prompt), text)
+}
+
+// search returns one page of the matching clips and the number of matches
+func (idx *animationIndex) search(q animationQuery) ([]animationSummary, int) {
+	idx.mu.RLock()
+	matched := make([]animationSummary, 0, len(idx.entries))
+	for _, s := range idx.entries {
+		if q.matches(s) {
+			matched = append(matched, s)
+		}
+	}
+	idx.mu.RUnlock()
+
+	slices.SortFunc(matched, func(a, b animationSummary) int {
+		var c int
+		switch q.Sort {
+		case "frames":
+			c = cmp.Compare(a.FrameCount, b.FrameCount)
+		case "name":
+		default:
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		// Names break ties so pages stay stable
+		c = cmp.Or(c, strings.Compare(a.Name, b.Name))
+		if q.Desc {
+			return -c
+		}
+		return c
+	})
+	total := len(matched)
+	start := min(q.Offset, total)
+	return matched[start:min(start+q.Limit, total)], total
+}
+
+// Handler for saving a clip to the library at /animations
+func createAnimation(w http.ResponseWriter, r *http.Request) {
+	var req AnimationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	if !validPoseName.MatchString(req.Name) {
+		writeError(w, newAPIError(http.StatusBadRequest, "name must be 1-64 letters, digits, '.', '-' or '_', not starting with '.'"))
+		return
+	}
+	if (len(req.Frames) > 0) == (req.GenerationHash != "") {
+		writeError(w, newAPIError(http.StatusBadRequest, "Specify either frames or generation_hash"))
+		return
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+
+	now := time.Now()
+	animation := Animation{
+		Name:           req.Name,
+		Prompt:         req.Prompt,
+		Tags:           tags,
+		Frames:         req.Frames,
+		GenerationHash: req.GenerationHash,
+		Score:          req.Score,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if req.GenerationHash != "" {
+		record, ok := loadGeneration(w, req.GenerationHash)
+		if !ok {
+			return
+		}
+		animation.Frames = record.Frames
+		if animation.Prompt == "" {
+			animation.Prompt = record.Request.Prompt
+		}
+	}
+	animations.writes.Lock()
+	defer animations.writes.Unlock()
+	if err := saveAnimation(animation); err != nil {
+		log.Printf("Failed to store animation %s: %v", animation.Name, err)
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to store animation"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/animations/"+animation.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(summarizeAnimation(animation))
+}
+
+// saveAnimation stores a clip and updates the search index; callers hold
+// animations.writes
+func saveAnimation(a Animation) error {
+	if err := store.Put(animationCollection, a.Name, a); err != nil {
+		return err
+	}
+	animations.put(a)
+	return nil
+}
+
+// loadAnimation fetches the named clip, writing the error response when it
+// cannot
+func loadAnimation(w http.ResponseWriter, name string) (*Animation, bool) {
+	if !validPoseName.MatchString(name) {
+		writeError(w, newAPIError(http.StatusNotFound, "Animation not found"))
+		return nil, false
+	}
+	var animation Animation
+	found, err := store.Get(animationCollection, name, &animation)
+	if err != nil {
+		log.Printf("Failed to load animation %s: %v", name, err)
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to load animation"))
+		return nil, false
+	}
+	if !found {
+		writeError(w, newAPIError(http.StatusNotFound, "Animation not found"))
+		return nil, false
+	}
+	return &animation, true
+}
+
+// Handler for the /animations/{name} endpoint
+func getAnimation(w http.ResponseWriter, r *http.Request) {
+	animation, ok := loadAnimation(w, r.PathValue("name"))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(animation); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
+
+// Handler for editing a clip's tags and score at PATCH /animations/{name}
+func patchAnimation(w http.ResponseWriter, r *http.Request) {
+	var patch AnimationPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	animations.writes.Lock()
+	defer animations.writes.Unlock()
+	animation, ok := loadAnimation(w, r.PathValue("name"))
+	if !ok {
+		return
+	}
+	if patch.Tags != nil {
+		tags, err := normalizeTags(*patch.Tags)
+		if err != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+			return
+		}
+		animation.Tags = tags
+	}
+	if patch.Score != nil {
+		animation.Score = patch.Score
+	}
+	animation.UpdatedAt = time.Now()
+	if err := saveAnimation(*animation); err != nil {
+		log.Printf("Failed to store animation %s: %v", animation.Name, err)
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to store animation"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summarizeAnimation(*animation))
+}
+
+// Handler for listing and searching the library at /animations
+func listAnimations(w http.ResponseWriter, r *http.Request) {
+	q, err := parseAnimationQuery(r)
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+	page, total := animations.search(q)
+	response := api.AnimationList{Animations: page, Total: total}
+	if next := q.Offset + len(page); next < total {
+		response.NextOffset = &next
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestAnimationSearch(t *testing.T) {
+	setupServer(t, nil)
+	clip := func(frames int) ResponsePayload {
+		return make(ResponsePayload, frames)
+	}
+	for _, req := range []AnimationRequest{
+		{Name: "wave", Prompt: "Wave the right hand", Tags: []string{"Greeting", "arms", "greeting"}, Frames: clip(4)},
+		{Name: "bow", Prompt: "bow politely", Tags: []string{"greeting"}, Frames: clip(8)},
+		{Name: "walk-cycle", Prompt: "walk in place", Tags: []string{"locomotion", " loop "}, Frames: clip(12)},
+		{Name: "run-cycle", Prompt: "run on the spot", Tags: []string{"locomotion", "loop"}, Frames: clip(6)},
+	} {
+		if rec := serve(t, http.MethodPost, "/animations", req, nil); rec.Code != http.StatusCreated {
+			t.Fatalf("save %s: status %d: %s", req.Name, rec.Code, rec.Body)
+		}
+	}
+	search := func(query string) api.AnimationList {
+		t.Helper()
+		rec := serve(t, http.MethodGet, "/animations?"+query, nil, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		return decodeBody[api.AnimationList](t, rec)
+	}
+	names := func(list api.AnimationList) []string {
+		var names []string
+		for _, a := range list.Animations {
+			names = append(names, a.Name)
+		}
+		return names
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"sort=name", []string{"bow", "run-cycle", "walk-cycle", "wave"}},
+		{"sort=frames", []string{"walk-cycle", "bow", "run-cycle", "wave"}},
+		{"sort=frames&order=asc", []string{"wave", "run-cycle", "bow", "walk-cycle"}},
+		// Every tag must match, without case
+		{"tag=GREETING&sort=name", []string{"bow", "wave"}},
+		{"tag=greeting&tag=arms", []string{"wave"}},
+		{"tag=loop&sort=name", []string{"run-cycle", "walk-cycle"}},
+		// Text searches names and prompts
+		{"q=CYCLE&sort=name", []string{"run-cycle", "walk-cycle"}},
+		{"q=right+hand", []string{"wave"}},
+		{"q=jump", nil},
+	} {
+		if got := names(search(tc.query)); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	// Pages carry the total and the next offset until the last one
+	page := search("sort=name&limit=3")
+	if page.Total != 4 || page.NextOffset == nil || *page.NextOffset != 3 {
+		t.Errorf("first page: total %d, next %v; want 4 and 3", page.Total, page.NextOffset)
+	}
+	if page = search("sort=name&limit=3&offset=3"); !slices.Equal(names(page), []string{"wave"}) || page.NextOffset != nil {
+		t.Errorf("last page %q, next %v; want wave alone", names(page), page.NextOffset)
+	}
+
+	// Tag edits show up in search at once
+	tags := []string{"arms"}
+	if rec := serve(t, http.MethodPatch, "/animations/wave", api.AnimationPatch{Tags: &tags}, nil); rec.Code != http.StatusOK {
+		t.Fatalf("patch: status %d: %s", rec.Code, rec.Body)
+	}
+	if got := names(search("tag=greeting")); !slices.Equal(got, []string{"bow"}) {
+		t.Errorf("greeting after retagging wave: %q, want bow", got)
+	}
+
+	// A rebuilt index finds the same clips as the live one
+	before := search("sort=name")
+	if err := animations.rebuild(store); err != nil {
+		t.Fatal(err)
+	}
+	if after := search("sort=name"); !slices.Equal(names(after), names(before)) || after.Animations[3].FrameCount != 4 {
+		t.Errorf("after rebuilding: %+v, want %+v", after.Animations, before.Animations)
+	}
+
+	for _, query := range []url.Values{{"sort": {"size"}}, {"order": {"up"}}, {"limit": {"0"}}, {"limit": {"201"}}, {"offset": {"-1"}}} {
+		if rec := serve(t, http.MethodGet, "/animations?"+query.Encode(), nil, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query.Encode(), rec.Code)
+		}
+	}
+}
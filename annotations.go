@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Optional fields the model may return next to its frames. Any of them may
+// be missing or malformed; they are parsed leniently and never fail a
+// generation.
+type modelAnnotations struct {
+	AffectedPoints []int
+	Confidence     map[int]float64
+	// In compact IDs until postprocess resolves them
+	Events  []frameEvent
+	present bool
+}
+
+// parseModelAnnotations reads affected_points, confidence and events from a
+// model response, accepting IDs as numbers or strings and skipping anything else
+func parseModelAnnotations(content string) modelAnnotations {
+	var raw struct {
+		AffectedPoints []json.RawMessage          `json:"affected_points"`
+		Confidence     map[string]json.RawMessage `json:"confidence"`
+		Events         []json.RawMessage          `json:"events"`
+	}
+	var a modelAnnotations
+	if err := json.Unmarshal([]byte(content), &raw); err != nil {
+		return a
+	}
+	if raw.AffectedPoints != nil {
+		a.present = true
+		for _, v := range raw.AffectedPoints {
+			if id, ok := parseLenientID(v); ok {
+				a.AffectedPoints = append(a.AffectedPoints, id)
+			}
+		}
+	}
+	for key, v := range raw.Confidence {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		var c float64
+		if string(v) == "null" || json.Unmarshal(v, &c) != nil || math.IsNaN(c) {
+			continue
+		}
+		if a.Confidence == nil {
+			a.Confidence = make(map[int]float64)
+		}
+		a.Confidence[id] = math.Max(0, math.Min(1, c))
+	}
+	a.Events = parseModelEvents(raw.Events)
+	return a
+}
+
+func parseLenientID(v json.RawMessage) (int, bool) {
+	// null would otherwise decode as ID 0
+	if string(v) == "null" {
+		return 0, false
+	}
+	var n float64
+	if json.Unmarshal(v, &n) == nil && n == math.Trunc(n) {
+		return int(n), true
+	}
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		if id, err := strconv.Atoi(s); err == nil {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// toOriginalIDs maps annotations from the compact IDs the model saw back to
+// the client's IDs. Duplicated client IDs share one compact ID, so one
+// compact ID can map to several client IDs. Events are left out, as only
+// the finished clip tells which of them to keep.
+func (a modelAnnotations) toOriginalIDs(idMap map[int]int) modelAnnotations {
+	originals := reverseIDMap(idMap)
+	mapped := modelAnnotations{present: a.present}
+	seen := make(map[int]bool)
+	for _, compact := range a.AffectedPoints {
+		for _, id := range originals[compact] {
+			if !seen[id] {
+				seen[id] = true
+				mapped.AffectedPoints = append(mapped.AffectedPoints, id)
+			}
+		}
+	}
+	sort.Ints(mapped.AffectedPoints)
+	for compact, c := range a.Confidence {
+		for _, id := range originals[compact] {
+			if mapped.Confidence == nil {
+				mapped.Confidence = make(map[int]float64)
+			}
+			mapped.Confidence[id] = c
+		}
+	}
+	return mapped
+}
+
+// reverseIDMap maps each compact ID to the client IDs that share it
+func reverseIDMap(idMap map[int]int) map[int][]int {
+	originals := make(map[int][]int)
+	for original, compact := range idMap {
+		originals[compact] = append(originals[compact], original)
+	}
+	return originals
+}
+
+// checkAffectedPoints compares the model's claimed affected points with the
+// actual motion. A point counts as moving when its largest displacement
+// exceeds 1% of the rig's bounding-box diagonal.
+func checkAffectedPoints(a modelAnnotations, frames ResponsePayload, points []ControlPoint) []string {
+	if !a.present {
+		return nil
+	}
+	threshold := math.Max(0.01, 0.01*rigDiagonal(points))
+	peak := make(map[int]float64)
+	for _, frame := range frames {
+		for id, d := range frame {
+			peak[id] = math.Max(peak[id], math.Sqrt(d.DeltaX*d.DeltaX+d.DeltaY*d.DeltaY+d.DeltaZ*d.DeltaZ))
+		}
+	}
+
+	claimed := make(map[int]bool, len(a.AffectedPoints))
+	var warnings []string
+	for _, id := range a.AffectedPoints {
+		claimed[id] = true
+		if peak[id] < threshold {
+			warnings = append(warnings, fmt.Sprintf("Point %d is listed as affected but barely moves (peak displacement %.3g)", id, peak[id]))
+		}
+	}
+	for _, id := range frameIDs(frames) {
+		if !claimed[id] && peak[id] >= threshold {
+			warnings = append(warnings, fmt.Sprintf("Point %d moves (peak displacement %.3g) but is not listed as affected", id, peak[id]))
+		}
+	}
+	return warnings
+}
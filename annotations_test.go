@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestParseModelAnnotations(t *testing.T) {
+	a := parseModelAnnotations(`{"frames": [], "affected_points": [2, "3", 1.5, "hand", null], "confidence": {"2": 1.4, "3": -1, "x": 0.5, "4": "high", "5": null}}`)
+	if !a.present || !slices.Equal(a.AffectedPoints, []int{2, 3}) {
+		t.Errorf("affected points %v, present %v; want [2 3]", a.AffectedPoints, a.present)
+	}
+	if len(a.Confidence) != 2 || a.Confidence[2] != 1 || a.Confidence[3] != 0 {
+		t.Errorf("confidence %v, want 2 and 3 clamped to [0, 1]", a.Confidence)
+	}
+
+	// Older models leave the fields out, which is not a claim that nothing moves
+	if a := parseModelAnnotations(`{"frames": []}`); a.present || a.AffectedPoints != nil || a.Confidence != nil {
+		t.Errorf("no annotations parsed as %+v", a)
+	}
+	if a := parseModelAnnotations(`{"frames": [], "affected_points": []}`); !a.present {
+		t.Error("an empty affected list was not recorded as present")
+	}
+	if a := parseModelAnnotations(`not json`); a.present {
+		t.Errorf("invalid JSON parsed as %+v", a)
+	}
+}
+
+// affectedContent is a model reply that waves the right hand (ID 2) and
+// adds the given annotation fields
+func affectedContent(annotations map[string]any) func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		frames := make([]map[string]Position, input.Length)
+		for f := range frames {
+			frames[f] = make(map[string]Position, len(input.ControlPoints))
+			for _, cp := range input.ControlPoints {
+				p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+				if cp.ID == 2 {
+					p.Y += 0.2 * math.Sin(math.Pi*float64(f)/float64(input.Length-1))
+				}
+				frames[f][strconv.Itoa(cp.ID)] = p
+			}
+		}
+		reply := map[string]any{"frames": frames}
+		for k, v := range annotations {
+			reply[k] = v
+		}
+		content, _ := json.Marshal(reply)
+		return contentResponse(string(content)), nil
+	}
+}
+
+func TestAffectedPointsInconsistencies(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]any
+		affected    []int
+		warnings    []string
+	}{
+		{"consistent", map[string]any{"affected_points": []int{2}, "confidence": map[string]float64{"2": 0.9}}, []int{2}, nil},
+		{"omitted", nil, nil, nil},
+		{"contradicted", map[string]any{"affected_points": []string{"0"}}, []int{0}, []string{
+			"Point 0 is listed as affected but barely moves",
+			"Point 2 moves (peak displacement 0.2) but is not listed as affected",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := setupServer(t, nil)
+			fake.respond = affectedContent(tc.annotations)
+			payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "raise the right hand", Length: 5}}
+			rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			meta := decodeBody[struct {
+				Meta generationMeta `json:"meta"`
+			}](t, rec).Meta
+			if !slices.Equal(meta.AffectedPoints, tc.affected) {
+				t.Errorf("affected points %v, want %v", meta.AffectedPoints, tc.affected)
+			}
+			var warnings []string
+			for _, w := range meta.Warnings {
+				if strings.Contains(w, "affected") {
+					warnings = append(warnings, w)
+				}
+			}
+			if len(warnings) != len(tc.warnings) {
+				t.Fatalf("affected-point warnings %q, want %q", warnings, tc.warnings)
+			}
+			for i, want := range tc.warnings {
+				if !strings.HasPrefix(warnings[i], want) {
+					t.Errorf("warning %q, want it to start with %q", warnings[i], want)
+				}
+			}
+			if tc.name == "consistent" && meta.Confidence[2] != 0.9 {
+				t.Errorf("confidence %v, want 0.9 for point 2", meta.Confidence)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import "time"
+
+// A clip kept in the library under a name
+type Animation struct {
+	Name string `json:"name"`
+	// Prompt the clip was generated from, for search
+	Prompt string   `json:"prompt,omitempty"`
+	Tags   []string `json:"tags"`
+	Frames Frames   `json:"frames"`
+	// Stored generation the clip came from, empty for frames sent inline
+	GenerationHash string `json:"generation_hash,omitempty"`
+	// Quality score supplied by the client, if any
+	Score     *float64  `json:"score,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Input struct for the /animations endpoint. The frames come from frames or
+// a stored generation, whose prompt is used unless one is given.
+type AnimationRequest struct {
+	Name           string   `json:"name"`
+	Frames         Frames   `json:"frames,omitempty"`
+	GenerationHash string   `json:"generation_hash,omitempty"`
+	Prompt         string   `json:"prompt,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+	Score          *float64 `json:"score,omitempty"`
+}
+
+// Input struct for PATCH /animations/{name}; present fields replace the
+// stored values
+type AnimationPatch struct {
+	Tags  *[]string `json:"tags,omitempty"`
+	Score *float64  `json:"score,omitempty"`
+}
+
+// Listing entry of a library clip, enough to show it without fetching the
+// frames
+type AnimationSummary struct {
+	Name           string    `json:"name"`
+	Prompt         string    `json:"prompt,omitempty"`
+	Tags           []string  `json:"tags"`
+	FrameCount     int       `json:"frame_count"`
+	PointCount     int       `json:"point_count"`
+	GenerationHash string    `json:"generation_hash,omitempty"`
+	Score          *float64  `json:"score,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// One page of GET /animations
+type AnimationList struct {
+	Animations []AnimationSummary `json:"animations"`
+	Total      int                `json:"total"`
+	// Offset of the next page, when there is one
+	NextOffset *int `json:"next_offset,omitempty"`
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// encoding/json switches to exponent notation below 1e-6, e.g. 1e-09, which
+// some game engine JSON parsers read wrongly. Deltas are written in plain
+// decimal notation instead, with the shortest digits that round-trip, so
+// values already rounded to a category's precision keep exactly those places.
+// Negative zero is written as 0.
+
+// Names of a Deformation's fields
+var DeltaFields = []string{"delta_x", "delta_y", "delta_z"}
+
+func (d Deformation) MarshalJSON() ([]byte, error) {
+	return AppendFields(nil, DeltaFields, -1, d.DeltaX, d.DeltaY, d.DeltaZ)
+}
+
+func (d SphericalDeformation) MarshalJSON() ([]byte, error) {
+	return AppendFields(nil, []string{"delta_r", "delta_theta", "delta_phi"}, -1, d.DeltaR, d.DeltaTheta, d.DeltaPhi)
+}
+
+func (t RootTransform) MarshalJSON() ([]byte, error) {
+	return AppendFields(nil, []string{"delta_x", "delta_y", "delta_z", "yaw"}, -1, t.DeltaX, t.DeltaY, t.DeltaZ, t.Yaw)
+}
+
+// AppendFields writes a JSON object of the named numbers, as the types here
+// marshal theirs. With places of 0 or more the numbers are rounded to that
+// many decimals, dropping trailing zeros; a negative places writes the
+// shortest digits that round-trip.
+func AppendFields(b []byte, names []string, places int, values ...float64) ([]byte, error) {
+	b = append(b, '{')
+	for i, name := range names {
+		v := values[i]
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%s: unsupported value %v", name, v)
+		}
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendQuote(b, name)
+		b = append(b, ':')
+		b = appendNumber(b, v, places)
+	}
+	return append(b, '}'), nil
+}
+
+func appendNumber(b []byte, v float64, places int) []byte {
+	start := len(b)
+	b = strconv.AppendFloat(b, v, 'f', places, 64)
+	if places > 0 {
+		b = bytes.TrimRight(b, "0")
+		b = bytes.TrimSuffix(b, []byte("."))
+	}
+	if string(b[start:]) == "-0" {
+		b = append(b[:start], '0')
+	}
+	return b
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDeformationMarshalJSON(t *testing.T) {
+	cases := []struct {
+		d    Deformation
+		want string
+	}{
+		{Deformation{DeltaX: 1e-7, DeltaY: 1e21, DeltaZ: 0.25}, `{"delta_x":0.0000001,"delta_y":1000000000000000000000,"delta_z":0.25}`},
+		{Deformation{DeltaX: -1e-9, DeltaY: -12.5}, `{"delta_x":-0.000000001,"delta_y":-12.5,"delta_z":0}`},
+		{Deformation{DeltaX: negativeZero()}, `{"delta_x":0,"delta_y":0,"delta_z":0}`},
+	}
+	for _, tc := range cases {
+		got, err := json.Marshal(tc.d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%+v marshals to %s, want %s", tc.d, got, tc.want)
+		}
+		var back Deformation
+		if err := json.Unmarshal(got, &back); err != nil || back.DeltaX != tc.d.DeltaX || back.DeltaY != tc.d.DeltaY || back.DeltaZ != tc.d.DeltaZ {
+			t.Errorf("%s reads back as %+v (%v)", got, back, err)
+		}
+	}
+}
+
+func TestAppendFieldsPlaces(t *testing.T) {
+	cases := []struct {
+		places int
+		values []float64
+		want   string
+	}{
+		{2, []float64{0.123456, 1.5, 2}, `{"delta_x":0.12,"delta_y":1.5,"delta_z":2}`},
+		{4, []float64{1e-7, 1e21, 0.00016}, `{"delta_x":0,"delta_y":1000000000000000000000,"delta_z":0.0002}`},
+		{2, []float64{-0.001, -0.004, negativeZero()}, `{"delta_x":0,"delta_y":0,"delta_z":0}`},
+		{0, []float64{2.6, -0.4, 10}, `{"delta_x":3,"delta_y":0,"delta_z":10}`},
+	}
+	for _, tc := range cases {
+		got, err := AppendFields(nil, DeltaFields, tc.places, tc.values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%v at %d places: %s, want %s", tc.values, tc.places, got, tc.want)
+		}
+	}
+}
+
+func negativeZero() float64 {
+	zero := 0.0
+	return -zero
+}
@@ -0,0 +1,201 @@
+// Package api defines the JSON the descriptive-rigidity service reads and
+// writes. The server and the Go client both use these types, so a request
+// or response field is declared once; the README documents what each one
+// does.
+package api
+
+import "encoding/json"
+
+// A point of the rig, at its position in the pose being animated
+type ControlPoint struct {
+	ID       int       `json:"id"`
+	Role     string    `json:"role"`
+	Position []float64 `json:"position"`
+	// body (default), face or prop
+	Category string `json:"category,omitempty"`
+}
+
+// Body of POST /generate-deformations, and of POST /jobs alongside the
+// callback URL
+type RequestPayload struct {
+	ControlPoints []ControlPoint `json:"control_points"`
+	// Rest positions of control points whose input position is a pose
+	// partway through a motion, keyed by control point ID. Deltas are
+	// measured from these; the model animates from the input positions.
+	RestPose map[int]Position `json:"rest_pose,omitempty"`
+	RigID    string           `json:"rig_id,omitempty"`
+	// Guess roles for control points sent without one
+	InferRoles bool `json:"infer_roles,omitempty"`
+	// The rig's up and forward axes and handedness, checked against its
+	// geometry
+	Axes                 *AxesConvention `json:"axes,omitempty"`
+	OnConventionMismatch string          `json:"on_convention_mismatch,omitempty"`
+	CacheMode            string          `json:"cache_mode,omitempty"`
+	Model                string          `json:"model,omitempty"`
+	ResponseMode         string          `json:"response_mode,omitempty"`
+	Profile              string          `json:"profile,omitempty"`
+	Prompt               string          `json:"prompt"`
+	SecondaryPrompt      string          `json:"secondary_prompt,omitempty"`
+	BlendWeight          float64         `json:"blend_weight,omitempty"`
+	Previous             Frames          `json:"previous,omitempty"`
+	BlendWithPrevious    float64         `json:"blend_with_previous,omitempty"`
+	Length               int             `json:"length"`
+	Loop                 bool            `json:"loop,omitempty"`
+	Easing               *EasingOptions  `json:"easing,omitempty"`
+	FreezeAxes           []string        `json:"freeze_axes,omitempty"`
+	Jiggle               *JiggleOptions  `json:"jiggle,omitempty"`
+	// Factor scaling all motion, and factors for roles that differ from it
+	Exaggerate      float64            `json:"exaggerate,omitempty"`
+	ExaggerateRoles map[string]float64 `json:"exaggerate_roles,omitempty"`
+	// Remove drift of the whole character, measured on COMPoints or on every
+	// point when it is empty
+	StabilizeCOM bool         `json:"stabilize_com,omitempty"`
+	COMPoints    []int        `json:"com_points,omitempty"`
+	Holds        []Hold       `json:"holds,omitempty"`
+	StartAtRest  *StartAtRest `json:"start_at_rest,omitempty"`
+	Pipeline     []string     `json:"pipeline,omitempty"`
+	// Operator-defined pipeline and stage parameters to start from
+	PostprocessPreset   string               `json:"postprocess_preset,omitempty"`
+	NeighborRigidity    float64              `json:"neighbor_rigidity,omitempty"`
+	Neighbors           map[int][]int        `json:"neighbors,omitempty"`
+	OutputCoords        string               `json:"output_coords,omitempty"`
+	SphericalPivot      []float64            `json:"spherical_pivot,omitempty"`
+	Candidates          int                  `json:"candidates,omitempty"`
+	OnCorrupt           string               `json:"on_corrupt,omitempty"`
+	OnMismatch          string               `json:"on_mismatch,omitempty"`
+	OnDrift             string               `json:"on_drift,omitempty"`
+	Events              string               `json:"events,omitempty"`
+	Plausibility        *PlausibilityOptions `json:"plausibility,omitempty"`
+	OnImplausible       string               `json:"on_implausible,omitempty"`
+	Keyframes           []Keyframe           `json:"keyframes,omitempty"`
+	DurationSec         float64              `json:"duration_sec,omitempty"`
+	FPS                 float64              `json:"fps,omitempty"`
+	PromptLanguageMode  string               `json:"prompt_language_mode,omitempty"`
+	AutoTranslatePrompt bool                 `json:"auto_translate_prompt,omitempty"`
+	ExpandPrompt        bool                 `json:"expand_prompt,omitempty"`
+	IndexBase           int                  `json:"index_base,omitempty"`
+	DisableIDRemap      bool                 `json:"disable_id_remap,omitempty"`
+	Constraints         *MotionConstraints   `json:"constraints,omitempty"`
+	MaxRange            float64              `json:"max_range,omitempty"`
+	Encoding            string               `json:"encoding,omitempty"`
+	UnchangedPoints     string               `json:"unchanged_points,omitempty"`
+	AuxiliaryHandling   string               `json:"auxiliary_handling,omitempty"`
+	OutputUnits         string               `json:"output_units,omitempty"`
+	RootMotion          string               `json:"root_motion,omitempty"`
+	RootYaw             bool                 `json:"root_yaw,omitempty"`
+	PromptSections      []string             `json:"prompt_sections,omitempty"`
+}
+
+// Absolute position of a control point
+type Position struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// The rig's coordinate convention as the client declares it, such as
+// {"up": "y", "forward": "-z", "handedness": "right"}. Axes are x, y or z
+// with an optional sign.
+type AxesConvention struct {
+	Up string `json:"up"`
+	// Direction the character faces, optional
+	Forward string `json:"forward,omitempty"`
+	// right (default) or left
+	Handedness string `json:"handedness,omitempty"`
+}
+
+// Easing envelope window lengths and curve shape
+type Easing struct {
+	InFrames  int    `json:"in_frames"`
+	OutFrames int    `json:"out_frames"`
+	Curve     string `json:"curve,omitempty"`
+}
+
+// Easing options for a request: a clip-wide envelope plus optional
+// overrides per control point ID or per role
+type EasingOptions struct {
+	Easing
+	Points map[int]Easing    `json:"points,omitempty"`
+	Groups map[string]Easing `json:"groups,omitempty"`
+}
+
+// Secondary motion for cloth-like and fleshy points such as a ponytail or a
+// belly: each listed point follows its generated trajectory through a spring
+// and damper, so it lags behind and overshoots instead of tracking it exactly
+type JiggleOptions struct {
+	// Point IDs, and roles whose points, to simulate
+	Points []int    `json:"points,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	// Spring constant per unit mass in 1/s²; higher follows more tightly
+	// (default 150)
+	Stiffness float64 `json:"stiffness,omitempty"`
+	// Damping per unit mass in 1/s; 2·√stiffness is critically damped and
+	// lower values overshoot (default 8)
+	Damping float64 `json:"damping,omitempty"`
+	// Frames at the end of a non-looping clip over which the points settle
+	// back onto the generated motion (default a quarter second)
+	SettleFrames int `json:"settle_frames,omitempty"`
+}
+
+// A pause in the clip: frame AfterFrame repeated Count more times
+type Hold struct {
+	AfterFrame int `json:"after_frame"`
+	Count      int `json:"count"`
+}
+
+// Options for start_at_rest, which guarantees the clip starts in the rest
+// pose. The request may send true for the defaults, false, or an object.
+type StartAtRest struct {
+	// Frames blended from rest into the model's motion when its first
+	// frame is far from rest (default 5)
+	BlendFrames int `json:"blend_frames,omitempty"`
+	// "extend" (default) plays the blend before the model's frames, adding
+	// to the clip; "within" blends over the model's first frames instead
+	BlendMode string `json:"blend_mode,omitempty"`
+	// Sent as false, turning the blend from rest off
+	Disabled bool `json:"-"`
+}
+
+func (o *StartAtRest) UnmarshalJSON(b []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(b, &enabled); err == nil {
+		*o = StartAtRest{Disabled: !enabled}
+		return nil
+	}
+	type options StartAtRest
+	return json.Unmarshal(b, (*options)(o))
+}
+
+func (o StartAtRest) MarshalJSON() ([]byte, error) {
+	if o.Disabled {
+		return []byte("false"), nil
+	}
+	type options StartAtRest
+	return json.Marshal(options(o))
+}
+
+// Thresholds of the plausibility check, all optional
+type PlausibilityOptions struct {
+	// Points far apart in the rig may not come closer than this fraction of
+	// their rest distance (default 0.2)
+	MinDistanceRatio float64 `json:"min_distance_ratio,omitempty"`
+	// Hops in the neighbors graph that make two points far apart (default 3)
+	MinGraphHops int `json:"min_graph_hops,omitempty"`
+	// The body's convex hull may not shrink below this fraction of its rest
+	// volume (default 0.3)
+	MinVolumeRatio float64 `json:"min_volume_ratio,omitempty"`
+}
+
+// A timed key pose described in words
+type Keyframe struct {
+	TimeSec     float64 `json:"time_sec"`
+	Description string  `json:"description"`
+}
+
+// Client overrides for the computed motion budgets
+type MotionConstraints struct {
+	// Budgets by control point ID, taking precedence over RoleBudgets
+	MotionBudgets map[int]float64 `json:"motion_budgets,omitempty"`
+	// Budgets by exact role, case-insensitive
+	RoleBudgets map[string]float64 `json:"role_budgets,omitempty"`
+}
@@ -0,0 +1,82 @@
+package api
+
+// Offset of a control point from its rest position in one frame
+type Deformation struct {
+	DeltaX float64 `json:"delta_x"`
+	DeltaY float64 `json:"delta_y"`
+	DeltaZ float64 `json:"delta_z"`
+}
+
+// Frames of deltas keyed by control point ID, as generations return them
+type Frames []map[int]Deformation
+
+// A delta expressed in spherical coordinates, with output_coords spherical.
+// Theta is the azimuth around the up axis (Y unless the request declares
+// axes) measured from the first other axis towards the second, e.g. +X
+// towards +Z; phi is the elevation above the plane they span. Both are in
+// radians.
+type SphericalDeformation struct {
+	DeltaR     float64 `json:"delta_r"`
+	DeltaTheta float64 `json:"delta_theta"`
+	DeltaPhi   float64 `json:"delta_phi"`
+}
+
+// Rigid motion of the whole rig in one frame, relative to its rest pose. A
+// point's final position is its local position rotated by Yaw about the rig's
+// rest centroid, then moved by the deltas.
+type RootTransform struct {
+	DeltaX float64 `json:"delta_x"`
+	DeltaY float64 `json:"delta_y"`
+	DeltaZ float64 `json:"delta_z"`
+	// Rotation about the vertical axis in radians, from +X towards +Z like
+	// spherical theta; zero unless root_yaw is set
+	Yaw float64 `json:"yaw"`
+}
+
+// A moment in the clip such as a foot planting or a hand reaching the top of
+// its swing
+type FrameEvent struct {
+	Frame   int `json:"frame"`
+	PointID int `json:"point_id"`
+	// contact, apex or release
+	Type  string `json:"type"`
+	Label string `json:"label,omitempty"`
+	// model or computed
+	Source string `json:"source"`
+}
+
+// A role infer_roles gave a control point sent without one, reported in
+// meta.inferred_roles so the client can correct it
+type InferredRole struct {
+	ID         int     `json:"id"`
+	Role       string  `json:"role"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Tokens a generation used upstream
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Snapshot of a running generation, as reported to progress hooks
+type ProgressUpdate struct {
+	Stage string `json:"stage"`
+	// Upstream calls finished and expected: one per batch of an oversized
+	// rig, per blended prompt and per retry
+	ChunksCompleted int `json:"chunks_completed"`
+	ChunksTotal     int `json:"chunks_total"`
+	FramesParsed    int `json:"frames_parsed"`
+	TokensUsed      int `json:"tokens_used"`
+	// Estimated from the time the finished chunks took, once one has
+	ETASeconds *float64 `json:"eta_seconds,omitempty"`
+}
+
+// Delivery state of a job's completion callback
+type JobCallback struct {
+	// pending, delivered or failed
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+}
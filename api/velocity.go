@@ -0,0 +1,56 @@
+package api
+
+import (
+	"maps"
+	"math"
+)
+
+// A clip as velocities, with output_units per_second. Frame i holds each
+// point's change from frame i-1 to frame i in units per second; the first
+// frame is the initial pose itself and holds zero velocities. Points left out
+// of a frame did not move.
+type VelocityPayload struct {
+	Units string  `json:"units"`
+	FPS   float64 `json:"fps"`
+	// Decimal places the offsets were rounded to; integrating velocities and
+	// rounding each frame to this many places restores them exactly
+	Precision   int                 `json:"precision"`
+	InitialPose map[int]Deformation `json:"initial_pose"`
+	Frames      Frames              `json:"frames"`
+}
+
+// VelocitiesToOffsets integrates a clip in per_second output units back into
+// per-frame offsets from rest, the inverse of the conversion the server
+// applies. Each frame is rounded to the clip's precision, which makes the
+// result identical to the offsets the server started from.
+func VelocitiesToOffsets(v VelocityPayload) Frames {
+	frames := make(Frames, len(v.Frames))
+	current := maps.Clone(v.InitialPose)
+	if current == nil {
+		current = make(map[int]Deformation)
+	}
+	step := 1 / v.FPS
+	for i, velocities := range v.Frames {
+		if i > 0 {
+			for id, d := range velocities {
+				c := current[id]
+				current[id] = Deformation{
+					DeltaX: roundTo(c.DeltaX+d.DeltaX*step, v.Precision),
+					DeltaY: roundTo(c.DeltaY+d.DeltaY*step, v.Precision),
+					DeltaZ: roundTo(c.DeltaZ+d.DeltaZ*step, v.Precision),
+				}
+			}
+		}
+		frames[i] = maps.Clone(current)
+	}
+	return frames
+}
+
+// roundTo rounds as the server rounds offsets, without negative zero
+func roundTo(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	if r := math.Round(v*scale) / scale; r != 0 {
+		return r
+	}
+	return 0
+}
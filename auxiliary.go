@@ -0,0 +1,152 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Roles that name no body part: empty, the generic role infer_roles gives
+// points it cannot place, and the names tools give helper points, with an
+// optional number
+var auxiliaryRole = regexp.MustCompile(`^(point|pt|p|helper|aux|auxiliary|extra|ctrl|control|control point|handle|null|unnamed|unknown|none|bone|joint|node|vertex|marker)?[\s_.#-]*\d*$`)
+
+func isAuxiliaryRole(role string) bool {
+	return auxiliaryRole.MatchString(strings.ToLower(strings.TrimSpace(role)))
+}
+
+// splitAuxiliary separates the points with meaningful roles from the
+// unnamed helpers among them. A rig with no named points at all has no
+// auxiliary points, as there is nothing for them to follow.
+func splitAuxiliary(points []ControlPoint) (named, auxiliary []ControlPoint) {
+	for _, cp := range points {
+		if isAuxiliaryRole(cp.Role) {
+			auxiliary = append(auxiliary, cp)
+		} else {
+			named = append(named, cp)
+		}
+	}
+	if len(named) == 0 {
+		return points, nil
+	}
+	return named, auxiliary
+}
+
+func validateAuxiliaryHandling(mode string) error {
+	switch mode {
+	case "", "model", "interpolate", "freeze":
+		return nil
+	}
+	return fmt.Errorf("invalid auxiliary_handling %q, expected model, interpolate or freeze", mode)
+}
+
+// An auxiliary point's nearest named points and their blend weights
+type auxiliaryBlend struct {
+	ids     []int
+	weights []float64
+}
+
+// auxiliaryBlends finds, for every auxiliary point at rest, its k nearest
+// named points and weighs them by inverse distance raised to falloff. A
+// named point at the auxiliary point's own position takes all the weight.
+func auxiliaryBlends(named, auxiliary []ControlPoint, k int, falloff float64) map[int]auxiliaryBlend {
+	blends := make(map[int]auxiliaryBlend, len(auxiliary))
+	for _, a := range auxiliary {
+		if len(a.Position) < 3 {
+			continue
+		}
+		type candidate struct {
+			id       int
+			distance float64
+		}
+		var candidates []candidate
+		for _, n := range named {
+			if len(n.Position) < 3 {
+				continue
+			}
+			d := math.Hypot(math.Hypot(n.Position[0]-a.Position[0], n.Position[1]-a.Position[1]), n.Position[2]-a.Position[2])
+			candidates = append(candidates, candidate{n.ID, d})
+		}
+		slices.SortStableFunc(candidates, func(x, y candidate) int { return cmp.Compare(x.distance, y.distance) })
+		candidates = candidates[:min(k, len(candidates))]
+
+		var blend auxiliaryBlend
+		for _, c := range candidates {
+			if c.distance == 0 {
+				blend = auxiliaryBlend{ids: []int{c.id}, weights: []float64{1}}
+				break
+			}
+			blend.ids = append(blend.ids, c.id)
+			blend.weights = append(blend.weights, 1/math.Pow(c.distance, falloff))
+		}
+		if len(blend.ids) > 0 {
+			blends[a.ID] = blend
+		}
+	}
+	return blends
+}
+
+// auxiliaryIDs lists the auxiliary points' IDs in order
+func auxiliaryIDs(points []ControlPoint) []int {
+	_, auxiliary := splitAuxiliary(points)
+	var ids []int
+	for _, cp := range auxiliary {
+		ids = append(ids, cp.ID)
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// applyAuxiliaryHandling replaces the model's deltas for auxiliary points:
+// interpolate blends the deltas of their nearest named points in each
+// frame, freeze keeps them at rest, and model leaves them as they are
+func applyAuxiliaryHandling(frames ResponsePayload, mode string, rest []ControlPoint) ResponsePayload {
+	named, auxiliary := splitAuxiliary(rest)
+	if len(auxiliary) == 0 {
+		return frames
+	}
+	ids := auxiliaryIDs(rest)
+
+	switch mode {
+	case "freeze":
+		for _, frame := range frames {
+			for _, id := range ids {
+				if _, ok := frame[id]; ok {
+					frame[id] = Deformation{}
+				}
+			}
+		}
+	case "interpolate":
+		blends := auxiliaryBlends(named, auxiliary, cfg.AuxiliaryNeighbors, cfg.AuxiliaryFalloff)
+		for _, frame := range frames {
+			blended := make(map[int]Deformation, len(blends))
+			for id, blend := range blends {
+				if _, ok := frame[id]; !ok {
+					continue
+				}
+				var sum Deformation
+				total := 0.0
+				for i, n := range blend.ids {
+					d, ok := frame[n]
+					if !ok {
+						continue
+					}
+					w := blend.weights[i]
+					sum = Deformation{DeltaX: sum.DeltaX + w*d.DeltaX, DeltaY: sum.DeltaY + w*d.DeltaY, DeltaZ: sum.DeltaZ + w*d.DeltaZ}
+					total += w
+				}
+				if total > 0 {
+					blended[id] = scaleDelta(sum, 1/total)
+				}
+			}
+			// Written afterwards so no blend reads another blended point
+			for id, d := range blended {
+				frame[id] = d
+			}
+		}
+	}
+	return frames
+}
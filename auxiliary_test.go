@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestIsAuxiliaryRole(t *testing.T) {
+	for role, want := range map[string]bool{
+		"":          true,
+		"point":     true,
+		"aux_3":     true,
+		"ctrl 12":   true,
+		" Helper ":  true,
+		"7":         true,
+		"left hand": false,
+		"pointer":   false,
+		"head":      false,
+	} {
+		if got := isAuxiliaryRole(role); got != want {
+			t.Errorf("isAuxiliaryRole(%q) = %v, want %v", role, got, want)
+		}
+	}
+}
+
+func TestApplyAuxiliaryHandling(t *testing.T) {
+	setupServer(t, nil)
+	rig := []ControlPoint{
+		{ID: 0, Role: "left hand", Position: []float64{0, 0, 0}},
+		{ID: 1, Role: "right hand", Position: []float64{2, 0, 0}},
+		{ID: 2, Position: []float64{0.5, 0, 0}},
+		// On top of the left hand
+		{ID: 3, Role: "helper", Position: []float64{0, 0, 0}},
+	}
+	frames := func() ResponsePayload {
+		return ResponsePayload{{0: {DeltaY: 1}, 1: {}, 2: {DeltaX: 5}, 3: {DeltaX: 5}}}
+	}
+
+	// Point 2 is 0.5 from the left hand and 1.5 from the right, so weighs
+	// them 4 to 4/9
+	got := applyAuxiliaryHandling(frames(), "interpolate", rig)[0]
+	if want := (Deformation{DeltaY: 0.9}); !closeDelta(got[2], want) {
+		t.Errorf("point 2 at %+v, want %+v", got[2], want)
+	}
+	if want := (Deformation{DeltaY: 1}); got[3] != want {
+		t.Errorf("point 3 at %+v, want the left hand's %+v", got[3], want)
+	}
+	if got[0] != (Deformation{DeltaY: 1}) || got[1] != (Deformation{}) {
+		t.Errorf("named points changed to %+v and %+v", got[0], got[1])
+	}
+
+	got = applyAuxiliaryHandling(frames(), "freeze", rig)[0]
+	if got[2] != (Deformation{}) || got[3] != (Deformation{}) || got[0] != (Deformation{DeltaY: 1}) {
+		t.Errorf("frozen frame %+v, want the auxiliary points at rest", got)
+	}
+	if got := applyAuxiliaryHandling(frames(), "model", rig)[0]; got[2] != (Deformation{DeltaX: 5}) {
+		t.Errorf("model handling changed point 2 to %+v", got[2])
+	}
+
+	// With no named points there is nothing to follow
+	unnamed := slices.Clone(rig)
+	unnamed[0].Role, unnamed[1].Role = "", ""
+	if got := applyAuxiliaryHandling(frames(), "freeze", unnamed)[0]; got[2] != (Deformation{DeltaX: 5}) {
+		t.Errorf("a rig without named points froze point 2 at %+v", got[2])
+	}
+}
+
+func TestAuxiliaryHandlingOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	// The right hand rises 0.04 a frame and the helper beside it, on its
+	// own, 0.1
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		return framesResponse(input.Length, func(f int) map[string]Position {
+			frame := make(map[string]Position)
+			for _, cp := range append(input.ControlPoints, input.AuxiliaryPoints...) {
+				p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+				switch cp.ID {
+				case 2:
+					p.Y += 0.04 * float64(f)
+				case 5:
+					p.Y += 0.1 * float64(f)
+				}
+				frame[strconv.Itoa(cp.ID)] = p
+			}
+			return frame
+		}), nil
+	}
+	rig := append(testRig(), ControlPoint{ID: 5, Role: "aux_1", Position: []float64{-0.6, 1.25, 0}})
+	type response struct {
+		Frames []map[int]Deformation `json:"frames"`
+		Meta   generationMeta        `json:"meta"`
+	}
+	send := func(mode string) response {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "raise the right hand", Length: 4, AuxiliaryHandling: mode}}
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", mode, rec.Code, rec.Body)
+		}
+		return decodeBody[response](t, rec)
+	}
+
+	body := send("model")
+	if !slices.Equal(body.Meta.AuxiliaryPoints, []int{5}) {
+		t.Errorf("meta.auxiliary_points %v, want [5]", body.Meta.AuxiliaryPoints)
+	}
+	if got := body.Frames[3][5].DeltaY; got != 0.3 {
+		t.Errorf("model: helper rose %v, want the model's 0.3", got)
+	}
+	// The model sees the helper apart from the named points
+	input, err := modelInputOf(fake.requests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(input.ControlPoints) != 5 || len(input.AuxiliaryPoints) != 1 || input.AuxiliaryPoints[0].ID != 5 {
+		t.Errorf("model input has %d control points and auxiliary points %+v", len(input.ControlPoints), input.AuxiliaryPoints)
+	}
+
+	// Nearly all the weight is on the hand 0.05 away
+	if got := send("interpolate").Frames[3][5].DeltaY; got != 0.12 {
+		t.Errorf("interpolate: helper rose %v, want 0.12 with the hand", got)
+	}
+	if got := send("freeze").Frames[3][5]; got != (Deformation{}) {
+		t.Errorf("freeze: helper at %+v, want rest", got)
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "wave", Length: 4, AuxiliaryHandling: "follow"}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("auxiliary_handling follow: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A signed coordinate axis
+type axisDirection struct {
+	index int
+	sign  float64
+}
+
+var axisNames = [3]string{"x", "y", "z"}
+
+func parseAxis(s string) (axisDirection, bool) {
+	sign := 1.0
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		s, sign = rest, -1
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+	i := slices.Index(axisNames[:], s)
+	return axisDirection{index: i, sign: sign}, i >= 0
+}
+
+func (a axisDirection) String() string {
+	if a.sign < 0 {
+		return "-" + axisNames[a.index]
+	}
+	return axisNames[a.index]
+}
+
+// vector returns the axis as a unit vector
+func (a axisDirection) vector() [3]float64 {
+	var v [3]float64
+	v[a.index] = a.sign
+	return v
+}
+
+// of returns the coordinate of p along the axis
+func (a axisDirection) of(p []float64) float64 {
+	return a.sign * p[a.index]
+}
+
+func validateAxes(a *AxesConvention) error {
+	if a == nil {
+		return nil
+	}
+	up, ok := parseAxis(a.Up)
+	if !ok {
+		return fmt.Errorf("invalid axes.up %q, expected x, y or z with an optional sign", a.Up)
+	}
+	if a.Forward != "" {
+		forward, ok := parseAxis(a.Forward)
+		if !ok {
+			return fmt.Errorf("invalid axes.forward %q, expected x, y or z with an optional sign", a.Forward)
+		}
+		if forward.index == up.index {
+			return fmt.Errorf("axes.forward %s and axes.up %s must be different axes", a.Forward, a.Up)
+		}
+	}
+	switch a.Handedness {
+	case "", "right", "left":
+		return nil
+	}
+	return fmt.Errorf("invalid axes.handedness %q, expected right or left", a.Handedness)
+}
+
+func validateOnConventionMismatch(mode string) error {
+	switch mode {
+	case "", "warn", "reject":
+		return nil
+	}
+	return fmt.Errorf("invalid on_convention_mismatch %q, expected warn or reject", mode)
+}
+
+// upAxis returns the declared up axis, or y when none is declared
+func upAxis(a *AxesConvention) axisDirection {
+	if a == nil {
+		return axisDirection{index: 1, sign: 1}
+	}
+	up, _ := parseAxis(a.Up)
+	return up
+}
+
+// leftAxis returns the direction of the character's left: up × forward in
+// right-handed coordinates and forward × up in left-handed ones. It is
+// false without a declared forward axis.
+func leftAxis(a *AxesConvention) ([3]float64, bool) {
+	if a == nil || a.Forward == "" {
+		return [3]float64{}, false
+	}
+	forward, _ := parseAxis(a.Forward)
+	u, f := upAxis(a).vector(), forward.vector()
+	if a.Handedness == "left" {
+		u, f = f, u
+	}
+	return [3]float64{u[1]*f[2] - u[2]*f[1], u[2]*f[0] - u[0]*f[2], u[0]*f[1] - u[1]*f[0]}, true
+}
+
+// describeAxes renders the convention for the system prompt
+func describeAxes(a *AxesConvention) string {
+	text := fmt.Sprintf("Coordinates are %s-handed with %s up", handedness(a), strings.ToUpper(a.Up))
+	if a.Forward != "" {
+		text += fmt.Sprintf(" and the character facing %s", strings.ToUpper(a.Forward))
+	}
+	if left, ok := leftAxis(a); ok {
+		for i, c := range left {
+			if c != 0 {
+				text += ", so its left is " + strings.ToUpper(axisDirection{index: i, sign: c}.String())
+			}
+		}
+	}
+	return text + ". Up, down, forward, backward, left and right in the prompt refer to these directions."
+}
+
+func handedness(a *AxesConvention) string {
+	if a.Handedness == "" {
+		return "right"
+	}
+	return a.Handedness
+}
+
+// Thresholds of the convention check
+const (
+	// The tallest axis must exceed the next by this factor to tell which
+	// way a rig stands
+	uprightMargin = 1.25
+	// Feet must average below this fraction of the rig's height
+	maxFootHeight = 0.25
+)
+
+var (
+	footRole  = regexp.MustCompile(`\b(foot|feet|toe|toes|ankle|heel)\b`)
+	leftRole  = regexp.MustCompile(`\bleft\b`)
+	rightRole = regexp.MustCompile(`\bright\b`)
+)
+
+// checkAxes compares a declared convention with the rig at rest, taking it
+// to be a standing character. The declared up axis should be the one the
+// rig is tallest along, feet should sit at its low end, and with a declared
+// forward axis, left-side roles should lie on the character's left. Checks
+// the rig is too small or too ambiguous for are skipped. It returns a
+// description of every disagreement.
+func checkAxes(a *AxesConvention, points []ControlPoint) []string {
+	if a == nil {
+		return nil
+	}
+	var positioned []ControlPoint
+	for _, cp := range points {
+		if len(cp.Position) >= 3 {
+			positioned = append(positioned, cp)
+		}
+	}
+	if len(positioned) < 3 {
+		return nil
+	}
+
+	var spread [3]float64
+	for axis := range 3 {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, cp := range positioned {
+			lo, hi = math.Min(lo, cp.Position[axis]), math.Max(hi, cp.Position[axis])
+		}
+		spread[axis] = hi - lo
+	}
+	order := []int{0, 1, 2}
+	slices.SortFunc(order, func(i, j int) int { return cmp.Compare(spread[j], spread[i]) })
+	if spread[order[0]] == 0 {
+		return nil
+	}
+
+	up := upAxis(a)
+	var problems []string
+	if spread[order[0]] >= uprightMargin*spread[order[1]] && order[0] != up.index {
+		problems = append(problems, fmt.Sprintf("axes.up is %s but the rig is tallest along %s (%.3g against %.3g)",
+			a.Up, axisNames[order[0]], spread[order[0]], spread[up.index]))
+	}
+
+	if spread[up.index] >= minRigSpread*spread[order[0]] {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, cp := range positioned {
+			lo, hi = math.Min(lo, up.of(cp.Position)), math.Max(hi, up.of(cp.Position))
+		}
+		var feet []int
+		height := 0.0
+		for _, cp := range positioned {
+			if footRole.MatchString(strings.ToLower(cp.Role)) {
+				feet = append(feet, cp.ID)
+				height += (up.of(cp.Position) - lo) / (hi - lo)
+			}
+		}
+		if len(feet) > 0 && height/float64(len(feet)) > maxFootHeight {
+			problems = append(problems, fmt.Sprintf("the feet (control points %v) sit %.0f%% of the way up the declared up axis %s instead of at its low end",
+				feet, 100*height/float64(len(feet)), a.Up))
+		}
+	}
+
+	if left, ok := leftAxis(a); ok {
+		var sides [2][]float64
+		for _, cp := range positioned {
+			role := strings.ToLower(cp.Role)
+			lateral := left[0]*cp.Position[0] + left[1]*cp.Position[1] + left[2]*cp.Position[2]
+			switch {
+			case leftRole.MatchString(role) && !rightRole.MatchString(role):
+				sides[0] = append(sides[0], lateral)
+			case rightRole.MatchString(role) && !leftRole.MatchString(role):
+				sides[1] = append(sides[1], lateral)
+			}
+		}
+		if len(sides[0]) > 0 && len(sides[1]) > 0 && mean(sides[1])-mean(sides[0]) > minRigSpread*spread[order[0]] {
+			problems = append(problems, fmt.Sprintf("the left-side control points lie on the character's right for up %s, forward %s and %s-handed axes",
+				a.Up, a.Forward, handedness(a)))
+		}
+	}
+	return problems
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// conventionWarnings returns a warning for every disagreement between the
+// declared axes and the rig
+func conventionWarnings(payload RequestPayload) []string {
+	var warnings []string
+	for _, problem := range payload.conventionMismatches {
+		warnings = append(warnings, "convention_mismatch: "+problem)
+	}
+	return warnings
+}
+
+// conventionMismatchError rejects a request whose declared axes disagree
+// with its rig
+func conventionMismatchError(problems []string) *apiError {
+	return newAPIError(http.StatusBadRequest, "The declared axes do not match the rig: %s", strings.Join(problems, "; ")).
+		withCode("convention_mismatch").
+		withDetails(map[string][]string{"problems": problems})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestValidateAxes(t *testing.T) {
+	for _, a := range []*AxesConvention{
+		nil,
+		{Up: "y"},
+		{Up: "+z", Forward: "-y", Handedness: "left"},
+		{Up: "-x", Forward: "z", Handedness: "right"},
+	} {
+		if err := validateAxes(a); err != nil {
+			t.Errorf("%+v: %v", a, err)
+		}
+	}
+	for _, a := range []AxesConvention{
+		{},
+		{Up: "up"},
+		{Up: "y", Forward: "w"},
+		{Up: "y", Forward: "-y"},
+		{Up: "y", Handedness: "ambidextrous"},
+	} {
+		if err := validateAxes(&a); err == nil {
+			t.Errorf("%+v accepted", a)
+		}
+	}
+}
+
+func TestLeftAxis(t *testing.T) {
+	for _, tc := range []struct {
+		axes AxesConvention
+		want [3]float64
+	}{
+		{AxesConvention{Up: "y", Forward: "z"}, [3]float64{1, 0, 0}},
+		{AxesConvention{Up: "y", Forward: "-z"}, [3]float64{-1, 0, 0}},
+		{AxesConvention{Up: "y", Forward: "-z", Handedness: "left"}, [3]float64{1, 0, 0}},
+		{AxesConvention{Up: "z", Forward: "-y"}, [3]float64{1, 0, 0}},
+	} {
+		if got, ok := leftAxis(&tc.axes); !ok || got != tc.want {
+			t.Errorf("%+v: left is %v, want %v", tc.axes, got, tc.want)
+		}
+	}
+	if _, ok := leftAxis(&AxesConvention{Up: "y"}); ok {
+		t.Error("left found without a forward axis")
+	}
+
+	got := describeAxes(&AxesConvention{Up: "y", Forward: "-z"})
+	if !strings.HasPrefix(got, "Coordinates are right-handed with Y up and the character facing -Z, so its left is -X.") {
+		t.Errorf("described as %q", got)
+	}
+}
+
+func TestCheckAxes(t *testing.T) {
+	// testRig stands along y with its left hand and foot on +x
+	for _, a := range []*AxesConvention{nil, {Up: "y"}, {Up: "y", Forward: "z"}, {Up: "y", Forward: "-z", Handedness: "left"}} {
+		if problems := checkAxes(a, testRig()); problems != nil {
+			t.Errorf("%+v: problems %q", a, problems)
+		}
+	}
+	for want, a := range map[string]AxesConvention{
+		"tallest along y":              {Up: "z"},
+		"feet (control points [3 4]":   {Up: "-y"},
+		"lie on the character's right": {Up: "y", Forward: "-z"},
+	} {
+		problems := checkAxes(&a, testRig())
+		if len(problems) != 1 || !strings.Contains(problems[0], want) {
+			t.Errorf("%+v: problems %q, want one mentioning %q", a, problems, want)
+		}
+	}
+	// Too few points to judge
+	if problems := checkAxes(&AxesConvention{Up: "z"}, testRig()[:2]); problems != nil {
+		t.Errorf("two points reported %q", problems)
+	}
+}
+
+func TestAxesOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4, CacheMode: cacheFresh, Axes: &AxesConvention{Up: "y", Forward: "-z"}}}
+
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	warnings := decodeBody[ResponseEnvelope](t, rec).Meta.Warnings
+	if !slices.ContainsFunc(warnings, func(w string) bool { return strings.HasPrefix(w, "convention_mismatch: ") }) {
+		t.Errorf("warnings %q lack the mismatch", warnings)
+	}
+	if system := fake.requests[0].Messages[0].Content; !strings.Contains(system, describeAxes(payload.Axes)) {
+		t.Error("the model was not told the axes")
+	}
+
+	payload.OnConventionMismatch = "reject"
+	rec = serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	body := decodeBody[errorResponse](t, rec)
+	details, _ := body.Error.Details.(map[string]any)
+	if problems, _ := details["problems"].([]any); rec.Code != http.StatusBadRequest || body.Error.Code != "convention_mismatch" || len(problems) != 1 {
+		t.Errorf("status %d, error %+v, want 400 convention_mismatch with one problem", rec.Code, body.Error)
+	}
+
+	payload.OnConventionMismatch = "ignore"
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("on_convention_mismatch ignore: status %d, want 400", rec.Code)
+	}
+}
+
+func TestAxesUsedDownstream(t *testing.T) {
+	// A point on +x moving up along z
+	frames := ResponsePayload{{0: {DeltaZ: 1}}}
+	positions := map[int][]float64{0: {1, 0, 0}}
+	pivot := []float64{0, 0, 0}
+
+	// With y up the move turns about the pole; with z up it rises
+	yUp := toSpherical(frames, positions, pivot, upAxis(nil))[0][0]
+	if yUp.DeltaTheta != 0.7854 || yUp.DeltaPhi != 0 {
+		t.Errorf("y up: %+v, want theta 0.7854 and phi 0", yUp)
+	}
+	zUp := toSpherical(frames, positions, pivot, upAxis(&AxesConvention{Up: "z"}))[0][0]
+	if zUp.DeltaTheta != 0 || zUp.DeltaPhi != 0.7854 {
+		t.Errorf("z up: %+v, want theta 0 and phi 0.7854", zUp)
+	}
+
+	// infer_roles finds up and left from the axes: bareRig standing along
+	// z, facing -y
+	points := bareRig()
+	for i, cp := range points {
+		points[i].Position = []float64{cp.Position[0], 0, cp.Position[1]}
+	}
+	roles := inferRoles(points, &AxesConvention{Up: "z", Forward: "-y"})
+	if len(roles) != len(points) {
+		t.Fatalf("inferred %+v, want a role for every point", roles)
+	}
+	for i, want := range []string{"head", "left hand", "right hand", "left foot", "right foot", "pelvis", "spine"} {
+		if roles[i].Role != want {
+			t.Errorf("point %d inferred as %q, want %q", roles[i].ID, roles[i].Role, want)
+		}
+	}
+}
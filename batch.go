@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// A set of control points generated together in one model call
+type pointGroup struct {
+	Name   string
+	Points []ControlPoint
+}
+
+// partitioner splits a rig into groups of at most maxSize points. Points
+// that move together should end up in the same group.
+type partitioner interface {
+	partition(points []ControlPoint, maxSize int) []pointGroup
+}
+
+// Available partitioning strategies, selected by the batch_strategy setting
+var partitioners = map[string]partitioner{
+	"role":       rolePartitioner{},
+	"sequential": sequentialPartitioner{},
+}
+
+// planBatches returns a single group unless the rig exceeds the batching
+// threshold
+func planBatches(points []ControlPoint) []pointGroup {
+	if len(points) <= cfg.BatchThreshold {
+		return []pointGroup{{Name: "all", Points: points}}
+	}
+	return partitioners[cfg.BatchStrategy].partition(points, cfg.BatchSize)
+}
+
+// sequentialPartitioner chunks points in request order
+type sequentialPartitioner struct{}
+
+func (sequentialPartitioner) partition(points []ControlPoint, maxSize int) []pointGroup {
+	var groups []pointGroup
+	for start := 0; start < len(points); start += maxSize {
+		end := min(start+maxSize, len(points))
+		groups = append(groups, pointGroup{
+			Name:   fmt.Sprintf("points %d-%d", start, end-1),
+			Points: points[start:end],
+		})
+	}
+	return groups
+}
+
+// rolePartitioner keeps body-part families (e.g. "left arm") together and
+// packs whole families into groups, largest first. Families larger than a
+// group are split into chunks.
+type rolePartitioner struct{}
+
+// Role keywords for each body-part family, checked in order
+var roleFamilies = []struct {
+	family   string
+	keywords []string
+}{
+	{"head", []string{"head", "neck", "face", "jaw", "eye", "ear", "nose", "mouth", "brow", "lip"}},
+	{"arm", []string{"shoulder", "arm", "elbow", "wrist", "hand", "finger", "thumb", "clavicle"}},
+	{"leg", []string{"hip", "leg", "thigh", "knee", "shin", "calf", "ankle", "foot", "toe", "heel"}},
+	{"torso", []string{"spine", "chest", "torso", "pelvis", "waist", "back", "root", "body", "belly", "abdomen"}},
+	{"tail", []string{"tail"}},
+}
+
+// roleFamily maps a role such as "left upper arm" to its family, "left arm"
+func roleFamily(role string) string {
+	role = strings.ToLower(role)
+	family := "other"
+	for _, f := range roleFamilies {
+		for _, k := range f.keywords {
+			if strings.Contains(role, k) {
+				family = f.family
+				break
+			}
+		}
+		if family != "other" {
+			break
+		}
+	}
+	switch {
+	case strings.Contains(role, "left"):
+		return "left " + family
+	case strings.Contains(role, "right"):
+		return "right " + family
+	}
+	return family
+}
+
+func (rolePartitioner) partition(points []ControlPoint, maxSize int) []pointGroup {
+	families := make(map[string][]ControlPoint)
+	var names []string
+	for _, cp := range points {
+		f := roleFamily(cp.Role)
+		if _, ok := families[f]; !ok {
+			names = append(names, f)
+		}
+		families[f] = append(families[f], cp)
+	}
+
+	// Split oversized families, then pack chunks first-fit by decreasing size
+	type chunk struct {
+		name   string
+		points []ControlPoint
+	}
+	var chunks []chunk
+	for _, name := range names {
+		members := families[name]
+		for start := 0; start < len(members); start += maxSize {
+			chunks = append(chunks, chunk{name, members[start:min(start+maxSize, len(members))]})
+		}
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return len(chunks[i].points) > len(chunks[j].points) })
+
+	var groups []pointGroup
+	var groupNames [][]string
+	for _, c := range chunks {
+		placed := false
+		for i := range groups {
+			if len(groups[i].Points)+len(c.points) <= maxSize {
+				groups[i].Points = append(groups[i].Points, c.points...)
+				groupNames[i] = append(groupNames[i], c.name)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			groups = append(groups, pointGroup{Points: append([]ControlPoint(nil), c.points...)})
+			groupNames = append(groupNames, []string{c.name})
+		}
+	}
+	for i := range groups {
+		groups[i].Name = strings.Join(groupNames[i], ", ")
+	}
+	return groups
+}
+
+// Batched generation details reported in the response metadata
+type batchInfo struct {
+	Strategy string         `json:"strategy"`
+	Groups   []batchSummary `json:"groups"`
+}
+
+type batchSummary struct {
+	Name     string `json:"name"`
+	PointIDs []int  `json:"point_ids"`
+}
+
+// describeBatches lists the groups in the client's ID space
+func describeBatches(groups []pointGroup, idMap map[int]int) *batchInfo {
+	originals := reverseIDMap(idMap)
+	info := &batchInfo{Strategy: cfg.BatchStrategy}
+	for _, g := range groups {
+		summary := batchSummary{Name: g.Name}
+		for _, cp := range g.Points {
+			summary.PointIDs = append(summary.PointIDs, originals[cp.ID]...)
+		}
+		sort.Ints(summary.PointIDs)
+		info.Groups = append(info.Groups, summary)
+	}
+	return info
+}
+
+// generateBatched runs one model call per group concurrently, each seeing
+// the other groups at rest, and merges the results into one clip. Groups
+// that return a different frame count are resampled to the requested length.
+func generateBatched(ctx context.Context, client chatClient, payload RequestPayload, groups []pointGroup) (*modelCall, error) {
+	calls := make([]*modelCall, len(groups))
+	errs := make([]error, len(groups))
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		var contextPoints []ControlPoint
+		for j, other := range groups {
+			if j != i {
+				contextPoints = append(contextPoints, other.Points...)
+			}
+		}
+		groupPayload := payload
+		groupPayload.batch = &batchPosition{Index: i + 1, Count: len(groups), Name: group.Name}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer recoverAsError(ctx, &errs[i])
+			calls[i], errs[i] = requestFrames(ctx, client, groupPayload, group.Points, contextPoints)
+		}()
+	}
+	wg.Wait()
+
+	merged := &modelCall{Frames: make([]map[int]Position, payload.Length)}
+	for i := range merged.Frames {
+		merged.Frames[i] = make(map[int]Position)
+	}
+	affected := make(map[int]bool)
+	for i, call := range calls {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		merged.Usage = addUsage(merged.Usage, call.Usage)
+		merged.Content = append(merged.Content, call.Content...)
+		if merged.Model == "" {
+			merged.Model = call.Model
+		} else if !slices.Contains(strings.Split(merged.Model, ", "), call.Model) {
+			merged.Model += ", " + call.Model
+		}
+		if c := call.TokenConfidence; c != nil && (merged.TokenConfidence == nil || *c < *merged.TokenConfidence) {
+			merged.TokenConfidence = c
+		}
+
+		frames := call.Frames
+		if len(frames) != payload.Length {
+			frames = resamplePositions(frames, payload.Length, payload.Loop)
+		}
+		for _, cp := range groups[i].Points {
+			for f, frame := range frames {
+				if p, ok := frame[cp.ID]; ok {
+					merged.Frames[f][cp.ID] = p
+				}
+			}
+		}
+
+		// Combine annotations, keeping only each group's own points
+		a := call.Annotations
+		merged.Annotations.present = merged.Annotations.present || a.present
+		inGroup := make(map[int]bool, len(groups[i].Points))
+		for _, cp := range groups[i].Points {
+			inGroup[cp.ID] = true
+		}
+		for _, id := range a.AffectedPoints {
+			if inGroup[id] && !affected[id] {
+				affected[id] = true
+				merged.Annotations.AffectedPoints = append(merged.Annotations.AffectedPoints, id)
+			}
+		}
+		for id, c := range a.Confidence {
+			if inGroup[id] {
+				if merged.Annotations.Confidence == nil {
+					merged.Annotations.Confidence = make(map[int]float64)
+				}
+				merged.Annotations.Confidence[id] = c
+			}
+		}
+	}
+	return merged, nil
+}
+
+func addUsage(a, b openai.Usage) openai.Usage {
+	return openai.Usage{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+	}
+}
+
+// resamplePositions linearly resamples model frames onto count frames,
+// keeping the first and last frames (or the seam, for loops) in place
+func resamplePositions(frames []map[int]Position, count int, loop bool) []map[int]Position {
+	if len(frames) == 0 || count <= 0 {
+		return make([]map[int]Position, count)
+	}
+	step := float64(len(frames)) / float64(count)
+	if !loop && count > 1 {
+		step = float64(len(frames)-1) / float64(count-1)
+	}
+	at := func(i int) map[int]Position {
+		if loop {
+			return frames[i%len(frames)]
+		}
+		return frames[min(i, len(frames)-1)]
+	}
+	result := make([]map[int]Position, count)
+	for j := range result {
+		x := float64(j) * step
+		i := int(math.Floor(x))
+		t := x - float64(i)
+		a, b := at(i), at(i+1)
+		frame := make(map[int]Position, len(a))
+		for id, pa := range a {
+			pb, ok := b[id]
+			if !ok || t == 0 {
+				frame[id] = pa
+				continue
+			}
+			frame[id] = Position{
+				X: pa.X + (pb.X-pa.X)*t,
+				Y: pa.Y + (pb.Y-pa.Y)*t,
+				Z: pa.Z + (pb.Z-pa.Z)*t,
+			}
+		}
+		result[j] = frame
+	}
+	return result
+}
+
+// Where a batched call sits among its siblings, for the system prompt
+type batchPosition struct {
+	Index int
+	Count int
+	Name  string
+}
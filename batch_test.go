@@ -0,0 +1,191 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+// limbRig is a 14-point humanoid: a head family of two and four limb
+// families of three
+func limbRig() []ControlPoint {
+	roles := []string{
+		"head", "neck",
+		"left shoulder", "left elbow", "left hand",
+		"right shoulder", "right elbow", "right hand",
+		"left hip", "left knee", "left foot",
+		"right hip", "right knee", "right foot",
+	}
+	points := make([]ControlPoint, len(roles))
+	for i, role := range roles {
+		points[i] = ControlPoint{ID: 10 + i, Role: role, Position: []float64{float64(i%3) * 0.2, 1.8 - float64(i)*0.12, 0}}
+	}
+	return points
+}
+
+func groupIDs(groups []pointGroup) [][]int {
+	ids := make([][]int, len(groups))
+	for i, g := range groups {
+		for _, cp := range g.Points {
+			ids[i] = append(ids[i], cp.ID)
+		}
+	}
+	return ids
+}
+
+func TestRoleFamily(t *testing.T) {
+	for role, want := range map[string]string{
+		"Left Upper Arm": "left arm",
+		"right toe":      "right leg",
+		"jaw":            "head",
+		"pelvis":         "torso",
+		"tail tip":       "tail",
+		"left wing":      "left other",
+		"":               "other",
+	} {
+		if got := roleFamily(role); got != want {
+			t.Errorf("roleFamily(%q) = %q, want %q", role, got, want)
+		}
+	}
+}
+
+func TestPartitioners(t *testing.T) {
+	rig := limbRig()
+
+	groups := sequentialPartitioner{}.partition(rig, 6)
+	if got := groupIDs(groups); len(got) != 3 || len(got[0]) != 6 || len(got[2]) != 2 || got[1][0] != 16 {
+		t.Errorf("sequential groups %v, want chunks of 6 in request order", got)
+	}
+
+	// Whole families are packed first-fit, largest first
+	groups = rolePartitioner{}.partition(rig, 6)
+	want := []struct {
+		name string
+		ids  []int
+	}{
+		{"left arm, right arm", []int{12, 13, 14, 15, 16, 17}},
+		{"left leg, right leg", []int{18, 19, 20, 21, 22, 23}},
+		{"head", []int{10, 11}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("role groups %v, want %d groups", groupIDs(groups), len(want))
+	}
+	for i, w := range want {
+		if groups[i].Name != w.name || !slices.Equal(groupIDs(groups)[i], w.ids) {
+			t.Errorf("group %d: %q %v, want %q %v", i, groups[i].Name, groupIDs(groups)[i], w.name, w.ids)
+		}
+	}
+
+	// Families larger than a group are split
+	groups = rolePartitioner{}.partition(rig, 2)
+	seen := make(map[int]bool)
+	for _, g := range groups {
+		if len(g.Points) > 2 {
+			t.Errorf("group %q has %d points, over the limit of 2", g.Name, len(g.Points))
+		}
+		for _, cp := range g.Points {
+			if seen[cp.ID] {
+				t.Errorf("point %d is in more than one group", cp.ID)
+			}
+			seen[cp.ID] = true
+		}
+	}
+	if len(seen) != len(rig) {
+		t.Errorf("groups hold %d of %d points", len(seen), len(rig))
+	}
+}
+
+func TestResamplePositions(t *testing.T) {
+	frames := []map[int]Position{{1: {X: 0}}, {1: {X: 1}}, {1: {X: 4}}}
+	xs := func(frames []map[int]Position) []float64 {
+		var out []float64
+		for _, f := range frames {
+			out = append(out, f[1].X)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		name  string
+		count int
+		loop  bool
+		want  []float64
+	}{
+		// The first and last frames stay in place
+		{"stretch", 5, false, []float64{0, 0.5, 1, 2.5, 4}},
+		{"shrink", 2, false, []float64{0, 4}},
+		// Loops wrap back toward the first frame instead
+		{"loop", 6, true, []float64{0, 0.5, 1, 2.5, 4, 2}},
+	} {
+		if got := xs(resamplePositions(frames, tc.count, tc.loop)); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if got := resamplePositions(nil, 3, false); len(got) != 3 {
+		t.Errorf("no frames resampled to %d, want 3", len(got))
+	}
+}
+
+func TestBatchedGeneration(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.BatchThreshold = 10
+		c.BatchSize = 6
+	})
+	// The head group answers with two frames instead of five; the others sway
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		if !slices.ContainsFunc(input.ControlPoints, func(cp ControlPoint) bool { return cp.Role == "head" }) {
+			return swayResponse(req)
+		}
+		return framesResponse(2, func(f int) map[string]Position {
+			frame := make(map[string]Position)
+			for _, cp := range input.ControlPoints {
+				frame[strconv.Itoa(cp.ID)] = Position{X: cp.Position[0], Y: cp.Position[1] + 0.08*float64(f), Z: cp.Position[2]}
+			}
+			return frame
+		}), nil
+	}
+
+	rig := limbRig()
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "nod while swaying", Length: 5}}
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	body := decodeBody[struct {
+		Frames []map[string]Deformation `json:"frames"`
+		Meta   generationMeta           `json:"meta"`
+	}](t, rec)
+
+	if got := fake.calls(); got != 3 {
+		t.Errorf("upstream called %d times, want once per group", got)
+	}
+	if u := body.Meta.Usage; u == nil || u.TotalTokens != 3*150 {
+		t.Errorf("usage %+v, want the three calls summed", u)
+	}
+	b := body.Meta.Batching
+	if b == nil || b.Strategy != "role" || len(b.Groups) != 3 || !slices.Equal(b.Groups[2].PointIDs, []int{10, 11}) {
+		t.Fatalf("batching %+v, want three role groups with the head last", b)
+	}
+
+	// Every point is in every frame, and the head group's two frames were
+	// stretched over five
+	if len(body.Frames) != 5 {
+		t.Fatalf("%d frames, want 5", len(body.Frames))
+	}
+	for f, frame := range body.Frames {
+		if len(frame) != len(rig) {
+			t.Errorf("frame %d has %d points, want %d", f, len(frame), len(rig))
+		}
+		if got, want := frame["10"].DeltaY, 0.02*float64(f); math.Abs(got-want) > 1e-9 {
+			t.Errorf("frame %d: head delta_y %v, want %v", f, got, want)
+		}
+	}
+}
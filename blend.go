@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Weight of the secondary prompt when a request does not set one
+const defaultBlendWeight = 0.5
+
+// generateBlend generates the primary and secondary prompts concurrently and
+// mixes their deltas frame by frame: weight 0 is all primary, 1 all secondary
+func generateBlend(ctx context.Context, payload RequestPayload) (*generationResult, error) {
+	weight := payload.BlendWeight
+	if weight == 0 {
+		weight = defaultBlendWeight
+	}
+	if weight < 0 || weight > 1 {
+		return nil, newAPIError(http.StatusBadRequest, "blend_weight must be between 0 and 1")
+	}
+
+	primary, secondary := payload, payload
+	primary.SecondaryPrompt = ""
+	secondary.SecondaryPrompt = ""
+	secondary.Prompt = payload.SecondaryPrompt
+
+	var (
+		results [2]*generationResult
+		errs    [2]error
+		wg      sync.WaitGroup
+	)
+	for i, p := range []RequestPayload{primary, secondary} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer recoverAsError(ctx, &errs[i])
+			results[i], errs[i] = generate(ctx, p)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a, b := results[0], results[1]
+	blended := *a
+	blended.Frames = roundFrames(blendFrames(a.Frames, b.Frames, weight, payload.Loop), a.Places)
+	blended.Root = blendRoots(a.Root, b.Root, weight)
+	blended.Usage.PromptTokens += b.Usage.PromptTokens
+	blended.Usage.CompletionTokens += b.Usage.CompletionTokens
+	blended.Usage.TotalTokens += b.Usage.TotalTokens
+	for _, w := range b.Warnings {
+		blended.Warnings = append(blended.Warnings, fmt.Sprintf("secondary prompt: %s", w))
+	}
+	return &blended, nil
+}
+
+// blendFrames linearly combines two clips by weight. The secondary clip is
+// resampled first if the model returned a different number of frames.
+func blendFrames(primary, secondary ResponsePayload, weight float64, loop bool) ResponsePayload {
+	if len(secondary) == 0 {
+		return primary
+	}
+	if len(secondary) != len(primary) {
+		secondary = resampleFrames(secondary, len(primary), "linear", false, loop)
+	}
+	result := make(ResponsePayload, len(primary))
+	for i, frame := range primary {
+		mixed := make(map[int]Deformation, len(frame))
+		for id, d := range frame {
+			other, ok := secondary[i][id]
+			if !ok {
+				mixed[id] = d
+				continue
+			}
+			mixed[id] = lerpDelta(d, other, weight)
+		}
+		result[i] = mixed
+	}
+	return result
+}
+
+// generateRefinement generates the request as usual and then blends the
+// result toward the previous clip the client sent: blend_with_previous 0
+// keeps the new motion, 1 the previous one. Points only one of the clips
+// moves keep the new clip's deltas, and the root track is the new clip's.
+func generateRefinement(ctx context.Context, payload RequestPayload) (*generationResult, error) {
+	weight := payload.BlendWithPrevious
+	if weight < 0 || weight > 1 {
+		return nil, newAPIError(http.StatusBadRequest, "blend_with_previous must be between 0 and 1")
+	}
+	if len(payload.Previous) == 0 {
+		return nil, newAPIError(http.StatusBadRequest, "blend_with_previous needs the previous frames in previous")
+	}
+	// The previous clip is keyed like the responses it came from
+	previous := ResponsePayload(rebaseKeys(payload.Previous, -payload.IndexBase))
+
+	current := payload
+	current.Previous, current.BlendWithPrevious = nil, 0
+	result, err := generate(ctx, current)
+	if err != nil {
+		return nil, err
+	}
+	if len(previous) != len(result.Frames) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("The previous clip has %d frames; it was resampled to %d before blending", len(previous), len(result.Frames)))
+	}
+	refined := *result
+	refined.Frames = roundFrames(blendFrames(result.Frames, previous, weight, payload.Loop), result.Places)
+	return &refined, nil
+}
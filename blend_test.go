@@ -0,0 +1,175 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+// raiseResponse lifts the right hand (ID 2) by step per frame, more for
+// prompts that say "high"
+func raiseResponse(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	input, err := modelInputOf(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	step := 0.04
+	if strings.Contains(input.Prompt, "high") {
+		step = 0.2
+	}
+	return framesResponse(input.Length, func(f int) map[string]Position {
+		frame := make(map[string]Position, len(input.ControlPoints))
+		for _, cp := range input.ControlPoints {
+			p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+			if cp.ID == 2 {
+				p.Y += step * float64(f)
+			}
+			frame[strconv.Itoa(cp.ID)] = p
+		}
+		return frame
+	}), nil
+}
+
+func TestBlendFrames(t *testing.T) {
+	primary := ResponsePayload{{1: {DeltaX: 0}, 2: {DeltaY: 1}}, {1: {DeltaX: 1}, 2: {DeltaY: 1}}, {1: {DeltaX: 2}, 2: {DeltaY: 1}}}
+	// Two frames, stretched over three; point 2 is missing
+	secondary := ResponsePayload{{1: {DeltaX: 4}}, {1: {DeltaX: 8}}}
+
+	got := blendFrames(primary, secondary, 0.25, false)
+	for f, want := range []float64{1, 2.25, 3.5} {
+		if d := got[f][1].DeltaX; math.Abs(d-want) > 1e-9 {
+			t.Errorf("frame %d: delta_x %v, want %v", f, d, want)
+		}
+		// Points the secondary clip lacks keep the primary's motion
+		if got[f][2].DeltaY != 1 {
+			t.Errorf("frame %d: point 2 %+v, want the primary's", f, got[f][2])
+		}
+	}
+	if got := blendFrames(primary, nil, 0.5, false); got[2][1].DeltaX != 2 {
+		t.Errorf("blending with nothing changed the clip: %+v", got)
+	}
+}
+
+func TestBlendRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	for _, tc := range []struct {
+		weight float64
+		step   float64
+	}{
+		{0.25, 0.08},
+		// Unset, the prompts count equally
+		{0, 0.12},
+		{1, 0.2},
+	} {
+		payload := RequestPayload{RequestPayload: api.RequestPayload{
+			ControlPoints:   testRig(),
+			Prompt:          "raise the right hand",
+			SecondaryPrompt: "raise the right hand high",
+			BlendWeight:     tc.weight,
+			Length:          3,
+		}}
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("weight %v: status %d: %s", tc.weight, rec.Code, rec.Body)
+		}
+		body := decodeBody[struct {
+			Frames []map[string]Deformation `json:"frames"`
+			Meta   generationMeta           `json:"meta"`
+		}](t, rec)
+		for f, frame := range body.Frames {
+			if got, want := frame["2"].DeltaY, tc.step*float64(f); math.Abs(got-want) > 1e-9 {
+				t.Errorf("weight %v, frame %d: delta_y %v, want %v", tc.weight, f, got, want)
+			}
+		}
+		// Both generations are paid for
+		if u := body.Meta.Usage; u == nil || u.TotalTokens != 2*150 {
+			t.Errorf("weight %v: usage %+v, want both calls", tc.weight, u)
+		}
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", SecondaryPrompt: "bow", BlendWeight: 1.5, Length: 3}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("blend_weight 1.5: status %d, want 400", rec.Code)
+	}
+}
+
+func TestBlendWithPrevious(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	type response struct {
+		Frames []map[string]Deformation `json:"frames"`
+		Meta   generationMeta           `json:"meta"`
+	}
+	send := func(previous ResponsePayload, weight float64, indexBase int) response {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{
+			ControlPoints:     testRig(),
+			Prompt:            "raise the right hand",
+			Length:            3,
+			Previous:          previous,
+			BlendWithPrevious: weight,
+			IndexBase:         indexBase,
+		}}
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		return decodeBody[response](t, rec)
+	}
+	// The new clip raises the hand 0.04 a frame and the previous one 0.2,
+	// so a quarter of the new and three quarters of the previous rise 0.16
+	rises := func(body response, key string) bool {
+		for f, frame := range body.Frames {
+			if math.Abs(frame[key].DeltaY-0.16*float64(f)) > 1e-9 {
+				return false
+			}
+		}
+		return len(body.Frames) == 3
+	}
+	resampled := func(w string) bool { return strings.Contains(w, "resampled to 3 before blending") }
+
+	calls := fake.calls()
+	body := send(ResponsePayload{{2: {}}, {2: {DeltaY: 0.2}}, {2: {DeltaY: 0.4}}}, 0.75, 0)
+	if !rises(body, "2") || fake.calls() != calls+1 {
+		t.Errorf("hand at %v after %d calls, want a rise of 0.16 a frame from one", body.Frames, fake.calls()-calls)
+	}
+	// Points the previous clip lacks keep the new motion, and points only
+	// it has are left out
+	if body.Frames[2]["0"] != (Deformation{}) || len(body.Frames[2]) != 5 {
+		t.Errorf("last frame %v, want the new clip's points", body.Frames[2])
+	}
+	if slices.ContainsFunc(body.Meta.Warnings, resampled) {
+		t.Errorf("warnings %q for a previous clip of the same length", body.Meta.Warnings)
+	}
+
+	// A shorter previous clip is stretched to the new length
+	body = send(ResponsePayload{{2: {}}, {2: {DeltaY: 0.4}, 7: {DeltaX: 1}}}, 0.75, 0)
+	if !rises(body, "2") || !slices.ContainsFunc(body.Meta.Warnings, resampled) {
+		t.Errorf("hand at %v with warnings %q, want the previous clip resampled", body.Frames, body.Meta.Warnings)
+	}
+	// The previous clip is keyed like the response it came from
+	if body := send(ResponsePayload{{3: {}}, {3: {DeltaY: 0.2}}, {3: {DeltaY: 0.4}}}, 0.75, 1); !rises(body, "3") {
+		t.Errorf("index_base 1: hand at %v", body.Frames)
+	}
+
+	for _, tc := range []struct {
+		previous ResponsePayload
+		weight   float64
+	}{
+		{ResponsePayload{{2: {}}}, 1.5},
+		{ResponsePayload{{2: {}}}, -0.5},
+		{nil, 0.5},
+	} {
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 3, Previous: tc.previous, BlendWithPrevious: tc.weight}}
+		if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("blend_with_previous %v with %d previous frames: status %d, want 400", tc.weight, len(tc.previous), rec.Code)
+		}
+	}
+}
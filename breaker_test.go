@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newCircuitBreaker(3, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	expect := func(step string, state breakerState, allowed bool) {
+		t.Helper()
+		ok, _ := b.allow()
+		if ok != allowed || b.currentState() != state {
+			t.Fatalf("%s: allowed %v in state %s, want %v in %s", step, ok, b.currentState(), allowed, state)
+		}
+	}
+
+	// Failures below the threshold, or broken by a success, keep it closed
+	b.record(false)
+	b.record(false)
+	b.record(true)
+	b.record(false)
+	b.record(false)
+	expect("two failures after a success", breakerClosed, true)
+
+	b.record(false)
+	ok, wait := b.allow()
+	if ok || b.currentState() != breakerOpen || wait != 30*time.Second {
+		t.Fatalf("third failure: allowed %v, wait %s, state %s; want open for 30s", ok, wait, b.currentState())
+	}
+	now = now.Add(20 * time.Second)
+	if ok, wait := b.allow(); ok || wait != 10*time.Second {
+		t.Fatalf("during cooldown: allowed %v, wait %s; want 10s left", ok, wait)
+	}
+
+	// After the cooldown a single probe goes through
+	now = now.Add(10 * time.Second)
+	expect("first call after cooldown", breakerHalfOpen, true)
+	expect("second call while probing", breakerHalfOpen, false)
+
+	// A failed probe reopens it for a full cooldown
+	b.record(false)
+	expect("after a failed probe", breakerOpen, false)
+	now = now.Add(30 * time.Second)
+	expect("probe after the second cooldown", breakerHalfOpen, true)
+
+	// A successful probe closes it and clears the failure count
+	b.record(true)
+	expect("after a successful probe", breakerClosed, true)
+	b.record(false)
+	b.record(false)
+	expect("two failures after closing", breakerClosed, true)
+}
+
+func TestIsUpstreamFailure(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&openai.APIError{HTTPStatusCode: http.StatusInternalServerError}, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusBadRequest}, false},
+		{fmt.Errorf("request: %w", &openai.RequestError{HTTPStatusCode: http.StatusBadGateway}), true},
+		{&openai.RequestError{HTTPStatusCode: http.StatusUnauthorized}, false},
+		{context.Canceled, false},
+		{errors.New("connection reset by peer"), true},
+	} {
+		if got := isUpstreamFailure(tc.err); got != tc.want {
+			t.Errorf("isUpstreamFailure(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestBreakerFailsFast(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.BreakerThreshold = 2
+		c.MaxRetries = 0
+	})
+	fake.respond = func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		return openai.ChatCompletionResponse{}, &openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable, Message: "overloaded"}
+	}
+	for i, prompt := range []string{"wave", "bow", "nod"} {
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: prompt, Length: 4}}
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+		if i < 2 {
+			continue
+		}
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("open breaker: status %d, Retry-After %q; want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+		}
+		if code := decodeBody[errorResponse](t, rec).Error.Code; code != "upstream_unavailable" {
+			t.Errorf("error code %q, want upstream_unavailable", code)
+		}
+	}
+	if got := fake.calls(); got != 2 {
+		t.Errorf("upstream called %d times, want 2: the open breaker let a call through", got)
+	}
+}
+
+func TestFallbackModels(t *testing.T) {
+	setupServer(t, func(c *Config) { c.ModelFallbacks = []string{"gpt-b", "gpt-a", "gpt-c", "gpt-b"} })
+	if got := fallbackModels("gpt-a"); !slices.Equal(got, []string{"gpt-b", "gpt-c"}) {
+		t.Errorf("fallbacks %v, want [gpt-b gpt-c] without the requested model or repeats", got)
+	}
+	setupServer(t, nil)
+	if got := fallbackModels("gpt-a"); got != nil {
+		t.Errorf("fallbacks %v without any configured", got)
+	}
+}
+
+func TestModelFallbackChain(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.DefaultModel = "gpt-a"
+		c.ModelFallbacks = []string{"gpt-b", "gpt-c"}
+		c.MaxRetries = 1
+		c.RetryBackoff = Duration{time.Millisecond}
+		c.BreakerThreshold = 100
+	})
+	// Models that fail, and how
+	failing := map[string]int{"gpt-a": http.StatusServiceUnavailable, "gpt-b": http.StatusTooManyRequests}
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		if status, ok := failing[req.Model]; ok {
+			return openai.ChatCompletionResponse{}, &openai.APIError{HTTPStatusCode: status, Message: "unavailable"}
+		}
+		return swayResponse(req)
+	}
+	modelsTried := func(from int) []string {
+		var models []string
+		for _, req := range fake.requests[from:] {
+			models = append(models, req.Model)
+		}
+		return models
+	}
+	fallbacks := func(model string) float64 {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		return metrics.counters["upstream_fallbacks_total"][formatLabel("model", model)]
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4}}
+
+	// Each failure moves on at once, without retrying the failed model
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Served-Model") != "gpt-c" {
+		t.Fatalf("status %d served by %q, want 200 from gpt-c: %s", rec.Code, rec.Header().Get("X-Served-Model"), rec.Body)
+	}
+	if got := modelsTried(0); !slices.Equal(got, []string{"gpt-a", "gpt-b", "gpt-c"}) {
+		t.Errorf("models tried %v, want each once in order", got)
+	}
+	if fallbacks("gpt-b") != 1 || fallbacks("gpt-c") != 1 {
+		t.Errorf("fallbacks counted %v to gpt-b and %v to gpt-c, want 1 each", fallbacks("gpt-b"), fallbacks("gpt-c"))
+	}
+	hash := decodeBody[ResponseEnvelope](t, rec).Meta.GenerationHash
+	record := decodeBody[GenerationRecord](t, serve(t, http.MethodGet, "/generations/"+hash, nil, nil))
+	if record.Model.Model != "gpt-a" || record.Model.ServedModel != "gpt-c" {
+		t.Errorf("stored model %+v, want gpt-a served by gpt-c", record.Model)
+	}
+
+	// Retries apply to the last model in the chain
+	failing["gpt-c"] = http.StatusBadGateway
+	from := fake.calls()
+	payload.Prompt = "bow"
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusInternalServerError {
+		t.Errorf("every model failing: status %d, want 500", rec.Code)
+	}
+	if got := modelsTried(from); !slices.Equal(got, []string{"gpt-a", "gpt-b", "gpt-c", "gpt-c"}) {
+		t.Errorf("models tried %v, want the chain then one retry of gpt-c", got)
+	}
+
+	// Errors that are not about availability end the request
+	failing["gpt-a"] = http.StatusBadRequest
+	from = fake.calls()
+	payload.Prompt = "nod"
+	serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if got := modelsTried(from); !slices.Equal(got, []string{"gpt-a"}) {
+		t.Errorf("models tried %v after a 400, want only gpt-a", got)
+	}
+}
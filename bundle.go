@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Formats a bundle can hold, in archive order, with their file names
+var bundleMembers = []struct {
+	format, file string
+}{
+	{"json", "frames.json"},
+	{"csv", "frames.csv"},
+	{"unity", "unity.json"},
+	{"unreal_curves", "unreal_curves.json"},
+}
+
+// parseBundleFormats reads ?formats=, a comma-separated list of the formats
+// to bundle; empty selects all of them. They come back in archive order.
+func parseBundleFormats(value string) ([]string, error) {
+	var known []string
+	for _, m := range bundleMembers {
+		known = append(known, m.format)
+	}
+	if strings.TrimSpace(value) == "" {
+		return known, nil
+	}
+	requested := strings.Split(value, ",")
+	for i, name := range requested {
+		requested[i] = strings.TrimSpace(name)
+		if !slices.Contains(known, requested[i]) {
+			return nil, fmt.Errorf("invalid bundle format %q, expected some of %s", requested[i], strings.Join(known, ", "))
+		}
+	}
+	var formats []string
+	for _, name := range known {
+		if slices.Contains(requested, name) {
+			formats = append(formats, name)
+		}
+	}
+	return formats, nil
+}
+
+// bundleFileName suggests an archive name from the prompt: its first words
+// in lowercase ASCII, joined by dashes
+func bundleFileName(prompt string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(prompt) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if b.Len() >= 48 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "animation.zip"
+	}
+	return b.String() + ".zip"
+}
+
+// A file in the bundle, as listed in manifest.json
+type bundleFile struct {
+	Name   string `json:"name"`
+	Format string `json:"format,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type bundleManifest struct {
+	GenerationHash string          `json:"generation_hash,omitempty"`
+	Prompt         string          `json:"prompt"`
+	APIVersion     int             `json:"api_version"`
+	Files          []bundleFile    `json:"files"`
+	Meta           *generationMeta `json:"meta"`
+}
+
+// Everything a bundle is built from
+type bundleContents struct {
+	formats []string
+	// Body of the JSON response, as /generate-deformations would return it
+	response any
+	// Rendered frames before rebasing, for the CSV writer
+	frames  any
+	layout  string
+	result  *generationResult
+	fps     float64
+	payload RequestPayload
+	meta    *generationMeta
+	version int
+}
+
+// writeBundle streams a zip archive of the selected formats, the request
+// that produced them and a manifest with every file's SHA-256. Each file is
+// encoded straight into the archive. The formats are checked before the
+// response starts, so encoding only fails when writing does; the connection
+// is then aborted, which the client sees as a failed transfer rather than a
+// truncated archive.
+func writeBundle(w http.ResponseWriter, c bundleContents) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bundleFileName(c.payload.Prompt)))
+	w.Header().Set("X-API-Version", fmt.Sprint(c.version))
+
+	archive := zip.NewWriter(w)
+	var files []bundleFile
+	add := func(name, format string, encode func(io.Writer) error) {
+		f, err := archive.Create(name)
+		if err == nil {
+			hash := sha256.New()
+			counter := &countingWriter{w: io.MultiWriter(f, hash)}
+			if err = encode(counter); err == nil {
+				files = append(files, bundleFile{Name: name, Format: format, Size: counter.n, SHA256: hex.EncodeToString(hash.Sum(nil))})
+				return
+			}
+		}
+		log.Printf("Failed to write %s to export bundle: %v", name, err)
+		panic(http.ErrAbortHandler)
+	}
+	encodeJSON := func(v any) func(io.Writer) error {
+		return func(out io.Writer) error {
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(v)
+		}
+	}
+
+	for _, m := range bundleMembers {
+		if !slices.Contains(c.formats, m.format) {
+			continue
+		}
+		switch m.format {
+		case "json":
+			add(m.file, m.format, encodeJSON(c.response))
+		case "csv":
+			add(m.file, m.format, func(out io.Writer) error {
+				return writeFramesCSV(out, c.frames, c.layout, c.payload.IndexBase)
+			})
+		case "unity":
+			add(m.file, m.format, encodeJSON(encodeUnityClip(c.result.Frames, c.result.Roles, c.fps, c.payload.Loop)))
+		case "unreal_curves":
+			add(m.file, m.format, encodeJSON(encodeUnrealCurves(c.result.Frames, c.result.Positions, c.result.Roles, c.fps)))
+		}
+	}
+	add("request.json", "", encodeJSON(c.payload))
+
+	manifest := bundleManifest{
+		GenerationHash: c.result.Hash,
+		Prompt:         c.payload.Prompt,
+		APIVersion:     c.version,
+		Files:          files,
+		Meta:           c.meta,
+	}
+	add("manifest.json", "", encodeJSON(manifest))
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to finish export bundle: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
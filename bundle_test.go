@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// unzip returns the files of an archive by name, and their order
+func unzip(t *testing.T, body []byte) (map[string][]byte, []string) {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("not a zip archive: %v", err)
+	}
+	files := make(map[string][]byte)
+	var names []string
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = content
+		names = append(names, f.Name)
+	}
+	return files, names
+}
+
+func TestBundle(t *testing.T) {
+	setupServer(t, nil)
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "Wave the LEFT hand!", Length: 4}}
+
+	rec := serve(t, http.MethodPost, "/generate-deformations?format=bundle&formats=csv,json&include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/zip" {
+		t.Errorf("Content-Type %q, want application/zip", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="wave-the-left-hand.zip"` {
+		t.Errorf("Content-Disposition %q", got)
+	}
+	files, names := unzip(t, rec.Body.Bytes())
+	if want := []string{"frames.json", "frames.csv", "request.json", "manifest.json"}; !slices.Equal(names, want) {
+		t.Fatalf("archive holds %v, want %v", names, want)
+	}
+
+	// The members match what the single formats return
+	for file, target := range map[string]string{
+		"frames.json": "/generate-deformations?include_meta=true",
+		"frames.csv":  "/generate-deformations?format=csv",
+	} {
+		single := serve(t, http.MethodPost, target, payload, nil).Body.Bytes()
+		if file == "frames.json" {
+			// The bundle's JSON is indented, and the cache status differs
+			var bundled, direct ResponseEnvelope
+			if err := json.Unmarshal(files[file], &bundled); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(single, &direct); err != nil {
+				t.Fatal(err)
+			}
+			a, _ := json.Marshal(bundled.Frames)
+			b, _ := json.Marshal(direct.Frames)
+			if !bytes.Equal(a, b) || bundled.Meta == nil {
+				t.Errorf("frames.json holds %s, want the frames %s with meta", a, b)
+			}
+			continue
+		}
+		if !bytes.Equal(files[file], single) {
+			t.Errorf("%s differs from %s:\n%s\nwant\n%s", file, target, files[file], single)
+		}
+	}
+	var request RequestPayload
+	if err := json.Unmarshal(files["request.json"], &request); err != nil || request.Prompt != payload.Prompt {
+		t.Errorf("request.json holds %s (%v)", files["request.json"], err)
+	}
+
+	var manifest bundleManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Prompt != payload.Prompt || manifest.APIVersion != 1 || manifest.GenerationHash == "" || manifest.Meta == nil {
+		t.Errorf("manifest %+v", manifest)
+	}
+	if len(manifest.Files) != 3 {
+		t.Fatalf("manifest lists %d files, want every file but itself", len(manifest.Files))
+	}
+	for _, f := range manifest.Files {
+		sum := sha256.Sum256(files[f.Name])
+		if f.Size != int64(len(files[f.Name])) || f.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("manifest entry %+v does not match the file", f)
+		}
+	}
+
+	t.Run("all formats", func(t *testing.T) {
+		rec := serve(t, http.MethodPost, "/generate-deformations?format=bundle", payload, nil)
+		_, names := unzip(t, rec.Body.Bytes())
+		want := []string{"frames.json", "frames.csv", "unity.json", "unreal_curves.json", "request.json", "manifest.json"}
+		if !slices.Equal(names, want) {
+			t.Errorf("archive holds %v, want %v", names, want)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, target := range map[string]string{
+			"unknown format":      "/generate-deformations?format=bundle&formats=json,bvh",
+			"spherical for unity": "/generate-deformations?format=bundle&formats=unity",
+		} {
+			body := payload
+			if name == "spherical for unity" {
+				body.OutputCoords = "spherical"
+			}
+			if rec := serve(t, http.MethodPost, target, body, nil); rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") == "application/zip" {
+				t.Errorf("%s: status %d with %s, want a 400 error", name, rec.Code, rec.Header().Get("Content-Type"))
+			}
+		}
+	})
+}
+
+func TestBundleFileName(t *testing.T) {
+	for prompt, want := range map[string]string{
+		"Wave the LEFT hand!": "wave-the-left-hand.zip",
+		"  jump -- twice  ":   "jump-twice.zip",
+		"跳ぶ":                  "animation.zip",
+		"":                    "animation.zip",
+		"naïve spin, 360°":    "na-ve-spin-360.zip",
+	} {
+		if got := bundleFileName(prompt); got != want {
+			t.Errorf("bundleFileName(%q) = %q, want %q", prompt, got, want)
+		}
+	}
+}
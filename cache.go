@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How a request may use the response cache
+const (
+	cacheFresh    = "fresh"     // always generate, then store
+	cacheCachedOK = "cached_ok" // serve unexpired entries (default)
+	cacheStaleOK  = "stale_ok"  // also serve expired entries, refreshing in the background
+)
+
+func validateCacheMode(mode string) error {
+	switch mode {
+	case "", cacheFresh, cacheCachedOK, cacheStaleOK:
+		return nil
+	}
+	return fmt.Errorf("invalid cache_mode %q, expected fresh, cached_ok or stale_ok", mode)
+}
+
+// Cache outcome reported in the response metadata
+type cacheStatus struct {
+	Status     string  `json:"status"`
+	Stale      bool    `json:"stale,omitempty"`
+	AgeSeconds float64 `json:"age_seconds,omitempty"`
+}
+
+type cacheEntry struct {
+	result   *generationResult
+	storedAt time.Time
+}
+
+// In-memory cache of generation results keyed by request. Entries are served
+// as fresh for ttl and kept as stale for maxStale after that. The cache owns
+// its entries: all access goes through its methods, under mu, and callers
+// get copies of the results they may modify.
+type resultCache struct {
+	mu         sync.Mutex
+	entries    map[string]*cacheEntry
+	maxEntries int
+	ttl        time.Duration
+	maxStale   time.Duration
+
+	// Background refreshes in flight, by key, and the slots bounding them
+	refreshing map[string]bool
+	slots      chan struct{}
+}
+
+func newResultCache(maxEntries int, ttl, maxStale time.Duration, maxRefreshes int) *resultCache {
+	return &resultCache{
+		entries:    make(map[string]*cacheEntry),
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		maxStale:   maxStale,
+		refreshing: make(map[string]bool),
+		slots:      make(chan struct{}, maxRefreshes),
+	}
+}
+
+var responses = newResultCache(cfg.CacheMaxEntries, cfg.CacheTTL.Duration, cfg.CacheMaxStale.Duration, cfg.CacheMaxRefreshes)
+
+// cacheKey hashes everything in the request that affects the result, and
+// the scope of callers allowed to share it: "" for everyone, or the client
+// key of the only caller who may see it
+func cacheKey(payload RequestPayload, scope string) (string, error) {
+	// Output-only options that do not change the generation
+	payload.CacheMode = ""
+	payload.IndexBase = 0
+	payload.Encoding = ""
+	// Equivalent inputs listed in another order generate identically
+	payload.ControlPoints = remapOrder(payload.ControlPoints)
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	if scope != "" {
+		raw = append(append(raw, 0), scope...)
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cacheScope returns who may share the cached results of a profile: every
+// caller of an open profile, but only the caller itself of one restricted to
+// allowed_keys, so access is never granted by a cache hit
+func cacheScope(ctx context.Context, profile UpstreamProfile) string {
+	if len(profile.AllowedKeys) == 0 {
+		return ""
+	}
+	return clientKeyFrom(ctx)
+}
+
+// get returns the entry for key and its age, dropping it once it is too old
+// to be served even as stale
+func (c *resultCache) get(key string) (*cacheEntry, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, 0
+	}
+	age := time.Since(entry.storedAt)
+	if age > c.ttl+c.maxStale {
+		delete(c.entries, key)
+		countEvictions("cache", 1)
+		return nil, 0
+	}
+	return entry, age
+}
+
+func (c *resultCache) put(key string, result *generationResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &cacheEntry{result: result, storedAt: time.Now()}
+	for len(c.entries) > c.maxEntries {
+		var oldestKey string
+		var oldest time.Time
+		for k, e := range c.entries {
+			if oldestKey == "" || e.storedAt.Before(oldest) {
+				oldestKey, oldest = k, e.storedAt
+			}
+		}
+		delete(c.entries, oldestKey)
+		countEvictions("cache", 1)
+	}
+}
+
+// sweep drops the entries too old to be served even as stale
+func (c *resultCache) sweep(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for key, entry := range c.entries {
+		if now.Sub(entry.storedAt) > c.ttl+c.maxStale {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	countEvictions("cache", removed)
+	return removed
+}
+
+func (c *resultCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// refresh regenerates key in the background unless a refresh for it is
+// already running or every refresh slot is busy. It runs as the client that
+// asked, so profiles restricted to that client still resolve, and reports
+// panics against the request that triggered it. Failures, panics included,
+// are only logged.
+func (c *resultCache) refresh(ctx context.Context, key string, payload RequestPayload) {
+	c.mu.Lock()
+	if c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	select {
+	case c.slots <- struct{}{}:
+	default:
+		c.mu.Unlock()
+		log.Printf("Skipping background cache refresh, %d already running", cap(c.slots))
+		incCounter("cache_refreshes_total", "outcome", "skipped", 1)
+		return
+	}
+	c.refreshing[key] = true
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+			<-c.slots
+		}()
+		refreshCtx := withRequestInfo(withClientKey(context.Background(), clientKeyFrom(ctx)), requestInfoFrom(ctx))
+		result, err := func() (result *generationResult, err error) {
+			defer recoverAsError(refreshCtx, &err)
+			return generate(refreshCtx, payload)
+		}()
+		if err != nil {
+			log.Printf("Background cache refresh failed: %v", err)
+			incCounter("cache_refreshes_total", "outcome", "failed", 1)
+			return
+		}
+		c.put(key, result)
+		incCounter("cache_refreshes_total", "outcome", "succeeded", 1)
+	}()
+}
+
+// generateCached serves a request through the response cache according to
+// its cache_mode
+func generateCached(ctx context.Context, payload RequestPayload) (*generationResult, *cacheStatus, error) {
+	if err := validateCacheMode(payload.CacheMode); err != nil {
+		return nil, nil, newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	// A caller may only be served results of profiles open to it
+	profile, err := resolveProfile(ctx, payload.Profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Generations on the caller's own OpenAI key are never shared, and
+	// could not be refreshed once the request and its key are gone
+	if upstreamKeyFrom(ctx) != "" {
+		result, err := generate(ctx, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		incCounter("cache_requests_total", "status", "bypass", 1)
+		return result, &cacheStatus{Status: "bypass"}, nil
+	}
+	key, err := cacheKey(payload, cacheScope(ctx, profile))
+	if err != nil {
+		return nil, nil, newAPIError(http.StatusInternalServerError, "Failed to hash request")
+	}
+
+	if payload.CacheMode != cacheFresh {
+		if entry, age := responses.get(key); entry != nil {
+			stale := age > responses.ttl
+			if !stale || payload.CacheMode == cacheStaleOK {
+				status := &cacheStatus{Status: "hit", AgeSeconds: age.Round(time.Millisecond).Seconds()}
+				if stale {
+					status.Status, status.Stale = "stale", true
+					responses.refresh(ctx, key, payload)
+				}
+				incCounter("cache_requests_total", "status", status.Status, 1)
+
+				// Hand out a copy so per-request post-processing can't touch the entry
+				result := *entry.result
+				result.Usage = usageReport{}
+				return &result, status, nil
+			}
+		}
+	}
+
+	result, err := generate(ctx, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	responses.put(key, result)
+	status := &cacheStatus{Status: "miss"}
+	if payload.CacheMode == cacheFresh {
+		status.Status = "bypass"
+	}
+	incCounter("cache_requests_total", "status", status.Status, 1)
+	copied := *result
+	return &copied, status, nil
+}
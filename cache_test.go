@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestCacheRestrictedProfile(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.UpstreamProfiles = []UpstreamProfile{{Name: "studio", APIKey: "studio-key", AllowedKeys: []string{"alice", "bob"}}}
+	})
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4, Profile: "studio"}}
+	as := func(key string) http.Header { return http.Header{"X-Api-Key": {key}} }
+
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, as("alice")); rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: status %d, X-Cache %q: %s", rec.Code, rec.Header().Get("X-Cache"), rec.Body)
+	}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, as("alice")); rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("repeated request: X-Cache %q, want HIT", rec.Header().Get("X-Cache"))
+	}
+
+	// A cached result must not grant access to the profile
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, as("mallory"))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("caller outside allowed_keys: status %d, want 403: %s", rec.Code, rec.Body)
+	}
+	if body := decodeBody[errorResponse](t, rec); body.Error.Code != "profile_forbidden" {
+		t.Errorf("error code %q, want profile_forbidden", body.Error.Code)
+	}
+
+	// Nor is one allowed caller served another's result
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, as("bob")); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("second allowed caller: X-Cache %q, want MISS", rec.Header().Get("X-Cache"))
+	}
+	if got := fake.calls(); got != 2 {
+		t.Errorf("upstream called %d times, want 2", got)
+	}
+}
+
+func TestCacheStaleRefreshKeepsCaller(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.UpstreamProfiles = []UpstreamProfile{{Name: "studio", APIKey: "studio-key", AllowedKeys: []string{"alice"}}}
+		c.CacheTTL = Duration{time.Millisecond}
+		c.CacheMaxStale = Duration{time.Hour}
+	})
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4, Profile: "studio", CacheMode: cacheStaleOK}}
+	alice := http.Header{"X-Api-Key": {"alice"}}
+
+	serve(t, http.MethodPost, "/generate-deformations", payload, alice)
+	key := mustCacheKey(t, payload, hashClientKey("alice"))
+	first, _ := responses.get(key)
+	if first == nil {
+		t.Fatal("result was not cached under alice's scope")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, alice); rec.Header().Get("X-Cache") != "STALE" {
+		t.Fatalf("expired entry: X-Cache %q, want STALE: %s", rec.Header().Get("X-Cache"), rec.Body)
+	}
+
+	// The refresh resolves the restricted profile as alice and replaces the
+	// entry
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if entry, _ := responses.get(key); entry != nil && entry.storedAt.After(first.storedAt) {
+			if got := fake.calls(); got != 2 {
+				t.Errorf("upstream called %d times, want 2", got)
+			}
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("background refresh did not store a new entry")
+}
+
+func mustCacheKey(t *testing.T, payload RequestPayload, scope string) string {
+	t.Helper()
+	key, err := cacheKey(payload, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// ageCache makes every cached entry older by d
+func ageCache(d time.Duration) {
+	responses.mu.Lock()
+	defer responses.mu.Unlock()
+	for _, e := range responses.entries {
+		e.storedAt = e.storedAt.Add(-d)
+	}
+}
+
+func TestCacheModes(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.CacheTTL = Duration{time.Minute}
+		c.CacheMaxStale = Duration{time.Hour}
+	})
+	send := func(step, mode, want string) {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4, CacheMode: mode}}
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+		if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != want {
+			t.Fatalf("%s: status %d, X-Cache %q; want %s", step, rec.Code, rec.Header().Get("X-Cache"), want)
+		}
+	}
+	calls := func(step string, want int) {
+		t.Helper()
+		waitForBackgroundWork()
+		if got := fake.calls(); got != want {
+			t.Fatalf("%s: upstream called %d times, want %d", step, got, want)
+		}
+	}
+
+	send("first request", "", "MISS")
+	send("repeat", cacheCachedOK, "HIT")
+	// The cache mode is not part of the key, so fresh replaces the entry
+	send("fresh", cacheFresh, "BYPASS")
+	calls("fresh", 2)
+	send("after fresh", "", "HIT")
+
+	// Past the TTL only stale_ok is served the entry, and it is refreshed
+	ageCache(2 * time.Minute)
+	send("expired, stale_ok", cacheStaleOK, "STALE")
+	calls("background refresh", 3)
+	send("after the refresh", "", "HIT")
+	ageCache(2 * time.Minute)
+	send("expired, cached_ok", cacheCachedOK, "MISS")
+	calls("expired, cached_ok", 4)
+
+	// Past the stale window not even stale_ok is served, and the entry goes
+	ageCache(2 * time.Hour)
+	send("too old, stale_ok", cacheStaleOK, "MISS")
+	calls("too old", 5)
+	if got := responses.size(); got != 1 {
+		t.Errorf("%d entries cached, want the replacement only", got)
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4, CacheMode: "always"}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown cache_mode: status %d, want 400", rec.Code)
+	}
+}
+
+func TestResultCacheLimits(t *testing.T) {
+	c := newResultCache(2, time.Minute, time.Minute, 1)
+	for _, key := range []string{"a", "b", "c"} {
+		c.put(key, &generationResult{})
+		time.Sleep(time.Millisecond)
+	}
+	// The oldest entry makes room for the newest
+	if entry, _ := c.get("a"); entry != nil || c.size() != 2 {
+		t.Errorf("%d entries, a cached %v; want the oldest evicted", c.size(), entry != nil)
+	}
+	if removed := c.sweep(time.Now().Add(time.Minute + 30*time.Second)); removed != 0 {
+		t.Errorf("sweep within the stale window removed %d entries", removed)
+	}
+	if removed := c.sweep(time.Now().Add(3 * time.Minute)); removed != 2 || c.size() != 0 {
+		t.Errorf("sweep past the stale window removed %d, left %d", removed, c.size())
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import "fmt"
+
+// Control point categories. Facial and prop points move on very different
+// scales from the body, so each category has its own motion budget,
+// rounding precision and weight in the smoothness score.
+const (
+	categoryBody = "body"
+	categoryFace = "face"
+	categoryProp = "prop"
+)
+
+type categoryProfile struct {
+	// Motion budget as a fraction of the character's height; 0 uses the
+	// role-based budget
+	budgetFraction float64
+	// Decimal places kept in the output deltas
+	places int
+	// Weight of the category's jerk when scoring candidates, so small
+	// motions are judged on their own scale
+	jerkWeight float64
+	// Guidance for the model when the rig has points of this category
+	guidance string
+}
+
+var categoryProfiles = map[string]categoryProfile{
+	categoryBody: {places: 2, jerkWeight: 1},
+	categoryFace: {
+		budgetFraction: 0.01,
+		places:         4,
+		jerkWeight:     25,
+		guidance:       "Control points with category \"face\" (brows, eyelids, jaw, lips) move on a much smaller scale than the body: keep their motion subtle and within their small motion budgets, but do animate them when the prompt calls for an expression or speech.",
+	},
+	categoryProp: {
+		budgetFraction: 0.5,
+		places:         2,
+		jerkWeight:     0.5,
+		guidance:       "Control points with category \"prop\" belong to objects rather than the body. Move them only as the prompt implies, such as following the hand that holds them.",
+	},
+}
+
+func pointCategory(cp ControlPoint) string {
+	if cp.Category == "" {
+		return categoryBody
+	}
+	return cp.Category
+}
+
+func validateCategories(points []ControlPoint) error {
+	for _, cp := range points {
+		if _, ok := categoryProfiles[pointCategory(cp)]; !ok {
+			return fmt.Errorf("control point %d has invalid category %q, expected body, face or prop", cp.ID, cp.Category)
+		}
+	}
+	return nil
+}
+
+// deltaPlaces returns the rounding precision of each control point's deltas
+func deltaPlaces(points []ControlPoint) map[int]int {
+	places := make(map[int]int, len(points))
+	for _, cp := range points {
+		places[cp.ID] = categoryProfiles[pointCategory(cp)].places
+	}
+	return places
+}
+
+// roundFrames rounds every delta to its point's precision, two places for
+// points without one
+func roundFrames(frames ResponsePayload, places map[int]int) ResponsePayload {
+	for _, frame := range frames {
+		for id, d := range frame {
+			p, ok := places[id]
+			if !ok {
+				p = 2
+			}
+			frame[id] = Deformation{DeltaX: roundTo(d.DeltaX, p), DeltaY: roundTo(d.DeltaY, p), DeltaZ: roundTo(d.DeltaZ, p)}
+		}
+	}
+	return frames
+}
+
+// jerkWeights returns the weight of each point in the smoothness score
+func jerkWeights(points []ControlPoint) map[int]float64 {
+	weights := make(map[int]float64, len(points))
+	for _, cp := range points {
+		weights[cp.ID] = categoryProfiles[pointCategory(cp)].jerkWeight
+	}
+	return weights
+}
+
+// categoryGuidance returns the prompt guidance for the non-body categories
+// present in the rig
+func categoryGuidance(points []ControlPoint) []string {
+	present := make(map[string]bool)
+	for _, cp := range points {
+		present[pointCategory(cp)] = true
+	}
+	var guidance []string
+	for _, category := range sortedKeys(present) {
+		if g := categoryProfiles[category].guidance; g != "" {
+			guidance = append(guidance, g)
+		}
+	}
+	return guidance
+}
+
+// categoryBudget returns the motion budget a category imposes regardless of
+// role, if any
+func categoryBudget(cp ControlPoint, height float64) (float64, bool) {
+	fraction := categoryProfiles[pointCategory(cp)].budgetFraction
+	if fraction == 0 {
+		return 0, false
+	}
+	return roundTo(fraction*height, 4), true
+}
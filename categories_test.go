@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestValidateCategories(t *testing.T) {
+	points := testRig()
+	points[0].Category = categoryFace
+	points[1].Category = categoryProp
+	if err := validateCategories(points); err != nil {
+		t.Fatalf("valid categories rejected: %v", err)
+	}
+	points[2].Category = "Face"
+	if err := validateCategories(points); err == nil || !strings.Contains(err.Error(), "control point 2") {
+		t.Errorf("error %v, want control point 2's category named", err)
+	}
+}
+
+func TestDeltaPlaces(t *testing.T) {
+	points := testRig()
+	points[0].Category = categoryFace
+	points[1].Category = categoryProp
+	places := deltaPlaces(points)
+	for id, want := range map[int]int{0: 4, 1: 2, 2: 2} {
+		if places[id] != want {
+			t.Errorf("point %d keeps %d places, want %d", id, places[id], want)
+		}
+	}
+
+	// Points without a precision keep two places
+	frames := roundFrames(ResponsePayload{{0: {DeltaY: 0.012345}, 2: {DeltaY: 0.012345}, 9: {DeltaY: 0.012345}}}, places)
+	for id, want := range map[int]float64{0: 0.0123, 2: 0.01, 9: 0.01} {
+		if got := frames[0][id].DeltaY; got != want {
+			t.Errorf("point %d rounded to %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestCategoryRoundingOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	// The brow moves a tenth as far as the hand each frame
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		return framesResponse(input.Length, func(f int) map[string]Position {
+			frame := make(map[string]Position, len(input.ControlPoints))
+			for _, cp := range input.ControlPoints {
+				p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+				switch cp.ID {
+				case 2:
+					p.Y += 0.0123 * float64(f)
+				case 5:
+					p.Y += 0.00123 * float64(f)
+				}
+				frame[strconv.Itoa(cp.ID)] = p
+			}
+			return frame
+		}), nil
+	}
+	rig := append(testRig(), ControlPoint{ID: 5, Role: "left brow", Position: []float64{0.05, 1.65, 0.1}, Category: categoryFace})
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "raise an eyebrow and a hand", Length: 3}}
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	frames := decodeBody[ResponsePayload](t, rec)
+	if hand, brow := frames[2][2].DeltaY, frames[2][5].DeltaY; hand != 0.02 || brow != 0.0025 {
+		t.Errorf("hand rose %v and brow %v, want 0.02 and 0.0025", hand, brow)
+	}
+
+	rig[5].Category = "eyebrow"
+	rec = serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown category: status %d, want 400", rec.Code)
+	}
+}
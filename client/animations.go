@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Search parameters of ListAnimations; zero values use the service's
+// defaults
+type AnimationQuery struct {
+	// Clips must carry every tag
+	Tags []string
+	// Searched for in names and prompts
+	Text string
+	// created, frames or name
+	Sort string
+	// asc or desc
+	Order  string
+	Limit  int
+	Offset int
+}
+
+// CreateAnimation saves a clip to the library and returns its summary
+func (c *Client) CreateAnimation(ctx context.Context, req AnimationRequest, opts ...CallOption) (*AnimationSummary, error) {
+	var summary AnimationSummary
+	if _, err := c.do(ctx, http.MethodPost, "/animations", req, &summary, opts); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// GetAnimation returns a library clip with its frames
+func (c *Client) GetAnimation(ctx context.Context, name string, opts ...CallOption) (*Animation, error) {
+	var animation Animation
+	if _, err := c.do(ctx, http.MethodGet, "/animations/"+url.PathEscape(name), nil, &animation, opts); err != nil {
+		return nil, err
+	}
+	return &animation, nil
+}
+
+// UpdateAnimation changes a clip's tags or score
+func (c *Client) UpdateAnimation(ctx context.Context, name string, patch AnimationPatch, opts ...CallOption) (*AnimationSummary, error) {
+	var summary AnimationSummary
+	if _, err := c.do(ctx, http.MethodPatch, "/animations/"+url.PathEscape(name), patch, &summary, opts); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// ListAnimations returns one page of the library clips matching q
+func (c *Client) ListAnimations(ctx context.Context, q AnimationQuery, opts ...CallOption) (*AnimationList, error) {
+	query := url.Values{"tag": q.Tags}
+	set := func(name, value string) {
+		if value != "" && value != "0" {
+			query.Set(name, value)
+		}
+	}
+	set("q", q.Text)
+	set("sort", q.Sort)
+	set("order", q.Order)
+	set("limit", strconv.Itoa(q.Limit))
+	set("offset", strconv.Itoa(q.Offset))
+	path := "/animations"
+	if encoded := query.Encode(); encoded != "" {
+		path += "?" + encoded
+	}
+	var list AnimationList
+	if _, err := c.do(ctx, http.MethodGet, path, nil, &list, opts); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
@@ -0,0 +1,243 @@
+// Package client calls the descriptive-rigidity HTTP API from Go programs.
+//
+// The request and response types are those of package api, which the server
+// itself decodes and encodes, so the client cannot drift from its JSON.
+//
+//	c := client.New("http://localhost:8080")
+//	gen, err := c.GenerateDeformations(ctx, client.RequestPayload{
+//		ControlPoints: points,
+//		Prompt:        "wave the right arm",
+//		Length:        30,
+//	}, client.WithTimeout(time.Minute))
+//	var apiErr *client.APIError
+//	if errors.As(err, &apiErr) && apiErr.Code == "too_many_control_points" {
+//		// ...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls one deployment of the service. Its fields may be changed
+// until it is first used; it is safe for concurrent use after that.
+type Client struct {
+	// Base URL of the service, such as http://localhost:8080
+	BaseURL string
+	// Sent as X-API-Key on every call unless a call sets its own
+	APIKey string
+	// Defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// New returns a client for the service at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// APIError is an error the service answered with: the HTTP status and the
+// code, message and details of its error body. Status is zero for a failed
+// job, which reports only its code and message.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details json.RawMessage
+	// Wait the service advised before retrying, from Retry-After
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// CallOption adjusts a single call
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	timeout time.Duration
+	apiKey  string
+	query   url.Values
+	header  http.Header
+}
+
+// WithTimeout bounds the call, on top of any deadline its context has
+func WithTimeout(d time.Duration) CallOption {
+	return func(o *callOptions) { o.timeout = d }
+}
+
+// WithAPIKey sends key instead of the client's API key
+func WithAPIKey(key string) CallOption {
+	return func(o *callOptions) { o.apiKey = key }
+}
+
+// WithQuery adds a query parameter, such as include_meta=true or
+// frames=0,10
+func WithQuery(name, value string) CallOption {
+	return func(o *callOptions) { o.query.Add(name, value) }
+}
+
+// WithHeader adds a request header, such as X-Request-ID
+func WithHeader(name, value string) CallOption {
+	return func(o *callOptions) { o.header.Add(name, value) }
+}
+
+// do sends a request with a JSON body, or none when body is nil, and
+// decodes a successful JSON answer into out unless it is nil. Error
+// answers are returned as *APIError.
+func (c *Client) do(ctx context.Context, method, path string, body, out any, opts []CallOption) (*http.Response, error) {
+	o := callOptions{apiKey: c.APIKey, query: url.Values{}, header: http.Header{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	req, err := c.newRequest(ctx, method, path, body, o)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return resp, decodeError(resp)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("decode %s %s response: %w", method, path, err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body any, o callOptions) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("encode %s %s request: %w", method, path, err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+	target := strings.TrimRight(c.BaseURL, "/") + path
+	if len(o.query) > 0 {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		target += sep + o.query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range o.header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	if o.apiKey != "" {
+		req.Header.Set("X-API-Key", o.apiKey)
+	}
+	return req, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Error body the service answers with
+type errorResponse struct {
+	Error struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details,omitempty"`
+	} `json:"error"`
+}
+
+// decodeError turns an error answer into an *APIError, keeping the status
+// when the body is not the service's error JSON (from a proxy, say)
+func decodeError(resp *http.Response) error {
+	apiErr := &APIError{Status: resp.StatusCode, Code: "http_" + strconv.Itoa(resp.StatusCode), Message: resp.Status}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var body errorResponse
+	if json.Unmarshal(raw, &body) == nil && body.Error.Code != "" {
+		apiErr.Code = body.Error.Code
+		apiErr.Message = body.Error.Message
+		apiErr.Details = body.Error.Details
+	}
+	return apiErr
+}
+
+// GenerateDeformations animates a rig. Query parameters such as
+// include_id_map and frames are passed with WithQuery; the response always
+// uses the version 2 envelope, so metadata and warnings are always there.
+func (c *Client) GenerateDeformations(ctx context.Context, payload RequestPayload, opts ...CallOption) (*Generation, error) {
+	opts = append([]CallOption{WithHeader("X-API-Version", "2")}, opts...)
+	var envelope generationEnvelope
+	resp, err := c.do(ctx, http.MethodPost, "/generate-deformations", payload, &envelope, opts)
+	if err != nil {
+		return nil, err
+	}
+	gen := &Generation{
+		RawFrames:   envelope.Frames,
+		Root:        envelope.Root,
+		IDMap:       envelope.IDMap,
+		Warnings:    envelope.Warnings,
+		Cache:       strings.ToLower(resp.Header.Get("X-Cache")),
+		ServedModel: resp.Header.Get("X-Served-Model"),
+	}
+	if envelope.Meta != nil {
+		gen.Meta = *envelope.Meta
+	}
+	if err := gen.decodeFrames(payload.OutputCoords); err != nil {
+		return nil, err
+	}
+	return gen, nil
+}
+
+// decodeFrames fills in Frames or Spherical when the frames are a plain
+// array; sparse and per_second encodings stay in RawFrames
+func (g *Generation) decodeFrames(coords string) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(g.RawFrames), []byte("[")) {
+		return nil
+	}
+	var err error
+	if coords == "spherical" {
+		err = json.Unmarshal(g.RawFrames, &g.Spherical)
+	} else {
+		err = json.Unmarshal(g.RawFrames, &g.Frames)
+	}
+	if err != nil {
+		return fmt.Errorf("decode frames: %w", err)
+	}
+	return nil
+}
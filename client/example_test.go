@@ -0,0 +1,59 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/Joshimello/descriptive-rigidity/client"
+)
+
+func ExampleClient_GenerateDeformations() {
+	// A stand-in for the service that decodes the request with the shared
+	// types and moves every point up by a little more each frame. The
+	// server's own tests run the client against its real router.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload api.RequestPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		frames := make(api.Frames, payload.Length)
+		for f := range frames {
+			frames[f] = make(map[int]api.Deformation)
+			for _, cp := range payload.ControlPoints {
+				frames[f][cp.ID] = api.Deformation{DeltaY: 0.25 * float64(f)}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Cache", "MISS")
+		json.NewEncoder(w).Encode(map[string]any{"frames": frames, "meta": map[string]any{}, "warnings": []string{}})
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	gen, err := c.GenerateDeformations(context.Background(), client.RequestPayload{
+		ControlPoints: []client.ControlPoint{
+			{ID: 0, Role: "left arm", Position: []float64{1, 2, 0}},
+			{ID: 1, Role: "right arm", Position: []float64{-1, 2, 0}},
+		},
+		Prompt: "raise both arms",
+		Length: 3,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("cache:", gen.Cache)
+	for f, frame := range gen.Frames {
+		fmt.Printf("frame %d: left arm %+v\n", f, frame[0])
+	}
+	// Output:
+	// cache: miss
+	// frame 0: left arm {DeltaX:0 DeltaY:0 DeltaZ:0}
+	// frame 1: left arm {DeltaX:0 DeltaY:0.25 DeltaZ:0}
+	// frame 2: left arm {DeltaX:0 DeltaY:0.5 DeltaZ:0}
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Body of POST /jobs: a generation and where to report its outcome
+type JobRequest struct {
+	RequestPayload
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// Longest the service holds GET /jobs/{id}?wait= open, in seconds
+const maxJobWait = 25
+
+// SubmitJob starts an asynchronous generation and returns the pending job
+func (c *Client) SubmitJob(ctx context.Context, req JobRequest, opts ...CallOption) (*Job, error) {
+	var job Job
+	if _, err := c.do(ctx, http.MethodPost, "/jobs", req, &job, opts); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJob returns a job as it stands
+func (c *Client) GetJob(ctx context.Context, id string, opts ...CallOption) (*Job, error) {
+	var job Job
+	if _, err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &job, opts); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// WaitForJob long-polls a job until it finishes or ctx ends. A failed job
+// is returned along with an *APIError carrying its error code; when ctx
+// ends first, the job as last seen is returned with ctx's error.
+func (c *Client) WaitForJob(ctx context.Context, id string, opts ...CallOption) (*Job, error) {
+	opts = append(opts, WithQuery("wait", fmt.Sprint(maxJobWait)))
+	var last *Job
+	for {
+		job, err := c.GetJob(ctx, id, opts...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return last, ctx.Err()
+			}
+			return last, err
+		}
+		last = job
+		switch job.Status {
+		case JobDone:
+			return job, nil
+		case JobFailed:
+			return job, &APIError{Code: job.ErrorCode, Message: job.Error}
+		}
+	}
+}
+
+// An event of a job's stream. Type is progress, frame, done or error, and
+// the matching field is set.
+type JobEvent struct {
+	Type     string
+	Progress *ProgressUpdate
+	// Index and offsets of one frame of the finished job, or its velocities
+	// when Units is per_second
+	Index int
+	Frame map[int]Deformation
+	Units string
+	// Set on the first frame of velocities: what integrating them needs
+	FPS         float64
+	Precision   int
+	InitialPose map[int]Deformation
+	// The job's failure, or a broken stream
+	Err error
+}
+
+// StreamJob follows a job's server-sent events: progress while it runs,
+// then every frame and done, or an error. The channel is closed after the
+// last event, or once ctx ends.
+func (c *Client) StreamJob(ctx context.Context, id string, opts ...CallOption) (<-chan JobEvent, error) {
+	o := callOptions{apiKey: c.APIKey, query: url.Values{}, header: http.Header{"Accept": {"text/event-stream"}}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// The stream lasts as long as the job; a timeout bounds all of it
+	cancel := context.CancelFunc(func() {})
+	if o.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/events", nil, o)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer cancel()
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+
+	events := make(chan JobEvent)
+	go func() {
+		defer cancel()
+		defer resp.Body.Close()
+		defer close(events)
+		send := func(e JobEvent) bool {
+			select {
+			case events <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 16<<20)
+		var name, data string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:"):
+				data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			case line == "" && name != "":
+				event := decodeJobEvent(name, data)
+				name, data = "", ""
+				if !send(event) || event.Type == "done" || event.Type == "error" {
+					return
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			send(JobEvent{Type: "error", Err: fmt.Errorf("read job events: %w", err)})
+		}
+	}()
+	return events, nil
+}
+
+func decodeJobEvent(name, data string) JobEvent {
+	event := JobEvent{Type: name}
+	var err error
+	switch name {
+	case "progress":
+		event.Progress = new(ProgressUpdate)
+		err = json.Unmarshal([]byte(data), event.Progress)
+	case "frame":
+		var frame struct {
+			Index        int                 `json:"index"`
+			Deformations map[int]Deformation `json:"deformations"`
+			Units        string              `json:"units"`
+			FPS          float64             `json:"fps"`
+			Precision    int                 `json:"precision"`
+			InitialPose  map[int]Deformation `json:"initial_pose"`
+		}
+		err = json.Unmarshal([]byte(data), &frame)
+		event.Index, event.Frame, event.Units = frame.Index, frame.Deformations, frame.Units
+		event.FPS, event.Precision, event.InitialPose = frame.FPS, frame.Precision, frame.InitialPose
+	case "error":
+		var body errorResponse
+		err = json.Unmarshal([]byte(data), &body)
+		event.Err = &APIError{Code: body.Error.Code, Message: body.Error.Message, Details: body.Error.Details}
+	}
+	if err != nil {
+		return JobEvent{Type: "error", Err: fmt.Errorf("decode %s event: %w", name, err)}
+	}
+	return event
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// Request and response types shared with the server, defined in package
+// api; the README documents what each field does
+type (
+	ControlPoint         = api.ControlPoint
+	Position             = api.Position
+	RequestPayload       = api.RequestPayload
+	AxesConvention       = api.AxesConvention
+	Easing               = api.Easing
+	EasingOptions        = api.EasingOptions
+	JiggleOptions        = api.JiggleOptions
+	Hold                 = api.Hold
+	StartAtRest          = api.StartAtRest
+	PlausibilityOptions  = api.PlausibilityOptions
+	Keyframe             = api.Keyframe
+	MotionConstraints    = api.MotionConstraints
+	Deformation          = api.Deformation
+	SphericalDeformation = api.SphericalDeformation
+	RootTransform        = api.RootTransform
+	FrameEvent           = api.FrameEvent
+	InferredRole         = api.InferredRole
+	Usage                = api.Usage
+	JobCallback          = api.JobCallback
+	ProgressUpdate       = api.ProgressUpdate
+	Animation            = api.Animation
+	AnimationRequest     = api.AnimationRequest
+	AnimationPatch       = api.AnimationPatch
+	AnimationSummary     = api.AnimationSummary
+	AnimationList        = api.AnimationList
+	VelocityPayload      = api.VelocityPayload
+)
+
+// VelocitiesToOffsets integrates frames sent in per_second output units,
+// decoded from Generation.RawFrames or Job.Result, back into the offsets
+// from rest the service computed
+func VelocitiesToOffsets(v VelocityPayload) []map[int]Deformation {
+	return api.VelocitiesToOffsets(v)
+}
+
+// A generated animation
+type Generation struct {
+	// Frames of cartesian offsets keyed by control point ID, or with
+	// output_coords spherical, Spherical instead
+	Frames    []map[int]Deformation
+	Spherical []map[int]SphericalDeformation
+	// The frames as the service sent them. Sparse encoding and per_second
+	// output units are only here.
+	RawFrames json.RawMessage
+	Root      []RootTransform
+	// With include_id_map=true
+	IDMap    map[int]int
+	Meta     Meta
+	Warnings []string
+	// hit, miss or stale, from X-Cache
+	Cache string
+	// Model that answered, a fallback when the requested one was
+	// unavailable, from X-Served-Model
+	ServedModel string
+}
+
+// The commonly used parts of a generation's metadata
+type Meta struct {
+	GenerationHash  string          `json:"generation_hash,omitempty"`
+	RigProfile      string          `json:"rig_profile,omitempty"`
+	AffectedPoints  []int           `json:"affected_points,omitempty"`
+	Confidence      map[int]float64 `json:"confidence,omitempty"`
+	TokenConfidence *float64        `json:"token_confidence,omitempty"`
+	UnchangedPoints []int           `json:"unchanged_points,omitempty"`
+	AuxiliaryPoints []int           `json:"auxiliary_points,omitempty"`
+	InferredRoles   []InferredRole  `json:"inferred_roles,omitempty"`
+	Events          []FrameEvent    `json:"events,omitempty"`
+	Usage           *Usage          `json:"usage,omitempty"`
+}
+
+// Version 2 response envelope
+type generationEnvelope struct {
+	Frames   json.RawMessage `json:"frames"`
+	Root     []RootTransform `json:"root,omitempty"`
+	IDMap    map[int]int     `json:"id_map,omitempty"`
+	Meta     *Meta           `json:"meta"`
+	Warnings []string        `json:"warnings"`
+}
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// An asynchronous generation
+type Job struct {
+	ID     string    `json:"job_id"`
+	Status JobStatus `json:"status"`
+	// The frames of a finished job, shaped by the request's output_coords,
+	// encoding and output_units
+	Result    json.RawMessage `json:"result,omitempty"`
+	Root      []RootTransform `json:"root,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	ErrorCode string          `json:"error_code,omitempty"`
+	Progress  *ProgressUpdate `json:"progress,omitempty"`
+	Callback  *JobCallback    `json:"callback,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Frames decodes the cartesian frames of a finished job
+func (j *Job) Frames() ([]map[int]Deformation, error) {
+	var frames []map[int]Deformation
+	err := json.Unmarshal(j.Result, &frames)
+	return frames, err
+}
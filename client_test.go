@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/client"
+	"github.com/sashabaranov/go-openai"
+)
+
+// The Go client against the real router, so the two cannot drift apart
+// unnoticed
+func TestClientAgainstServer(t *testing.T) {
+	fake := setupServer(t, nil)
+	srv := httptest.NewServer(newRouter())
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL)
+	ctx := context.Background()
+	payload := client.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 6, FPS: 30}
+	want := decodeBody[ResponsePayload](t, serve(t, http.MethodPost, "/generate-deformations", RequestPayload{RequestPayload: payload}, nil))
+
+	t.Run("generate", func(t *testing.T) {
+		gen, err := c.GenerateDeformations(ctx, payload, client.WithTimeout(5*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(gen.Frames, []map[int]Deformation(want)) {
+			t.Errorf("frames\n%v\nwant\n%v", gen.Frames, want)
+		}
+		if gen.Cache != "hit" || gen.Meta.GenerationHash == "" || gen.Warnings == nil {
+			t.Errorf("cache %q, generation hash %q, warnings %v", gen.Cache, gen.Meta.GenerationHash, gen.Warnings)
+		}
+
+		// Velocities stay raw and integrate back into the same offsets
+		velocity := payload
+		velocity.OutputUnits = "per_second"
+		gen, err = c.GenerateDeformations(ctx, velocity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v client.VelocityPayload
+		if err := json.Unmarshal(gen.RawFrames, &v); err != nil {
+			t.Fatal(err)
+		}
+		if gen.Frames != nil || !reflect.DeepEqual(client.VelocitiesToOffsets(v), []map[int]Deformation(want)) {
+			t.Errorf("velocities %s do not integrate to the offsets", gen.RawFrames)
+		}
+	})
+
+	t.Run("job", func(t *testing.T) {
+		job, err := c.SubmitJob(ctx, client.JobRequest{RequestPayload: payload})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.ID == "" || (job.Status != client.JobPending && job.Status != client.JobRunning) {
+			t.Fatalf("submitted job %+v", job)
+		}
+		job, err = c.WaitForJob(ctx, job.ID, client.WithTimeout(10*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames, err := job.Frames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(frames, []map[int]Deformation(want)) {
+			t.Errorf("job frames\n%v\nwant\n%v", frames, want)
+		}
+
+		events, err := c.StreamJob(ctx, job.ID, client.WithTimeout(10*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var streamed []map[int]Deformation
+		last := ""
+		for e := range events {
+			switch e.Type {
+			case "frame":
+				if e.Index != len(streamed) {
+					t.Fatalf("frame %d after %d frames", e.Index, len(streamed))
+				}
+				streamed = append(streamed, e.Frame)
+			case "error":
+				t.Fatal(e.Err)
+			}
+			last = e.Type
+		}
+		if last != "done" || !reflect.DeepEqual(streamed, []map[int]Deformation(want)) {
+			t.Errorf("stream ended with %q after frames\n%v\nwant\n%v", last, streamed, want)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		var apiErr *client.APIError
+		_, err := c.GenerateDeformations(ctx, client.RequestPayload{Prompt: "sway gently", Length: 4})
+		if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest || apiErr.Code != "invalid_request" || apiErr.Message == "" {
+			t.Errorf("invalid request: %v, want a 400 invalid_request APIError", err)
+		}
+		if _, err := c.StreamJob(ctx, "missing"); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+			t.Errorf("stream of a missing job: %v, want a 404 APIError", err)
+		}
+
+		// A failed job comes back with its error code
+		fake.respond = func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+			return contentResponse(`{"frames": []}`), nil
+		}
+		job, err := c.SubmitJob(ctx, client.JobRequest{RequestPayload: client.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		job, err = c.WaitForJob(ctx, job.ID, client.WithTimeout(10*time.Second))
+		if !errors.As(err, &apiErr) || apiErr.Code != "empty_generation" || job == nil || job.Status != client.JobFailed {
+			t.Errorf("failed job %+v: %v, want an empty_generation APIError", job, err)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Limits post-processing enforces on the model's output, as the constraint
+// report measures them
+type constraintLimits struct {
+	// Motion budgets by original ID, when the clamp stage runs
+	Budgets map[int]float64
+	// Points whose budget the request's constraints set
+	Explicit map[int]bool
+	MaxRange float64
+	// Frozen axes, when the freeze stage runs
+	FreezeAxes []string
+	// Input position minus rest position. Budgets and max_range are measured
+	// from the pose the model started from, frozen axes from rest.
+	Offsets map[int]Deformation
+	Roles   map[int]string
+}
+
+// How the model's output compared with one limit on one point
+type constraintCompliance struct {
+	// motion_budget, max_range or freeze_axes
+	Constraint string  `json:"constraint"`
+	Point      int     `json:"point"`
+	Role       string  `json:"role,omitempty"`
+	Limit      float64 `json:"limit"`
+	// Largest amount the model's output exceeded the limit by, over all
+	// frames, before post-processing corrected it
+	MaxViolation    float64 `json:"max_violation"`
+	FramesCorrected int     `json:"frames_corrected"`
+	ModelRespected  bool    `json:"model_respected"`
+}
+
+// Whether the model kept to the request's constraints or post-processing
+// had to enforce them, in meta.constraint_report. It lists every point the
+// constraints name and every point any limit was enforced on.
+type constraintReport struct {
+	ModelRespected bool                   `json:"model_respected"`
+	Constraints    []constraintCompliance `json:"constraints"`
+}
+
+// wantsConstraintReport reports whether a request sets limits of its own
+func wantsConstraintReport(payload RequestPayload) bool {
+	return payload.Constraints != nil || payload.MaxRange > 0 || len(payload.FreezeAxes) > 0
+}
+
+// constraintLimitsFor collects the limits a request's post-processing
+// enforces
+func constraintLimitsFor(payload RequestPayload, t pointTables) constraintLimits {
+	stages := payload.Pipeline
+	if len(stages) == 0 {
+		stages = defaultPipeline
+	}
+	limits := constraintLimits{MaxRange: payload.MaxRange, Offsets: t.restOffsets, Roles: t.roles, Explicit: make(map[int]bool)}
+	if slices.Contains(stages, "clamp") {
+		limits.Budgets = t.budgets
+	}
+	if slices.Contains(stages, "freeze") {
+		limits.FreezeAxes = payload.FreezeAxes
+	}
+	if c := payload.Constraints; c != nil {
+		roles := make(map[string]bool, len(c.RoleBudgets))
+		for role := range c.RoleBudgets {
+			roles[strings.ToLower(role)] = true
+		}
+		for id, role := range t.roles {
+			if _, ok := c.MotionBudgets[id]; ok || roles[strings.ToLower(role)] {
+				limits.Explicit[id] = true
+			}
+		}
+	}
+	return limits
+}
+
+// reportConstraints measures the model's deltas, in original IDs and before
+// any post-processing, against the limits. It depends only on its
+// arguments.
+func reportConstraints(raw ResponsePayload, limits constraintLimits) *constraintReport {
+	type key struct {
+		constraint string
+		point      int
+	}
+	entries := make(map[key]*constraintCompliance)
+	entry := func(constraint string, id int, limit float64) *constraintCompliance {
+		k := key{constraint, id}
+		if entries[k] == nil {
+			entries[k] = &constraintCompliance{Constraint: constraint, Point: id, Role: limits.Roles[id], Limit: limit, ModelRespected: true}
+		}
+		return entries[k]
+	}
+	record := func(e *constraintCompliance, excess float64) {
+		if excess > 0 {
+			e.MaxViolation = math.Max(e.MaxViolation, excess)
+			e.FramesCorrected++
+			e.ModelRespected = false
+		}
+	}
+	for id := range limits.Explicit {
+		if budget, ok := limits.Budgets[id]; ok {
+			entry("motion_budget", id, budget)
+		}
+	}
+
+	for _, frame := range raw {
+		for _, id := range sortedKeys(frame) {
+			d := frame[id]
+			o := limits.Offsets[id]
+			moved := [3]float64{d.DeltaX - o.DeltaX, d.DeltaY - o.DeltaY, d.DeltaZ - o.DeltaZ}
+			if budget, ok := limits.Budgets[id]; ok {
+				if excess := math.Hypot(math.Hypot(moved[0], moved[1]), moved[2]) - budget; excess > 0 {
+					record(entry("motion_budget", id, budget), excess)
+				}
+			}
+			if limits.MaxRange > 0 {
+				excess := 0.0
+				for _, m := range moved {
+					excess = math.Max(excess, math.Abs(m)-limits.MaxRange)
+				}
+				if excess > 0 {
+					record(entry("max_range", id, limits.MaxRange), excess)
+				}
+			}
+			excess := 0.0
+			for _, axis := range limits.FreezeAxes {
+				excess = math.Max(excess, math.Abs([3]float64{d.DeltaX, d.DeltaY, d.DeltaZ}[slices.Index(axisNames[:], axis)]))
+			}
+			if excess > 0 {
+				record(entry("freeze_axes", id, 0), excess)
+			}
+		}
+	}
+
+	report := &constraintReport{ModelRespected: true, Constraints: []constraintCompliance{}}
+	for _, e := range entries {
+		e.MaxViolation = roundTo(e.MaxViolation, 6)
+		report.Constraints = append(report.Constraints, *e)
+		report.ModelRespected = report.ModelRespected && e.ModelRespected
+	}
+	slices.SortFunc(report.Constraints, func(a, b constraintCompliance) int {
+		return cmp.Or(cmp.Compare(a.Constraint, b.Constraint), cmp.Compare(a.Point, b.Point))
+	})
+	return report
+}
+
+// Violations quoted back to the model on refinement, worst first
+const maxConstraintFeedback = 5
+
+// constraintFeedback summarises the limits the model broke for the prompt
+// of a follow-up generation, or returns "" when it kept to them all
+func constraintFeedback(r *constraintReport) string {
+	if r == nil || r.ModelRespected {
+		return ""
+	}
+	var broken []constraintCompliance
+	for _, c := range r.Constraints {
+		if !c.ModelRespected {
+			broken = append(broken, c)
+		}
+	}
+	slices.SortStableFunc(broken, func(a, b constraintCompliance) int { return cmp.Compare(b.MaxViolation, a.MaxViolation) })
+	var parts []string
+	for _, c := range broken[:min(len(broken), maxConstraintFeedback)] {
+		point := fmt.Sprintf("control point %d", c.Point)
+		if c.Role != "" {
+			point += fmt.Sprintf(" (%s)", c.Role)
+		}
+		switch c.Constraint {
+		case "motion_budget":
+			parts = append(parts, fmt.Sprintf("%s exceeded its motion budget of %g by up to %.3g in %s", point, c.Limit, c.MaxViolation, frameCount(c.FramesCorrected)))
+		case "max_range":
+			parts = append(parts, fmt.Sprintf("%s strayed up to %.3g past max_range %g in %s", point, c.MaxViolation, c.Limit, frameCount(c.FramesCorrected)))
+		case "freeze_axes":
+			parts = append(parts, fmt.Sprintf("%s moved up to %.3g along a frozen axis in %s", point, c.MaxViolation, frameCount(c.FramesCorrected)))
+		}
+	}
+	text := "The previous attempt broke limits that post-processing then had to enforce: " + strings.Join(parts, "; ")
+	if len(broken) > maxConstraintFeedback {
+		text += fmt.Sprintf("; and %d more", len(broken)-maxConstraintFeedback)
+	}
+	return text + ". Keep within every limit this time."
+}
+
+func frameCount(n int) string {
+	if n == 1 {
+		return "1 frame"
+	}
+	return fmt.Sprintf("%d frames", n)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestReportConstraints(t *testing.T) {
+	limits := constraintLimits{
+		Budgets:    map[int]float64{0: 0.2, 1: 1, 2: 0.5},
+		Explicit:   map[int]bool{1: true},
+		MaxRange:   0.25,
+		FreezeAxes: []string{"y"},
+		// Point 2 starts 0.4 along x from rest, so its moves are measured
+		// from there
+		Offsets: map[int]Deformation{2: {DeltaX: 0.4}},
+		Roles:   map[int]string{0: "head", 1: "left hand", 2: "right hand"},
+	}
+	raw := ResponsePayload{
+		{0: {DeltaX: 0.1}, 1: {}, 2: {DeltaX: 0.4}},
+		{0: {DeltaX: 0.3}, 1: {DeltaY: 0.1}, 2: {DeltaX: 0.6}},
+		{0: {DeltaX: 0.35}, 1: {}, 2: {DeltaX: 0.4}},
+	}
+	report := reportConstraints(raw, limits)
+	want := []constraintCompliance{
+		{Constraint: "freeze_axes", Point: 1, Role: "left hand", MaxViolation: 0.1, FramesCorrected: 1},
+		{Constraint: "max_range", Point: 0, Role: "head", Limit: 0.25, MaxViolation: 0.1, FramesCorrected: 2},
+		{Constraint: "motion_budget", Point: 0, Role: "head", Limit: 0.2, MaxViolation: 0.15, FramesCorrected: 2},
+		// Named by the constraints, so listed though kept to
+		{Constraint: "motion_budget", Point: 1, Role: "left hand", Limit: 1, ModelRespected: true},
+	}
+	if report.ModelRespected || !slices.Equal(report.Constraints, want) {
+		t.Errorf("report %+v, want unrespected %+v", report, want)
+	}
+
+	if report := reportConstraints(raw[:1], constraintLimits{Budgets: limits.Budgets, Offsets: limits.Offsets}); !report.ModelRespected || len(report.Constraints) != 0 {
+		t.Errorf("report %+v, want respected with no entries", report)
+	}
+
+	t.Run("feedback", func(t *testing.T) {
+		feedback := constraintFeedback(report)
+		// Worst first
+		if !strings.HasPrefix(feedback, "The previous attempt broke limits that post-processing then had to enforce: control point 0 (head) exceeded its motion budget of 0.2 by up to 0.15 in 2 frames; control point 1 (left hand) moved up to 0.1 along a frozen axis in 1 frame;") {
+			t.Errorf("feedback %q", feedback)
+		}
+		if strings.Contains(feedback, "point 1 (left hand) exceeded") {
+			t.Error("feedback quotes a limit the model kept to")
+		}
+		if got := constraintFeedback(&constraintReport{ModelRespected: true}); got != "" {
+			t.Errorf("feedback %q for a respected report", got)
+		}
+
+		var many constraintReport
+		for id := range 7 {
+			many.Constraints = append(many.Constraints, constraintCompliance{Constraint: "max_range", Point: id, Limit: 0.1, MaxViolation: 0.1, FramesCorrected: 1})
+		}
+		if got := constraintFeedback(&many); !strings.HasSuffix(got, "; and 2 more. Keep within every limit this time.") {
+			t.Errorf("feedback %q, want five violations and 2 more", got)
+		}
+	})
+}
+
+func TestConstraintReportOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	payload := RequestPayload{RequestPayload: api.RequestPayload{
+		ControlPoints: testRig(),
+		Prompt:        "raise the right hand high",
+		Length:        4,
+		Constraints:   &MotionConstraints{MotionBudgets: map[int]float64{2: 0.3}},
+	}}
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	// The hand rises 0.2 a frame, past its budget in the last two frames
+	report := decodeBody[ResponseEnvelope](t, rec).Meta.Constraints
+	if report == nil || report.ModelRespected || len(report.Constraints) != 1 {
+		t.Fatalf("constraint_report %+v, want one unrespected entry", report)
+	}
+	got := report.Constraints[0]
+	if got.Constraint != "motion_budget" || got.Point != 2 || got.Limit != 0.3 || got.FramesCorrected != 2 || math.Abs(got.MaxViolation-0.3) > 1e-9 {
+		t.Errorf("entry %+v, want the hand's budget of 0.3 exceeded by 0.3 in 2 frames", got)
+	}
+
+	payload.Constraints = nil
+	rec = serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if report := decodeBody[ResponseEnvelope](t, rec).Meta.Constraints; report != nil {
+		t.Errorf("constraint_report %+v without limits of the request's own", report)
+	}
+}
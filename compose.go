@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Input struct for the /compose endpoint
+type ComposeRequest struct {
+	Base    ResponsePayload `json:"base"`
+	Overlay ResponsePayload `json:"overlay"`
+	// How the shorter clip covers the longer one: pad (default) holds it at
+	// rest once it ends, tile repeats it
+	Align string `json:"align,omitempty"`
+	// Factor applied to the overlay's deltas, default 1
+	Weight *float64 `json:"weight,omitempty"`
+}
+
+type ComposeMeta struct {
+	FrameCount        int    `json:"frame_count"`
+	BaseFrameCount    int    `json:"base_frame_count"`
+	OverlayFrameCount int    `json:"overlay_frame_count"`
+	Align             string `json:"align"`
+}
+
+type ComposeResponse struct {
+	Frames ResponsePayload `json:"frames"`
+	Meta   ComposeMeta     `json:"meta"`
+}
+
+func validateCompose(req ComposeRequest) error {
+	if len(req.Base) == 0 || len(req.Overlay) == 0 {
+		return fmt.Errorf("missing base or overlay frames")
+	}
+	switch req.Align {
+	case "", "pad", "tile":
+	default:
+		return fmt.Errorf("invalid align %q, expected pad or tile", req.Align)
+	}
+	return nil
+}
+
+// alignedFrame returns frame i of a clip stretched to a longer timeline by
+// padding with rest frames or tiling
+func alignedFrame(frames ResponsePayload, i int, align string) map[int]Deformation {
+	if align == "tile" {
+		return frames[i%len(frames)]
+	}
+	if i < len(frames) {
+		return frames[i]
+	}
+	return nil
+}
+
+// composeFrames layers overlay on top of base by summing the deltas of every
+// point in every frame, overlay scaled by weight. Points moved by only one
+// of the clips keep that clip's deltas. The result is as long as the longer
+// clip.
+func composeFrames(base, overlay ResponsePayload, align string, weight float64) ResponsePayload {
+	count := max(len(base), len(overlay))
+	result := make(ResponsePayload, count)
+	for i := range result {
+		frame := make(map[int]Deformation)
+		for id, d := range alignedFrame(base, i, align) {
+			frame[id] = d
+		}
+		for id, d := range alignedFrame(overlay, i, align) {
+			sum := addDelta(frame[id], scaleDelta(d, weight))
+			// Drop float noise without losing the inputs' precision
+			frame[id] = Deformation{DeltaX: roundTo(sum.DeltaX, 6), DeltaY: roundTo(sum.DeltaY, 6), DeltaZ: roundTo(sum.DeltaZ, 6)}
+		}
+		result[i] = frame
+	}
+	return result
+}
+
+// Handler for the /compose endpoint
+func compose(w http.ResponseWriter, r *http.Request) {
+	var req ComposeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	if err := validateCompose(req); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+	align := req.Align
+	if align == "" {
+		align = "pad"
+	}
+	weight := 1.0
+	if req.Weight != nil {
+		weight = *req.Weight
+	}
+
+	frames := composeFrames(req.Base, req.Overlay, align, weight)
+	resp := ComposeResponse{
+		Frames: frames,
+		Meta: ComposeMeta{
+			FrameCount:        len(frames),
+			BaseFrameCount:    len(req.Base),
+			OverlayFrameCount: len(req.Overlay),
+			Align:             align,
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
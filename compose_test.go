@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestCompose(t *testing.T) {
+	setupServer(t, nil)
+	// A walk on point 0 with a wave of points 0 and 1 layered on top
+	base := ResponsePayload{{0: {}}, {0: {DeltaX: 0.1}}, {0: {DeltaX: 0.2}}}
+	overlay := ResponsePayload{{0: {DeltaY: 0.2}, 1: {DeltaZ: 0.1}}, {0: {DeltaY: 0.4}}}
+	half := 0.5
+
+	for _, tc := range []struct {
+		align string
+		want  ResponsePayload
+	}{
+		{"", ResponsePayload{
+			{0: {DeltaY: 0.1}, 1: {DeltaZ: 0.05}},
+			{0: {DeltaX: 0.1, DeltaY: 0.2}},
+			// The overlay has ended and holds at rest
+			{0: {DeltaX: 0.2}},
+		}},
+		{"tile", ResponsePayload{
+			{0: {DeltaY: 0.1}, 1: {DeltaZ: 0.05}},
+			{0: {DeltaX: 0.1, DeltaY: 0.2}},
+			{0: {DeltaX: 0.2, DeltaY: 0.1}, 1: {DeltaZ: 0.05}},
+		}},
+	} {
+		rec := serve(t, http.MethodPost, "/compose", ComposeRequest{Base: base, Overlay: overlay, Align: tc.align, Weight: &half}, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("align %q: status %d: %s", tc.align, rec.Code, rec.Body)
+		}
+		got := decodeBody[ComposeResponse](t, rec)
+		if !reflect.DeepEqual(got.Frames, tc.want) {
+			t.Errorf("align %q: frames %v, want %v", tc.align, got.Frames, tc.want)
+		}
+		if m := got.Meta; m.FrameCount != 3 || m.BaseFrameCount != 3 || m.OverlayFrameCount != 2 || m.Align == "" {
+			t.Errorf("align %q: meta %+v", tc.align, m)
+		}
+	}
+
+	// Without a weight the overlay adds in full, whichever clip is longer
+	rec := serve(t, http.MethodPost, "/compose", ComposeRequest{Base: overlay, Overlay: base}, nil)
+	want := ResponsePayload{{0: {DeltaY: 0.2}, 1: {DeltaZ: 0.1}}, {0: {DeltaX: 0.1, DeltaY: 0.4}}, {0: {DeltaX: 0.2}}}
+	if got := decodeBody[ComposeResponse](t, rec).Frames; !reflect.DeepEqual(got, want) {
+		t.Errorf("unweighted: frames %v, want %v", got, want)
+	}
+
+	for _, req := range []ComposeRequest{{Base: base}, {Base: base, Overlay: overlay, Align: "stretch"}} {
+		if rec := serve(t, http.MethodPost, "/compose", req, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%+v: status %d, want 400", req, rec.Code)
+		}
+	}
+}
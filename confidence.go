@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Optional quality gate on the model's own token probabilities. With
+// min_confidence set, completions are requested with logprobs and scored by
+// the geometric mean probability of their tokens.
+
+func validateLowConfidencePolicy(policy string) error {
+	switch policy {
+	case "retry", "flag":
+		return nil
+	}
+	return fmt.Errorf("%q is not one of retry, flag", policy)
+}
+
+// tokenConfidence returns exp of the mean token log probability, between 0
+// and 1, or false when the completion carries no logprobs
+func tokenConfidence(logprobs *openai.LogProbs) (float64, bool) {
+	if logprobs == nil || len(logprobs.Content) == 0 {
+		return 0, false
+	}
+	sum := 0.0
+	for _, lp := range logprobs.Content {
+		sum += lp.LogProb
+	}
+	return math.Exp(sum / float64(len(logprobs.Content))), true
+}
+
+// isLowConfidence reports whether a call's confidence is known and below the
+// configured minimum
+func isLowConfidence(call *modelCall) bool {
+	return cfg.MinConfidence > 0 && call.TokenConfidence != nil && *call.TokenConfidence < cfg.MinConfidence
+}
+
+func lowConfidenceWarning(confidence float64) string {
+	return fmt.Sprintf("low_confidence: the model's token confidence %.3f is below the minimum of %.3g", confidence, cfg.MinConfidence)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestTokenConfidence(t *testing.T) {
+	if _, ok := tokenConfidence(nil); ok {
+		t.Error("confidence reported without logprobs")
+	}
+	if _, ok := tokenConfidence(&openai.LogProbs{}); ok {
+		t.Error("confidence reported for empty logprobs")
+	}
+	got, ok := tokenConfidence(&openai.LogProbs{Content: []openai.LogProb{{LogProb: -0.1}, {LogProb: -0.3}}})
+	if want := math.Exp(-0.2); !ok || math.Abs(got-want) > 1e-12 {
+		t.Errorf("confidence %v, %v; want the geometric mean %v", got, ok, want)
+	}
+}
+
+// confidentSway is swayResponse with every token at the given log
+// probabilities, one per call in turn
+func confidentSway(fake *fakeUpstream, logprobs ...float64) func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		resp, err := swayResponse(req)
+		if err != nil {
+			return resp, err
+		}
+		lp := logprobs[min(fake.calls(), len(logprobs))-1]
+		resp.Choices[0].LogProbs = &openai.LogProbs{Content: []openai.LogProb{{Token: "{", LogProb: lp}, {Token: "}", LogProb: lp}}}
+		return resp, nil
+	}
+}
+
+func TestLowConfidenceGate(t *testing.T) {
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway", Length: 4}}
+	type response struct {
+		Meta generationMeta `json:"meta"`
+	}
+	flagged := func(meta generationMeta) bool {
+		return slices.ContainsFunc(meta.Warnings, func(w string) bool { return strings.HasPrefix(w, "low_confidence:") })
+	}
+
+	t.Run("flag", func(t *testing.T) {
+		fake := setupServer(t, func(c *Config) { c.MinConfidence = 0.8 })
+		fake.respond = confidentSway(fake, -0.5)
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if !fake.requests[0].LogProbs {
+			t.Error("logprobs not requested")
+		}
+		if got := rec.Header().Get("X-Token-Confidence"); got != "0.607" {
+			t.Errorf("X-Token-Confidence %q, want 0.607", got)
+		}
+		if meta := decodeBody[response](t, rec).Meta; !flagged(meta) || fake.calls() != 1 {
+			t.Errorf("warnings %q after %d calls, want low_confidence flagged without a retry", meta.Warnings, fake.calls())
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		fake := setupServer(t, func(c *Config) {
+			c.MinConfidence = 0.8
+			c.LowConfidencePolicy = "retry"
+		})
+		fake.respond = confidentSway(fake, -0.5, -0.05)
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if got := fake.calls(); got != 2 {
+			t.Fatalf("upstream called %d times, want a retry", got)
+		}
+		meta := decodeBody[response](t, rec).Meta
+		if flagged(meta) || meta.TokenConfidence == nil || roundTo(*meta.TokenConfidence, 3) != 0.951 {
+			t.Errorf("confidence %v, warnings %q; want the retry's 0.951 unflagged", meta.TokenConfidence, meta.Warnings)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		fake := setupServer(t, nil)
+		fake.respond = confidentSway(fake, -5)
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if fake.requests[0].LogProbs {
+			t.Error("logprobs requested without min_confidence")
+		}
+		if meta := decodeBody[response](t, rec).Meta; flagged(meta) || fake.calls() != 1 {
+			t.Errorf("warnings %q after %d calls, want no gate", meta.Warnings, fake.calls())
+		}
+	})
+}
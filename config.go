@@ -0,0 +1,592 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that reads and writes JSON as a Go duration
+// string such as "30s"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Config holds every setting of the server. It is loaded once at startup
+// from defaults, an optional JSON config file and environment variables (in
+// increasing order of precedence), then validated as a whole.
+type Config struct {
+	Port         string   `json:"port"`
+	DataDir      string   `json:"data_dir"`
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+
+	// Upstream model access
+	OpenAIAPIKey     string   `json:"openai_api_key"`
+	DefaultModel     string   `json:"default_model"`
+	AllowedModels    []string `json:"allowed_models"`
+	UpstreamTimeout  Duration `json:"upstream_timeout"`
+	TranslationModel string   `json:"translation_model"`
+	ExpansionModel   string   `json:"expansion_model"`
+	// json_object or tool_call, for requests that set no response_mode
+	ResponseMode string `json:"response_mode"`
+	// Further upstream accounts requests may select, and the one used when
+	// they select none
+	UpstreamProfiles []UpstreamProfile `json:"upstream_profiles,omitempty"`
+	DefaultProfile   string            `json:"default_profile"`
+	// Let clients send their own OpenAI key in X-OpenAI-Key
+	AllowClientKey bool `json:"allow_client_key"`
+	MaxRetries     int  `json:"max_retries"`
+	// Models tried in order when the requested one is rate limited or
+	// unavailable
+	ModelFallbacks []string `json:"model_fallbacks,omitempty"`
+	RetryBackoff   Duration `json:"retry_backoff"`
+	// Longest a call waits for the token budget or a 429's retry-after
+	RateLimitMaxWait Duration `json:"rate_limit_max_wait"`
+
+	// Record upstream exchanges as fixtures or replay them instead of
+	// calling the model; fuzzy replay ignores the system prompt
+	FixtureMode  string `json:"fixture_mode,omitempty"`
+	FixtureDir   string `json:"fixture_dir"`
+	FixtureFuzzy bool   `json:"fixture_fuzzy,omitempty"`
+
+	// Oversized rigs are split into batches of at most BatchSize points
+	BatchThreshold int    `json:"batch_threshold"`
+	BatchSize      int    `json:"batch_size"`
+	BatchStrategy  string `json:"batch_strategy"`
+
+	// Order in which control points are given compact IDs: sorted by ID and
+	// role, or as sent
+	RemapOrder string `json:"remap_order"`
+	// Send control point IDs to the model as they are instead of compacting
+	// them, rejecting requests with duplicate IDs
+	DisableIDRemap bool `json:"disable_id_remap"`
+
+	// Decimal places control point positions are rounded to in the prompt,
+	// negative to send them as received
+	InputPrecision int `json:"input_precision"`
+
+	// Few-shot examples injected by prompt keyword, inline or from a file
+	Examples     []FewShotExample `json:"examples,omitempty"`
+	ExamplesFile string           `json:"examples_file,omitempty"`
+	MaxExamples  int              `json:"max_examples"`
+
+	// Extra system prompt text for the deployment's rig conventions
+	PromptSections []PromptSection `json:"prompt_sections,omitempty"`
+
+	// Named post-processing setups requests may select, inline or from a file
+	PostprocessPresets     []PostprocessPreset `json:"postprocess_presets,omitempty"`
+	PostprocessPresetsFile string              `json:"postprocess_presets_file,omitempty"`
+
+	// Response cache
+	CacheTTL          Duration `json:"cache_ttl"`
+	CacheMaxStale     Duration `json:"cache_max_stale"`
+	CacheMaxEntries   int      `json:"cache_max_entries"`
+	CacheMaxRefreshes int      `json:"cache_max_refreshes"`
+	// Rig profiles kept in memory, least recently used evicted first
+	RigProfileCacheSize int `json:"rig_profile_cache_size"`
+
+	// Named points each auxiliary point follows with auxiliary_handling
+	// interpolate, and the power their inverse distance weights fall off by
+	AuxiliaryNeighbors int     `json:"auxiliary_neighbors"`
+	AuxiliaryFalloff   float64 `json:"auxiliary_falloff"`
+
+	// Furthest, as a fraction of the rig's bounding-box diagonal, a point the
+	// prompt does not involve may move before it counts as drift (0 disables
+	// the check)
+	StaticDriftThreshold float64 `json:"static_drift_threshold"`
+	// How close to the floor, and how slow per frame, a point must be to
+	// count as touching it, as a fraction of the rig's diagonal
+	EventContactTolerance float64 `json:"event_contact_tolerance"`
+
+	// What to do when the model returns no motion: retry once, fail or allow
+	StaticPolicy  string  `json:"static_policy"`
+	StaticEpsilon float64 `json:"static_epsilon"`
+
+	// Minimum token confidence (0 disables the gate) and what to do below it:
+	// retry once or flag the response
+	MinConfidence       float64 `json:"min_confidence"`
+	LowConfidencePolicy string  `json:"low_confidence_policy"`
+
+	// Sparse response encoding: largest change per axis left out of a frame
+	// and the distance between full keyframes
+	SparseEpsilon          float64 `json:"sparse_epsilon"`
+	SparseKeyframeInterval int     `json:"sparse_keyframe_interval"`
+
+	// Concurrent upstream calls, in total and per client (0 for no per-client
+	// limit), and the queue for callers beyond the limit
+	UpstreamConcurrency       int      `json:"upstream_concurrency"`
+	UpstreamConcurrencyPerKey int      `json:"upstream_concurrency_per_key"`
+	UpstreamQueueLength       int      `json:"upstream_queue_length"`
+	UpstreamQueueTimeout      Duration `json:"upstream_queue_timeout"`
+
+	// Interactive WebSocket sessions
+	SessionMaxInFlight  int      `json:"session_max_in_flight"`
+	SessionPingInterval Duration `json:"session_ping_interval"`
+	// Browser origins, such as https://editor.example.com, allowed to open a
+	// session besides the server's own; "*" allows any
+	WebSocketAllowedOrigins []string `json:"websocket_allowed_origins"`
+
+	// Circuit breaker
+	BreakerThreshold int      `json:"breaker_threshold"`
+	BreakerCooldown  Duration `json:"breaker_cooldown"`
+
+	// Request limits and safety
+	MaxControlPoints int      `json:"max_control_points"`
+	MaxFramePoints   int      `json:"max_frame_points"`
+	PromptMaxLength  int      `json:"prompt_max_length"`
+	RoleMaxLength    int      `json:"role_max_length"`
+	PromptDenylist   []string `json:"prompt_denylist"`
+	// Multiple of the rig's bounding-box diagonal beyond which a model
+	// displacement is considered absurd
+	SanityBoundFactor float64 `json:"sanity_bound_factor"`
+	// Fail instead of ignoring control point IDs the model made up
+	StrictPointIDs bool `json:"strict_point_ids"`
+
+	// Background state
+	JobTTL Duration `json:"job_ttl"`
+	// Signing key of job completion callbacks, which are refused without
+	// one, and how they are retried
+	WebhookSecret       string   `json:"webhook_secret"`
+	WebhookMaxAttempts  int      `json:"webhook_max_attempts"`
+	WebhookRetryBackoff Duration `json:"webhook_retry_backoff"`
+	WebhookTimeout      Duration `json:"webhook_timeout"`
+	// Send callbacks to loopback, private and link-local addresses too, for
+	// receivers on the server's own network
+	WebhookAllowPrivate bool `json:"webhook_allow_private"`
+	// How often expired cache entries and jobs are evicted
+	JanitorInterval Duration `json:"janitor_interval"`
+	// Retention of stored generations; 0 disables a limit
+	HistoryMaxEntries int      `json:"history_max_entries"`
+	HistoryMaxAge     Duration `json:"history_max_age"`
+
+	// Startup warm-up generation
+	Warmup        bool     `json:"warmup"`
+	StrictWarmup  bool     `json:"strict_warmup"`
+	WarmupTimeout Duration `json:"warmup_timeout"`
+
+	// Observability and administration
+	SlowRequestThreshold Duration `json:"slow_request_threshold"`
+	AdminAPIKey          string   `json:"admin_api_key"`
+	EnableDebugEndpoints bool     `json:"enable_debug_endpoints"`
+}
+
+// Effective configuration; replaced by main once loaded
+var cfg = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Port:                   "8080",
+		ReadTimeout:            Duration{30 * time.Second},
+		DefaultModel:           "gpt-4.1",
+		DefaultProfile:         defaultProfileName,
+		AllowedModels:          []string{"gpt-4.1", "gpt-4.1-mini", "gpt-4.1-nano", "gpt-4o", "gpt-4o-mini"},
+		TranslationModel:       "gpt-4.1-mini",
+		ExpansionModel:         "gpt-4.1-mini",
+		ResponseMode:           "json_object",
+		FixtureDir:             "testdata/fixtures",
+		UpstreamTimeout:        Duration{2 * time.Minute},
+		MaxRetries:             2,
+		RetryBackoff:           Duration{time.Second},
+		RateLimitMaxWait:       Duration{30 * time.Second},
+		BatchThreshold:         120,
+		BatchSize:              60,
+		BatchStrategy:          "role",
+		RemapOrder:             "sorted",
+		InputPrecision:         4,
+		MaxExamples:            2,
+		CacheTTL:               Duration{10 * time.Minute},
+		CacheMaxStale:          Duration{24 * time.Hour},
+		CacheMaxEntries:        500,
+		CacheMaxRefreshes:      4,
+		RigProfileCacheSize:    256,
+		AuxiliaryNeighbors:     3,
+		AuxiliaryFalloff:       2,
+		StaticDriftThreshold:   0.03,
+		EventContactTolerance:  0.02,
+		StaticPolicy:           "retry",
+		StaticEpsilon:          1e-3,
+		LowConfidencePolicy:    "flag",
+		SparseEpsilon:          1e-3,
+		SparseKeyframeInterval: 30,
+		UpstreamConcurrency:    8,
+		UpstreamQueueLength:    64,
+		UpstreamQueueTimeout:   Duration{10 * time.Second},
+		SessionMaxInFlight:     4,
+		SessionPingInterval:    Duration{30 * time.Second},
+		BreakerThreshold:       5,
+		BreakerCooldown:        Duration{30 * time.Second},
+		MaxControlPoints:       512,
+		MaxFramePoints:         500000,
+		PromptMaxLength:        1000,
+		RoleMaxLength:          64,
+		SanityBoundFactor:      1000,
+		JobTTL:                 Duration{time.Hour},
+		WebhookMaxAttempts:     5,
+		WebhookRetryBackoff:    Duration{2 * time.Second},
+		WebhookTimeout:         Duration{10 * time.Second},
+		JanitorInterval:        Duration{time.Minute},
+		HistoryMaxEntries:      1000,
+		HistoryMaxAge:          Duration{30 * 24 * time.Hour},
+		WarmupTimeout:          Duration{20 * time.Second},
+		SlowRequestThreshold:   Duration{10 * time.Second},
+	}
+}
+
+// loadConfig builds the configuration from defaults, the JSON file at path
+// (if any) and the environment, and validates the result
+func loadConfig(path string) (Config, error) {
+	c := defaultConfig()
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return c, fmt.Errorf("read config file: %w", err)
+		}
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return c, fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	}
+
+	env := &envReader{}
+	env.str("PORT", &c.Port)
+	env.str("DATA_DIR", &c.DataDir)
+	env.duration("READ_TIMEOUT", &c.ReadTimeout)
+	env.duration("WRITE_TIMEOUT", &c.WriteTimeout)
+	env.str("OPENAI_API_KEY", &c.OpenAIAPIKey)
+	env.str("DEFAULT_MODEL", &c.DefaultModel)
+	env.list("ALLOWED_MODELS", ",", &c.AllowedModels)
+	env.str("DEFAULT_PROFILE", &c.DefaultProfile)
+	env.str("TRANSLATION_MODEL", &c.TranslationModel)
+	env.str("EXPANSION_MODEL", &c.ExpansionModel)
+	env.str("RESPONSE_MODE", &c.ResponseMode)
+	env.str("OPENAI_FIXTURE_MODE", &c.FixtureMode)
+	env.str("OPENAI_FIXTURE_DIR", &c.FixtureDir)
+	env.bool("OPENAI_FIXTURE_FUZZY", &c.FixtureFuzzy)
+	env.duration("UPSTREAM_TIMEOUT", &c.UpstreamTimeout)
+	env.int("MAX_RETRIES", &c.MaxRetries)
+	env.list("MODEL_FALLBACKS", ",", &c.ModelFallbacks)
+	env.duration("RETRY_BACKOFF", &c.RetryBackoff)
+	env.duration("RATE_LIMIT_MAX_WAIT", &c.RateLimitMaxWait)
+	env.bool("ALLOW_CLIENT_KEY", &c.AllowClientKey)
+	env.int("BATCH_THRESHOLD", &c.BatchThreshold)
+	env.int("BATCH_SIZE", &c.BatchSize)
+	env.str("BATCH_STRATEGY", &c.BatchStrategy)
+	env.str("REMAP_ORDER", &c.RemapOrder)
+	env.bool("DISABLE_ID_REMAP", &c.DisableIDRemap)
+	env.int("INPUT_PRECISION", &c.InputPrecision)
+	env.str("EXAMPLES_FILE", &c.ExamplesFile)
+	env.int("MAX_EXAMPLES", &c.MaxExamples)
+	env.str("POSTPROCESS_PRESETS_FILE", &c.PostprocessPresetsFile)
+	env.duration("CACHE_TTL", &c.CacheTTL)
+	env.duration("CACHE_MAX_STALE", &c.CacheMaxStale)
+	env.int("CACHE_MAX_ENTRIES", &c.CacheMaxEntries)
+	env.int("CACHE_MAX_REFRESHES", &c.CacheMaxRefreshes)
+	env.int("RIG_PROFILE_CACHE_SIZE", &c.RigProfileCacheSize)
+	env.int("AUXILIARY_NEIGHBORS", &c.AuxiliaryNeighbors)
+	env.float("AUXILIARY_FALLOFF", &c.AuxiliaryFalloff)
+	env.float("STATIC_DRIFT_THRESHOLD", &c.StaticDriftThreshold)
+	env.float("EVENT_CONTACT_TOLERANCE", &c.EventContactTolerance)
+	env.str("STATIC_POLICY", &c.StaticPolicy)
+	env.float("STATIC_EPSILON", &c.StaticEpsilon)
+	env.float("MIN_CONFIDENCE", &c.MinConfidence)
+	env.str("LOW_CONFIDENCE_POLICY", &c.LowConfidencePolicy)
+	env.float("SPARSE_EPSILON", &c.SparseEpsilon)
+	env.int("SPARSE_KEYFRAME_INTERVAL", &c.SparseKeyframeInterval)
+	env.int("UPSTREAM_CONCURRENCY", &c.UpstreamConcurrency)
+	env.int("UPSTREAM_CONCURRENCY_PER_KEY", &c.UpstreamConcurrencyPerKey)
+	env.int("UPSTREAM_QUEUE_LENGTH", &c.UpstreamQueueLength)
+	env.duration("UPSTREAM_QUEUE_TIMEOUT", &c.UpstreamQueueTimeout)
+	env.int("SESSION_MAX_IN_FLIGHT", &c.SessionMaxInFlight)
+	env.duration("SESSION_PING_INTERVAL", &c.SessionPingInterval)
+	env.list("WEBSOCKET_ALLOWED_ORIGINS", ",", &c.WebSocketAllowedOrigins)
+	env.int("BREAKER_THRESHOLD", &c.BreakerThreshold)
+	env.duration("BREAKER_COOLDOWN", &c.BreakerCooldown)
+	env.int("MAX_CONTROL_POINTS", &c.MaxControlPoints)
+	env.int("MAX_FRAME_POINTS", &c.MaxFramePoints)
+	env.int("PROMPT_MAX_LENGTH", &c.PromptMaxLength)
+	env.int("ROLE_MAX_LENGTH", &c.RoleMaxLength)
+	env.list("PROMPT_DENYLIST", ";", &c.PromptDenylist)
+	env.float("SANITY_BOUND_FACTOR", &c.SanityBoundFactor)
+	env.bool("STRICT_POINT_IDS", &c.StrictPointIDs)
+	env.duration("JOB_TTL", &c.JobTTL)
+	env.str("WEBHOOK_SECRET", &c.WebhookSecret)
+	env.int("WEBHOOK_MAX_ATTEMPTS", &c.WebhookMaxAttempts)
+	env.duration("WEBHOOK_RETRY_BACKOFF", &c.WebhookRetryBackoff)
+	env.duration("WEBHOOK_TIMEOUT", &c.WebhookTimeout)
+	env.bool("WEBHOOK_ALLOW_PRIVATE", &c.WebhookAllowPrivate)
+	env.duration("JANITOR_INTERVAL", &c.JanitorInterval)
+	env.int("HISTORY_MAX_ENTRIES", &c.HistoryMaxEntries)
+	env.duration("HISTORY_MAX_AGE", &c.HistoryMaxAge)
+	env.bool("WARMUP", &c.Warmup)
+	env.bool("STRICT_WARMUP", &c.StrictWarmup)
+	env.duration("WARMUP_TIMEOUT", &c.WarmupTimeout)
+	env.duration("SLOW_REQUEST_THRESHOLD", &c.SlowRequestThreshold)
+	env.str("ADMIN_API_KEY", &c.AdminAPIKey)
+	env.bool("ENABLE_DEBUG_ENDPOINTS", &c.EnableDebugEndpoints)
+
+	if c.ExamplesFile != "" {
+		examples, err := loadExamplesFile(c.ExamplesFile)
+		if err != nil {
+			return c, err
+		}
+		c.Examples = append(c.Examples, examples...)
+	}
+	if err := loadPromptSectionFiles(c.PromptSections); err != nil {
+		return c, err
+	}
+	if c.PostprocessPresetsFile != "" {
+		presets, err := loadPresetsFile(c.PostprocessPresetsFile)
+		if err != nil {
+			return c, err
+		}
+		c.PostprocessPresets = append(c.PostprocessPresets, presets...)
+	}
+
+	problems := append(env.problems, c.validate()...)
+	if len(problems) > 0 {
+		return c, fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return c, nil
+}
+
+// validate returns a description of every invalid field
+func (c Config) validate() []string {
+	var problems []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "port: %q is not a valid TCP port", c.Port)
+	check(c.ReadTimeout.Duration >= 0, "read_timeout: must not be negative")
+	check(c.WriteTimeout.Duration >= 0, "write_timeout: must not be negative")
+	check(c.WriteTimeout.Duration == 0 || c.WriteTimeout.Duration > c.UpstreamTimeout.Duration,
+		"write_timeout: %s must be longer than upstream_timeout %s (or 0 to disable)", c.WriteTimeout, c.UpstreamTimeout)
+	check(len(c.AllowedModels) > 0, "allowed_models: must list at least one model")
+	check(slices.Contains(c.AllowedModels, c.DefaultModel), "default_model: %q is not in allowed_models", c.DefaultModel)
+	check(c.TranslationModel != "", "translation_model: must not be empty")
+	check(c.ExpansionModel != "", "expansion_model: must not be empty")
+	check(c.ResponseMode != "" && validateResponseMode(c.ResponseMode) == nil,
+		"response_mode: %q must be json_object or tool_call", c.ResponseMode)
+	if err := validateFixtureMode(c.FixtureMode); err != nil {
+		problems = append(problems, "fixture_mode: "+err.Error())
+	}
+	check(c.FixtureMode != "record" || c.OpenAIAPIKey != "", "fixture_mode: record requires openai_api_key")
+	check(c.FixtureMode == "" || c.FixtureDir != "", "fixture_dir: must not be empty")
+	check(c.UpstreamTimeout.Duration > 0, "upstream_timeout: must be positive")
+	check(c.MaxRetries >= 0, "max_retries: must not be negative")
+	check(!slices.Contains(c.ModelFallbacks, ""), "model_fallbacks: must not contain empty model names")
+	check(c.RetryBackoff.Duration >= 0, "retry_backoff: must not be negative")
+	check(c.RateLimitMaxWait.Duration >= 0, "rate_limit_max_wait: must not be negative")
+	check(c.BatchThreshold > 0, "batch_threshold: must be positive")
+	check(c.BatchSize > 0 && c.BatchSize <= c.BatchThreshold, "batch_size: must be between 1 and batch_threshold")
+	_, knownStrategy := partitioners[c.BatchStrategy]
+	check(knownStrategy, "batch_strategy: %q is not one of role, sequential", c.BatchStrategy)
+	check(c.RemapOrder == "sorted" || c.RemapOrder == "input", "remap_order: %q is not one of sorted, input", c.RemapOrder)
+	check(c.InputPrecision <= 10, "input_precision: must be at most 10")
+	check(c.MaxExamples >= 0, "max_examples: must not be negative")
+	problems = append(problems, validateExamples(c.Examples)...)
+	problems = append(problems, validatePromptSections(c.PromptSections)...)
+	problems = append(problems, validatePresets(c.PostprocessPresets)...)
+	problems = append(problems, validateProfiles(c.UpstreamProfiles, c.DefaultProfile, c.AllowedModels, c.FixtureMode != "replay")...)
+	check(c.CacheTTL.Duration >= 0, "cache_ttl: must not be negative")
+	check(c.CacheMaxStale.Duration >= 0, "cache_max_stale: must not be negative")
+	check(c.CacheMaxEntries > 0, "cache_max_entries: must be positive")
+	check(c.CacheMaxRefreshes > 0, "cache_max_refreshes: must be positive")
+	check(c.RigProfileCacheSize > 0, "rig_profile_cache_size: must be positive")
+	check(c.AuxiliaryNeighbors > 0, "auxiliary_neighbors: must be positive")
+	check(c.AuxiliaryFalloff >= 0, "auxiliary_falloff: must not be negative")
+	check(c.StaticDriftThreshold >= 0, "static_drift_threshold: must not be negative")
+	check(c.EventContactTolerance > 0, "event_contact_tolerance: must be positive")
+	if err := validateStaticPolicy(c.StaticPolicy); err != nil {
+		problems = append(problems, "static_policy: "+err.Error())
+	}
+	check(c.StaticEpsilon >= 0, "static_epsilon: must not be negative")
+	check(c.MinConfidence >= 0 && c.MinConfidence < 1, "min_confidence: must be between 0 and 1")
+	if err := validateLowConfidencePolicy(c.LowConfidencePolicy); err != nil {
+		problems = append(problems, "low_confidence_policy: "+err.Error())
+	}
+	check(c.SparseEpsilon >= 0, "sparse_epsilon: must not be negative")
+	check(c.SparseKeyframeInterval > 0, "sparse_keyframe_interval: must be positive")
+	check(c.UpstreamConcurrency > 0, "upstream_concurrency: must be positive")
+	check(c.UpstreamConcurrencyPerKey >= 0 && c.UpstreamConcurrencyPerKey <= c.UpstreamConcurrency, "upstream_concurrency_per_key: must be between 0 and upstream_concurrency")
+	check(c.UpstreamQueueLength >= 0, "upstream_queue_length: must not be negative")
+	check(c.UpstreamQueueTimeout.Duration > 0, "upstream_queue_timeout: must be positive")
+	check(c.SessionMaxInFlight > 0, "session_max_in_flight: must be positive")
+	check(c.SessionPingInterval.Duration > 0, "session_ping_interval: must be positive")
+	check(c.BreakerThreshold > 0, "breaker_threshold: must be positive")
+	check(c.BreakerCooldown.Duration > 0, "breaker_cooldown: must be positive")
+	check(c.MaxControlPoints > 0, "max_control_points: must be positive")
+	check(c.MaxFramePoints >= 0, "max_frame_points: must not be negative")
+	check(c.PromptMaxLength > 0, "prompt_max_length: must be positive")
+	check(c.RoleMaxLength > 0, "role_max_length: must be positive")
+	check(c.SanityBoundFactor > 0, "sanity_bound_factor: must be positive")
+	check(c.JobTTL.Duration > 0, "job_ttl: must be positive")
+	check(c.WebhookMaxAttempts > 0, "webhook_max_attempts: must be positive")
+	check(c.WebhookRetryBackoff.Duration >= 0, "webhook_retry_backoff: must not be negative")
+	check(c.WebhookTimeout.Duration > 0, "webhook_timeout: must be positive")
+	check(c.JanitorInterval.Duration > 0, "janitor_interval: must be positive")
+	check(c.HistoryMaxEntries >= 0, "history_max_entries: must not be negative")
+	check(c.HistoryMaxAge.Duration >= 0, "history_max_age: must not be negative")
+	check(c.WarmupTimeout.Duration > 0, "warmup_timeout: must be positive")
+	check(!c.Warmup || c.OpenAIAPIKey != "", "warmup: requires openai_api_key")
+	check(c.SlowRequestThreshold.Duration > 0, "slow_request_threshold: must be positive")
+	check(!c.EnableDebugEndpoints || c.AdminAPIKey != "", "enable_debug_endpoints: requires admin_api_key")
+	return problems
+}
+
+const redactedSecret = "[redacted]"
+
+// redacted returns a copy safe to log or expose, with secrets masked
+func (c Config) redacted() Config {
+	if c.OpenAIAPIKey != "" {
+		c.OpenAIAPIKey = redactedSecret
+	}
+	if c.AdminAPIKey != "" {
+		c.AdminAPIKey = redactedSecret
+	}
+	if c.WebhookSecret != "" {
+		c.WebhookSecret = redactedSecret
+	}
+	c.UpstreamProfiles = slices.Clone(c.UpstreamProfiles)
+	for i, p := range c.UpstreamProfiles {
+		if p.APIKey != "" {
+			c.UpstreamProfiles[i].APIKey = redactedSecret
+		}
+		if len(p.AllowedKeys) > 0 {
+			c.UpstreamProfiles[i].AllowedKeys = []string{redactedSecret}
+		}
+	}
+	return c
+}
+
+// applyConfig rebuilds the components that are derived from configuration
+func applyConfig(c Config) {
+	cfg = c
+	promptSafety = newPromptFilter(c.PromptMaxLength, c.RoleMaxLength, c.PromptDenylist)
+	upstreamBreaker = newCircuitBreaker(c.BreakerThreshold, c.BreakerCooldown.Duration)
+	upstreamLimiter = newConcurrencyLimiter(c.UpstreamConcurrency, c.UpstreamQueueLength, c.UpstreamQueueTimeout.Duration)
+	upstreamSlots = newFairLimiter(upstreamLimiter, c.UpstreamConcurrencyPerKey, c.UpstreamQueueLength, c.UpstreamQueueTimeout.Duration)
+	jobs.setTTL(c.JobTTL.Duration)
+	resetProfileClients()
+	storeJanitor.interval = c.JanitorInterval.Duration
+	responses = newResultCache(c.CacheMaxEntries, c.CacheTTL.Duration, c.CacheMaxStale.Duration, c.CacheMaxRefreshes)
+	rigProfiles = newRigProfileCache(c.RigProfileCacheSize)
+}
+
+// Handler for the /config endpoint
+func getConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(cfg.redacted()); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
+
+// envReader overrides config fields from environment variables, collecting
+// a problem for every value that fails to parse
+type envReader struct {
+	problems []string
+}
+
+func (e *envReader) lookup(name string) (string, bool) {
+	v, ok := os.LookupEnv(name)
+	return v, ok && v != ""
+}
+
+func (e *envReader) fail(name, v, expected string) {
+	e.problems = append(e.problems, fmt.Sprintf("%s: %q is not %s", name, v, expected))
+}
+
+func (e *envReader) str(name string, dst *string) {
+	if v, ok := e.lookup(name); ok {
+		*dst = v
+	}
+}
+
+func (e *envReader) int(name string, dst *int) {
+	if v, ok := e.lookup(name); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			e.fail(name, v, "an integer")
+			return
+		}
+		*dst = n
+	}
+}
+
+func (e *envReader) float(name string, dst *float64) {
+	if v, ok := e.lookup(name); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			e.fail(name, v, "a number")
+			return
+		}
+		*dst = f
+	}
+}
+
+func (e *envReader) bool(name string, dst *bool) {
+	if v, ok := e.lookup(name); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			e.fail(name, v, "true or false")
+			return
+		}
+		*dst = b
+	}
+}
+
+func (e *envReader) duration(name string, dst *Duration) {
+	if v, ok := e.lookup(name); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			e.fail(name, v, `a duration like "30s"`)
+			return
+		}
+		dst.Duration = d
+	}
+}
+
+func (e *envReader) list(name, sep string, dst *[]string) {
+	if v, ok := e.lookup(name); ok {
+		var items []string
+		for _, item := range strings.Split(v, sep) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+	}
+}
+
+// logConfig prints the effective configuration with secrets redacted
+func logConfig(c Config) {
+	raw, _ := json.Marshal(c.redacted())
+	log.Printf("Effective configuration: %s", raw)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidation(t *testing.T) {
+	valid := defaultConfig()
+	valid.OpenAIAPIKey = "test-key"
+	if problems := valid.validate(); len(problems) != 0 {
+		t.Fatalf("default configuration has problems: %v", problems)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"port", func(c *Config) { c.Port = "http" }, "port:"},
+		{"port range", func(c *Config) { c.Port = "70000" }, "port:"},
+		{"write timeout", func(c *Config) { c.WriteTimeout = Duration{time.Second} }, "write_timeout:"},
+		{"default model", func(c *Config) { c.DefaultModel = "gpt-2" }, "default_model:"},
+		{"response mode", func(c *Config) { c.ResponseMode = "xml" }, "response_mode:"},
+		{"batch size", func(c *Config) { c.BatchSize = c.BatchThreshold + 1 }, "batch_size:"},
+		{"batch strategy", func(c *Config) { c.BatchStrategy = "random" }, "batch_strategy:"},
+		{"min confidence", func(c *Config) { c.MinConfidence = 1 }, "min_confidence:"},
+		{"per-key concurrency", func(c *Config) { c.UpstreamConcurrencyPerKey = c.UpstreamConcurrency + 1 }, "upstream_concurrency_per_key:"},
+		{"model fallbacks", func(c *Config) { c.ModelFallbacks = []string{""} }, "model_fallbacks:"},
+		{"profile name", func(c *Config) { c.UpstreamProfiles = []UpstreamProfile{{Name: "Prod", APIKey: "k"}} }, "upstream_profiles[0]:"},
+		{"default profile", func(c *Config) { c.DefaultProfile = "staging" }, "default_profile:"},
+		{"warmup", func(c *Config) { c.Warmup = true; c.OpenAIAPIKey = "" }, "warmup:"},
+		{"debug endpoints", func(c *Config) { c.EnableDebugEndpoints = true }, "enable_debug_endpoints:"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := valid
+			tc.change(&c)
+			problems := c.validate()
+			if len(problems) != 1 || !strings.HasPrefix(problems[0], tc.want) {
+				t.Errorf("problems %q, want one starting with %q", problems, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigCollectsProblems(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("MAX_RETRIES", "lots")
+	t.Setenv("UPSTREAM_TIMEOUT", "soon")
+	t.Setenv("PORT", "0")
+	_, err := loadConfig("")
+	if err == nil {
+		t.Fatal("loadConfig accepted invalid values")
+	}
+	// Every problem is reported at once, not just the first
+	for _, want := range []string{`MAX_RETRIES: "lots" is not an integer`, `UPSTREAM_TIMEOUT: "soon" is not a duration`, `port: "0" is not a valid TCP port`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q lacks %q", err, want)
+		}
+	}
+}
+
+func TestConfigEndpointRedactsSecrets(t *testing.T) {
+	setupServer(t, func(c *Config) {
+		c.OpenAIAPIKey = "sk-live-secret"
+		c.AdminAPIKey = "admin-secret"
+		c.UpstreamProfiles = []UpstreamProfile{
+			{Name: "prod", APIKey: "sk-prod-secret", AllowedKeys: []string{"client-a", "client-b"}},
+			{Name: "local", BaseURL: "http://localhost:8081"},
+		}
+	})
+	if rec := serve(t, http.MethodGet, "/config", nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without the admin key: status %d, want 401", rec.Code)
+	}
+
+	rec := serve(t, http.MethodGet, "/config", nil, http.Header{"X-Admin-Key": {"admin-secret"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	for _, secret := range []string{"sk-live-secret", "admin-secret", "sk-prod-secret", "client-a", "client-b"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Errorf("/config exposes %q", secret)
+		}
+	}
+	got := decodeBody[Config](t, rec)
+	if got.OpenAIAPIKey != redactedSecret || got.AdminAPIKey != redactedSecret {
+		t.Errorf("keys %q, %q; want them redacted", got.OpenAIAPIKey, got.AdminAPIKey)
+	}
+	// Unset secrets stay empty so operators can tell them apart
+	if got.WebhookSecret != "" {
+		t.Errorf("unset webhook_secret shown as %q", got.WebhookSecret)
+	}
+	prod, local := got.UpstreamProfiles[0], got.UpstreamProfiles[1]
+	if prod.APIKey != redactedSecret || len(prod.AllowedKeys) != 1 || prod.AllowedKeys[0] != redactedSecret {
+		t.Errorf("prod profile %+v, want its keys redacted", prod)
+	}
+	if local.APIKey != "" || local.BaseURL != "http://localhost:8081" {
+		t.Errorf("local profile %+v, want no key and its base URL", local)
+	}
+	// Redacting works on a copy
+	if cfg.UpstreamProfiles[0].APIKey != "sk-prod-secret" {
+		t.Error("redacting changed the live configuration")
+	}
+}
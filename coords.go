@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+type SphericalPayload []map[int]SphericalDeformation
+
+func validateOutputCoords(coords string, pivot []float64) error {
+	switch coords {
+	case "", "cartesian", "spherical":
+	default:
+		return fmt.Errorf("invalid output_coords %q, expected cartesian or spherical", coords)
+	}
+	if pivot != nil && len(pivot) != 3 {
+		return fmt.Errorf("spherical_pivot must have exactly 3 components")
+	}
+	return nil
+}
+
+// cartesianToSpherical converts a vector to (r, theta, phi)
+func cartesianToSpherical(x, y, z float64) (r, theta, phi float64) {
+	r = math.Sqrt(x*x + y*y + z*z)
+	if r == 0 {
+		return 0, 0, 0
+	}
+	theta = math.Atan2(z, x)
+	phi = math.Atan2(y, math.Hypot(x, z))
+	return r, theta, phi
+}
+
+// sphericalToCartesian is the inverse of cartesianToSpherical
+func sphericalToCartesian(r, theta, phi float64) (x, y, z float64) {
+	horizontal := r * math.Cos(phi)
+	return horizontal * math.Cos(theta), r * math.Sin(phi), horizontal * math.Sin(theta)
+}
+
+// wrapAngle maps an angle difference into (-pi, pi]
+func wrapAngle(a float64) float64 {
+	a = math.Mod(a+math.Pi, 2*math.Pi)
+	if a <= 0 {
+		a += 2 * math.Pi
+	}
+	return a - math.Pi
+}
+
+// toSpherical re-expresses cartesian deltas as changes in spherical
+// coordinates about a pivot, with up as the polar axis. Without a pivot each
+// point's own original position is used, so the result describes the delta
+// vector itself.
+func toSpherical(frames ResponsePayload, positions map[int][]float64, pivot []float64, up axisDirection) SphericalPayload {
+	// The two other axes in order, spanning the plane theta turns in
+	var plane []int
+	for axis := range 3 {
+		if axis != up.index {
+			plane = append(plane, axis)
+		}
+	}
+	polar := func(x, y, z float64) (r, theta, phi float64) {
+		v := [3]float64{x, y, z}
+		return cartesianToSpherical(v[plane[0]], up.sign*v[up.index], v[plane[1]])
+	}
+	result := make(SphericalPayload, len(frames))
+	for i, frame := range frames {
+		converted := make(map[int]SphericalDeformation, len(frame))
+		for id, d := range frame {
+			var bx, by, bz float64
+			if origin := positions[id]; pivot != nil && len(origin) >= 3 {
+				bx, by, bz = origin[0]-pivot[0], origin[1]-pivot[1], origin[2]-pivot[2]
+			}
+			r0, theta0, phi0 := polar(bx, by, bz)
+			r1, theta1, phi1 := polar(bx+d.DeltaX, by+d.DeltaY, bz+d.DeltaZ)
+			converted[id] = SphericalDeformation{
+				DeltaR:     roundDelta(r1 - r0),
+				DeltaTheta: roundTo(wrapAngle(theta1-theta0), 4),
+				DeltaPhi:   roundTo(phi1-phi0, 4),
+			}
+		}
+		result[i] = converted
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCartesianToSphericalPolesAndOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		x, y, z       float64
+		r, theta, phi float64
+	}{
+		// The origin has no direction; it is all zeros rather than NaN
+		{"origin", 0, 0, 0, 0, 0, 0},
+		// At the poles the azimuth is undefined and reported as 0
+		{"north pole", 0, 2, 0, 2, 0, math.Pi / 2},
+		{"south pole", 0, -2, 0, 2, 0, -math.Pi / 2},
+		{"+x", 1, 0, 0, 1, 0, 0},
+		{"+z", 0, 0, 1, 1, math.Pi / 2, 0},
+		{"-x", -1, 0, 0, 1, math.Pi, 0},
+	} {
+		r, theta, phi := cartesianToSpherical(tc.x, tc.y, tc.z)
+		if math.IsNaN(r) || math.IsNaN(theta) || math.IsNaN(phi) {
+			t.Errorf("%s: NaN in (%v, %v, %v)", tc.name, r, theta, phi)
+			continue
+		}
+		if math.Abs(r-tc.r) > 1e-12 || math.Abs(theta-tc.theta) > 1e-12 || math.Abs(phi-tc.phi) > 1e-12 {
+			t.Errorf("%s: (%v, %v, %v), want (%v, %v, %v)", tc.name, r, theta, phi, tc.r, tc.theta, tc.phi)
+		}
+		x, y, z := sphericalToCartesian(r, theta, phi)
+		if math.Abs(x-tc.x) > 1e-12 || math.Abs(y-tc.y) > 1e-12 || math.Abs(z-tc.z) > 1e-12 {
+			t.Errorf("%s: round trip gives (%v, %v, %v)", tc.name, x, y, z)
+		}
+	}
+}
+
+func TestWrapAngle(t *testing.T) {
+	for in, want := range map[float64]float64{
+		0:                0,
+		math.Pi:          math.Pi,
+		-math.Pi:         math.Pi,
+		3 * math.Pi / 2:  -math.Pi / 2,
+		-3 * math.Pi / 2: math.Pi / 2,
+		5 * math.Pi:      math.Pi,
+	} {
+		if got := wrapAngle(in); math.Abs(got-want) > 1e-12 {
+			t.Errorf("wrapAngle(%v) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestToSpherical(t *testing.T) {
+	positions := map[int][]float64{0: {0, 1, 0}, 1: {-1, 1, 0.001}}
+	frames := ResponsePayload{{
+		// Straight up from the pivot's own height
+		0: {DeltaY: 0.5},
+		// Across the -x seam of the azimuth, where atan2 jumps from π to -π
+		1: {DeltaZ: -0.002},
+	}}
+
+	// Without a pivot each delta is described from the origin, so a point
+	// moving straight up heads for the pole
+	got := toSpherical(frames, positions, nil, upAxis(nil))
+	if d := got[0][0]; d.DeltaR != 0.5 || d.DeltaTheta != 0 || d.DeltaPhi != roundTo(math.Pi/2, 4) {
+		t.Errorf("no pivot, up: %+v", d)
+	}
+
+	// About a pivot at point 0, so point 1 sits on the -x side of it
+	got = toSpherical(frames, positions, []float64{0, 1, 0}, upAxis(nil))
+	if d := got[0][0]; d.DeltaR != 0.5 || d.DeltaPhi != roundTo(math.Pi/2, 4) {
+		t.Errorf("pivot, up: %+v", d)
+	}
+	if d := got[0][1]; d.DeltaR != 0 || d.DeltaTheta != 0.002 || d.DeltaPhi != 0 {
+		t.Errorf("across the seam: %+v, want a small turn rather than a full one", d)
+	}
+
+	// With Z up the pole is along z
+	got = toSpherical(ResponsePayload{{0: {DeltaZ: 1}}}, positions, nil, upAxis(&AxesConvention{Up: "z"}))
+	if d := got[0][0]; d.DeltaR != 1 || d.DeltaPhi != roundTo(math.Pi/2, 4) {
+		t.Errorf("z up: %+v", d)
+	}
+	got = toSpherical(ResponsePayload{{0: {DeltaZ: 1}}}, positions, nil, upAxis(&AxesConvention{Up: "-z"}))
+	if d := got[0][0]; d.DeltaPhi != -roundTo(math.Pi/2, 4) {
+		t.Errorf("-z up: %+v", d)
+	}
+}
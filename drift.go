@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Frames over which on_drift zero fades a drifting point's motion out and
+// back in, so it does not snap
+const driftBlendFrames = 3
+
+// Words that set the whole body in motion, which turn the drift check off
+var wholeBodyWords = map[string]bool{
+	"body": true, "whole": true, "entire": true,
+	"dance": true, "dances": true, "dancing": true, "jump": true, "jumps": true, "jumping": true,
+	"walk": true, "walks": true, "walking": true, "run": true, "runs": true, "running": true,
+	"jog": true, "jogging": true, "spin": true, "spins": true, "spinning": true, "turn": true,
+	"turns": true, "turning": true, "twirl": true, "bow": true, "bows": true, "bowing": true,
+	"crouch": true, "crouches": true, "crouching": true, "squat": true, "squats": true,
+	"squatting": true, "stretch": true, "stretches": true, "stretching": true, "fall": true,
+	"falls": true, "falling": true, "shiver": true, "shivers": true, "shivering": true,
+}
+
+// Verbs that imply a family outside the limbs
+var familyVerbs = map[string]string{
+	"nod": "head", "nods": "head", "nodding": "head", "wag": "tail", "wags": "tail", "wagging": "tail",
+	"breathe": "torso", "breathes": "torso", "breathing": "torso",
+}
+
+func validateOnDrift(mode string) error {
+	switch mode {
+	case "", "warn", "zero", "retry":
+		return nil
+	}
+	return fmt.Errorf("invalid on_drift %q, expected warn, zero or retry", mode)
+}
+
+// A point the prompt leaves out that the model moved anyway
+type staticDrift struct {
+	Point int    `json:"point"`
+	Role  string `json:"role"`
+	// Largest distance from the input pose over the clip
+	Displacement float64 `json:"displacement"`
+}
+
+// promptFamilies returns the role families a prompt involves, such as
+// "right arm" or "head", and false when it moves the whole body or names no
+// part of it, so every point may move
+func promptFamilies(prompt string) (map[string]bool, bool) {
+	words := strings.FieldsFunc(strings.ToLower(prompt), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	})
+	sides := make(map[string]bool)
+	families := make(map[string]bool)
+	for _, w := range words {
+		switch {
+		case wholeBodyWords[w]:
+			return nil, false
+		case w == "left" || w == "right":
+			sides[w] = true
+		case limbNouns[w] != "":
+			families[limbNouns[w]] = true
+		case limbVerbs[w] != "":
+			families[limbVerbs[w]] = true
+		case familyVerbs[w] != "":
+			families[familyVerbs[w]] = true
+		default:
+			for _, f := range roleFamilies {
+				if slices.Contains(f.keywords, w) || slices.Contains(f.keywords, strings.TrimSuffix(w, "s")) {
+					families[f.family] = true
+				}
+			}
+		}
+	}
+	if len(families) == 0 {
+		return nil, false
+	}
+	// A single side narrows the limbs to that side; otherwise both move
+	involved := make(map[string]bool)
+	for family := range families {
+		involved[family] = true
+		if family != "arm" && family != "leg" {
+			continue
+		}
+		for _, side := range []string{"left", "right"} {
+			if len(sides) != 1 || sides[side] {
+				involved[side+" "+family] = true
+			}
+		}
+		if len(sides) == 1 {
+			delete(involved, family)
+		}
+	}
+	return involved, true
+}
+
+// detectStaticDrift measures, for every point whose role belongs to a family
+// the prompt does not involve, how far it moves from the input pose, and
+// lists those that move further than threshold, furthest first. deltas are
+// offsets from the input pose. Points whose role matches no family are left
+// alone, as nothing says whether they should move.
+func detectStaticDrift(prompt string, deltas ResponsePayload, points []ControlPoint, threshold float64) []staticDrift {
+	involved, ok := promptFamilies(prompt)
+	if !ok || threshold <= 0 {
+		return nil
+	}
+	var drift []staticDrift
+	for _, cp := range points {
+		family := roleFamily(cp.Role)
+		_, unsided, sided := strings.Cut(family, " ")
+		switch {
+		case family == "other" || involved[family]:
+			continue
+		case sided && involved[unsided]:
+			continue
+		case !sided && (involved["left "+family] || involved["right "+family]):
+			continue
+		}
+		peak := 0.0
+		for _, frame := range deltas {
+			if d, ok := frame[cp.ID]; ok {
+				peak = math.Max(peak, deltaNorm(d))
+			}
+		}
+		if peak > threshold {
+			drift = append(drift, staticDrift{Point: cp.ID, Role: cp.Role, Displacement: roundDelta(peak)})
+		}
+	}
+	slices.SortStableFunc(drift, func(a, b staticDrift) int {
+		return cmp.Or(cmp.Compare(b.Displacement, a.Displacement), cmp.Compare(a.Point, b.Point))
+	})
+	return drift
+}
+
+func deltaNorm(d Deformation) float64 {
+	return math.Sqrt(d.DeltaX*d.DeltaX + d.DeltaY*d.DeltaY + d.DeltaZ*d.DeltaZ)
+}
+
+func staticDriftWarning(drift []staticDrift) string {
+	parts := make([]string, len(drift))
+	for i, d := range drift {
+		parts[i] = fmt.Sprintf("%d (%s) by up to %.3g", d.Point, d.Role, d.Displacement)
+	}
+	return "static_drift: control points the prompt does not involve moved: " + strings.Join(parts, ", ")
+}
+
+// zeroStaticDrift holds each drifting point at the input pose from the
+// first frame it strays further than threshold to the last, fading its
+// motion out over the blend frames before and back in over those after.
+// offsets are the input pose's offsets from rest, which frames are relative
+// to.
+func zeroStaticDrift(frames ResponsePayload, drift []staticDrift, offsets map[int]Deformation, threshold float64, blend int) ResponsePayload {
+	for _, d := range drift {
+		id, offset := d.Point, offsets[d.Point]
+		first, last := -1, -1
+		for i, frame := range frames {
+			if delta, ok := frame[id]; ok && deltaNorm(addDelta(delta, scaleDelta(offset, -1))) > threshold {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		if first < 0 {
+			continue
+		}
+		for i, frame := range frames {
+			delta, ok := frame[id]
+			if !ok {
+				continue
+			}
+			// Share of the motion kept: none within the span, ramping back
+			// to all of it blend+1 frames away
+			distance := max(first-i, i-last, 0)
+			keep := math.Min(1, float64(distance)/float64(blend+1))
+			frame[id] = addDelta(offset, scaleDelta(addDelta(delta, scaleDelta(offset, -1)), keep))
+		}
+	}
+	return frames
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestPromptFamilies(t *testing.T) {
+	for prompt, want := range map[string][]string{
+		"raise the right hand":       {"right arm"},
+		"wave with both hands":       {"arm", "left arm", "right arm"},
+		"nod and kick with the left": {"head", "left leg"},
+	} {
+		got, ok := promptFamilies(prompt)
+		if keys := slices.Sorted(maps.Keys(got)); !ok || !slices.Equal(keys, want) {
+			t.Errorf("%q involves %v (%v), want %v", prompt, keys, ok, want)
+		}
+	}
+	// Whole-body motion, or no body part named, lets every point move
+	for _, prompt := range []string{"dance with the right hand", "sway gently"} {
+		if _, ok := promptFamilies(prompt); ok {
+			t.Errorf("%q limited the points that may move", prompt)
+		}
+	}
+}
+
+func TestDetectStaticDrift(t *testing.T) {
+	deltas := ResponsePayload{
+		{0: {}, 1: {}, 2: {}},
+		{0: {DeltaX: 0.1}, 1: {DeltaY: 0.2}, 2: {DeltaY: 0.5}, 3: {DeltaZ: 0.01}},
+	}
+	got := detectStaticDrift("raise the right hand", deltas, testRig(), 0.05)
+	want := []staticDrift{{Point: 1, Role: "left hand", Displacement: 0.2}, {Point: 0, Role: "head", Displacement: 0.1}}
+	if !slices.Equal(got, want) {
+		t.Errorf("drift %+v, want %+v", got, want)
+	}
+	if got := detectStaticDrift("dance", deltas, testRig(), 0.05); got != nil {
+		t.Errorf("whole-body prompt reported drift %+v", got)
+	}
+}
+
+func TestZeroStaticDrift(t *testing.T) {
+	// Point 0 starts 0.5 off rest and strays 1 from there in frames 4 and 5
+	offset := Deformation{DeltaY: 0.5}
+	frames := make(ResponsePayload, 10)
+	for i := range frames {
+		moved := 0.01
+		if i == 4 || i == 5 {
+			moved = 1
+		}
+		frames[i] = map[int]Deformation{0: {DeltaX: moved, DeltaY: 0.5}}
+	}
+	zeroed := zeroStaticDrift(frames, []staticDrift{{Point: 0}}, map[int]Deformation{0: offset}, 0.1, 2)
+	// Held at the input pose in the span, fading in a third a frame
+	want := []float64{0.01, 0.01, 0.01 * 2 / 3, 0.01 / 3, 0, 0, 0.01 / 3, 0.01 * 2 / 3, 0.01, 0.01}
+	for i, frame := range zeroed {
+		if got := frame[0]; math.Abs(got.DeltaX-want[i]) > 1e-12 || got.DeltaY != 0.5 {
+			t.Errorf("frame %d: %+v, want x %v at the input pose's y 0.5", i, got, want[i])
+		}
+	}
+}
+
+func TestStaticDriftOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	// The right hand rises as asked and the head sways along x with it,
+	// unless the model was told to keep still
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		corrected := strings.Contains(req.Messages[0].Content, "Keep them at their original positions")
+		return framesResponse(input.Length, func(f int) map[string]Position {
+			frame := make(map[string]Position)
+			for _, cp := range input.ControlPoints {
+				p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+				switch {
+				case cp.Role == "right hand":
+					p.Y += 0.1 * float64(f)
+				case cp.Role == "head" && !corrected:
+					p.X += 0.03 * float64(f)
+				}
+				frame[strconv.Itoa(cp.ID)] = p
+			}
+			return frame
+		}), nil
+	}
+	// IDs from 10, so the model knows the points by others
+	rig := testRig()
+	for i := range rig {
+		rig[i].ID += 10
+	}
+	type response struct {
+		Frames []map[int]Deformation `json:"frames"`
+		Meta   generationMeta        `json:"meta"`
+	}
+	send := func(mode string) response {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "raise the right hand", Length: 6, CacheMode: cacheFresh, OnDrift: mode}}
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", mode, rec.Code, rec.Body)
+		}
+		return decodeBody[response](t, rec)
+	}
+	driftWarning := func(w string) bool { return strings.HasPrefix(w, "static_drift: ") }
+
+	body := send("warn")
+	if want := []staticDrift{{Point: 10, Role: "head", Displacement: 0.15}}; !slices.Equal(body.Meta.Drift, want) {
+		t.Errorf("warn: meta.static_drift %+v, want %+v", body.Meta.Drift, want)
+	}
+	if !slices.ContainsFunc(body.Meta.Warnings, driftWarning) || body.Frames[5][10].DeltaX != 0.15 {
+		t.Errorf("warn: warnings %q with the head at %+v, want a warning and the drift kept", body.Meta.Warnings, body.Frames[5][10])
+	}
+
+	body = send("zero")
+	for i, frame := range body.Frames {
+		if frame[10].DeltaX > 0.03*float64(i) || frame[12].DeltaY != roundDelta(0.1*float64(i)) {
+			t.Errorf("zero: frame %d has the head at %+v and the hand at %+v", i, frame[10], frame[12])
+		}
+	}
+	if got := body.Frames[5][10]; got != (Deformation{}) {
+		t.Errorf("zero: head at %+v in the last frame, want it held", got)
+	}
+	if !slices.ContainsFunc(body.Meta.Warnings, func(w string) bool { return driftWarning(w) && strings.HasSuffix(w, "held at their input positions") }) {
+		t.Errorf("zero: warnings %q", body.Meta.Warnings)
+	}
+
+	calls := fake.calls()
+	body = send("retry")
+	if fake.calls() != calls+2 || body.Meta.Drift != nil || slices.ContainsFunc(body.Meta.Warnings, driftWarning) {
+		t.Errorf("retry: %d calls, drift %+v, warnings %q; want a corrected second call", fake.calls()-calls, body.Meta.Drift, body.Meta.Warnings)
+	}
+	if system := fake.requests[len(fake.requests)-1].Messages[0].Content; !strings.Contains(system, "moved control points 0 (head)") {
+		t.Error("retry: the model was not told which points drifted")
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "wave", Length: 4, OnDrift: "ignore"}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("on_drift ignore: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// One upstream call a request would make
+type dryRunCall struct {
+	// Set when the rig is split into batches
+	Batch    *dryRunBatch    `json:"batch,omitempty"`
+	Messages []dryRunMessage `json:"messages"`
+}
+
+type dryRunBatch struct {
+	Index int    `json:"index"`
+	Count int    `json:"count"`
+	Name  string `json:"name"`
+}
+
+type dryRunMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Response of /generate-deformations/dry-run
+type DryRunResponse struct {
+	Model string `json:"model"`
+	// Prompt sections included in the system prompt, in order
+	PromptSections []string     `json:"prompt_sections"`
+	Calls          []dryRunCall `json:"calls"`
+	// Post-processing stages the generation would run, in order
+	Postprocess *postprocessReport `json:"postprocess"`
+	// Expected size of the response frames
+	Response responseEstimate `json:"response"`
+	Warnings []string         `json:"warnings"`
+}
+
+// Handler for the /generate-deformations/dry-run endpoint. It validates a
+// generation request and returns the fully assembled prompts without calling
+// the model.
+func dryRun(w http.ResponseWriter, r *http.Request) {
+	var payload RequestPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	if _, err := applyProfile(r.Context(), &payload); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := validatePayload(&payload); err != nil {
+		writeError(w, err)
+		return
+	}
+	points := preparePoints(&payload)
+
+	warnings := append([]string{}, conventionWarnings(payload)...)
+	// Translation needs an upstream call; show what the hint would look like
+	if payload.PromptLanguageMode == "translate" {
+		payload.PromptLanguageMode = "hint"
+		warnings = append(warnings, "Prompt translation is skipped in a dry run; the prompt is shown untranslated with a language hint")
+	}
+	_, _, languageWarnings := resolvePromptLanguage(r.Context(), nil, &payload)
+	warnings = append(warnings, languageWarnings...)
+	if payload.ExpandPrompt {
+		warnings = append(warnings, "Prompt expansion is skipped in a dry run; the prompt is shown as written")
+	}
+
+	response := DryRunResponse{
+		Model:          payload.Model,
+		PromptSections: []string{},
+		Postprocess:    describePipeline(payload),
+		Response:       estimateResponse(payload, points.places),
+		Warnings:       warnings,
+	}
+	for _, s := range promptSectionsFor(payload) {
+		response.PromptSections = append(response.PromptSections, s.Name)
+	}
+
+	groups := planBatches(payload.ControlPoints)
+	if len(groups) <= 1 {
+		groups = []pointGroup{{Points: payload.ControlPoints}}
+	}
+	for i, group := range groups {
+		callPayload := payload
+		var contextPoints []ControlPoint
+		if len(groups) > 1 {
+			callPayload.batch = &batchPosition{Index: i + 1, Count: len(groups), Name: group.Name}
+			for j, other := range groups {
+				if j != i {
+					contextPoints = append(contextPoints, other.Points...)
+				}
+			}
+		}
+		messages, _, err := buildMessages(callPayload, group.Points, contextPoints)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		var call dryRunCall
+		if b := callPayload.batch; b != nil {
+			call.Batch = &dryRunBatch{Index: b.Index, Count: b.Count, Name: b.Name}
+		}
+		for _, m := range messages {
+			call.Messages = append(call.Messages, dryRunMessage{Role: m.Role, Content: m.Content})
+		}
+		response.Calls = append(response.Calls, call)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
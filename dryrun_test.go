@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestDryRunPromptSections(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.PromptSections = []PromptSection{
+			{Name: "camera", Content: "The camera looks down -Z.", Optional: true},
+			{Name: "skeleton", Heading: "Skeleton Conventions", Content: "Hands are wrists.\n"},
+			{Name: "props", Content: "Props follow the right hand.", Optional: true},
+		}
+	})
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4, PromptSections: []string{"props"}}}
+	rec := serve(t, http.MethodPost, "/generate-deformations/dry-run", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	got := decodeBody[DryRunResponse](t, rec)
+	// Required sections and the selected ones, in configuration order
+	if !slices.Equal(got.PromptSections, []string{"skeleton", "props"}) {
+		t.Errorf("prompt_sections %q, want skeleton and props", got.PromptSections)
+	}
+	if len(got.Calls) != 1 || got.Calls[0].Batch != nil {
+		t.Fatalf("calls %+v, want one unbatched call", got.Calls)
+	}
+	messages := got.Calls[0].Messages
+	system, user := messages[0], messages[len(messages)-1]
+	if system.Role != openai.ChatMessageRoleSystem || user.Role != openai.ChatMessageRoleUser {
+		t.Fatalf("messages run from %s to %s, want system to user", system.Role, user.Role)
+	}
+	skeleton := strings.Index(system.Content, "\n**Skeleton Conventions**:\nHands are wrists.\n")
+	props := strings.Index(system.Content, "\n**props**:\nProps follow the right hand.\n")
+	if skeleton < 0 || props < skeleton {
+		t.Errorf("system prompt lacks the sections in order:\n%s", system.Content)
+	}
+	if strings.Contains(system.Content, "camera") {
+		t.Error("system prompt includes the unselected camera section")
+	}
+	if !strings.HasPrefix(user.Content, userDataStart) || !strings.Contains(user.Content, `"prompt":"wave"`) {
+		t.Errorf("user message %q, want the fenced request data", user.Content)
+	}
+
+	for _, selection := range [][]string{{"skeleton"}, {"lighting"}} {
+		payload.PromptSections = selection
+		if rec := serve(t, http.MethodPost, "/generate-deformations/dry-run", payload, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("selecting %q: status %d, want 400", selection, rec.Code)
+		}
+	}
+	if got := fake.calls(); got != 0 {
+		t.Errorf("dry runs called the model %d times", got)
+	}
+}
+
+func TestDryRunBatches(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.BatchThreshold = 10
+		c.BatchSize = 6
+	})
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: limbRig(), Prompt: "wave", Length: 4}}
+	rec := serve(t, http.MethodPost, "/generate-deformations/dry-run", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	calls := decodeBody[DryRunResponse](t, rec).Calls
+	if len(calls) < 2 {
+		t.Fatalf("%d calls, want the rig split into batches", len(calls))
+	}
+	for i, call := range calls {
+		if call.Batch == nil || call.Batch.Index != i+1 || call.Batch.Count != len(calls) {
+			t.Errorf("call %d batch %+v, want part %d of %d", i, call.Batch, i+1, len(calls))
+		}
+		if system := call.Messages[0].Content; !strings.Contains(system, "animated in") {
+			t.Errorf("call %d system prompt does not describe its part", i)
+		}
+	}
+	if got := fake.calls(); got != 0 {
+		t.Errorf("dry run called the model %d times", got)
+	}
+}
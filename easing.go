@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+func validateEasing(e Easing) error {
+	if e.InFrames < 0 || e.OutFrames < 0 {
+		return fmt.Errorf("easing in_frames and out_frames must not be negative")
+	}
+	switch e.Curve {
+	case "", "linear", "cubic", "sine":
+		return nil
+	}
+	return fmt.Errorf("invalid easing curve %q, expected linear, cubic or sine", e.Curve)
+}
+
+func validateEasingOptions(opts *EasingOptions) error {
+	if err := validateEasing(opts.Easing); err != nil {
+		return err
+	}
+	for _, e := range opts.Points {
+		if err := validateEasing(e); err != nil {
+			return err
+		}
+	}
+	for _, e := range opts.Groups {
+		if err := validateEasing(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// easingCurve maps t in [0, 1] onto the named curve. Every curve returns
+// exactly 0 at t=0 and exactly 1 at t=1.
+func easingCurve(curve string, t float64) float64 {
+	if t <= 0 {
+		return 0
+	}
+	if t >= 1 {
+		return 1
+	}
+	switch curve {
+	case "cubic":
+		return t * t * (3 - 2*t)
+	case "sine":
+		return 0.5 - 0.5*math.Cos(math.Pi*t)
+	}
+	return t
+}
+
+// easingWeights returns the ease-in and ease-out multipliers for a frame
+func easingWeights(e Easing, frameIndex, frameCount int) (float64, float64) {
+	in, out := 1.0, 1.0
+	if e.InFrames > 0 && frameIndex < e.InFrames {
+		in = easingCurve(e.Curve, float64(frameIndex)/float64(e.InFrames))
+	}
+	fromEnd := frameCount - 1 - frameIndex
+	if e.OutFrames > 0 && fromEnd < e.OutFrames {
+		out = easingCurve(e.Curve, float64(fromEnd)/float64(e.OutFrames))
+	}
+	return in, out
+}
+
+// applyEasing fades motion in from the rest pose and back out again. The
+// first frame is exactly rest when in_frames > 0 and the last frame exactly
+// reaches the ease-out target when out_frames > 0. For loops the ease-out
+// target is the (eased) first frame pose instead of rest so the seam closes.
+func applyEasing(frames ResponsePayload, opts *EasingOptions, roles map[int]string, loop bool) ResponsePayload {
+	if opts == nil || len(frames) == 0 {
+		return frames
+	}
+
+	envelopeFor := func(id int) Easing {
+		if e, ok := opts.Points[id]; ok {
+			return e
+		}
+		if e, ok := opts.Groups[roles[id]]; ok {
+			return e
+		}
+		return opts.Easing
+	}
+
+	result := make(ResponsePayload, len(frames))
+	for i := range frames {
+		result[i] = make(map[int]Deformation, len(frames[i]))
+	}
+
+	for _, id := range frameIDs(frames) {
+		e := envelopeFor(id)
+		// Ease in first so a looping ease-out sees the final first-frame pose
+		eased := make([]Deformation, len(frames))
+		for i, frame := range frames {
+			in, _ := easingWeights(e, i, len(frames))
+			eased[i] = scaleDelta(frame[id], in)
+		}
+
+		var target Deformation
+		if loop {
+			target = eased[0]
+		}
+		for i, frame := range frames {
+			if _, ok := frame[id]; !ok {
+				continue
+			}
+			_, out := easingWeights(e, i, len(frames))
+			d := lerpDelta(target, eased[i], out)
+			result[i][id] = d
+		}
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEasingCurves(t *testing.T) {
+	for _, curve := range []string{"", "linear", "cubic", "sine"} {
+		if got := easingCurve(curve, 0); got != 0 {
+			t.Errorf("%q at 0: %v, want 0", curve, got)
+		}
+		if got := easingCurve(curve, 1); got != 1 {
+			t.Errorf("%q at 1: %v, want 1", curve, got)
+		}
+		// Every curve is symmetric about its midpoint
+		if got := easingCurve(curve, 0.5); math.Abs(got-0.5) > 1e-12 {
+			t.Errorf("%q at 0.5: %v, want 0.5", curve, got)
+		}
+		previous := 0.0
+		for i := 1; i <= 10; i++ {
+			v := easingCurve(curve, float64(i)/10)
+			if v < previous {
+				t.Errorf("%q falls from %v to %v at %v", curve, previous, v, float64(i)/10)
+			}
+			previous = v
+		}
+	}
+	// Cubic and sine start slower than linear
+	for _, curve := range []string{"cubic", "sine"} {
+		if got := easingCurve(curve, 0.25); got >= 0.25 {
+			t.Errorf("%q at 0.25: %v, want below linear", curve, got)
+		}
+	}
+}
+
+// constantFrames is count frames holding point 0 at delta_x 1
+func constantFrames(count int) ResponsePayload {
+	frames := make(ResponsePayload, count)
+	for f := range frames {
+		frames[f] = map[int]Deformation{0: {DeltaX: 1}}
+	}
+	return frames
+}
+
+func TestApplyEasingEnvelope(t *testing.T) {
+	opts := &EasingOptions{Easing: Easing{InFrames: 4, OutFrames: 2}}
+	got := applyEasing(constantFrames(10), opts, nil, false)
+	// Ramps from rest over four frames, holds, then falls back to rest
+	want := []float64{0, 0.25, 0.5, 0.75, 1, 1, 1, 1, 0.5, 0}
+	for f, w := range want {
+		if d := got[f][0].DeltaX; math.Abs(d-w) > 1e-12 {
+			t.Errorf("frame %d: delta_x %v, want %v", f, d, w)
+		}
+	}
+}
+
+func TestApplyEasingLoop(t *testing.T) {
+	frames := make(ResponsePayload, 8)
+	for f := range frames {
+		frames[f] = map[int]Deformation{0: {DeltaX: 1 + float64(f)}}
+	}
+	for _, opts := range []*EasingOptions{
+		{Easing: Easing{OutFrames: 3}},
+		{Easing: Easing{InFrames: 2, OutFrames: 3, Curve: "sine"}},
+	} {
+		got := applyEasing(frames, opts, nil, true)
+		// The loop eases out onto its first frame, not onto rest, so the
+		// seam closes
+		first, last := got[0][0], got[len(got)-1][0]
+		if last != first {
+			t.Errorf("%+v: last frame %+v, want the first frame %+v", opts.Easing, last, first)
+		}
+		if opts.InFrames == 0 && first.DeltaX != 1 {
+			t.Errorf("%+v: first frame %+v, want it untouched", opts.Easing, first)
+		}
+		if opts.InFrames > 0 && first.DeltaX != 0 {
+			t.Errorf("%+v: first frame %+v, want rest", opts.Easing, first)
+		}
+		// Frames before the window are untouched
+		if got[3][0].DeltaX != 4 {
+			t.Errorf("%+v: frame 3 delta_x %v, want 4", opts.Easing, got[3][0].DeltaX)
+		}
+	}
+}
+
+func TestApplyEasingOverrides(t *testing.T) {
+	frames := make(ResponsePayload, 5)
+	for f := range frames {
+		frames[f] = map[int]Deformation{0: {DeltaX: 1}, 1: {DeltaX: 1}, 2: {DeltaX: 1}}
+	}
+	opts := &EasingOptions{
+		Easing: Easing{InFrames: 4},
+		Points: map[int]Easing{1: {}},
+		Groups: map[string]Easing{"tail": {InFrames: 2}},
+	}
+	got := applyEasing(frames, opts, map[int]string{1: "tail", 2: "tail"}, false)
+	// Point 0 takes the clip-wide envelope, point 1 its own (none), which
+	// wins over its role's, and point 2 its role's
+	for id, want := range map[int]float64{0: 0.25, 1: 1, 2: 0.5} {
+		if d := got[1][id].DeltaX; d != want {
+			t.Errorf("point %d at frame 1: delta_x %v, want %v", id, d, want)
+		}
+	}
+}
+
+func TestValidateEasing(t *testing.T) {
+	for _, e := range []Easing{{InFrames: -1}, {OutFrames: -1}, {Curve: "bounce"}} {
+		if validateEasing(e) == nil {
+			t.Errorf("%+v accepted", e)
+		}
+	}
+	if err := validateEasingOptions(&EasingOptions{Groups: map[string]Easing{"arm": {Curve: "elastic"}}}); err == nil {
+		t.Error("invalid group curve accepted")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// apiError is an error carrying the HTTP status and machine-readable code it
+// should be reported with
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	Details any
+	// RetryAfter, when set, is sent as a Retry-After header
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, format string, args ...any) *apiError {
+	return &apiError{Status: status, Code: defaultErrorCode(status), Message: fmt.Sprintf(format, args...)}
+}
+
+// withCode overrides the default code derived from the status
+func (e *apiError) withCode(code string) *apiError {
+	e.Code = code
+	return e
+}
+
+// withDetails attaches structured context for the client
+func (e *apiError) withDetails(details any) *apiError {
+	e.Details = details
+	return e
+}
+
+// withRetryAfter suggests when the client may retry
+func (e *apiError) withRetryAfter(d time.Duration) *apiError {
+	e.RetryAfter = d
+	return e
+}
+
+func defaultErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusNotAcceptable:
+		return "not_acceptable"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusUnprocessableEntity:
+		return "unprocessable"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	case http.StatusBadGateway:
+		return "upstream_error"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	}
+	return "internal_error"
+}
+
+// Error body returned to clients
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// writeError reports err to the client as a JSON error body, using its status
+// and code when it is an apiError
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		apiErr = newAPIError(http.StatusInternalServerError, "%v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(apiErr.RetryAfter.Seconds()))))
+	}
+	w.WriteHeader(apiErr.Status)
+	json.NewEncoder(w).Encode(errorResponse{Error: errorBody{
+		Code:    apiErr.Code,
+		Message: apiErr.Message,
+		Details: apiErr.Details,
+	}})
+}
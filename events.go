@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Kinds of moment game code reacts to
+var eventTypes = []string{"contact", "apex", "release"}
+
+func validateEventsMode(mode string) error {
+	switch mode {
+	case "", "model", "computed":
+		return nil
+	}
+	return fmt.Errorf("invalid events %q, expected model or computed", mode)
+}
+
+// parseModelEvents reads the model's events leniently, skipping entries
+// whose frame or point is not a number. IDs are the compact ones the model
+// saw.
+func parseModelEvents(raw []json.RawMessage) []frameEvent {
+	var events []frameEvent
+	for _, v := range raw {
+		var entry struct {
+			Frame   json.RawMessage `json:"frame"`
+			PointID json.RawMessage `json:"point_id"`
+			Type    string          `json:"type"`
+			Label   string          `json:"label"`
+		}
+		if json.Unmarshal(v, &entry) != nil {
+			continue
+		}
+		frame, ok := parseLenientID(entry.Frame)
+		if !ok {
+			continue
+		}
+		id, ok := parseLenientID(entry.PointID)
+		if !ok {
+			continue
+		}
+		events = append(events, frameEvent{
+			Frame:   frame,
+			PointID: id,
+			Type:    strings.ToLower(strings.TrimSpace(entry.Type)),
+			Label:   strings.TrimSpace(entry.Label),
+			Source:  "model",
+		})
+	}
+	return events
+}
+
+// resolveModelEvents maps the model's events back to the client's IDs and
+// drops, with a warning each, those of an unknown type, at a frame outside
+// the clip or on a point the model was not given
+func resolveModelEvents(events []frameEvent, idMap map[int]int, frameCount int) ([]frameEvent, []string) {
+	originals := reverseIDMap(idMap)
+	var resolved []frameEvent
+	var warnings []string
+	for _, e := range events {
+		switch {
+		case !slices.Contains(eventTypes, e.Type):
+			warnings = append(warnings, fmt.Sprintf("Dropped the model's event at frame %d: unknown type %q", e.Frame, e.Type))
+		case e.Frame < 0 || e.Frame >= frameCount:
+			warnings = append(warnings, fmt.Sprintf("Dropped the model's %s event at frame %d: the clip has %d frames", e.Type, e.Frame, frameCount))
+		case len(originals[e.PointID]) == 0:
+			warnings = append(warnings, fmt.Sprintf("Dropped the model's %s event at frame %d: unknown control point %d", e.Type, e.Frame, e.PointID))
+		default:
+			for _, id := range originals[e.PointID] {
+				e.PointID = id
+				resolved = append(resolved, e)
+			}
+		}
+	}
+	sortEvents(resolved)
+	return resolved, warnings
+}
+
+func sortEvents(events []frameEvent) {
+	slices.SortStableFunc(events, func(a, b frameEvent) int {
+		return cmp.Or(cmp.Compare(a.Frame, b.Frame), cmp.Compare(a.PointID, b.PointID))
+	})
+}
+
+// detectEvents finds events in the trajectories themselves. frames are
+// deltas from positions, the rest positions by original ID. A point moving
+// further than the motion threshold along the up axis reaches an apex where
+// its vertical velocity turns from rising to falling; one moving mostly
+// sideways, as a waving hand does, reaches one at every turn of its main
+// axis. A point makes contact when it comes within tolerance of the floor,
+// the lowest point of the clip, at a speed under tolerance per frame, and is
+// released on the frame it starts to leave that state.
+func detectEvents(frames ResponsePayload, positions map[int][]float64, roles map[int]string, up axisDirection, tolerance, threshold float64) []frameEvent {
+	if len(frames) < 2 {
+		return nil
+	}
+	tracks := make(map[int][][3]float64, len(positions))
+	floor := math.Inf(1)
+	for _, id := range sortedKeys(positions) {
+		rest := positions[id]
+		if len(rest) < 3 {
+			continue
+		}
+		track := make([][3]float64, 0, len(frames))
+		for _, frame := range frames {
+			d, ok := frame[id]
+			if !ok {
+				break
+			}
+			p := [3]float64{rest[0] + d.DeltaX, rest[1] + d.DeltaY, rest[2] + d.DeltaZ}
+			floor = math.Min(floor, up.of(p[:]))
+			track = append(track, p)
+		}
+		if len(track) == len(frames) {
+			tracks[id] = track
+		}
+	}
+
+	var events []frameEvent
+	for _, id := range sortedKeys(tracks) {
+		track := tracks[id]
+		add := func(frame int, kind string) {
+			label := kind
+			if role := roles[id]; role != "" {
+				label = role + " " + kind
+			}
+			events = append(events, frameEvent{Frame: frame, PointID: id, Type: kind, Label: label, Source: "computed"})
+		}
+
+		// Apexes along the axis the point mostly moves on
+		var axes [3][]float64
+		var ranges [3]float64
+		for axis := range axes {
+			axes[axis] = make([]float64, len(track))
+			for i, p := range track {
+				axes[axis][i] = p[axis]
+			}
+			ranges[axis] = slices.Max(axes[axis]) - slices.Min(axes[axis])
+		}
+		primary := up.index
+		for axis := range ranges {
+			if ranges[axis] > ranges[primary] {
+				primary = axis
+			}
+		}
+		// A lift a quarter the size of the main motion still marks the top
+		// of a step or a jump
+		if ranges[up.index] >= threshold && ranges[up.index] >= ranges[primary]/4 {
+			height := make([]float64, len(track))
+			for i, p := range track {
+				height[i] = up.of(p[:])
+			}
+			for _, turn := range turningPoints(height, math.Max(threshold, ranges[up.index]/4)) {
+				if turn.maximum {
+					add(turn.frame, "apex")
+				}
+			}
+		} else if ranges[primary] >= threshold {
+			for _, turn := range turningPoints(axes[primary], math.Max(threshold, ranges[primary]/4)) {
+				add(turn.frame, "apex")
+			}
+		}
+
+		// Contacts and releases on entering and leaving the floor
+		onFloor := touchesFloor(track, 0, up, floor, tolerance)
+		for i := 1; i < len(track); i++ {
+			now := touchesFloor(track, i, up, floor, tolerance)
+			switch {
+			case now && !onFloor:
+				add(i, "contact")
+			case onFloor && !now:
+				add(i, "release")
+			}
+			onFloor = now
+		}
+	}
+	sortEvents(events)
+	return events
+}
+
+// touchesFloor reports whether a track rests on the floor at frame i: within
+// tolerance of it and moving less than tolerance until the next frame, so a
+// foot touches down on the frame it lands rather than the one after
+func touchesFloor(track [][3]float64, i int, up axisDirection, floor, tolerance float64) bool {
+	if up.of(track[i][:])-floor > tolerance {
+		return false
+	}
+	p, q := track[i], track[max(i-1, 0)]
+	if i+1 < len(track) {
+		q = track[i+1]
+	}
+	return math.Sqrt((p[0]-q[0])*(p[0]-q[0])+(p[1]-q[1])*(p[1]-q[1])+(p[2]-q[2])*(p[2]-q[2])) <= tolerance
+}
+
+// A frame where a coordinate reverses direction
+type turningPoint struct {
+	frame   int
+	maximum bool
+}
+
+// turningPoints finds where the velocity of series c crosses zero, ignoring
+// reversals smaller than swing so jitter does not count. A plateau turns at
+// its first frame; the clip's ends never do, as the velocity on their far
+// side is unknown.
+func turningPoints(c []float64, swing float64) []turningPoint {
+	var turns []turningPoint
+	lo, hi, extreme, direction := 0, 0, 0, 0
+	for i := 1; i < len(c); i++ {
+		switch direction {
+		case 0:
+			// Until the first swing the series may still be going either way
+			if c[i] < c[lo] {
+				lo = i
+			}
+			if c[i] > c[hi] {
+				hi = i
+			}
+			if c[i]-c[lo] >= swing {
+				if lo > 0 {
+					turns = append(turns, turningPoint{lo, false})
+				}
+				direction, extreme = 1, i
+			} else if c[hi]-c[i] >= swing {
+				if hi > 0 {
+					turns = append(turns, turningPoint{hi, true})
+				}
+				direction, extreme = -1, i
+			}
+		case 1:
+			if c[i] > c[extreme] {
+				extreme = i
+			} else if c[extreme]-c[i] >= swing {
+				turns = append(turns, turningPoint{extreme, true})
+				direction, extreme = -1, i
+			}
+		case -1:
+			if c[i] < c[extreme] {
+				extreme = i
+			} else if c[i]-c[extreme] >= swing {
+				turns = append(turns, turningPoint{extreme, false})
+				direction, extreme = 1, i
+			}
+		}
+	}
+	return turns
+}
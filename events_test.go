@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestValidateEventsMode(t *testing.T) {
+	for mode, valid := range map[string]bool{"": true, "model": true, "computed": true, "both": false} {
+		if err := validateEventsMode(mode); (err == nil) != valid {
+			t.Errorf("events %q: %v, want valid %v", mode, err, valid)
+		}
+	}
+}
+
+func TestResolveModelEvents(t *testing.T) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(`[
+		{"frame": 3, "point_id": "1", "type": " Apex ", "label": "hand up"},
+		{"frame": "1", "point_id": 0, "type": "contact"},
+		{"frame": "soon", "point_id": 0, "type": "contact"},
+		"release",
+		{"frame": 2, "point_id": 0, "type": "wiggle"},
+		{"frame": 9, "point_id": 0, "type": "release"},
+		{"frame": 2, "point_id": 5, "type": "release"}
+	]`), &raw); err != nil {
+		t.Fatal(err)
+	}
+	events := parseModelEvents(raw)
+	if len(events) != 5 || events[0].Type != "apex" || events[0].Label != "hand up" || events[1].Frame != 1 {
+		t.Fatalf("parsed %+v, want five events with their type trimmed and lowered", events)
+	}
+
+	// Client points 10 and 11 share compact ID 0
+	got, warnings := resolveModelEvents(events, map[int]int{10: 0, 11: 0, 12: 1}, 4)
+	want := []frameEvent{
+		{Frame: 1, PointID: 10, Type: "contact", Source: "model"},
+		{Frame: 1, PointID: 11, Type: "contact", Source: "model"},
+		{Frame: 3, PointID: 12, Type: "apex", Label: "hand up", Source: "model"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("events %+v, want %+v", got, want)
+	}
+	if len(warnings) != 3 || !strings.Contains(warnings[0], `unknown type "wiggle"`) ||
+		!strings.Contains(warnings[1], "the clip has 4 frames") || !strings.Contains(warnings[2], "unknown control point 5") {
+		t.Errorf("warnings %q, want the type, frame and point dropped", warnings)
+	}
+}
+
+func TestTurningPoints(t *testing.T) {
+	for _, tc := range []struct {
+		series []float64
+		want   []turningPoint
+	}{
+		{[]float64{0, 0.1, 0.2, 0.1, 0, 0.1, 0.2, 0.1}, []turningPoint{{2, true}, {4, false}, {6, true}}},
+		// A plateau turns at its first frame
+		{[]float64{0, 0.2, 0.2, 0.2, 0}, []turningPoint{{1, true}}},
+		// Jitter under the swing is not a reversal
+		{[]float64{0, 0.1, 0.08, 0.2, 0}, []turningPoint{{3, true}}},
+		// Nor are the clip's ends
+		{[]float64{0.2, 0.1, 0}, nil},
+	} {
+		if got := turningPoints(tc.series, 0.05); !slices.Equal(got, tc.want) {
+			t.Errorf("turning points of %v: %v, want %v", tc.series, got, tc.want)
+		}
+	}
+}
+
+// footLift is the left foot's height over an eight frame step
+var footLift = []float64{0, 0, 0.1, 0.2, 0.1, 0, 0, 0}
+
+func TestDetectEvents(t *testing.T) {
+	rig := testRig()
+	positions := make(map[int][]float64, len(rig))
+	roles := make(map[int]string, len(rig))
+	for _, cp := range rig {
+		positions[cp.ID], roles[cp.ID] = cp.Position, cp.Role
+	}
+	// The left foot steps while the right hand waves side to side
+	wave := []float64{0, 0.1, 0.2, 0.1, 0, 0.1, 0.2, 0.1}
+	frames := make(ResponsePayload, len(footLift))
+	for f := range frames {
+		frames[f] = map[int]Deformation{0: {}, 1: {}, 2: {DeltaX: wave[f]}, 3: {DeltaY: footLift[f]}, 4: {}}
+	}
+	up := axisDirection{index: 1, sign: 1}
+
+	got := detectEvents(frames, positions, roles, up, 0.02, 0.01)
+	want := []frameEvent{
+		{Frame: 1, PointID: 3, Type: "release", Label: "left foot release", Source: "computed"},
+		{Frame: 2, PointID: 2, Type: "apex", Label: "right hand apex", Source: "computed"},
+		{Frame: 3, PointID: 3, Type: "apex", Label: "left foot apex", Source: "computed"},
+		{Frame: 4, PointID: 2, Type: "apex", Label: "right hand apex", Source: "computed"},
+		{Frame: 5, PointID: 3, Type: "contact", Label: "left foot contact", Source: "computed"},
+		{Frame: 6, PointID: 2, Type: "apex", Label: "right hand apex", Source: "computed"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("events %+v, want %+v", got, want)
+	}
+
+	// Motion under the threshold marks nothing
+	if got := detectEvents(frames, positions, roles, up, 0.02, 0.5); len(got) != 2 || got[0].Type != "release" || got[1].Type != "contact" {
+		t.Errorf("events %+v, want only the foot's release and contact", got)
+	}
+	if got := detectEvents(frames[:1], positions, roles, up, 0.02, 0.01); got != nil {
+		t.Errorf("a single frame has events %+v", got)
+	}
+}
+
+func TestEventsOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	// The left foot steps, and when asked the model reports the right hand
+	// reaching the top of its swing with modelEvents
+	var modelEvents func(ids map[string]int) string
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		ids := make(map[string]int, len(input.ControlPoints))
+		resp := framesResponse(input.Length, func(f int) map[string]Position {
+			frame := make(map[string]Position)
+			for _, cp := range input.ControlPoints {
+				ids[cp.Role] = cp.ID
+				p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+				if cp.Role == "left foot" {
+					p.Y += footLift[f]
+				}
+				frame[strconv.Itoa(cp.ID)] = p
+			}
+			return frame
+		})
+		if modelEvents == nil || !strings.Contains(req.Messages[0].Content, `include "events"`) {
+			return resp, nil
+		}
+		var content map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(resp.Choices[0].Message.Content), &content); err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		content["events"] = json.RawMessage(modelEvents(ids))
+		out, _ := json.Marshal(content)
+		resp.Choices[0].Message.Content = string(out)
+		return resp, nil
+	}
+	// IDs from 10, so the model knows the points by others
+	rig := testRig()
+	for i := range rig {
+		rig[i].ID += 10
+	}
+	type response struct {
+		Frames []map[int]Deformation `json:"frames"`
+		Meta   generationMeta        `json:"meta"`
+	}
+	send := func(mode string) response {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "take a step", Length: len(footLift), CacheMode: cacheFresh, Events: mode}}
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status %d: %s", mode, rec.Code, rec.Body)
+		}
+		return decodeBody[response](t, rec)
+	}
+	computed := []frameEvent{
+		{Frame: 1, PointID: 13, Type: "release", Label: "left foot release", Source: "computed"},
+		{Frame: 3, PointID: 13, Type: "apex", Label: "left foot apex", Source: "computed"},
+		{Frame: 5, PointID: 13, Type: "contact", Label: "left foot contact", Source: "computed"},
+	}
+
+	if body := send(""); body.Meta.Events != nil || strings.Contains(fake.requests[len(fake.requests)-1].Messages[0].Content, `include "events"`) {
+		t.Errorf("no events: meta.events %+v, or the model was asked for them", body.Meta.Events)
+	}
+	if body := send("computed"); !slices.Equal(body.Meta.Events, computed) {
+		t.Errorf("computed: meta.events %+v, want %+v", body.Meta.Events, computed)
+	}
+	// A model that returns no events leaves them to the trajectories
+	if body := send("model"); !slices.Equal(body.Meta.Events, computed) {
+		t.Errorf("model without events: meta.events %+v, want the computed %+v", body.Meta.Events, computed)
+	}
+
+	modelEvents = func(ids map[string]int) string {
+		return `[{"frame": 4, "point_id": ` + strconv.Itoa(ids["right hand"]) + `, "type": "apex", "label": "hand up"}, {"frame": 20, "point_id": 0, "type": "contact"}]`
+	}
+	body := send("model")
+	if want := []frameEvent{{Frame: 4, PointID: 12, Type: "apex", Label: "hand up", Source: "model"}}; !slices.Equal(body.Meta.Events, want) {
+		t.Errorf("model: meta.events %+v, want %+v in the client's IDs", body.Meta.Events, want)
+	}
+	if !slices.ContainsFunc(body.Meta.Warnings, func(w string) bool { return strings.HasPrefix(w, "Dropped the model's contact event at frame 20") }) {
+		t.Errorf("model: warnings %q, want the event past the clip dropped", body.Meta.Warnings)
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "wave", Length: 4, Events: "both"}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("events both: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Largest factor exaggerate may scale motion by
+const maxExaggeration = 10
+
+// validateExaggeration checks the clip-wide factor and the per-role ones;
+// zero leaves motion as it is
+func validateExaggeration(factor float64, roles map[string]float64) error {
+	if !(factor >= 0 && factor <= maxExaggeration) {
+		return fmt.Errorf("exaggerate must be between 0 and %d", maxExaggeration)
+	}
+	for _, role := range sortedKeys(roles) {
+		if f := roles[role]; !(f > 0 && f <= maxExaggeration) {
+			return fmt.Errorf("exaggerate_roles[%q] must be above 0 and at most %d", role, maxExaggeration)
+		}
+	}
+	return nil
+}
+
+// exaggerateMotion scales every point's deltas by its role's factor in
+// roleFactors, matched without case, or by factor. It is linear, so 1
+// changes nothing and the rest pose stays where it is.
+func exaggerateMotion(frames ResponsePayload, factor float64, roleFactors map[string]float64, roles map[int]string) ResponsePayload {
+	if factor == 0 {
+		factor = 1
+	}
+	lowered := make(map[string]float64, len(roleFactors))
+	for role, f := range roleFactors {
+		lowered[strings.ToLower(role)] = f
+	}
+	scales := make(map[int]float64)
+	for id, role := range roles {
+		scale := factor
+		if f, ok := lowered[strings.ToLower(role)]; ok {
+			scale = f
+		}
+		if scale != 1 {
+			scales[id] = scale
+		}
+	}
+	if len(scales) == 0 {
+		return frames
+	}
+	for _, frame := range frames {
+		for id, d := range frame {
+			if scale, ok := scales[id]; ok {
+				frame[id] = scaleDelta(d, scale)
+			}
+		}
+	}
+	return frames
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestValidateExaggeration(t *testing.T) {
+	for _, tc := range []struct {
+		factor float64
+		roles  map[string]float64
+		valid  bool
+	}{
+		{0, nil, true},
+		{1.5, map[string]float64{"head": 1}, true},
+		{maxExaggeration, map[string]float64{"tail": maxExaggeration}, true},
+		{-1, nil, false},
+		{maxExaggeration + 1, nil, false},
+		{math.NaN(), nil, false},
+		{1, map[string]float64{"head": 0}, false},
+		{1, map[string]float64{"head": maxExaggeration + 1}, false},
+	} {
+		if err := validateExaggeration(tc.factor, tc.roles); (err == nil) != tc.valid {
+			t.Errorf("exaggerate %v with roles %v: %v, want valid %v", tc.factor, tc.roles, err, tc.valid)
+		}
+	}
+}
+
+func TestExaggerateMotion(t *testing.T) {
+	roles := map[int]string{0: "Head", 1: "left hand", 2: "right hand"}
+	frames := func() ResponsePayload {
+		frame := map[int]Deformation{}
+		for id := range roles {
+			frame[id] = Deformation{DeltaX: -0.2, DeltaY: 0.1}
+		}
+		return ResponsePayload{frame}
+	}
+
+	// Role factors replace the clip-wide one, matched without case
+	got := exaggerateMotion(frames(), 2, map[string]float64{"HEAD": 1, "right hand": 0.5}, roles)[0]
+	want := map[int]Deformation{0: {DeltaX: -0.2, DeltaY: 0.1}, 1: {DeltaX: -0.4, DeltaY: 0.2}, 2: {DeltaX: -0.1, DeltaY: 0.05}}
+	for id, w := range want {
+		if !closeDelta(got[id], w) {
+			t.Errorf("point %d (%s) at %+v, want %+v", id, roles[id], got[id], w)
+		}
+	}
+
+	// A factor of 0, or of 1, changes nothing
+	for _, factor := range []float64{0, 1} {
+		if got := exaggerateMotion(frames(), factor, nil, roles)[0]; got[1] != (Deformation{DeltaX: -0.2, DeltaY: 0.1}) {
+			t.Errorf("factor %v moved point 1 to %+v", factor, got[1])
+		}
+	}
+}
+
+func TestExaggerateOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	send := func(factor float64, roles map[string]float64, rest map[int]Position) []map[int]Deformation {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{
+			ControlPoints:   testRig(),
+			Prompt:          "raise the right hand",
+			Length:          4,
+			Exaggerate:      factor,
+			ExaggerateRoles: roles,
+			RestPose:        rest,
+		}}
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		return decodeBody[[]map[int]Deformation](t, rec)
+	}
+	rises := func(frames []map[int]Deformation, start, step float64) bool {
+		for f, frame := range frames {
+			if math.Abs(frame[2].DeltaY-(start+step*float64(f))) > 1e-9 {
+				return false
+			}
+		}
+		return true
+	}
+
+	// The hand rises 0.04 a frame
+	if frames := send(2, nil, nil); !rises(frames, 0, 0.08) {
+		t.Errorf("exaggerate 2: hand at %v, want twice the rise", frames)
+	}
+	if frames := send(2, map[string]float64{"Right Hand": 3}, nil); !rises(frames, 0, 0.12) {
+		t.Errorf("exaggerate_roles 3: hand at %v, want three times the rise", frames)
+	}
+	// Amplified from the pose the model started from, not from rest
+	if frames := send(2, nil, map[int]Position{2: {X: -0.6, Y: 1, Z: 0}}); !rises(frames, 0.2, 0.08) {
+		t.Errorf("exaggerate 2 with a rest pose: hand at %v, want 0.2 off rest, then twice the rise", frames)
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4, Exaggerate: 11}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("exaggerate 11: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// A few-shot example injected into the conversation when the prompt mentions
+// one of its keywords. Input is a model input object and Output the frames
+// object the model should answer with.
+type FewShotExample struct {
+	Name     string          `json:"name"`
+	Keywords []string        `json:"keywords"`
+	Input    json.RawMessage `json:"input"`
+	Output   json.RawMessage `json:"output"`
+}
+
+// loadExamplesFile reads a JSON array of few-shot examples
+func loadExamplesFile(path string) ([]FewShotExample, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read examples file: %w", err)
+	}
+	var examples []FewShotExample
+	if err := json.Unmarshal(raw, &examples); err != nil {
+		return nil, fmt.Errorf("parse examples file %s: %w", path, err)
+	}
+	return examples, nil
+}
+
+// validateExamples describes every malformed example
+func validateExamples(examples []FewShotExample) []string {
+	var problems []string
+	for i, ex := range examples {
+		label := fmt.Sprintf("examples[%d]", i)
+		if ex.Name != "" {
+			label = fmt.Sprintf("examples[%d] (%s)", i, ex.Name)
+		}
+		if len(ex.Keywords) == 0 {
+			problems = append(problems, label+": must list at least one keyword")
+		}
+		if len(ex.Input) == 0 || !json.Valid(ex.Input) {
+			problems = append(problems, label+": input must be a JSON object")
+		}
+		if len(ex.Output) == 0 || !json.Valid(ex.Output) {
+			problems = append(problems, label+": output must be a JSON object")
+		}
+	}
+	return problems
+}
+
+// selectExamples returns up to limit examples whose keywords appear in the
+// prompt, in configuration order. Keywords match whole words or word
+// prefixes, so "walk" also matches "walking" but not "sidewalk".
+func selectExamples(prompt string, examples []FewShotExample, limit int) []FewShotExample {
+	words := strings.FieldsFunc(strings.ToLower(prompt), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	matches := func(keyword string) bool {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword == "" {
+			return false
+		}
+		// Multi-word keywords match as a phrase
+		if strings.Contains(keyword, " ") {
+			return strings.Contains(" "+strings.Join(words, " "), " "+keyword)
+		}
+		for _, w := range words {
+			if strings.HasPrefix(w, keyword) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var selected []FewShotExample
+	for _, ex := range examples {
+		if len(selected) >= limit {
+			break
+		}
+		for _, k := range ex.Keywords {
+			if matches(k) {
+				selected = append(selected, ex)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// exampleMessages renders examples as user/assistant turns
+func exampleMessages(examples []FewShotExample) []openai.ChatCompletionMessage {
+	messages := make([]openai.ChatCompletionMessage, 0, 2*len(examples))
+	for _, ex := range examples {
+		messages = append(messages,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: fenceUserData(string(ex.Input))},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: string(ex.Output)},
+		)
+	}
+	return messages
+}
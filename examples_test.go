@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func example(name string, keywords ...string) FewShotExample {
+	return FewShotExample{
+		Name:     name,
+		Keywords: keywords,
+		Input:    json.RawMessage(`{"prompt":"` + name + `","length":1,"control_points":[]}`),
+		Output:   json.RawMessage(`{"frames":[{}]}`),
+	}
+}
+
+func TestSelectExamples(t *testing.T) {
+	examples := []FewShotExample{
+		example("walk", "walk", "stroll"),
+		example("wave", "Wave"),
+		example("jumping jack", "jumping jack"),
+		example("blank", " "),
+	}
+	names := func(selected []FewShotExample) []string {
+		var out []string
+		for _, ex := range selected {
+			out = append(out, ex.Name)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		prompt string
+		limit  int
+		want   []string
+	}{
+		{"walking forward", 2, []string{"walk"}},
+		// Keywords match at the start of a word only
+		{"cross the sidewalk", 2, nil},
+		{"WAVE, then stroll", 2, []string{"walk", "wave"}},
+		// Configuration order, not prompt order, decides which fit the limit
+		{"wave and walk", 1, []string{"walk"}},
+		{"do a jumping jack", 2, []string{"jumping jack"}},
+		{"jumping then a jack", 2, nil},
+		{"wave", 0, nil},
+		{"  ", 2, nil},
+	} {
+		if got := names(selectExamples(tc.prompt, examples, tc.limit)); !slices.Equal(got, tc.want) {
+			t.Errorf("%q, limit %d: %v, want %v", tc.prompt, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestExamplesInConversation(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.Examples = []FewShotExample{example("walk", "walk"), example("wave", "wave")}
+	})
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave hello", Length: 4}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var roles []string
+	for _, m := range fake.requests[0].Messages {
+		roles = append(roles, m.Role)
+	}
+	want := []string{openai.ChatMessageRoleSystem, openai.ChatMessageRoleUser, openai.ChatMessageRoleAssistant, openai.ChatMessageRoleUser}
+	if !slices.Equal(roles, want) {
+		t.Fatalf("message roles %v, want one example turn before the request", roles)
+	}
+	if got := fake.requests[0].Messages[1].Content; got != fenceUserData(string(cfg.Examples[1].Input)) {
+		t.Errorf("example turn %q, want the wave example", got)
+	}
+	input, err := modelInputOf(fake.requests[0])
+	if err != nil || input.Prompt != "wave hello" {
+		t.Errorf("the request is not the last user turn: %+v, %v", input, err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// expandPrompt asks a small model to turn a terse prompt ("dance") into a
+// detailed description of the motion for the main generation, through the
+// same retries, budget and breaker as the main call
+func expandPrompt(ctx context.Context, client chatClient, profile, prompt string) (string, openai.Usage, error) {
+	resp, _, err := completeWithRetry(ctx, client, profile, openai.ChatCompletionRequest{
+		Model: cfg.ExpansionModel,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role: openai.ChatMessageRoleSystem,
+				Content: "Rewrite the user's animation prompt for a humanoid character as a detailed motion description: " +
+					"which body parts move, in which directions, how far, in what order and with what rhythm. " +
+					"Keep everything the prompt asks for and add nothing that contradicts it. " +
+					"Reply with the description only, in at most 80 words of plain English.",
+			},
+			{Role: openai.ChatMessageRoleUser, Content: prompt},
+		},
+	})
+	if err != nil {
+		return "", resp.Usage, err
+	}
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		return "", resp.Usage, fmt.Errorf("empty expansion")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), resp.Usage, nil
+}
+
+// applyPromptExpansion replaces the prompt with its expansion when the
+// request asks for one. A failed expansion keeps the prompt as written and
+// returns a warning instead of failing the request.
+func applyPromptExpansion(ctx context.Context, client chatClient, payload *RequestPayload, info *promptLanguageInfo) (openai.Usage, []string) {
+	if !payload.ExpandPrompt {
+		return openai.Usage{}, nil
+	}
+	expanded, usage, err := expandPrompt(ctx, client, payload.Profile, payload.Prompt)
+	if err == nil {
+		// The expansion goes to the model like any prompt, so it passes the same checks
+		expanded, err = sanitizePrompt(expanded)
+	}
+	if err != nil {
+		log.Printf("WARN prompt expansion failed, using the prompt as written: %v", err)
+		return usage, []string{"Prompt expansion failed, the prompt was used as written"}
+	}
+	log.Printf("Expanded prompt %q to %q", payload.Prompt, expanded)
+	info.Expanded = expanded
+	payload.Prompt = expanded
+	return usage, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestExpansionRetriesTransientFailures(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.ExpansionModel = "expander"
+		c.RetryBackoff = Duration{time.Millisecond}
+	})
+	expansions := 0
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		if req.Model != "expander" {
+			return swayResponse(req)
+		}
+		if expansions++; expansions == 1 {
+			return openai.ChatCompletionResponse{}, &openai.APIError{HTTPStatusCode: http.StatusBadGateway, Message: "bad gateway"}
+		}
+		return contentResponse("sway the hips from side to side while the arms swing loosely"), nil
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "dance", Length: 4, ExpandPrompt: true}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if expansions != 2 {
+		t.Errorf("expansion called %d times, want 2", expansions)
+	}
+	input, err := modelInputOf(fake.requests[len(fake.requests)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input.Prompt != "sway the hips from side to side while the arms swing loosely" {
+		t.Errorf("model was sent %q, want the expansion", input.Prompt)
+	}
+}
+
+func TestExpansionRespectsBreaker(t *testing.T) {
+	fake := setupServer(t, nil)
+	for range cfg.BreakerThreshold {
+		upstreamBreaker.record(false)
+	}
+
+	payload := &RequestPayload{RequestPayload: api.RequestPayload{Prompt: "dance", ExpandPrompt: true}}
+	_, warnings := applyPromptExpansion(t.Context(), fake, payload, &promptLanguageInfo{})
+	if got := fake.calls(); got != 0 {
+		t.Errorf("upstream called %d times while the breaker was open", got)
+	}
+	if payload.Prompt != "dance" || len(warnings) != 1 {
+		t.Errorf("prompt %q with warnings %q, want it kept as written with a warning", payload.Prompt, warnings)
+	}
+}
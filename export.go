@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Frame rate used for exported clips when the request does not set one
+const defaultExportFPS = 30
+
+// Unity AnimationClip curve data: one curve per control point per axis
+type UnityClip struct {
+	FrameRate float64      `json:"frame_rate"`
+	Length    float64      `json:"length"`
+	Loop      bool         `json:"loop"`
+	Curves    []UnityCurve `json:"curves"`
+}
+
+type UnityCurve struct {
+	Path     string          `json:"path"`
+	Type     string          `json:"type"`
+	Property string          `json:"property"`
+	PointID  int             `json:"point_id"`
+	Role     string          `json:"role,omitempty"`
+	Keys     []UnityKeyframe `json:"keys"`
+}
+
+type UnityKeyframe struct {
+	Time  float64 `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// exportFPS picks the clip frame rate from the ?fps= query value, the
+// request's own fps, or the default
+func exportFPS(query string, payload RequestPayload) (float64, error) {
+	if query != "" {
+		fps, err := strconv.ParseFloat(query, 64)
+		if err != nil || fps <= 0 {
+			return 0, fmt.Errorf("fps must be a positive number")
+		}
+		return fps, nil
+	}
+	if payload.FPS > 0 {
+		return payload.FPS, nil
+	}
+	return defaultExportFPS, nil
+}
+
+// encodeUnityClip reshapes per-frame deltas into Unity curves. Each control
+// point becomes a Transform at path "point_<id>" whose m_LocalPosition
+// curves carry the delta on that axis, with one key per frame.
+func encodeUnityClip(frames ResponsePayload, roles map[int]string, fps float64, loop bool) UnityClip {
+	clip := UnityClip{
+		FrameRate: fps,
+		Length:    roundTo(float64(max(len(frames)-1, 0))/fps, 4),
+		Loop:      loop,
+	}
+	axes := []struct {
+		property string
+		value    func(Deformation) float64
+	}{
+		{"m_LocalPosition.x", func(d Deformation) float64 { return d.DeltaX }},
+		{"m_LocalPosition.y", func(d Deformation) float64 { return d.DeltaY }},
+		{"m_LocalPosition.z", func(d Deformation) float64 { return d.DeltaZ }},
+	}
+	for _, id := range frameIDs(frames) {
+		for _, axis := range axes {
+			keys := make([]UnityKeyframe, len(frames))
+			for i, frame := range frames {
+				keys[i] = UnityKeyframe{Time: roundTo(float64(i)/fps, 4), Value: axis.value(frame[id])}
+			}
+			clip.Curves = append(clip.Curves, UnityCurve{
+				Path:     "point_" + strconv.Itoa(id),
+				Type:     "Transform",
+				Property: axis.property,
+				PointID:  id,
+				Role:     roles[id],
+				Keys:     keys,
+			})
+		}
+	}
+	return clip
+}
+
+func validateCSVLayout(layout string) error {
+	switch layout {
+	case "", "long", "wide":
+		return nil
+	}
+	return fmt.Errorf("invalid layout %q, expected long or wide", layout)
+}
+
+// Column names of a coordinate system's three delta components, as used by
+// the long and wide CSV layouts
+type csvAxes struct {
+	long [3]string
+	wide [3]string
+}
+
+var (
+	cartesianCSVAxes = csvAxes{[3]string{"delta_x", "delta_y", "delta_z"}, [3]string{"dx", "dy", "dz"}}
+	sphericalCSVAxes = csvAxes{[3]string{"delta_r", "delta_theta", "delta_phi"}, [3]string{"dr", "dtheta", "dphi"}}
+)
+
+// csvTable flattens frames into delta triples per frame and control point
+func csvTable(frames any) (csvAxes, []map[int][3]float64, error) {
+	switch f := frames.(type) {
+	case ResponsePayload:
+		table := make([]map[int][3]float64, len(f))
+		for i, frame := range f {
+			table[i] = make(map[int][3]float64, len(frame))
+			for id, d := range frame {
+				table[i][id] = [3]float64{d.DeltaX, d.DeltaY, d.DeltaZ}
+			}
+		}
+		return cartesianCSVAxes, table, nil
+	case SphericalPayload:
+		table := make([]map[int][3]float64, len(f))
+		for i, frame := range f {
+			table[i] = make(map[int][3]float64, len(frame))
+			for id, d := range frame {
+				table[i][id] = [3]float64{d.DeltaR, d.DeltaTheta, d.DeltaPhi}
+			}
+		}
+		return sphericalCSVAxes, table, nil
+	}
+	return csvAxes{}, nil, fmt.Errorf("cannot write %T as CSV", frames)
+}
+
+// writeFramesCSV writes frames with columns named after the coordinate
+// system. The long layout has one row per frame and control point; the wide
+// layout has one row per frame and three columns per control point, ordered
+// by ID, left empty where a frame lacks the point.
+func writeFramesCSV(w io.Writer, frames any, layout string, indexBase int) error {
+	axes, table, err := csvTable(frames)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	num := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	if layout == "wide" {
+		ids := make(map[int]bool)
+		for _, frame := range table {
+			for id := range frame {
+				ids[id] = true
+			}
+		}
+		columns := sortedKeys(ids)
+		header := []string{"frame"}
+		for _, id := range columns {
+			for _, axis := range axes.wide {
+				header = append(header, fmt.Sprintf("%d_%s", id, axis))
+			}
+		}
+		cw.Write(header)
+		for i, frame := range table {
+			row := []string{strconv.Itoa(i + indexBase)}
+			for _, id := range columns {
+				d, ok := frame[id]
+				if !ok {
+					row = append(row, "", "", "")
+					continue
+				}
+				row = append(row, num(d[0]), num(d[1]), num(d[2]))
+			}
+			cw.Write(row)
+		}
+	} else {
+		cw.Write(append([]string{"frame", "point_id"}, axes.long[:]...))
+		for i, frame := range table {
+			for _, id := range sortedKeys(frame) {
+				d := frame[id]
+				cw.Write([]string{strconv.Itoa(i + indexBase), strconv.Itoa(id), num(d[0]), num(d[1]), num(d[2])})
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Unreal float curve data: three translation tracks per control point
+type UnrealCurves struct {
+	Metadata UnrealMetadata     `json:"metadata"`
+	Tracks   []UnrealTrack      `json:"tracks"`
+	Mapping  []UnrealTrackOwner `json:"mapping"`
+}
+
+type UnrealMetadata struct {
+	FrameRate  float64 `json:"frame_rate"`
+	Length     float64 `json:"length"`
+	FrameCount int     `json:"frame_count"`
+}
+
+type UnrealTrack struct {
+	Name string          `json:"name"`
+	Keys []UnityKeyframe `json:"keys"`
+}
+
+// Relates a track back to the control point and axis it animates
+type UnrealTrackOwner struct {
+	Track   string `json:"track"`
+	PointID int    `json:"point_id"`
+	Role    string `json:"role"`
+	Axis    string `json:"axis"`
+}
+
+// unrealIdentifier turns a role into a name made of letters, digits and
+// underscores that does not start with a digit
+func unrealIdentifier(role string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range role {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	name := strings.TrimSuffix(b.String(), "_")
+	if name == "" {
+		name = "point"
+	}
+	if unicode.IsDigit(rune(name[0])) {
+		name = "P_" + name
+	}
+	return name
+}
+
+// encodeUnrealCurves writes absolute positions (rest position plus delta) as
+// tracks named <role>_<axis>. Points whose roles sanitize to the same name
+// get their ID appended so every track name is unique.
+func encodeUnrealCurves(frames ResponsePayload, positions map[int][]float64, roles map[int]string, fps float64) UnrealCurves {
+	ids := frameIDs(frames)
+	baseNames := make(map[int]string, len(ids))
+	uses := make(map[string]int)
+	for _, id := range ids {
+		baseNames[id] = unrealIdentifier(roles[id])
+		uses[baseNames[id]]++
+	}
+
+	curves := UnrealCurves{
+		Metadata: UnrealMetadata{
+			FrameRate:  fps,
+			Length:     roundTo(float64(max(len(frames)-1, 0))/fps, 4),
+			FrameCount: len(frames),
+		},
+	}
+	for _, id := range ids {
+		base := baseNames[id]
+		if uses[base] > 1 {
+			base += "_" + strconv.Itoa(id)
+		}
+		rest := positions[id]
+		if len(rest) < 3 {
+			rest = []float64{0, 0, 0}
+		}
+		for axis, name := range []string{"x", "y", "z"} {
+			track := UnrealTrack{Name: base + "_" + name, Keys: make([]UnityKeyframe, len(frames))}
+			for i, frame := range frames {
+				d := frame[id]
+				delta := []float64{d.DeltaX, d.DeltaY, d.DeltaZ}[axis]
+				track.Keys[i] = UnityKeyframe{Time: roundTo(float64(i)/fps, 4), Value: roundTo(rest[axis]+delta, 4)}
+			}
+			curves.Tracks = append(curves.Tracks, track)
+			curves.Mapping = append(curves.Mapping, UnrealTrackOwner{Track: track.Name, PointID: id, Role: roles[id], Axis: name})
+		}
+	}
+	return curves
+}
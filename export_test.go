@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestUnrealIdentifier(t *testing.T) {
+	for role, want := range map[string]string{
+		"left hand":        "left_hand",
+		"  Right--Foot!! ": "Right_Foot",
+		"2nd finger":       "P_2nd_finger",
+		"épaule":           "paule",
+		"???":              "point",
+		"":                 "point",
+	} {
+		if got := unrealIdentifier(role); got != want {
+			t.Errorf("unrealIdentifier(%q) = %q, want %q", role, got, want)
+		}
+	}
+}
+
+func TestUnrealTrackNames(t *testing.T) {
+	frames := ResponsePayload{{1: {}, 2: {DeltaY: 0.5}, 3: {}}}
+	positions := map[int][]float64{1: {0, 1, 0}, 2: {0, 1, 0}}
+	roles := map[int]string{1: "hand", 2: "hand!", 3: "tip"}
+	curves := encodeUnrealCurves(frames, positions, roles, 24)
+
+	// Roles that sanitize to the same name are told apart by ID
+	want := []string{"hand_1_x", "hand_1_y", "hand_1_z", "hand_2_x", "hand_2_y", "hand_2_z", "tip_x", "tip_y", "tip_z"}
+	if len(curves.Tracks) != len(want) {
+		t.Fatalf("%d tracks, want %d", len(curves.Tracks), len(want))
+	}
+	for i, name := range want {
+		if curves.Tracks[i].Name != name || curves.Mapping[i].Track != name {
+			t.Errorf("track %d: %q (mapped as %q), want %q", i, curves.Tracks[i].Name, curves.Mapping[i].Track, name)
+		}
+	}
+	// Values are absolute, from the origin when a point has no position
+	if got := curves.Tracks[4].Keys[0].Value; got != 1.5 {
+		t.Errorf("hand_2_y = %v, want 1.5", got)
+	}
+	if got := curves.Tracks[7].Keys[0].Value; got != 0 {
+		t.Errorf("tip_y = %v, want 0", got)
+	}
+}
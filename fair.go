@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fairLimiter caps the upstream slots one client may hold at a time, so a
+// single heavy caller cannot take the whole global limit. Each client gets
+// its own limiter in front of the global one: calls beyond a client's share
+// queue on that client's limiter without occupying the global queue, so
+// other clients' calls keep getting through.
+type fairLimiter struct {
+	global   *concurrencyLimiter
+	perKey   int
+	maxQueue int
+	maxWait  time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*keyShare
+}
+
+// A client's limiter and the number of calls holding or waiting on it, so
+// idle clients can be forgotten
+type keyShare struct {
+	limiter *concurrencyLimiter
+	users   int
+}
+
+// A perKey of 0 turns fairness off and only the global limit applies
+func newFairLimiter(global *concurrencyLimiter, perKey, maxQueue int, maxWait time.Duration) *fairLimiter {
+	return &fairLimiter{global: global, perKey: perKey, maxQueue: maxQueue, maxWait: maxWait, keys: make(map[string]*keyShare)}
+}
+
+var upstreamSlots = newFairLimiter(upstreamLimiter, cfg.UpstreamConcurrencyPerKey, cfg.UpstreamQueueLength, cfg.UpstreamQueueTimeout.Duration)
+
+// acquire takes a slot of the client ctx belongs to, then a global one. On
+// success the returned function must be called to release both.
+func (f *fairLimiter) acquire(ctx context.Context) (func(), error) {
+	if f.perKey <= 0 {
+		return f.global.acquire(ctx)
+	}
+	key := clientKeyFrom(ctx)
+	share := f.join(key)
+	releaseKey, err := share.limiter.acquire(ctx)
+	if err != nil {
+		f.leave(key)
+		return nil, err
+	}
+	releaseGlobal, err := f.global.acquire(ctx)
+	if err != nil {
+		releaseKey()
+		f.leave(key)
+		return nil, err
+	}
+	return func() {
+		releaseGlobal()
+		releaseKey()
+		f.leave(key)
+	}, nil
+}
+
+func (f *fairLimiter) join(key string) *keyShare {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	share := f.keys[key]
+	if share == nil {
+		limiter := newConcurrencyLimiter(f.perKey, f.maxQueue, f.maxWait)
+		limiter.metric = "upstream_key_queue"
+		share = &keyShare{limiter: limiter}
+		f.keys[key] = share
+	}
+	share.users++
+	return share
+}
+
+func (f *fairLimiter) leave(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if share := f.keys[key]; share != nil {
+		share.users--
+		if share.users == 0 {
+			delete(f.keys, key)
+		}
+	}
+}
+
+// activeKeys counts the clients currently holding or waiting for a slot
+func (f *fairLimiter) activeKeys() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.keys)
+}
+
+type clientKeyKey struct{}
+
+func withClientKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, clientKeyKey{}, key)
+}
+
+// clientKeyFrom returns the client ctx belongs to, or "" for the server's
+// own calls such as warm-up
+func clientKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(clientKeyKey{}).(string)
+	return key
+}
+
+// clientKeyFromRequest identifies the caller by its API key, from X-API-Key
+// or a bearer token, falling back to its IP address. Only keys the
+// configuration knows count: anyone can send a fresh made-up key, which
+// would earn them a fair share of their own. Keys are hashed so the secrets
+// themselves are not kept around.
+func clientKeyFromRequest(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key != "" && knownClientKey(key) {
+		return hashClientKey(key)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// knownClientKey reports whether key is the admin key or one a profile's
+// allowed_keys lists
+func knownClientKey(key string) bool {
+	known := cfg.AdminAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(cfg.AdminAPIKey)) == 1
+	for _, p := range cfg.UpstreamProfiles {
+		for _, allowed := range p.AllowedKeys {
+			known = subtle.ConstantTimeCompare([]byte(key), []byte(allowed)) == 1 || known
+		}
+	}
+	return known
+}
+
+// hashClientKey turns an API key into the client key it is tracked under
+func hashClientKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:8])
+}
+
+// identifyClient records who is calling, for fair scheduling and profile
+// permissions
+func identifyClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(withClientKey(r.Context(), clientKeyFromRequest(r))))
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientKeyFromRequest(t *testing.T) {
+	setupServer(t, func(c *Config) {
+		c.AdminAPIKey = "admin-secret"
+		c.UpstreamProfiles = []UpstreamProfile{{Name: "studio", APIKey: "studio-key", AllowedKeys: []string{"alice"}}}
+	})
+	cases := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{name: "allowed key", header: http.Header{"X-Api-Key": {"alice"}}, want: hashClientKey("alice")},
+		{name: "allowed bearer token", header: http.Header{"Authorization": {"Bearer alice"}}, want: hashClientKey("alice")},
+		{name: "admin key", header: http.Header{"X-Api-Key": {"admin-secret"}}, want: hashClientKey("admin-secret")},
+		{name: "made-up key", header: http.Header{"X-Api-Key": {"random-1234"}}, want: "ip:192.0.2.1"},
+		{name: "no key", want: "ip:192.0.2.1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/generate-deformations", nil)
+			r.RemoteAddr = "192.0.2.1:4321"
+			for name, values := range tc.header {
+				r.Header[name] = values
+			}
+			if got := clientKeyFromRequest(r); got != tc.want {
+				t.Errorf("client key %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
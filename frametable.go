@@ -0,0 +1,207 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// Rough cost of one point in one frame held as a map[int]Deformation entry,
+// with the map's slack, of the map itself, and of the point's delta and
+// presence flag in a frameTable
+const (
+	framePointMapBytes   = 40
+	frameMapBytes        = 64
+	framePointTableBytes = 25
+)
+
+// frameTable is a clip laid out densely: a point-index table and one
+// preallocated slice holding every point's delta in every frame. It is built
+// from the finished frames just before they are encoded and held alongside
+// them, so it adds to a response's peak memory; what it saves is the sorted
+// key slice and the string per point per frame that encoding the maps
+// allocates, as it writes the same JSON into a single buffer.
+type frameTable struct {
+	// Point index → original ID, in the order the JSON lists them
+	ids []int
+	// Point index → decimal places its deltas are written with
+	places []int
+	// Frame-major: point i of frame f is at f*len(ids)+i
+	deltas []Deformation
+	// Whether the frame has the point at all, as omitted points do not
+	present []bool
+	frames  int
+}
+
+// Places written for points without a precision of their own: the finest
+// any category rounds to, so no digits are lost
+const defaultTablePlaces = 4
+
+// newFrameTable lays out frames over the IDs any of them holds. places are
+// the decimal places of each point by its ID before the clip's keys were
+// moved to start at base.
+func newFrameTable(frames ResponsePayload, places map[int]int, base int) *frameTable {
+	seen := make(map[int]bool)
+	for _, frame := range frames {
+		for id := range frame {
+			seen[id] = true
+		}
+	}
+	ids := make([]int, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	// encoding/json sorts map keys as strings, so "10" comes before "9"
+	slices.SortFunc(ids, func(a, b int) int { return cmp.Compare(strconv.Itoa(a), strconv.Itoa(b)) })
+	index := make(map[int]int, len(ids))
+	idPlaces := make([]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+		p, ok := places[id-base]
+		if !ok {
+			p = defaultTablePlaces
+		}
+		idPlaces[i] = p
+	}
+
+	t := &frameTable{
+		ids:     ids,
+		places:  idPlaces,
+		deltas:  make([]Deformation, len(frames)*len(ids)),
+		present: make([]bool, len(frames)*len(ids)),
+		frames:  len(frames),
+	}
+	for f, frame := range frames {
+		for id, d := range frame {
+			t.deltas[f*len(ids)+index[id]] = d
+			t.present[f*len(ids)+index[id]] = true
+		}
+	}
+	return t
+}
+
+// MarshalJSON writes the clip as json.Marshal writes the ResponsePayload it
+// came from, in plain decimal notation, with each point's deltas rounded to
+// its places
+func (t *frameTable) MarshalJSON() ([]byte, error) {
+	keys := make([][]byte, len(t.ids))
+	for i, id := range t.ids {
+		keys[i] = strconv.AppendInt([]byte{'"'}, int64(id), 10)
+		keys[i] = append(keys[i], '"', ':')
+	}
+	b := make([]byte, 0, t.estimatedBytes())
+	b = append(b, '[')
+	for f := range t.frames {
+		if f > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, '{')
+		first := true
+		for i := range t.ids {
+			if !t.present[f*len(t.ids)+i] {
+				continue
+			}
+			if !first {
+				b = append(b, ',')
+			}
+			first = false
+			d := t.deltas[f*len(t.ids)+i]
+			var err error
+			b = append(b, keys[i]...)
+			if b, err = api.AppendFields(b, api.DeltaFields, t.places[i], d.DeltaX, d.DeltaY, d.DeltaZ); err != nil {
+				return nil, err
+			}
+		}
+		b = append(b, '}')
+	}
+	return append(b, ']'), nil
+}
+
+// estimatedBytes sizes the JSON buffer, assuming short decimals
+func (t *frameTable) estimatedBytes() int {
+	return 2 + t.frames*(3+len(t.ids)*estimatedEntryBytes(defaultTablePlaces))
+}
+
+// estimatedEntryBytes is the JSON size of one point's delta in one frame,
+// such as "12":{"delta_x":-0.25,"delta_y":1.5,"delta_z":0}, with a short ID
+// and deltas of a few digits before the decimal places
+func estimatedEntryBytes(places int) int {
+	return 42 + 3*(places+3)
+}
+
+// Memory a generation's response is expected to take, reported by dry runs
+type responseEstimate struct {
+	Frames      int `json:"frames"`
+	Points      int `json:"points"`
+	FramePoints int `json:"frame_points"`
+	// Limit on frame_points, 0 when there is none
+	Limit int `json:"limit"`
+	// Size of the dense JSON frames
+	JSONBytes int `json:"json_bytes"`
+	// Peak memory of the frames held while the response is assembled and
+	// encoded
+	MemoryBytes int `json:"memory_bytes"`
+}
+
+// estimateResponse sizes the response of a validated request from its frame
+// count and the decimal places its points are rounded to
+func estimateResponse(payload RequestPayload, places map[int]int) responseEstimate {
+	e := responseEstimate{
+		Frames: plannedFrames(payload),
+		Points: len(places),
+		Limit:  cfg.MaxFramePoints,
+	}
+	e.FramePoints = e.Frames * e.Points
+	frameBytes := 3
+	for _, p := range places {
+		frameBytes += estimatedEntryBytes(p)
+	}
+	e.JSONBytes = 2 + e.Frames*frameBytes
+	// The frames as maps, the table built from them, the body it encodes, and
+	// the encoder's buffer, which copies the body and grows by doubling, so it
+	// can briefly hold three times it
+	e.MemoryBytes = e.FramePoints*(framePointMapBytes+framePointTableBytes) + e.Frames*frameMapBytes + 4*e.JSONBytes
+	return e
+}
+
+// plannedFrames is the most frames a request's response can have: its
+// length, plus the frames holds repeat and start_at_rest may blend in before
+// the clip and, for a loop, after it, all played twice by pingpong playback
+func plannedFrames(payload RequestPayload) int {
+	frames := payload.Length
+	for _, h := range payload.Holds {
+		frames += max(h.Count, 0)
+	}
+	if o := payload.StartAtRest; restBlendEnabled(o) && (o.BlendMode == "" || o.BlendMode == "extend") {
+		blend := o.BlendFrames
+		if blend <= 0 {
+			blend = defaultRestBlendFrames
+		}
+		if payload.Loop {
+			blend *= 2
+		}
+		frames += blend
+	}
+	if payload.playback == "pingpong" && frames > 1 {
+		frames = 2*frames - 1
+	}
+	return frames
+}
+
+// checkFramePoints rejects frames × points over the configured ceiling.
+// Requests are checked on the frames they plan to return before the model
+// is called, and responses on the frames they hold before they are encoded,
+// so neither can exhaust memory.
+func checkFramePoints(frames, points int) error {
+	if cfg.MaxFramePoints <= 0 || points == 0 || frames <= cfg.MaxFramePoints/points {
+		return nil
+	}
+	received := float64(frames) * float64(points)
+	return newAPIError(http.StatusBadRequest, "Too many frame points: %d frames of %d control points is %.0f, the limit is %d; shorten the clip or animate fewer points",
+		frames, points, received, cfg.MaxFramePoints).
+		withCode("too_many_frame_points").
+		withDetails(map[string]any{"limit": cfg.MaxFramePoints, "received": received, "frames": frames, "points": points})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"runtime"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestFramePointsCountsEveryFrame(t *testing.T) {
+	// Five points, so at most 20 frames
+	setupServer(t, func(c *Config) { c.MaxFramePoints = 100 })
+	request := func(length int, holds []Hold, rest *StartAtRest, query string) int {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: length, Holds: holds, StartAtRest: rest}}
+		rec := serve(t, http.MethodPost, "/generate-deformations"+query, payload, nil)
+		if rec.Code == http.StatusBadRequest {
+			if body := decodeBody[errorResponse](t, rec); body.Error.Code != "too_many_frame_points" {
+				t.Errorf("error code %q, want too_many_frame_points", body.Error.Code)
+			}
+		}
+		return rec.Code
+	}
+
+	cases := []struct {
+		name   string
+		length int
+		holds  []Hold
+		rest   *StartAtRest
+		query  string
+		want   int
+	}{
+		{name: "at the limit", length: 20, want: http.StatusOK},
+		{name: "over the limit", length: 21, want: http.StatusBadRequest},
+		{name: "holds", length: 10, holds: []Hold{{AfterFrame: 2, Count: 6}, {AfterFrame: 5, Count: 5}}, want: http.StatusBadRequest},
+		{name: "rest blend", length: 16, rest: &StartAtRest{}, want: http.StatusBadRequest},
+		{name: "rest blend within", length: 16, rest: &StartAtRest{BlendMode: "within"}, want: http.StatusOK},
+		{name: "pingpong", length: 11, query: "?playback=pingpong", want: http.StatusBadRequest},
+		{name: "pingpong within the limit", length: 10, query: "?playback=pingpong", want: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := request(tc.length, tc.holds, tc.rest, tc.query); got != tc.want {
+				t.Errorf("status %d, want %d", got, tc.want)
+			}
+		})
+	}
+
+	// A cached result served with pingpong is checked on what it returns
+	if got := request(15, nil, nil, ""); got != http.StatusOK {
+		t.Fatalf("status %d, want 200", got)
+	}
+	if got := request(15, nil, nil, "?playback=pingpong"); got != http.StatusBadRequest {
+		t.Errorf("cached result played pingpong: status %d, want 400", got)
+	}
+}
+
+func TestModelFramesTrimmedToLength(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		input.Length *= 50
+		raw, _ := json.Marshal(input)
+		req.Messages = []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: userDataStart + string(raw) + userDataEnd}}
+		return swayResponse(req)
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if frames := decodeBody[ResponsePayload](t, rec); len(frames) != 4 {
+		t.Errorf("response has %d frames, want 4", len(frames))
+	}
+}
+
+func TestFrameTableMatchesJSON(t *testing.T) {
+	frames := benchmarkFrames(20, 12)
+	delete(frames[3], 7)
+	want, err := json.Marshal(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(newFrameTable(frames, nil, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("frame table encodes\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFrameTablePlaces(t *testing.T) {
+	frames := ResponsePayload{{
+		0: {DeltaX: 0.123456, DeltaY: -0.001},
+		1: {DeltaX: 0.123456, DeltaY: -0.00001},
+		2: {DeltaX: 0.123456},
+	}}
+	// Keys already moved to start at 1: body point 0, face point 1 and a
+	// point without a precision
+	got, err := json.Marshal(newFrameTable(rebaseKeys(frames, 1), map[int]int{0: 2, 1: 4}, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"1":{"delta_x":0.12,"delta_y":0,"delta_z":0},"2":{"delta_x":0.1235,"delta_y":0,"delta_z":0},"3":{"delta_x":0.1235,"delta_y":0,"delta_z":0}}]`
+	if string(got) != want {
+		t.Errorf("frame table encodes\n%s\nwant\n%s", got, want)
+	}
+}
+
+// benchmarkFrames is a clip of points swaying by different amounts
+func TestEstimateCoversEncoding(t *testing.T) {
+	places := make(map[int]int, benchPoints)
+	for id := range benchPoints {
+		places[id] = defaultTablePlaces
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{Length: benchFrames}}
+	e := estimateResponse(payload, places)
+
+	// Everything held at once before the encoder copies the body
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	frames := benchmarkFrames(benchFrames, benchPoints)
+	body, err := newFrameTable(frames, nil, 0).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	held := int(after.TotalAlloc-before.TotalAlloc) + len(body)
+	if e.MemoryBytes < held {
+		t.Errorf("memory_bytes %d, but the frames, their table and two copies of the body take %d", e.MemoryBytes, held)
+	}
+	if e.JSONBytes < len(body) {
+		t.Errorf("json_bytes %d, body is %d", e.JSONBytes, len(body))
+	}
+}
+
+func benchmarkFrames(frames, points int) ResponsePayload {
+	clip := make(ResponsePayload, frames)
+	for f := range clip {
+		clip[f] = make(map[int]Deformation, points)
+		for id := range points {
+			phase := 2 * math.Pi * float64(f) / float64(frames)
+			clip[f][id] = Deformation{
+				DeltaX: roundTo(0.1*math.Sin(phase+float64(id)), 4),
+				DeltaY: roundTo(0.05*math.Cos(phase), 4),
+			}
+		}
+	}
+	return clip
+}
+
+// A long clip of a large rig: 1000 frames of 100 points
+const benchFrames, benchPoints = 1000, 100
+
+// The table is built from the frames for every response, so it is part of
+// what encoding them costs
+func BenchmarkFrameTableMarshal(b *testing.B) {
+	frames := benchmarkFrames(benchFrames, benchPoints)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := newFrameTable(frames, nil, 0).MarshalJSON(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFramesMarshal(b *testing.B) {
+	frames := benchmarkFrames(benchFrames, benchPoints)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := json.Marshal(frames); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkModelDeltas(b *testing.B) {
+	positions := make(map[int][]float64, benchPoints)
+	idMap := make(map[int]int, benchPoints)
+	for id := range benchPoints {
+		positions[id] = []float64{float64(id), 1, 0}
+		idMap[id] = id
+	}
+	modelFrames := make([]map[int]Position, benchFrames)
+	for f := range modelFrames {
+		modelFrames[f] = make(map[int]Position, benchPoints)
+		for id := range benchPoints {
+			modelFrames[f][id] = Position{X: float64(id) + 0.01*float64(f), Y: 1, Z: 0}
+		}
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		modelDeltas(modelFrames, positions, idMap)
+	}
+}
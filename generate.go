@@ -0,0 +1,1337 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+// chatClient is the part of the OpenAI client used by the generator
+type chatClient interface {
+	CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+}
+
+// newChatClient builds the upstream client of a profile; replaced by fakes
+// in tests
+var newChatClient = func(profile UpstreamProfile) chatClient {
+	var client chatClient = openai.NewClientWithConfig(openAIConfig(profile))
+	if cfg.FixtureMode != "" {
+		client = &RecordingClient{Mode: cfg.FixtureMode, Dir: cfg.FixtureDir, Fuzzy: cfg.FixtureFuzzy, Next: client}
+	}
+	return client
+}
+
+// Metadata returned alongside frames when requested
+type generationMeta struct {
+	Timings         *timingBreakdown    `json:"timings,omitempty"`
+	Usage           *usageReport        `json:"usage,omitempty"`
+	Prompt          *promptLanguageInfo `json:"prompt,omitempty"`
+	AffectedPoints  []int               `json:"affected_points,omitempty"`
+	Confidence      map[int]float64     `json:"confidence,omitempty"`
+	Batching        *batchInfo          `json:"batching,omitempty"`
+	Mismatch        *semanticMismatch   `json:"semantic_mismatch,omitempty"`
+	Drift           []staticDrift       `json:"static_drift,omitempty"`
+	Events          []frameEvent        `json:"events,omitempty"`
+	GenerationHash  string              `json:"generation_hash,omitempty"`
+	RigProfile      string              `json:"rig_profile,omitempty"`
+	TokenConfidence *float64            `json:"token_confidence,omitempty"`
+	Cache           *cacheStatus        `json:"cache,omitempty"`
+	UnchangedPoints []int               `json:"unchanged_points,omitempty"`
+	AuxiliaryPoints []int               `json:"auxiliary_points,omitempty"`
+	InferredRoles   []inferredRole      `json:"inferred_roles,omitempty"`
+	StartAtRest     *restBlendReport    `json:"start_at_rest,omitempty"`
+	Postprocess     *postprocessReport  `json:"postprocess,omitempty"`
+	Constraints     *constraintReport   `json:"constraint_report,omitempty"`
+	Score           *animationScore     `json:"score,omitempty"`
+	Warnings        []string            `json:"warnings,omitempty"`
+}
+
+// Token usage summed over every upstream call made for a request
+type usageReport struct {
+	api.Usage
+}
+
+func (u *usageReport) add(usage openai.Usage) {
+	u.PromptTokens += usage.PromptTokens
+	u.CompletionTokens += usage.CompletionTokens
+	u.TotalTokens += usage.TotalTokens
+}
+
+// Result of a generation, in the client's original ID space
+type generationResult struct {
+	Frames ResponsePayload
+	// Root track split off the frames with root_motion separate
+	Root           []RootTransform
+	IDMap          map[int]int
+	Positions      map[int][]float64
+	Roles          map[int]string
+	Usage          usageReport
+	Prompt         *promptLanguageInfo
+	Warnings       []string
+	AffectedPoints []int
+	Confidence     map[int]float64
+	Batching       *batchInfo
+	InferredRoles  []inferredRole
+	RestBlend      *restBlendReport
+	Postprocess    *postprocessReport
+	Mismatch       *semanticMismatch
+	Drift          []staticDrift
+	Events         []frameEvent
+	Constraints    *constraintReport
+	// Model that served the request, a fallback when the requested one was
+	// unavailable
+	ServedModel string
+	// Decimal places of each point's deltas
+	Places map[int]int
+	// Content hash of the rig's cached profile
+	RigProfile string
+	// Key of the stored generation, empty when it was not recorded
+	Hash string
+	// Token confidence of the model output, when measured
+	TokenConfidence *float64
+	LowConfidence   bool
+}
+
+// Handler for the /generate-deformations endpoint
+func generateDeformations(w http.ResponseWriter, r *http.Request) {
+	ctx, timings := withTimings(r.Context())
+	defer timings.finish(r.URL.Path)
+
+	// Parse JSON request body
+	endDecode := timings.stage("decode")
+	var payload RequestPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	endDecode()
+
+	// Negotiate the output format before spending an upstream call
+	query := r.URL.Query()
+	format, err := resolveFormat(r.Header.Get("Accept"), query.Get("format"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version, err := resolveAPIVersion(r.Header.Get("X-API-Version"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// A bundle holds several formats; each must accept the request options
+	formats := []string{format.Name}
+	if format.Name == "bundle" {
+		if formats, err = parseBundleFormats(query.Get("formats")); err != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+			return
+		}
+	}
+	onlyJSON := slices.Equal(formats, []string{"json"})
+	playback := query.Get("playback")
+	if err := validatePlayback(playback); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+	smoothWindow, err := parseSmoothing(query.Get("smooth"), query.Get("window"))
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+	frameSelection, err := parseFrameSelection(query.Get("frames"))
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+	layout := query.Get("layout")
+	if err := validateCSVLayout(layout); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+	if err := validateOutputOptions(payload); err != nil {
+		writeError(w, err)
+		return
+	}
+	if payload.Encoding == "sparse" && !onlyJSON {
+		writeError(w, newAPIError(http.StatusBadRequest, "encoding=sparse requires JSON output"))
+		return
+	}
+	if payload.OutputUnits == "per_second" && !onlyJSON {
+		writeError(w, newAPIError(http.StatusBadRequest, "output_units=per_second requires JSON output"))
+		return
+	}
+	if payload.RootMotion == "separate" && !onlyJSON {
+		writeError(w, newAPIError(http.StatusBadRequest, "root_motion=separate requires JSON output"))
+		return
+	}
+	// Timed outputs assume consecutive frames
+	if frameSelection != nil && payload.OutputUnits == "per_second" {
+		writeError(w, newAPIError(http.StatusBadRequest, "frames cannot be combined with output_units=per_second"))
+		return
+	}
+	var fps float64
+	if slices.Contains(formats, "unity") || slices.Contains(formats, "unreal_curves") {
+		if frameSelection != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, "frames cannot be combined with format=%s", format.Name))
+			return
+		}
+		if payload.OutputCoords == "spherical" {
+			writeError(w, newAPIError(http.StatusBadRequest, "format=%s requires cartesian output_coords", format.Name))
+			return
+		}
+		if fps, err = exportFPS(query.Get("fps"), payload); err != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+			return
+		}
+	}
+
+	payload.playback = playback
+	result, cache, err := generateCached(ctx, payload)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("X-Cache", strings.ToUpper(cache.Status))
+	if result.TokenConfidence != nil {
+		w.Header().Set("X-Token-Confidence", strconv.FormatFloat(roundTo(*result.TokenConfidence, 3), 'f', -1, 64))
+	}
+	if result.LowConfidence {
+		w.Header().Set("X-Low-Confidence", "true")
+	}
+	if result.ServedModel != "" {
+		w.Header().Set("X-Served-Model", result.ServedModel)
+	}
+
+	if smoothWindow > 1 {
+		result.Frames = roundFrames(movingAverage(result.Frames, smoothWindow), result.Places)
+	}
+	result.Frames = applyPlayback(result.Frames, playback)
+	result.Root = applyPlayback(result.Root, playback)
+	if frameSelection != nil {
+		if result.Frames, err = selectFrames(result.Frames, frameSelection); err != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+			return
+		}
+		if result.Root != nil {
+			// The root track has a transform per frame
+			result.Root, _ = selectFrames(result.Root, frameSelection)
+		}
+	}
+	// A cached result was checked without this request's playback
+	if err := checkFramePoints(len(result.Frames), len(result.Positions)); err != nil {
+		writeError(w, err)
+		return
+	}
+	frames := renderFrames(result, payload)
+	rendered := frames
+	switch format.Name {
+	case "unity":
+		frames = encodeUnityClip(result.Frames, result.Roles, fps, payload.Loop)
+	case "unreal_curves":
+		frames = encodeUnrealCurves(result.Frames, result.Positions, result.Roles, fps)
+	}
+
+	w.Header().Set("Vary", "Accept")
+	if format.Name == "csv" {
+		defer timings.stage("encode")()
+		w.Header().Set("Content-Type", format.MediaType)
+		if err := writeFramesCSV(w, frames, layout, payload.IndexBase); err != nil {
+			log.Printf("Failed to write CSV response: %v", err)
+		}
+		return
+	}
+
+	frames = encodeFrames(frames, payload)
+	// Dense frames are encoded through a table, which is faster than
+	// encoding a map per frame but holds a second copy of the clip
+	if f, ok := frames.(ResponsePayload); ok {
+		frames = newFrameTable(f, result.Places, payload.IndexBase)
+	}
+
+	// Shape the JSON response for the requested schema version
+	meta := &generationMeta{
+		Timings:         timings.breakdown(),
+		Usage:           &result.Usage,
+		Prompt:          result.Prompt,
+		Warnings:        result.Warnings,
+		AffectedPoints:  result.AffectedPoints,
+		Confidence:      result.Confidence,
+		Batching:        result.Batching,
+		Mismatch:        result.Mismatch,
+		Drift:           result.Drift,
+		Events:          result.Events,
+		GenerationHash:  result.Hash,
+		RigProfile:      result.RigProfile,
+		TokenConfidence: result.TokenConfidence,
+		Cache:           cache,
+		InferredRoles:   result.InferredRoles,
+		StartAtRest:     result.RestBlend,
+		Postprocess:     result.Postprocess,
+		Constraints:     result.Constraints,
+	}
+	if payload.UnchangedPoints == "omit" {
+		meta.UnchangedPoints = unchangedPoints(result.Frames, sortedKeys(result.Positions))
+	}
+	meta.AuxiliaryPoints = auxiliaryIDs(payload.ControlPoints)
+	wantScore := query.Get("score") == "true"
+	if wantScore {
+		score := scoreAnimation(result.Frames, result.Positions, scoreTargets(result, payload.Prompt), payload.Loop)
+		meta.Score = &score
+	}
+	response := responseEncoders[version](frames, result, meta, responseOptions{
+		IncludeIDMap: query.Get("include_id_map") == "true",
+		// The score is part of the metadata
+		IncludeMeta: query.Get("include_meta") == "true" || wantScore,
+	})
+
+	defer timings.stage("encode")()
+	if format.Name == "bundle" {
+		writeBundle(w, bundleContents{
+			formats:  formats,
+			response: response,
+			frames:   rendered,
+			layout:   layout,
+			result:   result,
+			fps:      fps,
+			payload:  payload,
+			meta:     meta,
+			version:  version,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", format.MediaType)
+	w.Header().Set("X-API-Version", strconv.Itoa(version))
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
+
+// parseModelContent parses one completion into frames keyed by control point ID
+func parseModelContent(content string) ([]map[int]Position, error) {
+	var openaiResp OpenAIResponse
+	if err := json.Unmarshal([]byte(content), &openaiResp); err != nil {
+		log.Printf("Failed to parse OpenAI response: %v", err)
+		log.Printf("Response content was: %s", content)
+		return nil, newAPIError(http.StatusInternalServerError, "Failed to parse OpenAI response: %v", err)
+	}
+	if isEmptyGeneration(openaiResp) {
+		log.Printf("OpenAI returned no animation frames: %s", content)
+		return nil, emptyGenerationError(content)
+	}
+
+	// Convert string keys to integers, in sorted order so that keys naming the
+	// same point ("7" and "07") resolve the same way on every run
+	frames := make([]map[int]Position, len(openaiResp.Frames))
+	for frameIndex, frame := range openaiResp.Frames {
+		frames[frameIndex] = make(map[int]Position, len(frame))
+		for _, idStr := range sortedKeys(frame) {
+			position := frame[idStr]
+			id := 0
+			if _, err := fmt.Sscanf(idStr, "%d", &id); err != nil {
+				log.Printf("Invalid ID format: %s", idStr)
+				continue
+			}
+			frames[frameIndex][id] = position
+		}
+	}
+	return frames, nil
+}
+
+// selectCandidate parses every returned choice and keeps the one with the
+// lowest total jerk. Unusable choices are skipped; if none parse, the first
+// choice's error is returned.
+func selectCandidate(choices []openai.ChatCompletionChoice, weights map[int]float64) ([]map[int]Position, openai.ChatCompletionChoice, error) {
+	if len(choices) == 0 {
+		return nil, openai.ChatCompletionChoice{}, emptyGenerationError("")
+	}
+
+	var (
+		best       []map[int]Position
+		bestChoice openai.ChatCompletionChoice
+		bestScore  = math.Inf(1)
+		firstErr   error
+	)
+	for i, choice := range choices {
+		content := choiceContent(choice)
+		log.Printf("OpenAI Response Content (choice %d): %s", i, content)
+		frames, err := parseModelContent(content)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if score := motionJerk(frames, weights); best == nil || score < bestScore {
+			best, bestChoice, bestScore = frames, choice, score
+		}
+	}
+	if best == nil {
+		return nil, openai.ChatCompletionChoice{}, firstErr
+	}
+	if len(choices) > 1 {
+		log.Printf("Selected candidate with total jerk %.4f out of %d choices", bestScore, len(choices))
+	}
+	return best, bestChoice, nil
+}
+
+// isEmptyGeneration reports whether the model returned no usable frames: a
+// missing or empty frames array, or frames that are all empty objects
+func isEmptyGeneration(resp OpenAIResponse) bool {
+	for _, frame := range resp.Frames {
+		if len(frame) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func emptyGenerationError(content string) *apiError {
+	return newAPIError(http.StatusBadGateway, "The model returned no animation frames").
+		withCode("empty_generation").
+		withDetails(map[string]string{"content_snippet": snippet(content, 200)})
+}
+
+// snippet truncates s to at most n runes for inclusion in errors and logs
+func snippet(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
+
+// renderFrames expresses the result frames in the requested coordinate system
+func renderFrames(result *generationResult, payload RequestPayload) any {
+	frames := result.Frames
+	if payload.OutputUnits == "per_second" {
+		velocities := offsetsToVelocities(frames, result.Places, payload.FPS)
+		if payload.UnchangedPoints == "omit" {
+			velocities.Frames = omitUnchanged(velocities.Frames)
+		}
+		return velocities
+	}
+	if payload.UnchangedPoints == "omit" {
+		frames = omitUnchanged(frames)
+	}
+	if payload.OutputCoords == "spherical" {
+		return toSpherical(frames, result.Positions, payload.SphericalPivot, upAxis(payload.Axes))
+	}
+	return frames
+}
+
+// validateOutputOptions checks the options that only shape the response.
+// They are left out of the cache key and cache hits skip validatePayload, so
+// the handler checks them before serving from the cache too.
+func validateOutputOptions(payload RequestPayload) error {
+	if err := validateIndexBase(payload.IndexBase); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateEncoding(payload.Encoding, payload.OutputCoords, payload.OutputUnits, payload.UnchangedPoints); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	return nil
+}
+
+// encodeFrames applies the request's index_base and encoding to rendered
+// JSON frames
+func encodeFrames(frames any, payload RequestPayload) any {
+	frames = rebaseFrameKeys(frames, payload.IndexBase)
+	if payload.Encoding == "sparse" {
+		frames = encodeSparse(frames.(ResponsePayload), cfg.SparseEpsilon, cfg.SparseKeyframeInterval)
+	}
+	return frames
+}
+
+// validatePayload resolves rig references and checks the request options
+func validatePayload(payload *RequestPayload) error {
+	// Resolve a registered rig reference into inline control points
+	if err := resolveRig(payload); err != nil {
+		if errors.Is(err, errUnknownRig) || errors.Is(err, errRigConflict) {
+			return newAPIError(http.StatusBadRequest, "%v", err)
+		}
+		log.Printf("Failed to resolve rig %s: %v", payload.RigID, err)
+		return newAPIError(http.StatusInternalServerError, "Failed to load rig")
+	}
+
+	// Timed keyframes determine the frame count from duration and fps
+	if err := resolveTiming(payload); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+
+	if len(payload.ControlPoints) == 0 || payload.Prompt == "" || payload.Length <= 0 {
+		return newAPIError(http.StatusBadRequest, "Missing control_points, prompt, or invalid length")
+	}
+	if len(payload.ControlPoints) > cfg.MaxControlPoints {
+		return newAPIError(http.StatusBadRequest, "Too many control points: %d received, the limit is %d", len(payload.ControlPoints), cfg.MaxControlPoints).
+			withCode("too_many_control_points").
+			withDetails(map[string]int{"limit": cfg.MaxControlPoints, "received": len(payload.ControlPoints)})
+	}
+	if err := checkFramePoints(plannedFrames(*payload), len(payload.ControlPoints)); err != nil {
+		return err
+	}
+	prompt, err := sanitizePrompt(payload.Prompt)
+	if err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	payload.Prompt = prompt
+	// Roles end up in the prompt too; clean them on a copy of the points
+	payload.ControlPoints = slices.Clone(payload.ControlPoints)
+	for i, cp := range payload.ControlPoints {
+		role, err := sanitizeRole(cp.Role)
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "control point %d: %v", cp.ID, err)
+		}
+		payload.ControlPoints[i].Role = role
+	}
+	if err := validateFinitePositions(payload.ControlPoints); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateAxes(payload.Axes); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateOnConventionMismatch(payload.OnConventionMismatch); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	// Check the client's own roles, before any are inferred from the axes
+	if problems := checkAxes(payload.Axes, payload.ControlPoints); len(problems) > 0 {
+		if payload.OnConventionMismatch == "reject" {
+			return conventionMismatchError(problems)
+		}
+		payload.conventionMismatches = problems
+	}
+	if payload.InferRoles {
+		payload.inferredRoles = inferRoles(payload.ControlPoints, payload.Axes)
+	}
+	if err := applyPreset(payload); err != nil {
+		return err
+	}
+	if payload.Easing != nil {
+		if err := validateEasingOptions(payload.Easing); err != nil {
+			return newAPIError(http.StatusBadRequest, "%v", err)
+		}
+	}
+	if err := validateFreezeAxes(payload.FreezeAxes); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if idRemapDisabled(*payload) {
+		if duplicates := duplicateIDs(payload.ControlPoints); len(duplicates) > 0 {
+			return newAPIError(http.StatusBadRequest, "Control point IDs %v are used more than once, which is not allowed with ID remapping disabled", duplicates).
+				withCode("duplicate_point_ids").
+				withDetails(map[string]any{"duplicate_ids": duplicates})
+		}
+	}
+	if err := validateOutputUnits(payload.OutputUnits, payload.FPS, payload.OutputCoords); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateUnchangedPoints(payload.UnchangedPoints); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateOutputOptions(*payload); err != nil {
+		return err
+	}
+	if err := validateAuxiliaryHandling(payload.AuxiliaryHandling); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateJiggle(payload.Jiggle, payload.ControlPoints); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateExaggeration(payload.Exaggerate, payload.ExaggerateRoles); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateRestPose(payload.RestPose, payload.ControlPoints); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateStabilizeCOM(payload.StabilizeCOM, payload.COMPoints, payload.ControlPoints); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateHolds(payload.Holds, payload.Length); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateStartAtRest(payload.StartAtRest); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validatePipeline(payload.Pipeline); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateNeighborRigidity(payload.NeighborRigidity, payload.Neighbors, payload.ControlPoints); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateOutputCoords(payload.OutputCoords, payload.SphericalPivot); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validatePromptLanguageMode(payload.PromptLanguageMode); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := applyAutoTranslate(payload); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateCategories(payload.ControlPoints); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateConstraints(payload.Constraints, payload.ControlPoints); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateMaxRange(payload.MaxRange); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateOnCorrupt(payload.OnCorrupt); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateOnMismatch(payload.OnMismatch); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateOnDrift(payload.OnDrift); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateResponseMode(payload.ResponseMode); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateEventsMode(payload.Events); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateOnImplausible(payload.OnImplausible); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validatePlausibility(payload.Plausibility); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateRootMotion(payload.RootMotion, payload.RootYaw); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if err := validateSectionSelection(payload.PromptSections); err != nil {
+		return newAPIError(http.StatusBadRequest, "%v", err)
+	}
+	if payload.Model == "" {
+		payload.Model = cfg.DefaultModel
+	} else if !slices.Contains(cfg.AllowedModels, payload.Model) {
+		return newAPIError(http.StatusBadRequest, "Model %q is not allowed", payload.Model).
+			withDetails(map[string]any{"allowed_models": cfg.AllowedModels})
+	}
+	if payload.Candidates < 0 || payload.Candidates > maxCandidates {
+		return newAPIError(http.StatusBadRequest, "candidates must be between 1 and %d", maxCandidates)
+	}
+	return nil
+}
+
+func idRemapDisabled(payload RequestPayload) bool {
+	return cfg.DisableIDRemap || payload.DisableIDRemap
+}
+
+// duplicateIDs returns the sorted control point IDs used more than once
+func duplicateIDs(points []ControlPoint) []int {
+	counts := make(map[int]int, len(points))
+	for _, cp := range points {
+		counts[cp.ID]++
+	}
+	var duplicates []int
+	for _, id := range sortedKeys(counts) {
+		if counts[id] > 1 {
+			duplicates = append(duplicates, id)
+		}
+	}
+	return duplicates
+}
+
+// remapOrder returns a copy of the control points in the order they are given
+// compact IDs. Sorting makes the remap, and everything downstream of it,
+// independent of the order clients list their points in.
+func remapOrder(points []ControlPoint) []ControlPoint {
+	points = slices.Clone(points)
+	if cfg.RemapOrder == "input" {
+		return points
+	}
+	slices.SortStableFunc(points, func(a, b ControlPoint) int {
+		if c := cmp.Compare(a.ID, b.ID); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Role, b.Role); c != 0 {
+			return c
+		}
+		return slices.Compare(a.Position, b.Position)
+	})
+	return points
+}
+
+// generate runs the full pipeline for a request: validation, prompt
+// construction, the upstream call, parsing and post-processing
+func generate(ctx context.Context, payload RequestPayload) (*generationResult, error) {
+	if payload.BlendWithPrevious != 0 {
+		return generateRefinement(ctx, payload)
+	}
+	if payload.SecondaryPrompt != "" {
+		return generateBlend(ctx, payload)
+	}
+	timings := timingsFrom(ctx)
+	progress := progressFrom(ctx)
+
+	progress.stage("validate")
+	endValidate := timings.stage("validate")
+	request := payload
+	profile, err := applyProfile(ctx, &payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePayload(&payload); err != nil {
+		return nil, err
+	}
+	request.ControlPoints, request.RigID = payload.ControlPoints, ""
+	points := preparePoints(&payload)
+	endValidate()
+
+	// Replaying recorded fixtures needs no key
+	if profile.APIKey == "" && upstreamKeyFrom(ctx) == "" && cfg.FixtureMode != "replay" {
+		return nil, newAPIError(http.StatusInternalServerError, "OpenAI API key not configured")
+	}
+	client := upstreamClient(ctx, profile)
+
+	// Translate or annotate non-English prompts
+	var usage usageReport
+	progress.stage("language")
+	endLanguage := timings.stage("language")
+	promptInfo, translationUsage, warnings := resolvePromptLanguage(ctx, client, &payload)
+	usage.add(translationUsage)
+	endLanguage()
+	warnings = append(conventionWarnings(payload), warnings...)
+
+	// Flesh out terse prompts with a cheap model first
+	if payload.ExpandPrompt {
+		progress.stage("expand")
+		endExpand := timings.stage("expand")
+		expansionUsage, expansionWarnings := applyPromptExpansion(ctx, client, &payload, promptInfo)
+		usage.add(expansionUsage)
+		warnings = append(warnings, expansionWarnings...)
+		endExpand()
+	}
+
+	// Ask the model for positions, splitting oversized rigs into batches
+	var batching *batchInfo
+	groups := planBatches(payload.ControlPoints)
+	if len(groups) > 1 {
+		log.Printf("Splitting %d control points into %d batches", len(payload.ControlPoints), len(groups))
+		batching = describeBatches(groups, points.idMap)
+	}
+	progress.stage("upstream")
+	fetch := func(payload RequestPayload) (*modelCall, error) {
+		progress.expectChunks(max(len(groups), 1))
+		var call *modelCall
+		var err error
+		if len(groups) > 1 {
+			call, err = generateBatched(ctx, client, payload, groups)
+		} else {
+			call, err = requestFrames(ctx, client, payload, payload.ControlPoints, nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Repair or reject corrupt coordinates before the checks below
+		// measure motion with them
+		modelPositions := make(map[int][]float64, len(payload.ControlPoints))
+		for _, cp := range payload.ControlPoints {
+			modelPositions[cp.ID] = cp.Position
+		}
+		call.ParsedFrames = call.Frames
+		if call.Frames, err = sanitizeModelFrames(call.Frames, payload, modelPositions, points.idMap); err != nil {
+			return nil, err
+		}
+		return call, nil
+	}
+	call, err := fetch(payload)
+	if err != nil {
+		return nil, err
+	}
+	usage.add(call.Usage)
+
+	// A clip with no motion at all is almost never what was asked for
+	if !payload.warmup && cfg.StaticPolicy != "allow" && isStaticGeneration(call.Frames, payload.ControlPoints) {
+		if cfg.StaticPolicy == "fail" {
+			return nil, staticGenerationError()
+		}
+		log.Printf("Model returned a static clip, retrying with a reinforced prompt")
+		incCounter("static_generation_retries_total", "", "", 1)
+		payload.reinforceMotion = true
+		if call, err = fetch(payload); err != nil {
+			return nil, err
+		}
+		usage.add(call.Usage)
+		if isStaticGeneration(call.Frames, payload.ControlPoints) {
+			return nil, staticGenerationError()
+		}
+	}
+
+	// A response made up mostly of IDs that were never sent usually means the
+	// request data derailed the model
+	if likelyInjectionArtifact(call.Frames, payload.ControlPoints) {
+		log.Printf("Model output is mostly unknown control point IDs, retrying with a reinforced prompt")
+		incCounter("unknown_id_retries_total", "", "", 1)
+		payload.reinforceIDs = true
+		if call, err = fetch(payload); err != nil {
+			return nil, err
+		}
+		usage.add(call.Usage)
+	}
+
+	// Give generations the model itself was unsure about another chance
+	if isLowConfidence(call) && cfg.LowConfidencePolicy == "retry" {
+		log.Printf("Model token confidence %.3f is below %.3g, retrying", *call.TokenConfidence, cfg.MinConfidence)
+		incCounter("low_confidence_retries_total", "", "", 1)
+		if call, err = fetch(payload); err != nil {
+			return nil, err
+		}
+		usage.add(call.Usage)
+	}
+	lowConfidence := isLowConfidence(call)
+	if lowConfidence {
+		warnings = append(warnings, lowConfidenceWarning(*call.TokenConfidence))
+	}
+
+	// Catch the model moving the wrong side of the body
+	var mismatch *semanticMismatch
+	if !payload.warmup {
+		mismatch = detectSemanticMismatch(payload.Prompt, call.Frames, payload.ControlPoints)
+	}
+	if mismatch != nil && payload.OnMismatch == "retry" {
+		log.Printf("Model moved the %s instead of the %s, retrying with a corrective instruction", mismatch.Observed, mismatch.Expected)
+		incCounter("semantic_mismatch_retries_total", "", "", 1)
+		payload.correction = mismatch
+		if call, err = fetch(payload); err != nil {
+			return nil, err
+		}
+		usage.add(call.Usage)
+		mismatch = detectSemanticMismatch(payload.Prompt, call.Frames, payload.ControlPoints)
+	}
+	if mismatch != nil {
+		if payload.OnMismatch == "reject" {
+			return nil, semanticMismatchError(mismatch)
+		}
+		warnings = append(warnings, mismatch.String())
+	}
+
+	// Catch points the prompt leaves out moving anyway. The model's frames
+	// use the IDs it was sent, so its motion is measured from the positions
+	// it was sent under them.
+	driftThreshold := cfg.StaticDriftThreshold * rigDiagonal(points.rest)
+	inputPositions := make(map[int][]float64, len(payload.ControlPoints))
+	for _, cp := range payload.ControlPoints {
+		inputPositions[cp.ID] = cp.Position
+	}
+	modelDrift := func() []staticDrift {
+		deltas := modelDeltas(call.Frames, inputPositions, points.idMap)
+		return detectStaticDrift(payload.Prompt, deltas, points.rest, driftThreshold)
+	}
+	var drift []staticDrift
+	if !payload.warmup {
+		drift = modelDrift()
+	}
+	if len(drift) > 0 && payload.OnDrift == "retry" {
+		log.Printf("Model moved %d control points the prompt does not involve, retrying with a corrective instruction", len(drift))
+		incCounter("static_drift_retries_total", "", "", 1)
+		// Named to the model by the IDs it was sent
+		payload.driftCorrection = slices.Clone(drift)
+		for i, d := range payload.driftCorrection {
+			payload.driftCorrection[i].Point = points.idMap[d.Point]
+		}
+		if call, err = fetch(payload); err != nil {
+			return nil, err
+		}
+		usage.add(call.Usage)
+		drift = modelDrift()
+	}
+	if len(drift) > 0 {
+		warning := staticDriftWarning(drift)
+		if payload.OnDrift == "zero" {
+			warning += "; they were held at their input positions"
+		}
+		warnings = append(warnings, warning)
+	}
+
+	// Catch the character folding through itself, when asked to
+	if !payload.warmup && (payload.Plausibility != nil || payload.OnImplausible != "") {
+		opts := plausibilityDefaults(payload.Plausibility)
+		implausible := checkPlausibility(call.Frames, payload.ControlPoints, payload.Neighbors, points.idMap, opts)
+		if len(implausible) > 0 && payload.OnImplausible == "retry" {
+			log.Printf("Model output folds the character through itself in %d frames, retrying", len(implausible))
+			incCounter("implausible_retries_total", "", "", 1)
+			if call, err = fetch(payload); err != nil {
+				return nil, err
+			}
+			usage.add(call.Usage)
+			implausible = checkPlausibility(call.Frames, payload.ControlPoints, payload.Neighbors, points.idMap, opts)
+		}
+		if len(implausible) > 0 {
+			if payload.OnImplausible == "reject" {
+				return nil, implausibleGenerationError(implausible)
+			}
+			warnings = append(warnings, implausibleWarnings(implausible)...)
+		}
+	}
+	progress.stage("postprocess")
+	endPostprocess := timings.stage("postprocess")
+	frames, annotations, constraints, postWarnings, err := postprocess(payload, points, call)
+	if err != nil {
+		return nil, err
+	}
+	warnings = append(warnings, postWarnings...)
+	if len(drift) > 0 && payload.OnDrift == "zero" {
+		frames = zeroStaticDrift(frames, drift, points.restOffsets, driftThreshold, driftBlendFrames)
+	}
+	frames, restReport, restWarnings := applyStartAtRest(frames, payload, points)
+	warnings = append(warnings, restWarnings...)
+	// Events the model leaves out are found in the trajectories instead
+	events := annotations.Events
+	if payload.Events == "computed" || (payload.Events == "model" && len(events) == 0) {
+		diagonal := rigDiagonal(points.rest)
+		events = detectEvents(frames, points.positions, points.roles, upAxis(payload.Axes),
+			cfg.EventContactTolerance*diagonal, math.Max(0.01, 0.01*diagonal))
+	}
+	frames, root := applyRootMotion(frames, payload, points)
+	endPostprocess()
+
+	readiness.recordSuccess()
+
+	hash := recordGeneration(request, payload, len(groups), points.idMap, call, frames, warnings)
+
+	// Attribute the spend to the profile for billing
+	incCounter("upstream_tokens_total", "profile", profile.Name, float64(usage.TotalTokens))
+	log.Printf("Usage: request=%s profile=%s model=%s client=%s tokens=%d generation=%s", requestIDFrom(ctx), profile.Name, payload.Model, clientKeyFrom(ctx), usage.TotalTokens, hash)
+
+	return &generationResult{
+		Frames:          frames,
+		Root:            root,
+		IDMap:           points.idMap,
+		Positions:       points.positions,
+		Roles:           points.roles,
+		Usage:           usage,
+		Prompt:          promptInfo,
+		Warnings:        warnings,
+		AffectedPoints:  annotations.AffectedPoints,
+		Confidence:      annotations.Confidence,
+		Batching:        batching,
+		InferredRoles:   payload.inferredRoles,
+		RestBlend:       restReport,
+		Postprocess:     describePipeline(payload),
+		Mismatch:        mismatch,
+		Drift:           drift,
+		Events:          events,
+		Places:          points.places,
+		Constraints:     constraints,
+		ServedModel:     call.Model,
+		RigProfile:      points.profile.Hash,
+		Hash:            hash,
+		TokenConfidence: call.TokenConfidence,
+		LowConfidence:   lowConfidence,
+	}, nil
+}
+
+// Lookup tables built from the control points before the model is called,
+// keyed by original ID
+type pointTables struct {
+	budgets    map[int]float64
+	places     map[int]int
+	roles      map[int]string
+	positions  map[int][]float64
+	categories map[int]string
+	// Original ID → compact ID sent to the model
+	idMap map[int]int
+	// The rig at rest with original IDs, at rest_pose positions where the
+	// request gives them
+	rest []ControlPoint
+	// Input position minus rest position of the points rest_pose moves
+	restOffsets map[int]Deformation
+	// Statistics shared with every request for the same rig; places and
+	// categories are its maps and must not be modified
+	profile *RigProfile
+}
+
+// preparePoints builds the point tables and compacts the payload's control
+// point IDs for the model
+func preparePoints(payload *RequestPayload) pointTables {
+	// Statistics of the rig itself come from the profile cache
+	profile := rigProfiles.profile(payload.ControlPoints)
+
+	// Work on a copy so the caller's control points keep their order and IDs
+	payload.ControlPoints = remapOrder(payload.ControlPoints)
+
+	// Remember roles, positions, categories, motion budgets and precision by
+	// original ID for post-processing
+	t := pointTables{
+		budgets:     profile.budgets(payload.Constraints),
+		places:      profile.Places,
+		roles:       make(map[int]string),
+		positions:   make(map[int][]float64),
+		categories:  profile.Categories,
+		idMap:       make(map[int]int),
+		rest:        restPosePoints(payload.ControlPoints, payload.RestPose),
+		restOffsets: restOffsets(payload.ControlPoints, payload.RestPose),
+		profile:     profile,
+	}
+	for _, cp := range t.rest {
+		t.roles[cp.ID] = cp.Role
+		t.positions[cp.ID] = cp.Position
+	}
+
+	// Fix duplicate IDs by reassigning unique IDs (assuming typo in input),
+	// unless the client vouches for its IDs and they are used as they are
+	uniqueID := 0
+	for i, cp := range payload.ControlPoints {
+		if idRemapDisabled(*payload) {
+			t.idMap[cp.ID] = cp.ID
+		} else if _, exists := t.idMap[cp.ID]; !exists {
+			t.idMap[cp.ID] = uniqueID
+			payload.ControlPoints[i].ID = uniqueID
+			uniqueID++
+		} else {
+			payload.ControlPoints[i].ID = t.idMap[cp.ID]
+		}
+	}
+	payload.budgets = make(map[int]float64, len(t.budgets))
+	for originalID, budget := range t.budgets {
+		payload.budgets[t.idMap[originalID]] = budget
+	}
+	return t
+}
+
+// postprocess turns the model's positions into the client's deltas: it
+// drops invented points, repairs corrupt coordinates, maps IDs back and
+// applies the motion budgets, neighbour smoothing, easing, frozen axes and
+// rounding. With limits of the request's own, it also reports how the
+// model's output measured up to them. It makes no upstream calls, so stored
+// generations can be replayed through it with different options.
+func postprocess(payload RequestPayload, t pointTables, call *modelCall) (ResponsePayload, modelAnnotations, *constraintReport, []string, error) {
+	var warnings []string
+	annotations := call.Annotations
+
+	// Create a map of original positions for delta calculation
+	originalPositions := make(map[int][]float64)
+	for _, cp := range payload.ControlPoints {
+		originalPositions[cp.ID] = cp.Position
+	}
+
+	// Points the model invented have no rest position to measure from
+	modelFrames, idWarnings, err := checkUnknownPointIDs(call.Frames, originalPositions)
+	if err != nil {
+		return nil, annotations, nil, nil, err
+	}
+	warnings = append(warnings, idWarnings...)
+
+	// Deltas are measured from the rest pose, which rest_pose may set apart
+	// from the positions the model animated from
+	restPositions := maps.Clone(originalPositions)
+	for originalID, p := range payload.RestPose {
+		if id, ok := t.idMap[originalID]; ok {
+			restPositions[id] = []float64{p.X, p.Y, p.Z}
+		}
+	}
+
+	// Reject or repair non-finite and absurd coordinates before they reach
+	// clients. Fresh generations were sanitized as they arrived; stored ones
+	// replayed with a different on_corrupt were not.
+	modelFrames, err = sanitizeModelFrames(modelFrames, payload, originalPositions, t.idMap)
+	if err != nil {
+		return nil, annotations, nil, nil, err
+	}
+	rawFrames := modelFrames
+
+	// Keep every coordinate within max_range of where the point started
+	if payload.MaxRange > 0 {
+		var clamped []int
+		modelFrames, clamped = clampToRange(modelFrames, originalPositions, payload.MaxRange)
+		if len(clamped) > 0 {
+			originals := reverseIDMap(t.idMap)
+			var ids []int
+			for _, id := range clamped {
+				ids = append(ids, originals[id]...)
+			}
+			slices.Sort(ids)
+			warnings = append(warnings, fmt.Sprintf("Control points %v strayed more than max_range from their original positions and were clamped", ids))
+		}
+	}
+
+	// Calculate deltas from absolute positions; they are rounded to each
+	// point's precision once post-processing is done
+	adjustedDeformations := modelDeltas(modelFrames, restPositions, t.idMap)
+
+	// Measure the model's own output against the request's limits before
+	// anything enforces them
+	var report *constraintReport
+	if wantsConstraintReport(payload) {
+		raw := adjustedDeformations
+		if payload.MaxRange > 0 {
+			raw = modelDeltas(rawFrames, restPositions, t.idMap)
+		}
+		report = reportConstraints(raw, constraintLimitsFor(payload, t))
+	}
+
+	// Report what the model says it animated and flag contradictions
+	annotations = annotations.toOriginalIDs(t.idMap)
+	warnings = append(warnings, checkAffectedPoints(annotations, adjustedDeformations, payload.ControlPoints)...)
+
+	// Every frame carries every point from here on, so the pipeline and the
+	// unchanged_points modes see the same clip
+	adjustedDeformations, filled := backfillPoints(adjustedDeformations, sortedKeys(t.idMap))
+	if len(filled) > 0 {
+		warnings = append(warnings, fmt.Sprintf("The model left control points %v out of some frames; they hold their previous delta there", filled))
+	}
+
+	// Unnamed helper points follow the named points around them, or stay put
+	adjustedDeformations = applyAuxiliaryHandling(adjustedDeformations, payload.AuxiliaryHandling, t.rest)
+
+	// Budgets, smoothing, easing, frozen axes and holds, in the request's order
+	adjustedDeformations = runPipeline(adjustedDeformations, buildPipeline(payload, t, &warnings), t.rest)
+
+	adjustedDeformations = roundFrames(adjustedDeformations, t.places)
+
+	if payload.Events == "model" {
+		var eventWarnings []string
+		annotations.Events, eventWarnings = resolveModelEvents(call.Annotations.Events, t.idMap, len(adjustedDeformations))
+		warnings = append(warnings, eventWarnings...)
+	}
+
+	return adjustedDeformations, annotations, report, warnings, nil
+}
+
+// modelDeltas turns the model's positions into deltas from each point's rest
+// position, keyed by original ID. Long clips of large rigs make this the
+// biggest allocation of a generation, so each frame is built once, at its
+// final size, from a table of the original IDs behind each compact ID.
+func modelDeltas(modelFrames []map[int]Position, restPositions map[int][]float64, idMap map[int]int) ResponsePayload {
+	originals := reverseIDMap(idMap)
+	adjustedDeformations := make(ResponsePayload, len(modelFrames))
+	for frameIndex, frame := range modelFrames {
+		adjustedFrame := make(map[int]Deformation, len(idMap))
+		for id, position := range frame {
+			// Calculate delta from rest position
+			originalPos := restPositions[id]
+			if len(originalPos) < 3 {
+				continue
+			}
+			delta := Deformation{
+				DeltaX: position.X - originalPos[0],
+				DeltaY: position.Y - originalPos[1],
+				DeltaZ: position.Z - originalPos[2],
+			}
+			// Adjust IDs back to original (if they were remapped)
+			for _, originalID := range originals[id] {
+				adjustedFrame[originalID] = delta
+			}
+		}
+		adjustedDeformations[frameIndex] = adjustedFrame
+	}
+	return adjustedDeformations
+}
+
+// One model call's parsed output, in compact IDs
+type modelCall struct {
+	Frames []map[int]Position
+	// The frames as parsed, before on_corrupt repaired them; stored so a
+	// replay can apply a different policy
+	ParsedFrames []map[int]Position
+	Annotations  modelAnnotations
+	Usage        openai.Usage
+	// Raw completion content, one entry per upstream call
+	Content []string
+	// Geometric mean token probability when logprobs were requested; the
+	// lowest of all batches
+	TokenConfidence *float64
+	// Model that answered, a fallback when the requested one was
+	// unavailable; comma-separated when batches were served by different
+	// models
+	Model string
+}
+
+// promptPositions returns copies of points with positions rounded to places,
+// or points itself when places is negative
+func promptPositions(points []ControlPoint, places int) []ControlPoint {
+	if places < 0 || len(points) == 0 {
+		return points
+	}
+	rounded := slices.Clone(points)
+	for i, cp := range rounded {
+		position := make([]float64, len(cp.Position))
+		for j, v := range cp.Position {
+			position[j] = roundTo(v, places)
+		}
+		rounded[i].Position = position
+	}
+	return rounded
+}
+
+// buildMessages assembles the chat messages asking the model to animate
+// points, returning the user input JSON alongside them
+func buildMessages(payload RequestPayload, points, contextPoints []ControlPoint) ([]openai.ChatCompletionMessage, []byte, error) {
+	// Deltas are measured from the original positions; excess precision only
+	// costs tokens in the prompt
+	points = promptPositions(points, cfg.InputPrecision)
+	contextPoints = promptPositions(contextPoints, cfg.InputPrecision)
+	named, auxiliary := splitAuxiliary(points)
+	inputJSON, err := json.Marshal(modelInput{
+		ControlPoints:   named,
+		AuxiliaryPoints: auxiliary,
+		ContextPoints:   contextPoints,
+		Prompt:          payload.Prompt,
+		Length:          payload.Length,
+		Loop:            payload.Loop,
+		Keyframes:       modelKeyframes(payload),
+	})
+	if err != nil {
+		return nil, nil, newAPIError(http.StatusInternalServerError, "Failed to serialize input")
+	}
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: buildSystemPrompt(payload, points),
+		},
+	}
+	if examples := selectExamples(payload.Prompt, cfg.Examples, cfg.MaxExamples); len(examples) > 0 {
+		messages = append(messages, exampleMessages(examples)...)
+	}
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: fenceUserData(string(inputJSON)),
+	})
+	return messages, inputJSON, nil
+}
+
+// requestFrames asks the model to animate points. contextPoints, when set,
+// are the rest of the rig shown at rest for reference only.
+func requestFrames(ctx context.Context, client chatClient, payload RequestPayload, points, contextPoints []ControlPoint) (*modelCall, error) {
+	timings := timingsFrom(ctx)
+
+	// Prepare input for the model
+	endPrompt := timings.stage("prompt_build")
+	messages, inputJSON, err := buildMessages(payload, points, contextPoints)
+	if err != nil {
+		return nil, err
+	}
+	request := openai.ChatCompletionRequest{
+		Model:    payload.Model,
+		Messages: messages,
+	}
+	setResponseMode(&request, responseMode(payload))
+	if payload.Candidates > 1 {
+		request.N = payload.Candidates
+	}
+	if cfg.MinConfidence > 0 {
+		request.LogProbs = true
+	}
+	endPrompt()
+
+	log.Printf("Sending payload to OpenAI: %s", string(inputJSON))
+
+	// Call the model, retrying transient upstream failures
+	endUpstream := timings.stage("upstream")
+	resp, servedBy, err := completeWithRetry(ctx, client, payload.Profile, request)
+	endUpstream()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse OpenAI response, picking the smoothest candidate when several were requested
+	endParse := timings.stage("parse")
+	frames, choice, err := selectCandidate(resp.Choices, jerkWeights(points))
+	if err != nil {
+		return nil, err
+	}
+	// Frames past the requested length would only grow the response beyond
+	// what the request was checked for
+	if len(frames) > payload.Length {
+		log.Printf("Model returned %d frames, %d were requested; dropping the rest", len(frames), payload.Length)
+		frames = frames[:payload.Length]
+	}
+	endParse()
+
+	progressFrom(ctx).chunkDone(len(frames), resp.Usage.TotalTokens)
+
+	content := choiceContent(choice)
+	call := &modelCall{Frames: frames, Annotations: parseModelAnnotations(content), Usage: resp.Usage, Content: []string{content}, Model: servedBy}
+	if confidence, ok := tokenConfidence(choice.LogProbs); ok {
+		call.TokenConfidence = &confidence
+	}
+	return call, nil
+}
+
+// completeWithRetry calls the model, retrying failures that look transient
+// with exponential backoff, or after the wait a 429 advised. With
+// model_fallbacks configured, a rate-limit or availability error moves on to
+// the next model in the chain at once instead, and retries start over there.
+// Every attempt waits for the token budget, gets its own upstream timeout
+// and fails fast while the breaker is open. The budget is the one of the
+// named profile the client calls through. It returns the model that
+// answered.
+func completeWithRetry(ctx context.Context, client chatClient, profile string, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, string, error) {
+	fallbacks := fallbackModels(request.Model)
+	backoff := cfg.RetryBackoff.Duration
+	for attempt := 0; ; attempt++ {
+		if err := waitForBudget(ctx, profile, request); err != nil {
+			return openai.ChatCompletionResponse{}, request.Model, err
+		}
+		// Each attempt takes its own slot so backoff does not hold one
+		release, err := upstreamSlots.acquire(ctx)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, request.Model, err
+		}
+		if ok, retryAfter := upstreamBreaker.allow(); !ok {
+			release()
+			return openai.ChatCompletionResponse{}, request.Model, newAPIError(http.StatusServiceUnavailable, "OpenAI is currently unavailable, try again later").
+				withCode("upstream_unavailable").
+				withRetryAfter(retryAfter)
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, cfg.UpstreamTimeout.Duration)
+		resp, err := client.CreateChatCompletion(attemptCtx, request)
+		cancel()
+		release()
+		upstreamBreaker.record(err == nil || !isUpstreamFailure(err))
+		if err == nil {
+			return resp, request.Model, nil
+		}
+		if len(fallbacks) > 0 && isUpstreamFailure(err) && ctx.Err() == nil {
+			log.Printf("OpenAI call to %s failed, falling back to %s: %v", request.Model, fallbacks[0], err)
+			incCounter("upstream_fallbacks_total", "model", fallbacks[0], 1)
+			request.Model, fallbacks = fallbacks[0], fallbacks[1:]
+			attempt, backoff = -1, cfg.RetryBackoff.Duration
+			continue
+		}
+		if attempt >= cfg.MaxRetries || !isUpstreamFailure(err) || ctx.Err() != nil {
+			return resp, request.Model, newAPIError(http.StatusInternalServerError, "OpenAI API error: %v", err)
+		}
+
+		wait := backoff
+		if advised, ok := budgetFor(profile).retryAfter(); ok && isRateLimited(err) && upstreamKeyFrom(ctx) == "" {
+			wait = min(advised, cfg.RateLimitMaxWait.Duration)
+			incCounter("upstream_ratelimit_delays_total", "reason", "retry_after", 1)
+			incCounter("upstream_ratelimit_delay_seconds_total", "", "", wait.Seconds())
+		} else {
+			backoff *= 2
+		}
+		log.Printf("OpenAI call failed (attempt %d of %d), retrying in %s: %v", attempt+1, cfg.MaxRetries+1, wait, err)
+		incCounter("upstream_retries_total", "", "", 1)
+		if sleepContext(ctx, wait) != nil {
+			return resp, request.Model, newAPIError(http.StatusInternalServerError, "OpenAI API error: %v", err)
+		}
+	}
+}
+
+// fallbackModels returns the models to try, in order, when model is
+// unavailable
+func fallbackModels(model string) []string {
+	var fallbacks []string
+	for _, m := range cfg.ModelFallbacks {
+		if m != model && !slices.Contains(fallbacks, m) {
+			fallbacks = append(fallbacks, m)
+		}
+	}
+	return fallbacks
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestEmptyGeneration(t *testing.T) {
+	long := `{"frames": [], "note": "` + strings.Repeat("x", 300) + `"}`
+	for name, content := range map[string]string{
+		"empty frames":   `{"frames": []}`,
+		"missing frames": `{}`,
+		"empty objects":  `{"frames": [{}, {}, {}]}`,
+		"long content":   long,
+	} {
+		t.Run(name, func(t *testing.T) {
+			fake := setupServer(t, nil)
+			fake.respond = func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+				return contentResponse(content), nil
+			}
+			payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 3}}
+			rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+			if rec.Code != http.StatusBadGateway {
+				t.Fatalf("status %d, want 502", rec.Code)
+			}
+			body := decodeBody[struct {
+				Error struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+					Details struct {
+						ContentSnippet string `json:"content_snippet"`
+					} `json:"details"`
+				} `json:"error"`
+			}](t, rec)
+			if body.Error.Code != "empty_generation" || body.Error.Message == "" {
+				t.Errorf("error %+v, want code empty_generation with a message", body.Error)
+			}
+			// The snippet quotes what the model said, cut to 200 characters
+			want := content
+			if len(content) > 200 {
+				want = content[:200] + "..."
+			}
+			if body.Error.Details.ContentSnippet != want {
+				t.Errorf("content_snippet %q, want %q", body.Error.Details.ContentSnippet, want)
+			}
+		})
+	}
+}
+
+// riseContent is model output moving every point up by step per frame, plus
+// jitter that alternates in sign from frame to frame
+func riseContent(input modelInput, step, jitter float64) string {
+	out := OpenAIResponse{Frames: make([]map[string]Position, input.Length)}
+	for f := range out.Frames {
+		out.Frames[f] = make(map[string]Position)
+		offset := step*float64(f) + jitter*float64(1-2*(f%2))
+		for _, cp := range input.ControlPoints {
+			out.Frames[f][strconv.Itoa(cp.ID)] = Position{X: cp.Position[0], Y: cp.Position[1] + offset, Z: cp.Position[2]}
+		}
+	}
+	content, _ := json.Marshal(out)
+	return string(content)
+}
+
+func TestSmoothestCandidateWins(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		resp := contentResponse(riseContent(input, 0.01, 0.05))
+		for _, content := range []string{"not json", riseContent(input, 0.02, 0), riseContent(input, 0.01, 0.02)} {
+			resp.Choices = append(resp.Choices, openai.ChatCompletionChoice{Index: len(resp.Choices), Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content}})
+		}
+		return resp, nil
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "rise", Length: 6, Candidates: 4}}
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if n := fake.requests[0].N; n != 4 {
+		t.Errorf("model asked for %d choices, want 4", n)
+	}
+	// The jitter-free candidate wins over the jittery ones and the one that
+	// does not parse
+	frames := decodeBody[ResponsePayload](t, rec)
+	for f, frame := range frames {
+		if d := frame[0].DeltaY; math.Abs(d-0.02*float64(f)) > 1e-9 {
+			t.Fatalf("frame %d delta_y %v, want the smooth candidate's %v", f, d, 0.02*float64(f))
+		}
+	}
+}
+
+func TestSelectCandidate(t *testing.T) {
+	choice := func(content string) openai.ChatCompletionChoice {
+		return openai.ChatCompletionChoice{Message: openai.ChatCompletionMessage{Content: content}}
+	}
+	input := modelInput{ControlPoints: testRig()[:2], Length: 6}
+	// Point 0 jitters in one candidate and point 1 in the other, so the
+	// weights decide between them
+	jitterOn := func(id int) string {
+		var out OpenAIResponse
+		if err := json.Unmarshal([]byte(riseContent(input, 0, 0)), &out); err != nil {
+			t.Fatal(err)
+		}
+		for f, frame := range out.Frames {
+			p := frame[strconv.Itoa(id)]
+			p.Y += 0.05 * float64(1-2*(f%2))
+			frame[strconv.Itoa(id)] = p
+		}
+		content, _ := json.Marshal(out)
+		return string(content)
+	}
+	choices := []openai.ChatCompletionChoice{choice(jitterOn(0)), choice(jitterOn(1))}
+	for heavy, want := range map[int]int{0: 1, 1: 0} {
+		_, picked, err := selectCandidate(choices, map[int]float64{heavy: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if picked.Message.Content != choices[want].Message.Content {
+			t.Errorf("weighting point %d picked the candidate jittering it", heavy)
+		}
+	}
+
+	// With nothing usable the first choice's error is returned
+	_, _, err := selectCandidate([]openai.ChatCompletionChoice{choice(`{"frames": []}`), choice("not json")}, nil)
+	if apiErr, ok := err.(*apiError); !ok || apiErr.Code != "empty_generation" {
+		t.Errorf("error %v, want the first choice's empty_generation", err)
+	}
+}
+
+func TestInputPrecision(t *testing.T) {
+	rig := testRig()
+	rig[2].Position = []float64{-0.6, 1.204, 0.123456789}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "wave", Length: 4}}
+	for _, tc := range []struct {
+		precision int
+		want      string
+	}{
+		{2, `[-0.6,1.2,0.12]`},
+		{4, `[-0.6,1.204,0.1235]`},
+		{-1, `[-0.6,1.204,0.123456789]`},
+	} {
+		t.Run(strconv.Itoa(tc.precision), func(t *testing.T) {
+			fake := setupServer(t, func(c *Config) { c.InputPrecision = tc.precision })
+			if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			messages := fake.requests[0].Messages
+			if user := messages[len(messages)-1].Content; !strings.Contains(user, `"position":`+tc.want) {
+				t.Errorf("model was sent %s, want the right hand at %s", user, tc.want)
+			}
+		})
+	}
+}
+
+func TestDeterministicParsingAndScoring(t *testing.T) {
+	// "07" and "7" name the same point; sorted keys make "7" win every time
+	for range 50 {
+		frames, err := parseModelContent(`{"frames": [{"07": {"x": 1, "y": 0, "z": 0}, "7": {"x": 2, "y": 0, "z": 0}}]}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := frames[0][7].X; got != 2 {
+			t.Fatalf("point 7 parsed to x=%v, want 2 from the later key", got)
+		}
+	}
+
+	// Enough points with awkward values that summing in map order would
+	// round differently from run to run
+	var points []ControlPoint
+	frames := make([]map[int]Position, 8)
+	for f := range frames {
+		frames[f] = map[int]Position{}
+	}
+	for id := range 64 {
+		base := 1 / float64(id+3)
+		points = append(points, ControlPoint{ID: id, Position: []float64{base, 0, 0}})
+		for f := range frames {
+			frames[f][id] = Position{X: base * math.Pow(1.1, float64(f*f)), Y: math.Sin(float64(id * f)), Z: 1e-9 * float64(id)}
+		}
+	}
+	jerk, motion := motionJerk(frames, nil), totalMotion(frames, points)
+	for range 50 {
+		if got := motionJerk(frames, nil); got != jerk {
+			t.Fatalf("motionJerk returned %v then %v", jerk, got)
+		}
+		if got := totalMotion(frames, points); got != motion {
+			t.Fatalf("totalMotion returned %v then %v", motion, got)
+		}
+	}
+}
+
+func TestMaxControlPoints(t *testing.T) {
+	fake := setupServer(t, func(c *Config) { c.MaxControlPoints = 4 })
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 3}}
+
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", rec.Code)
+	}
+	body := decodeBody[errorResponse](t, rec)
+	details, _ := body.Error.Details.(map[string]any)
+	if body.Error.Code != "too_many_control_points" || details["limit"] != 4.0 || details["received"] != 5.0 {
+		t.Errorf("error %+v, want too_many_control_points with limit 4 and received 5", body.Error)
+	}
+
+	// Jobs fail with the same code
+	id := decodeBody[Job](t, serve(t, http.MethodPost, "/jobs", payload, nil)).ID
+	if job := decodeBody[Job](t, serve(t, http.MethodGet, "/jobs/"+id+"?wait=5", nil, nil)); job.Status != jobFailed || job.ErrorCode != "too_many_control_points" {
+		t.Errorf("job %+v, want failed with too_many_control_points", job)
+	}
+	if calls := fake.calls(); calls != 0 {
+		t.Errorf("%d upstream calls for rejected requests", calls)
+	}
+
+	payload.ControlPoints = payload.ControlPoints[:4]
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+		t.Errorf("at the limit: status %d: %s", rec.Code, rec.Body)
+	}
+}
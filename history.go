@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const generationCollection = "generations"
+
+// A successful generation, kept so it can be fetched and post-processed
+// again without calling the model
+type GenerationRecord struct {
+	Hash     string    `json:"hash"`
+	StoredAt time.Time `json:"stored_at"`
+	// The request as the client sent it, with rig references resolved
+	Request RequestPayload `json:"request"`
+	Model   ModelParams    `json:"model"`
+	// Original ID → compact ID the model saw
+	IDMap  map[int]int `json:"id_map"`
+	Output ModelOutput `json:"output"`
+	// Frames and warnings returned to the client
+	Frames   ResponsePayload `json:"frames"`
+	Warnings []string        `json:"warnings"`
+}
+
+// Upstream parameters of a stored generation
+type ModelParams struct {
+	Model      string `json:"model"`
+	Profile    string `json:"profile,omitempty"`
+	Candidates int    `json:"candidates,omitempty"`
+	// Model calls the rig was split into
+	Batches int `json:"batches"`
+	// Model that answered, when a fallback stood in for Model
+	ServedModel string `json:"served_model,omitempty"`
+}
+
+// What the model returned, before any post-processing
+type ModelOutput struct {
+	// Raw completion content, one entry per upstream call
+	Content []string `json:"content"`
+	// Parsed positions in compact IDs, with batches merged
+	Frames         []map[int]Position `json:"frames"`
+	AffectedPoints []int              `json:"affected_points,omitempty"`
+	Confidence     map[int]float64    `json:"confidence,omitempty"`
+	Annotated      bool               `json:"annotated,omitempty"`
+}
+
+func (o ModelOutput) call() *modelCall {
+	return &modelCall{
+		Frames:  o.Frames,
+		Content: o.Content,
+		Annotations: modelAnnotations{
+			AffectedPoints: o.AffectedPoints,
+			Confidence:     o.Confidence,
+			present:        o.Annotated,
+		},
+	}
+}
+
+var validGenerationHash = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// Set while a retention sweep is running
+var historyPruning atomic.Bool
+
+// recordGeneration stores a successful generation and returns its hash.
+// Storage is best effort: failures are logged and counted, and the hash is
+// only returned when the record was written.
+func recordGeneration(request, payload RequestPayload, batches int, idMap map[int]int, call *modelCall, frames ResponsePayload, warnings []string) string {
+	record := GenerationRecord{
+		Request: request,
+		Model:   ModelParams{Model: payload.Model, Profile: payload.Profile, Candidates: payload.Candidates, Batches: batches},
+		IDMap:   idMap,
+		Output: ModelOutput{
+			Content:        call.Content,
+			Frames:         call.Frames,
+			AffectedPoints: call.Annotations.AffectedPoints,
+			Confidence:     call.Annotations.Confidence,
+			Annotated:      call.Annotations.present,
+		},
+		Frames:   frames,
+		Warnings: warnings,
+	}
+	if call.ParsedFrames != nil {
+		record.Output.Frames = call.ParsedFrames
+	}
+	if call.Model != payload.Model {
+		record.Model.ServedModel = call.Model
+	}
+
+	// Identical requests answered identically share a hash
+	raw, err := json.Marshal([]any{record.Request, record.Model, record.Output})
+	if err != nil {
+		log.Printf("Failed to hash generation: %v", err)
+		incCounter("generation_history_write_failures_total", "", "", 1)
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	record.Hash = hex.EncodeToString(sum[:16])
+	record.StoredAt = time.Now()
+
+	if err := store.Put(generationCollection, record.Hash, record); err != nil {
+		log.Printf("Failed to store generation %s: %v", record.Hash, err)
+		incCounter("generation_history_write_failures_total", "", "", 1)
+		return ""
+	}
+	if historyPruning.CompareAndSwap(false, true) {
+		go func() {
+			defer historyPruning.Store(false)
+			if n := pruneHistory(time.Now()); n > 0 {
+				log.Printf("Removed %d generations from history", n)
+			}
+		}()
+	}
+	return record.Hash
+}
+
+// pruneHistory removes generations older than history_max_age, then the
+// oldest ones beyond history_max_entries. A zero limit is not enforced.
+func pruneHistory(now time.Time) int {
+	if cfg.HistoryMaxAge.Duration == 0 && cfg.HistoryMaxEntries == 0 {
+		return 0
+	}
+	keys, err := store.List(generationCollection)
+	if err != nil {
+		log.Printf("Failed to list generations: %v", err)
+		return 0
+	}
+
+	type entry struct {
+		hash     string
+		storedAt time.Time
+	}
+	var kept []entry
+	removed := 0
+	remove := func(hash string) {
+		if err := store.Delete(generationCollection, hash); err != nil {
+			log.Printf("Failed to remove generation %s: %v", hash, err)
+			return
+		}
+		removed++
+	}
+	for _, hash := range keys {
+		var record GenerationRecord
+		found, err := store.Get(generationCollection, hash, &record)
+		if err != nil || !found {
+			continue
+		}
+		if cfg.HistoryMaxAge.Duration > 0 && now.Sub(record.StoredAt) > cfg.HistoryMaxAge.Duration {
+			remove(hash)
+			continue
+		}
+		kept = append(kept, entry{hash, record.StoredAt})
+	}
+
+	if cfg.HistoryMaxEntries > 0 && len(kept) > cfg.HistoryMaxEntries {
+		slices.SortFunc(kept, func(a, b entry) int { return a.storedAt.Compare(b.storedAt) })
+		for _, e := range kept[:len(kept)-cfg.HistoryMaxEntries] {
+			remove(e.hash)
+		}
+	}
+	return removed
+}
+
+// loadGeneration fetches a stored generation, writing the error response
+// when it cannot
+func loadGeneration(w http.ResponseWriter, hash string) (*GenerationRecord, bool) {
+	if !validGenerationHash.MatchString(hash) {
+		writeError(w, newAPIError(http.StatusNotFound, "Generation not found"))
+		return nil, false
+	}
+	var record GenerationRecord
+	found, err := store.Get(generationCollection, hash, &record)
+	if err != nil {
+		log.Printf("Failed to load generation %s: %v", hash, err)
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to load generation"))
+		return nil, false
+	}
+	if !found {
+		writeError(w, newAPIError(http.StatusNotFound, "Generation not found"))
+		return nil, false
+	}
+	return &record, true
+}
+
+// Handler for the /generations/{hash} endpoint
+func getGeneration(w http.ResponseWriter, r *http.Request) {
+	record, ok := loadGeneration(w, r.PathValue("hash"))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(record); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
+
+// Response of /generations/{hash}/replay
+type ReplayResponse struct {
+	Hash   string          `json:"hash"`
+	Frames any             `json:"frames"`
+	Root   []RootTransform `json:"root,omitempty"`
+	// With limits of the request's own, how the stored output measures up
+	// to them
+	ConstraintReport *constraintReport `json:"constraint_report,omitempty"`
+	Warnings         []string          `json:"warnings"`
+}
+
+// Handler for the /generations/{hash}/replay endpoint. The body holds
+// post-processing options that replace the stored ones; the stored model
+// output is run through post-processing again without an upstream call.
+func replayGeneration(w http.ResponseWriter, r *http.Request) {
+	record, ok := loadGeneration(w, r.PathValue("hash"))
+	if !ok {
+		return
+	}
+
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(r.Body); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Failed to read request body"))
+		return
+	}
+	payload := record.Request
+	if len(bytes.TrimSpace(body.Bytes())) > 0 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body.Bytes(), &fields); err != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+			return
+		}
+		var overrides RequestPayload
+		if err := json.Unmarshal(body.Bytes(), &overrides); err != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+			return
+		}
+		// Present fields replace the stored values rather than merging into them
+		for name := range fields {
+			switch name {
+			case "constraints":
+				payload.Constraints = overrides.Constraints
+			case "neighbors":
+				payload.Neighbors = overrides.Neighbors
+			case "neighbor_rigidity":
+				payload.NeighborRigidity = overrides.NeighborRigidity
+			case "easing":
+				payload.Easing = overrides.Easing
+			case "freeze_axes":
+				payload.FreezeAxes = overrides.FreezeAxes
+			case "jiggle":
+				payload.Jiggle = overrides.Jiggle
+			case "exaggerate":
+				payload.Exaggerate = overrides.Exaggerate
+			case "exaggerate_roles":
+				payload.ExaggerateRoles = overrides.ExaggerateRoles
+			case "stabilize_com":
+				payload.StabilizeCOM = overrides.StabilizeCOM
+			case "com_points":
+				payload.COMPoints = overrides.COMPoints
+			case "on_corrupt":
+				payload.OnCorrupt = overrides.OnCorrupt
+			case "max_range":
+				payload.MaxRange = overrides.MaxRange
+			case "holds":
+				payload.Holds = overrides.Holds
+			case "start_at_rest":
+				payload.StartAtRest = overrides.StartAtRest
+			case "pipeline":
+				payload.Pipeline = overrides.Pipeline
+			case "postprocess_preset":
+				payload.PostprocessPreset = overrides.PostprocessPreset
+			case "unchanged_points":
+				payload.UnchangedPoints = overrides.UnchangedPoints
+			case "root_motion":
+				payload.RootMotion = overrides.RootMotion
+			case "root_yaw":
+				payload.RootYaw = overrides.RootYaw
+			case "auxiliary_handling":
+				payload.AuxiliaryHandling = overrides.AuxiliaryHandling
+			default:
+				writeError(w, newAPIError(http.StatusBadRequest, "%q cannot be changed on replay, only constraints, neighbors, neighbor_rigidity, easing, freeze_axes, jiggle, exaggerate, exaggerate_roles, stabilize_com, com_points, on_corrupt, max_range, holds, start_at_rest, pipeline, postprocess_preset, unchanged_points, root_motion, root_yaw and auxiliary_handling", name))
+				return
+			}
+		}
+	}
+
+	if err := validatePayload(&payload); err != nil {
+		writeError(w, err)
+		return
+	}
+	points := preparePoints(&payload)
+	if !maps.Equal(points.idMap, record.IDMap) {
+		writeError(w, newAPIError(http.StatusConflict, "Control point IDs are no longer remapped the way they were for this generation (remap_order or disable_id_remap changed)"))
+		return
+	}
+	frames, _, report, warnings, err := postprocess(payload, points, record.Output.call())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	frames, _, restWarnings := applyStartAtRest(frames, payload, points)
+	warnings = append(warnings, restWarnings...)
+	frames, root := applyRootMotion(frames, payload, points)
+
+	result := &generationResult{Frames: frames, Positions: points.positions}
+	response := ReplayResponse{
+		Hash:             record.Hash,
+		Root:             root,
+		Frames:           rebaseFrameKeys(renderFrames(result, payload), payload.IndexBase),
+		ConstraintReport: report,
+		Warnings:         append([]string{}, warnings...),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestGenerationHistory(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "raise the right hand", Length: 4}}
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	generated := decodeBody[struct {
+		Frames ResponsePayload `json:"frames"`
+		Meta   generationMeta  `json:"meta"`
+	}](t, rec)
+	hash := generated.Meta.GenerationHash
+	if !validGenerationHash.MatchString(hash) {
+		t.Fatalf("generation_hash %q, want 32 hex digits", hash)
+	}
+
+	t.Run("fetch", func(t *testing.T) {
+		rec := serve(t, http.MethodGet, "/generations/"+hash, nil, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		record := decodeBody[GenerationRecord](t, rec)
+		if record.Hash != hash || record.Request.Prompt != payload.Prompt || record.Model.Model != cfg.DefaultModel {
+			t.Errorf("record hash %q, prompt %q, model %q; want the request's", record.Hash, record.Request.Prompt, record.Model.Model)
+		}
+		if len(record.Output.Content) != 1 || len(record.Output.Frames) != 4 {
+			t.Errorf("stored %d completions and %d model frames, want 1 and 4", len(record.Output.Content), len(record.Output.Frames))
+		}
+		if !reflect.DeepEqual(record.Frames, generated.Frames) {
+			t.Errorf("stored frames %v, want the response's %v", record.Frames, generated.Frames)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		replay := func(body any) ResponsePayload {
+			t.Helper()
+			rec := serve(t, http.MethodPost, "/generations/"+hash+"/replay", body, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			return decodeBody[struct {
+				Frames ResponsePayload `json:"frames"`
+			}](t, rec).Frames
+		}
+
+		// Without options the stored output post-processes as before
+		if got := replay(nil); !reflect.DeepEqual(got, generated.Frames) {
+			t.Errorf("replayed frames %v, want %v", got, generated.Frames)
+		}
+		// New options apply to the stored output
+		clamped := replay(map[string]any{"constraints": map[string]any{"motion_budgets": map[string]float64{"2": 0.05}}})
+		if got := clamped[3][2].DeltaY; got != 0.05 {
+			t.Errorf("right hand rose %v on replay, want it clamped to 0.05", got)
+		}
+		if got := fake.calls(); got != 1 {
+			t.Errorf("upstream called %d times, want replays to reuse the stored output", got)
+		}
+
+		rec := serve(t, http.MethodPost, "/generations/"+hash+"/replay", map[string]any{"prompt": "bow"}, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("replay changing the prompt: status %d, want 400", rec.Code)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		for _, tc := range []struct{ method, path string }{
+			{http.MethodGet, "/generations/0123456789abcdef0123456789abcdef"},
+			{http.MethodGet, "/generations/not-a-hash"},
+			{http.MethodPost, "/generations/0123456789abcdef0123456789abcdef/replay"},
+		} {
+			if rec := serve(t, tc.method, tc.path, nil, nil); rec.Code != http.StatusNotFound {
+				t.Errorf("%s %s: status %d, want 404", tc.method, tc.path, rec.Code)
+			}
+		}
+	})
+}
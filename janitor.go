@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A store the janitor keeps in check: sweep evicts expired entries and
+// returns how many, size counts what is left
+type janitorTask struct {
+	name  string
+	sweep func(now time.Time) int
+	size  func() int
+}
+
+// janitor periodically evicts expired entries from the in-memory stores in
+// one background goroutine, so long-running servers do not grow without bound
+type janitor struct {
+	interval time.Duration
+	tasks    []janitorTask
+
+	started  atomic.Bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newJanitor(interval time.Duration, tasks ...janitorTask) *janitor {
+	return &janitor{interval: interval, tasks: tasks, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// The stores are looked up on every run, as applyConfig may replace them
+var storeJanitor = newJanitor(cfg.JanitorInterval.Duration,
+	janitorTask{"cache", func(now time.Time) int { return responses.sweep(now) }, func() int { return responses.size() }},
+	janitorTask{"jobs", func(now time.Time) int { return jobs.cleanup(now) }, func() int { return jobs.size() }},
+)
+
+// run sweeps every store once
+func (j *janitor) run(now time.Time) {
+	for _, task := range j.tasks {
+		if n := task.sweep(now); n > 0 {
+			incCounter("janitor_evictions_total", "store", task.name, float64(n))
+			log.Printf("Janitor evicted %d expired %s entries", n, task.name)
+		}
+	}
+	incCounter("janitor_runs_total", "", "", 1)
+}
+
+// start runs the janitor every interval until shutdown, and exposes each
+// store's size as a <name>_entries gauge
+func (j *janitor) start() {
+	j.started.Store(true)
+	for _, task := range j.tasks {
+		registerGauge(task.name+"_entries", func() float64 { return float64(task.size()) })
+	}
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				j.run(now)
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// shutdown stops the janitor and waits for a run in progress to finish
+func (j *janitor) shutdown() {
+	if !j.started.Load() {
+		return
+	}
+	j.stopOnce.Do(func() { close(j.stop) })
+	<-j.done
+}
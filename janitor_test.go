@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestJanitorEvictsExpiredEntries(t *testing.T) {
+	setupServer(t, func(c *Config) {
+		c.CacheTTL = Duration{time.Minute}
+		c.CacheMaxStale = Duration{time.Minute}
+		c.JobTTL = Duration{time.Hour}
+	})
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	finished := jobs.create("")
+	jobs.update(finished.ID, func(j *Job) { j.Status = jobDone })
+	pending := jobs.create("")
+
+	// Nothing has expired yet
+	storeJanitor.run(time.Now())
+	if n := responses.size(); n != 1 {
+		t.Fatalf("cache holds %d entries before expiry, want 1", n)
+	}
+	if _, ok := jobs.get(finished.ID); !ok {
+		t.Fatal("finished job evicted before its TTL")
+	}
+
+	// Past the TTL plus the stale window the cache entry goes, and so does
+	// the finished job once the job TTL has passed; pending jobs stay
+	storeJanitor.run(time.Now().Add(2 * time.Hour))
+	if n := responses.size(); n != 0 {
+		t.Errorf("cache holds %d entries after expiry, want 0", n)
+	}
+	if _, ok := jobs.get(finished.ID); ok {
+		t.Error("finished job survived past its TTL")
+	}
+	if _, ok := jobs.get(pending.ID); !ok {
+		t.Error("pending job was evicted")
+	}
+}
+
+func TestJanitorStartShutdown(t *testing.T) {
+	var runs atomic.Int32
+	j := newJanitor(time.Millisecond, janitorTask{"test", func(time.Time) int { runs.Add(1); return 0 }, func() int { return 0 }})
+	// Shutting down a janitor that never started returns at once
+	j.shutdown()
+
+	j = newJanitor(time.Millisecond, janitorTask{"test", func(time.Time) int { runs.Add(1); return 0 }, func() int { return 0 }})
+	j.start()
+	deadline := time.Now().Add(time.Second)
+	for runs.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	j.shutdown()
+	if runs.Load() == 0 {
+		t.Fatal("janitor never ran")
+	}
+	after := runs.Load()
+	time.Sleep(10 * time.Millisecond)
+	if runs.Load() != after {
+		t.Error("janitor kept running after shutdown")
+	}
+	// A second shutdown is harmless
+	j.shutdown()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultJiggleStiffness = 150
+	defaultJiggleDamping   = 8
+	maxJiggleStiffness     = 10000
+	maxJiggleDamping       = 1000
+	// Longest integration step; frames are split into equal substeps no
+	// longer than this, which keeps the integrator stable up to the maximums
+	maxSpringStep = 0.001
+)
+
+func validateJiggle(opts *JiggleOptions, points []ControlPoint) error {
+	if opts == nil {
+		return nil
+	}
+	if len(opts.Points) == 0 && len(opts.Roles) == 0 {
+		return fmt.Errorf("jiggle needs points or roles")
+	}
+	for _, id := range opts.Points {
+		if !slices.ContainsFunc(points, func(cp ControlPoint) bool { return cp.ID == id }) {
+			return fmt.Errorf("jiggle point %d is not a control point", id)
+		}
+	}
+	if opts.Stiffness < 0 || opts.Stiffness > maxJiggleStiffness {
+		return fmt.Errorf("jiggle.stiffness must be between 0 and %d", maxJiggleStiffness)
+	}
+	if opts.Damping < 0 || opts.Damping > maxJiggleDamping {
+		return fmt.Errorf("jiggle.damping must be between 0 and %d", maxJiggleDamping)
+	}
+	if opts.SettleFrames < 0 {
+		return fmt.Errorf("jiggle.settle_frames must not be negative")
+	}
+	return nil
+}
+
+// jiggleDefaults fills in the parameters the request left out
+func jiggleDefaults(o JiggleOptions, fps float64) JiggleOptions {
+	if o.Stiffness == 0 {
+		o.Stiffness = defaultJiggleStiffness
+	}
+	if o.Damping == 0 {
+		o.Damping = defaultJiggleDamping
+	}
+	if o.SettleFrames == 0 {
+		o.SettleFrames = max(1, int(math.Round(fps/4)))
+	}
+	return o
+}
+
+// jigglePoints returns the sorted IDs the options select, by ID or role
+func jigglePoints(opts JiggleOptions, roles map[int]string) []int {
+	selected := make(map[int]bool)
+	for _, id := range opts.Points {
+		selected[id] = true
+	}
+	for id, role := range roles {
+		if slices.ContainsFunc(opts.Roles, func(r string) bool { return strings.EqualFold(strings.TrimSpace(r), role) }) {
+			selected[id] = true
+		}
+	}
+	return sortedKeys(selected)
+}
+
+// simulateSpring drives a unit mass on a spring and damper with target, one
+// value per frame dt seconds apart, and returns the mass's position at every
+// frame. It starts at rest on the first target, and each target is held for
+// the frame leading up to it. Loops run one cycle first so the returned
+// cycle starts in the state the previous one ends in.
+func simulateSpring(target []float64, stiffness, damping, dt float64, loop bool) []float64 {
+	result := make([]float64, len(target))
+	if len(target) == 0 {
+		return result
+	}
+	steps := int(math.Ceil(dt / maxSpringStep))
+	h := dt / float64(steps)
+	x, v := target[0], 0.0
+	advance := func(goal float64) {
+		// Semi-implicit Euler: deterministic and stable for h·√stiffness < 2
+		for range steps {
+			v += (stiffness*(goal-x) - damping*v) * h
+			x += v * h
+		}
+	}
+	if loop {
+		for i := 1; i <= len(target); i++ {
+			advance(target[i%len(target)])
+		}
+	}
+	result[0] = x
+	for i := 1; i < len(target); i++ {
+		advance(target[i])
+		result[i] = x
+	}
+	return result
+}
+
+// applyJiggle replaces the selected points' deltas with their simulated
+// secondary motion. Non-looping clips blend back onto the generated motion
+// over the last settle frames so the clip ends where the model put it. The
+// overshoot is held to the points' motion budgets, measured from the pose the
+// model started from (rest moved by offsets); the IDs of points that hit them
+// are returned.
+func applyJiggle(frames ResponsePayload, opts *JiggleOptions, roles map[int]string, budgets map[int]float64, offsets map[int]Deformation, fps float64, loop bool) (ResponsePayload, []int) {
+	if opts == nil || len(frames) < 2 {
+		return frames, nil
+	}
+	o := jiggleDefaults(*opts, fps)
+	ids := jigglePoints(o, roles)
+	settle := min(o.SettleFrames, len(frames)-1)
+
+	result := copyFrames(frames)
+	for _, id := range ids {
+		// Generated trajectory, holding the last delta over frames without one
+		var axes [3][]float64
+		last := Deformation{}
+		for _, frame := range frames {
+			if d, ok := frame[id]; ok {
+				last = d
+			}
+			axes[0] = append(axes[0], last.DeltaX)
+			axes[1] = append(axes[1], last.DeltaY)
+			axes[2] = append(axes[2], last.DeltaZ)
+		}
+		var simulated [3][]float64
+		for k, target := range axes {
+			simulated[k] = simulateSpring(target, o.Stiffness, o.Damping, 1/fps, loop)
+			if !loop {
+				for i := len(target) - settle; i < len(target); i++ {
+					w := easingCurve("sine", float64(i-(len(target)-1-settle))/float64(settle))
+					simulated[k][i] += (target[i] - simulated[k][i]) * w
+				}
+			}
+		}
+		for i, frame := range result {
+			if _, ok := frame[id]; ok {
+				frame[id] = Deformation{DeltaX: simulated[0][i], DeltaY: simulated[1][i], DeltaZ: simulated[2][i]}
+			}
+		}
+	}
+
+	jiggleBudgets := make(map[int]float64, len(ids))
+	jiggleOffsets := make(map[int]Deformation, len(ids))
+	for _, id := range ids {
+		if budget, ok := budgets[id]; ok {
+			jiggleBudgets[id] = budget
+		}
+		if o, ok := offsets[id]; ok {
+			jiggleOffsets[id] = o
+		}
+	}
+	result, clamped := clampMotion(shiftFrames(result, jiggleOffsets, -1), jiggleBudgets)
+	return shiftFrames(result, jiggleOffsets, 1), clamped
+}
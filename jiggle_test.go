@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// stepTarget rests at 0 for one frame, then jumps to 1 and stays there
+func stepTarget(n int) []float64 {
+	target := make([]float64, n)
+	for i := 1; i < n; i++ {
+		target[i] = 1
+	}
+	return target
+}
+
+func TestSimulateSpring(t *testing.T) {
+	const stiffness, fps = 150.0, 30.0
+	target := stepTarget(90)
+
+	underdamped := simulateSpring(target, stiffness, 4, 1/fps, false)
+	if underdamped[0] != 0 {
+		t.Errorf("spring starts at %v, want the first target 0", underdamped[0])
+	}
+	// The mass lags behind the jump, then swings past it
+	if underdamped[1] <= 0 || underdamped[1] >= 1 {
+		t.Errorf("frame 1 at %v, want between rest and the target", underdamped[1])
+	}
+	if peak := slices.Max(underdamped); peak <= 1.1 {
+		t.Errorf("underdamped spring peaks at %v, want it to overshoot", peak)
+	}
+	if end := underdamped[len(underdamped)-1]; math.Abs(end-1) > 0.01 {
+		t.Errorf("underdamped spring ends at %v, want it settled on 1", end)
+	}
+
+	// Critically damped springs approach the target without passing it
+	critical := simulateSpring(target, stiffness, 2*math.Sqrt(stiffness), 1/fps, false)
+	for i, x := range critical {
+		if x > 1+1e-9 {
+			t.Fatalf("critically damped spring overshoots to %v at frame %d", x, i)
+		}
+	}
+	if !slices.IsSorted(critical) {
+		t.Error("critically damped spring does not rise monotonically")
+	}
+
+	// The integrator is deterministic
+	if again := simulateSpring(target, stiffness, 4, 1/fps, false); !slices.Equal(again, underdamped) {
+		t.Error("simulating twice gave different results")
+	}
+}
+
+func TestApplyJiggle(t *testing.T) {
+	frames := make(ResponsePayload, 30)
+	for i := range frames {
+		step := 0.0
+		if i > 0 {
+			step = 0.1
+		}
+		frames[i] = map[int]Deformation{0: {DeltaY: step}, 1: {DeltaY: step}}
+	}
+	opts := &JiggleOptions{Points: []int{0}, Damping: 2}
+	peak := func(frames ResponsePayload, id int) float64 {
+		most := 0.0
+		for _, frame := range frames {
+			most = max(most, frame[id].DeltaY)
+		}
+		return most
+	}
+
+	free, clamped := applyJiggle(frames, opts, nil, nil, nil, 30, false)
+	if len(clamped) != 0 {
+		t.Errorf("clamped %v with no budgets", clamped)
+	}
+	if p := peak(free, 0); p <= 0.1 {
+		t.Errorf("jiggled point peaks at %v, want it to overshoot 0.1", p)
+	}
+	// Points not selected keep their generated motion
+	if p := peak(free, 1); p != 0.1 {
+		t.Errorf("unselected point peaks at %v, want 0.1", p)
+	}
+	// A clip that does not loop settles back onto the generated pose
+	if end := free[len(free)-1][0].DeltaY; math.Abs(end-0.1) > 1e-9 {
+		t.Errorf("clip ends at %v, want the generated 0.1", end)
+	}
+	if frames[1][0].DeltaY != 0.1 {
+		t.Error("applyJiggle modified its input")
+	}
+
+	// The overshoot is held to the motion budget
+	held, clamped := applyJiggle(frames, opts, nil, map[int]float64{0: 0.1, 1: 0.01}, nil, 30, false)
+	if p := peak(held, 0); p > 0.1+1e-9 {
+		t.Errorf("jiggled point peaks at %v past its 0.1 budget", p)
+	}
+	// Only jiggled points are clamped here; the other budgets are not ours
+	if !slices.Equal(clamped, []int{0}) {
+		t.Errorf("clamped %v, want [0]", clamped)
+	}
+	if p := peak(held, 1); p != 0.1 {
+		t.Errorf("unselected point clamped to %v", p)
+	}
+
+	// With a rest pose the budget is measured from where the model started,
+	// 0.5 above rest here
+	offsets := map[int]Deformation{0: {DeltaY: 0.5}}
+	raised := shiftFrames(copyFrames(frames), offsets, 1)
+	held, _ = applyJiggle(raised, opts, nil, map[int]float64{0: 0.1}, offsets, 30, false)
+	if p := peak(held, 0); p > 0.6+1e-9 {
+		t.Errorf("jiggled point peaks at %v past its 0.1 budget from 0.5", p)
+	}
+	if end := held[len(held)-1][0].DeltaY; math.Abs(end-0.6) > 1e-9 {
+		t.Errorf("clip ends at %v, want the generated 0.6 rather than pulled toward rest", end)
+	}
+}
+
+func TestValidateJiggle(t *testing.T) {
+	points := testRig()
+	for name, opts := range map[string]*JiggleOptions{
+		"nothing selected":   {},
+		"unknown point":      {Points: []int{9}},
+		"negative stiffness": {Points: []int{0}, Stiffness: -1},
+		"stiffness too high": {Points: []int{0}, Stiffness: maxJiggleStiffness + 1},
+		"damping too high":   {Points: []int{0}, Damping: maxJiggleDamping + 1},
+		"negative settle":    {Roles: []string{"head"}, SettleFrames: -1},
+	} {
+		if err := validateJiggle(opts, points); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if err := validateJiggle(&JiggleOptions{Roles: []string{"head"}}, points); err != nil {
+		t.Errorf("role selection rejected: %v", err)
+	}
+}
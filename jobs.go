@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Least time between progress writes to a job
+const jobProgressInterval = 250 * time.Millisecond
+
+// Longest /jobs/{id}?wait= holds a request, below the idle timeouts of
+// common proxies and load balancers
+const maxJobWait = 25 * time.Second
+
+type jobStatus string
+
+const (
+	jobPending jobStatus = "pending"
+	jobRunning jobStatus = "running"
+	jobDone    jobStatus = "done"
+	jobFailed  jobStatus = "failed"
+)
+
+// An asynchronous generation submitted via /jobs
+type Job struct {
+	ID     string    `json:"job_id"`
+	Status jobStatus `json:"status"`
+	Result any       `json:"result,omitempty"`
+	// Root track of a finished job with root_motion separate
+	Root      []RootTransform `json:"root,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	ErrorCode string          `json:"error_code,omitempty"`
+	// Latest progress reported by the pipeline while the job runs
+	Progress *ProgressUpdate `json:"progress,omitempty"`
+	// Delivery of the completion callback, when the job has one
+	Callback  *jobCallback `json:"callback,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+
+	callbackURL string
+}
+
+// In-memory registry of jobs; finished jobs are dropped once they are older
+// than the TTL. Jobs are only changed through update, under mu, and get
+// returns copies.
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	ttl  time.Duration
+	// Channels signalled on every update of a job, for event streams
+	watchers map[string][]chan struct{}
+	// Channel of each unfinished job, closed when it finishes
+	completions map[string]chan struct{}
+}
+
+var jobs = newJobRegistry(cfg.JobTTL.Duration)
+
+func newJobRegistry(ttl time.Duration) *jobRegistry {
+	return &jobRegistry{
+		jobs:        make(map[string]*Job),
+		ttl:         ttl,
+		watchers:    make(map[string][]chan struct{}),
+		completions: make(map[string]chan struct{}),
+	}
+}
+
+func (s *jobRegistry) setTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttl = ttl
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// create registers a pending job, which reports its outcome to callbackURL
+// when that is set
+func (s *jobRegistry) create(callbackURL string) Job {
+	now := time.Now()
+	job := &Job{ID: newJobID(), Status: jobPending, CreatedAt: now, UpdatedAt: now, callbackURL: callbackURL}
+	if callbackURL != "" {
+		job.Callback = &jobCallback{Status: "pending"}
+	}
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.completions[job.ID] = make(chan struct{})
+	s.mu.Unlock()
+	return *job
+}
+
+func (s *jobRegistry) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (s *jobRegistry) update(id string, fn func(*Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = time.Now()
+		// Wake every waiter once; later waiters find the job finished
+		if done, waiting := s.completions[id]; waiting && (job.Status == jobDone || job.Status == jobFailed) {
+			close(done)
+			delete(s.completions, id)
+		}
+	}
+	for _, ch := range s.watchers[id] {
+		// A pending signal already tells the watcher to look again
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watch returns a channel signalled whenever job id changes, and the
+// function that stops watching
+func (s *jobRegistry) watch(id string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.watchers[id] = append(s.watchers[id], ch)
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.watchers[id] = slices.DeleteFunc(s.watchers[id], func(c chan struct{}) bool { return c == ch })
+		if len(s.watchers[id]) == 0 {
+			delete(s.watchers, id)
+		}
+	}
+}
+
+// wait blocks until job id finishes, timeout lapses or ctx is done, and
+// returns the job as it then stands. Waiters share the job's completion
+// channel, so a waiter that gives up leaves nothing behind.
+func (s *jobRegistry) wait(ctx context.Context, id string, timeout time.Duration) (Job, bool) {
+	s.mu.Lock()
+	done, unfinished := s.completions[id]
+	s.mu.Unlock()
+	if unfinished {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	return s.get(id)
+}
+
+func (s *jobRegistry) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs)
+}
+
+// countByStatus returns the number of jobs in each status
+func (s *jobRegistry) countByStatus() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[string]int{
+		string(jobPending): 0,
+		string(jobRunning): 0,
+		string(jobDone):    0,
+		string(jobFailed):  0,
+	}
+	for _, job := range s.jobs {
+		counts[string(job.Status)]++
+	}
+	return counts
+}
+
+// cleanup removes finished jobs whose last update is older than the TTL
+func (s *jobRegistry) cleanup(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for id, job := range s.jobs {
+		finished := job.Status == jobDone || job.Status == jobFailed
+		if finished && now.Sub(job.UpdatedAt) > s.ttl {
+			delete(s.jobs, id)
+			removed++
+		}
+	}
+	countEvictions("jobs", removed)
+	return removed
+}
+
+// runJob performs the generation in the background and records the outcome
+func runJob(id, clientKey, upstreamKey string, payload RequestPayload) {
+	// Report the outcome to the callback once the job has finished, however
+	// it ended
+	defer func() {
+		if job, ok := jobs.get(id); ok && job.callbackURL != "" {
+			deliverCallback(background, job)
+		}
+	}()
+	// Nothing is left to recover a background job's panic, so fail the job
+	defer func() {
+		if v := recover(); v != nil {
+			reportPanic("job-"+id, http.MethodPost, "/jobs", v)
+			jobs.update(id, func(j *Job) {
+				j.Status = jobFailed
+				j.Error = "Internal server error"
+				j.ErrorCode = "internal_error"
+			})
+		}
+	}()
+	jobs.update(id, func(j *Job) { j.Status = jobRunning })
+	ctx := withUpstreamKey(withClientKey(context.Background(), clientKey), upstreamKey)
+	ctx = withRequestInfo(ctx, requestInfo{ID: "job-" + id, Method: http.MethodPost, Path: "/jobs"})
+	ctx = withProgress(ctx, throttleProgress(jobProgressInterval, func(u ProgressUpdate) {
+		jobs.update(id, func(j *Job) { j.Progress = &u })
+	}))
+	result, err := generate(ctx, payload)
+	var apiErr *apiError
+	isAPIError := errors.As(err, &apiErr)
+	jobs.update(id, func(j *Job) {
+		if err != nil {
+			j.Status = jobFailed
+			j.Error = err.Error()
+			if isAPIError {
+				j.ErrorCode = apiErr.Code
+			}
+			return
+		}
+		j.Status = jobDone
+		j.Result = encodeFrames(renderFrames(result, payload), payload)
+		j.Root = result.Root
+		// Replace rather than modify the update: event streams hold on to
+		// the last one they sent
+		if j.Progress != nil {
+			done := *j.Progress
+			done.Stage, done.ETASeconds = "done", nil
+			j.Progress = &done
+		}
+	})
+	if err != nil {
+		if !isAPIError || apiErr.Status >= 500 {
+			log.Printf("Job %s failed: %v", id, err)
+		}
+	}
+}
+
+// Body of /jobs: a generation request and where to report its outcome
+type jobSubmission struct {
+	RequestPayload
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// Handler for the /jobs endpoint
+func submitJob(w http.ResponseWriter, r *http.Request) {
+	var submission jobSubmission
+	if err := json.NewDecoder(r.Body).Decode(&submission); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	if submission.CallbackURL != "" {
+		if err := validateCallbackURL(r.Context(), submission.CallbackURL); err != nil {
+			writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+			return
+		}
+	}
+
+	job := jobs.create(submission.CallbackURL)
+	go runJob(job.ID, clientKeyFrom(r.Context()), upstreamKeyFrom(r.Context()), submission.RequestPayload)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Handler for the /jobs/{id} endpoint. With ?wait=N it holds the request
+// for up to N seconds (at most maxJobWait) until the job finishes, and
+// answers 202 with the job's status if it is still running by then.
+func getJob(w http.ResponseWriter, r *http.Request) {
+	var wait time.Duration
+	if value := r.URL.Query().Get("wait"); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < 0 || math.IsNaN(seconds) {
+			writeError(w, newAPIError(http.StatusBadRequest, "wait must be a number of seconds"))
+			return
+		}
+		wait = time.Duration(math.Min(seconds, maxJobWait.Seconds()) * float64(time.Second))
+	}
+
+	id := r.PathValue("id")
+	job, ok := jobs.get(id)
+	if ok && wait > 0 {
+		job, ok = jobs.wait(r.Context(), id, wait)
+	}
+	if !ok {
+		writeError(w, newAPIError(http.StatusNotFound, "Job not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if wait > 0 && job.Status != jobDone && job.Status != jobFailed {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
+
+// Handler for the /jobs/{id}/events endpoint. It streams the job as
+// server-sent events: progress events while it runs, then one frame event
+// per frame and a done event, or an error event if it failed.
+func jobEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := jobs.get(id); !ok {
+		writeError(w, newAPIError(http.StatusNotFound, "Job not found"))
+		return
+	}
+	changed, stop := jobs.watch(id)
+	defer stop()
+
+	// Generations outlast the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var sent *ProgressUpdate
+	for {
+		job, ok := jobs.get(id)
+		if !ok {
+			writeErrorEvent(w, newAPIError(http.StatusNotFound, "Job not found"))
+			return
+		}
+		if job.Progress != nil && (sent == nil || !sameProgress(*sent, *job.Progress)) {
+			writeEvent(w, "progress", job.Progress)
+			sent = job.Progress
+		}
+		switch job.Status {
+		case jobDone:
+			for _, frame := range jobFrameEvents(job.Result) {
+				writeEvent(w, "frame", frame)
+			}
+			writeEvent(w, "done", map[string]string{"job_id": id})
+			return
+		case jobFailed:
+			apiErr := newAPIError(http.StatusInternalServerError, "%s", job.Error)
+			if job.ErrorCode != "" {
+				apiErr = apiErr.withCode(job.ErrorCode)
+			}
+			writeErrorEvent(w, apiErr)
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func sameProgress(a, b ProgressUpdate) bool {
+	etaA, etaB := -1.0, -1.0
+	if a.ETASeconds != nil {
+		etaA = *a.ETASeconds
+	}
+	if b.ETASeconds != nil {
+		etaB = *b.ETASeconds
+	}
+	a.ETASeconds, b.ETASeconds = nil, nil
+	return a == b && etaA == etaB
+}
+
+// jobFrameEvents splits a job result into the data of its frame events.
+// Velocities are marked with their units, and the first of them also
+// carries the fps, precision and initial pose integrating them needs. Sparse
+// frames are marked likewise, the first with the epsilon and keyframe
+// interval.
+func jobFrameEvents(result any) []map[string]any {
+	var events []map[string]any
+	add := func(frame any) map[string]any {
+		event := map[string]any{"index": len(events), "deformations": frame}
+		events = append(events, event)
+		return event
+	}
+	switch r := result.(type) {
+	case ResponsePayload:
+		for _, f := range r {
+			add(f)
+		}
+	case SphericalPayload:
+		for _, f := range r {
+			add(f)
+		}
+	case ResponsePayloadSparse:
+		for i, f := range r.Frames {
+			event := add(f)
+			event["encoding"] = r.Encoding
+			if i == 0 {
+				event["epsilon"] = r.Epsilon
+				event["keyframe_interval"] = r.KeyframeInterval
+			}
+		}
+	case velocityPayload:
+		for i, f := range r.Frames {
+			event := add(f)
+			event["units"] = r.Units
+			if i == 0 {
+				event["fps"] = r.FPS
+				event["precision"] = r.Precision
+				event["initial_pose"] = r.InitialPose
+			}
+		}
+	}
+	return events
+}
+
+// writeEvent sends one server-sent event with a JSON body
+func writeEvent(w http.ResponseWriter, event string, data any) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", event, err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, raw)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestJobEventsStreamVelocities(t *testing.T) {
+	setupServer(t, nil)
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 6, FPS: 30, OutputUnits: "per_second"}}
+	rec := serve(t, http.MethodPost, "/jobs", payload, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", rec.Code, rec.Body)
+	}
+	id := decodeBody[Job](t, rec).ID
+	if job := decodeBody[Job](t, serve(t, http.MethodGet, "/jobs/"+id+"?wait=5", nil, nil)); job.Status != jobDone {
+		t.Fatalf("job %s: %s", job.Status, job.Error)
+	}
+
+	rec = serve(t, http.MethodGet, "/jobs/"+id+"/events", nil, nil)
+	velocities := velocityPayload{}
+	scanner := bufio.NewScanner(rec.Body)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || event != "frame" {
+			continue
+		}
+		var frame struct {
+			Index        int                 `json:"index"`
+			Deformations map[int]Deformation `json:"deformations"`
+			Units        string              `json:"units"`
+			FPS          float64             `json:"fps"`
+			Precision    int                 `json:"precision"`
+			InitialPose  map[int]Deformation `json:"initial_pose"`
+		}
+		if err := json.Unmarshal([]byte(data), &frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame.Index != len(velocities.Frames) || frame.Units != "per_second" {
+			t.Fatalf("frame event %s", data)
+		}
+		if frame.Index == 0 {
+			velocities.Units, velocities.FPS, velocities.Precision, velocities.InitialPose = frame.Units, frame.FPS, frame.Precision, frame.InitialPose
+		}
+		velocities.Frames = append(velocities.Frames, frame.Deformations)
+	}
+	if len(velocities.Frames) != 6 || velocities.FPS != 30 || len(velocities.InitialPose) != len(testRig()) {
+		t.Fatalf("streamed %d frames at %g fps from an initial pose of %d points", len(velocities.Frames), velocities.FPS, len(velocities.InitialPose))
+	}
+
+	// Integrated, the stream gives the same offsets as a per-frame request
+	payload.OutputUnits = ""
+	offsets := decodeBody[ResponsePayload](t, serve(t, http.MethodPost, "/generate-deformations", payload, nil))
+	if got := api.VelocitiesToOffsets(velocities); !reflect.DeepEqual(got, offsets) {
+		t.Errorf("integrated velocities\n%v\nwant\n%v", got, offsets)
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	fake := setupServer(t, nil)
+	release := make(chan struct{})
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		<-release
+		return swayResponse(req)
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+	rec := serve(t, http.MethodPost, "/jobs", payload, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("submit: status %d, want 202: %s", rec.Code, rec.Body)
+	}
+	submitted := decodeBody[Job](t, rec)
+	if submitted.Status != jobPending || rec.Header().Get("Location") != "/jobs/"+submitted.ID {
+		t.Errorf("submitted job %+v at %q, want pending at /jobs/%s", submitted, rec.Header().Get("Location"), submitted.ID)
+	}
+
+	// Until the model answers the job is pending or running, without a result
+	rec = serve(t, http.MethodGet, "/jobs/"+submitted.ID, nil, nil)
+	if job := decodeBody[Job](t, rec); rec.Code != http.StatusOK || (job.Status != jobPending && job.Status != jobRunning) || job.Result != nil {
+		t.Errorf("unfinished job: status %d, %+v", rec.Code, job)
+	}
+
+	close(release)
+	rec = serve(t, http.MethodGet, "/jobs/"+submitted.ID+"?wait=5", nil, nil)
+	var job struct {
+		Status jobStatus       `json:"status"`
+		Result ResponsePayload `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || job.Status != jobDone || len(job.Result) != 4 || len(job.Result[0]) != len(testRig()) {
+		t.Errorf("finished job: status %d, %s", rec.Code, rec.Body)
+	}
+
+	if rec := serve(t, http.MethodGet, "/jobs/unknown", nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want 404", rec.Code)
+	}
+}
+
+func TestJobFailures(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		return contentResponse(`{"frames": []}`), nil
+	}
+	for _, tc := range []struct {
+		name    string
+		payload api.RequestPayload
+		code    string
+	}{
+		{"invalid request", api.RequestPayload{Prompt: "sway gently", Length: 4}, "invalid_request"},
+		{"empty generation", api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}, "empty_generation"},
+		// Output options are checked like the rest of the request
+		{"index_base", api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4, IndexBase: 7}, "invalid_request"},
+		{"sparse spherical", api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4, Encoding: "sparse", OutputCoords: "spherical"}, "invalid_request"},
+	} {
+		rec := serve(t, http.MethodPost, "/jobs", RequestPayload{RequestPayload: tc.payload}, nil)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("%s: submit status %d, want 202", tc.name, rec.Code)
+		}
+		id := decodeBody[Job](t, rec).ID
+		job := decodeBody[Job](t, serve(t, http.MethodGet, "/jobs/"+id+"?wait=5", nil, nil))
+		if job.Status != jobFailed || job.Error == "" || job.Result != nil {
+			t.Errorf("%s: job %+v, want failed with an error and no result", tc.name, job)
+		}
+		if job.ErrorCode != tc.code {
+			t.Errorf("%s: error code %q, want %q", tc.name, job.ErrorCode, tc.code)
+		}
+	}
+
+	if rec := serve(t, http.MethodPost, "/jobs", "{not json", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed submission: status %d, want 400", rec.Code)
+	}
+}
+
+func TestJobOutputOptions(t *testing.T) {
+	setupServer(t, nil)
+	run := func(payload api.RequestPayload) (string, Job) {
+		t.Helper()
+		rec := serve(t, http.MethodPost, "/jobs", RequestPayload{RequestPayload: payload}, nil)
+		id := decodeBody[Job](t, rec).ID
+		job := decodeBody[Job](t, serve(t, http.MethodGet, "/jobs/"+id+"?wait=5", nil, nil))
+		if job.Status != jobDone {
+			t.Fatalf("job %s: %s", job.Status, job.Error)
+		}
+		return id, job
+	}
+	result := func(job Job, v any) {
+		t.Helper()
+		raw, _ := json.Marshal(job.Result)
+		if err := json.Unmarshal(raw, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, job := run(api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4, IndexBase: 1})
+	var dense ResponsePayload
+	result(job, &dense)
+	if _, ok := dense[0][0]; ok || len(dense[0]) != 5 || dense[0][5] != (Deformation{}) {
+		t.Errorf("index_base 1: first frame keyed %v, want 1 to 5", slices.Sorted(maps.Keys(dense[0])))
+	}
+
+	id, job := run(api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4, Encoding: "sparse"})
+	var sparse ResponsePayloadSparse
+	result(job, &sparse)
+	if sparse.Encoding != "sparse" || len(sparse.Frames) != 4 || len(sparse.Frames[0]) != 5 {
+		t.Errorf("encoding sparse: result %+v", sparse)
+	}
+	// The event stream sends the frames as they were encoded
+	rec := serve(t, http.MethodGet, "/jobs/"+id+"/events", nil, nil)
+	if body := rec.Body.String(); !strings.Contains(body, `"encoding":"sparse"`) || !strings.Contains(body, `"keyframe_interval":`) {
+		t.Errorf("events %s, want sparse frames", body)
+	}
+
+	// The handler checks them before serving a cached result, which is
+	// cached whatever the output options
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+	serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	payload.IndexBase = 7
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("cached request with index_base 7: status %d, want 400", rec.Code)
+	}
+}
+
+func TestJobWait(t *testing.T) {
+	fake := setupServer(t, nil)
+	release := make(chan struct{})
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		<-release
+		return swayResponse(req)
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+	id := decodeBody[Job](t, serve(t, http.MethodPost, "/jobs", payload, nil)).ID
+
+	// A job still running when the wait runs out answers 202
+	start := time.Now()
+	rec := serve(t, http.MethodGet, "/jobs/"+id+"?wait=0.1", nil, nil)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("wait=0.1 returned after %v", elapsed)
+	}
+	if job := decodeBody[Job](t, rec); rec.Code != http.StatusAccepted || job.Status == jobDone || job.Result != nil {
+		t.Errorf("timed out wait: status %d, %+v, want 202 unfinished", rec.Code, job)
+	}
+
+	// A job finishing during the wait answers as soon as it does
+	answered := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		rec := serve(t, http.MethodGet, "/jobs/"+id+"?wait=20", nil, nil)
+		if job := decodeBody[Job](t, rec); rec.Code != http.StatusOK || job.Status != jobDone {
+			t.Errorf("finished wait: status %d, %+v, want 200 done", rec.Code, job)
+		}
+		answered <- time.Since(start)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if elapsed := <-answered; elapsed > 5*time.Second {
+		t.Errorf("wait=20 returned after %v, long after the job finished", elapsed)
+	}
+
+	for _, wait := range []string{"-1", "soon", "NaN"} {
+		if rec := serve(t, http.MethodGet, "/jobs/"+id+"?wait="+wait, nil, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("wait=%s: status %d, want 400", wait, rec.Code)
+		}
+	}
+	if rec := serve(t, http.MethodGet, "/jobs/unknown?wait=1", nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want 404", rec.Code)
+	}
+}
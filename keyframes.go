@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Keyframe as presented to the model, with its frame index resolved
+type modelKeyframe struct {
+	Frame       int     `json:"frame"`
+	TimeSec     float64 `json:"time_sec"`
+	Description string  `json:"description"`
+}
+
+// timedFrameCount returns the number of frames sampled at fps over a clip of
+// the given duration, including the frame at t=duration
+func timedFrameCount(durationSec, fps float64) int {
+	return int(math.Round(durationSec*fps)) + 1
+}
+
+// resolveTiming derives the frame count from duration_sec and fps, and
+// checks and orders the keyframes
+func resolveTiming(payload *RequestPayload) error {
+	if payload.DurationSec == 0 && len(payload.Keyframes) == 0 {
+		return nil
+	}
+	if payload.DurationSec <= 0 || payload.FPS <= 0 {
+		return fmt.Errorf("keyframes require positive duration_sec and fps")
+	}
+
+	length := timedFrameCount(payload.DurationSec, payload.FPS)
+	if payload.Length != 0 && payload.Length != length {
+		return fmt.Errorf("length %d does not match duration_sec %.3g at fps %.3g (%d frames)",
+			payload.Length, payload.DurationSec, payload.FPS, length)
+	}
+	payload.Length = length
+
+	for i, kf := range payload.Keyframes {
+		if kf.TimeSec < 0 || kf.TimeSec > payload.DurationSec {
+			return fmt.Errorf("keyframe at %.3gs is outside the clip duration of %.3gs", kf.TimeSec, payload.DurationSec)
+		}
+		description, err := sanitizePrompt(kf.Description)
+		if err != nil {
+			return fmt.Errorf("keyframe at %.3gs: %v", kf.TimeSec, err)
+		}
+		payload.Keyframes[i].Description = description
+	}
+	sort.SliceStable(payload.Keyframes, func(i, j int) bool {
+		return payload.Keyframes[i].TimeSec < payload.Keyframes[j].TimeSec
+	})
+	return nil
+}
+
+// modelKeyframes resolves keyframe times to frame indices for the prompt
+func modelKeyframes(payload RequestPayload) []modelKeyframe {
+	if len(payload.Keyframes) == 0 {
+		return nil
+	}
+	result := make([]modelKeyframe, len(payload.Keyframes))
+	for i, kf := range payload.Keyframes {
+		result[i] = modelKeyframe{
+			Frame:       int(math.Round(kf.TimeSec * payload.FPS)),
+			TimeSec:     kf.TimeSec,
+			Description: kf.Description,
+		}
+	}
+	return result
+}
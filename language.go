@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Human-readable names for the languages the detector can report
+var languageNames = map[string]string{
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"de": "German",
+	"pt": "Portuguese",
+	"it": "Italian",
+	"ja": "Japanese",
+	"zh": "Chinese",
+	"ko": "Korean",
+	"ru": "Russian",
+	"ar": "Arabic",
+	"he": "Hebrew",
+	"el": "Greek",
+	"th": "Thai",
+	"hi": "Hindi",
+}
+
+// Common function words used to tell Latin-script languages apart
+var stopwords = map[string][]string{
+	"en": {"the", "and", "make", "character", "with", "his", "her", "their", "while", "to", "of", "is", "up", "down", "walk", "wave", "jump", "arms", "legs", "head"},
+	"es": {"el", "la", "los", "las", "que", "y", "con", "del", "una", "un", "haz", "hace", "personaje", "sus", "mientras", "brazo", "brazos", "mano", "saltar", "caminar"},
+	"fr": {"le", "la", "les", "et", "avec", "des", "une", "un", "fais", "faire", "personnage", "ses", "pendant", "bras", "main", "sauter", "marcher", "du", "au"},
+	"de": {"der", "die", "das", "und", "mit", "ein", "eine", "lass", "figur", "seine", "ihre", "während", "arm", "arme", "hand", "springen", "gehen", "den", "dem"},
+	"pt": {"o", "os", "as", "e", "com", "do", "da", "uma", "um", "faça", "faz", "personagem", "seus", "enquanto", "braço", "braços", "mão", "pular", "andar"},
+	"it": {"il", "lo", "gli", "e", "con", "del", "della", "una", "un", "fai", "personaggio", "suoi", "mentre", "braccio", "braccia", "mano", "saltare", "camminare"},
+}
+
+// detectLanguage guesses the language of a prompt from its script and, for
+// Latin script, from common words. It returns "" when it cannot tell.
+func detectLanguage(text string) string {
+	scripts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			scripts["ja"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["ru"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Japanese mixes kana with kanji, so any kana decides it
+	if scripts["ja"] > 0 {
+		return "ja"
+	}
+	best, bestCount := "", 0
+	for lang, n := range scripts {
+		if n > bestCount {
+			best, bestCount = lang, n
+		}
+	}
+	if bestCount*2 >= letters {
+		return best
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	best, bestScore, tied := "", 0, false
+	for lang, list := range stopwords {
+		score := 0
+		for _, w := range words {
+			for _, s := range list {
+				if w == s {
+					score++
+					break
+				}
+			}
+		}
+		switch {
+		case score > bestScore:
+			best, bestScore, tied = lang, score, false
+		case score == bestScore && score > 0:
+			tied = true
+		}
+	}
+	if bestScore == 0 || (tied && best != "en") {
+		return ""
+	}
+	return best
+}
+
+// Outcome of prompt language handling, reported in the response metadata
+type promptLanguageInfo struct {
+	Language   string `json:"language,omitempty"`
+	Mode       string `json:"mode"`
+	Original   string `json:"original"`
+	Translated string `json:"translated,omitempty"`
+	// Detailed description the prompt was expanded into
+	Expanded string `json:"expanded,omitempty"`
+}
+
+func validatePromptLanguageMode(mode string) error {
+	switch mode {
+	case "", "hint", "translate", "off":
+		return nil
+	}
+	return fmt.Errorf("invalid prompt_language_mode %q, expected hint, translate or off", mode)
+}
+
+// applyAutoTranslate turns auto_translate_prompt into the equivalent
+// language mode, rejecting it alongside a different explicit mode
+func applyAutoTranslate(payload *RequestPayload) error {
+	if !payload.AutoTranslatePrompt {
+		return nil
+	}
+	if payload.PromptLanguageMode != "" && payload.PromptLanguageMode != "translate" {
+		return fmt.Errorf("auto_translate_prompt conflicts with prompt_language_mode %q", payload.PromptLanguageMode)
+	}
+	payload.PromptLanguageMode = "translate"
+	return nil
+}
+
+// resolvePromptLanguage detects the prompt language and, for non-English
+// prompts, either translates the prompt to English or records a language
+// hint for the system prompt. Failures never fail the request: the prompt is
+// passed through unchanged with a warning.
+func resolvePromptLanguage(ctx context.Context, client chatClient, payload *RequestPayload) (*promptLanguageInfo, openai.Usage, []string) {
+	var usage openai.Usage
+	mode := payload.PromptLanguageMode
+	if mode == "" {
+		mode = "hint"
+	}
+	info := &promptLanguageInfo{Mode: mode, Original: payload.Prompt}
+	if mode == "off" {
+		return info, usage, nil
+	}
+
+	info.Language = detectLanguage(payload.Prompt)
+	if info.Language == "" || info.Language == "en" {
+		return info, usage, nil
+	}
+	name := languageNames[info.Language]
+
+	if mode == "translate" {
+		translated, u, err := translatePrompt(ctx, client, payload.Profile, payload.Prompt, name)
+		usage = u
+		if err == nil {
+			translated, err = sanitizePrompt(translated)
+		}
+		if err == nil {
+			log.Printf("Translated %s prompt %q to %q", name, payload.Prompt, translated)
+			info.Translated = translated
+			payload.Prompt = translated
+			return info, usage, nil
+		}
+		log.Printf("WARN prompt translation failed, passing through: %v", err)
+		info.Mode = "hint"
+		payload.promptLanguage = name
+		return info, usage, []string{fmt.Sprintf("Prompt translation failed, the %s prompt was passed through with a language hint", name)}
+	}
+
+	payload.promptLanguage = name
+	return info, usage, nil
+}
+
+// translatePrompt asks a small model for an English rendering of the prompt,
+// through the same retries, budget and breaker as the main call
+func translatePrompt(ctx context.Context, client chatClient, profile, prompt, language string) (string, openai.Usage, error) {
+	resp, _, err := completeWithRetry(ctx, client, profile, openai.ChatCompletionRequest{
+		Model: cfg.TranslationModel,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role: openai.ChatMessageRoleSystem,
+				Content: fmt.Sprintf("Translate the user's %s animation prompt into English. "+
+					"Keep its meaning and body-part references exact. Reply with the translation only.", language),
+			},
+			{Role: openai.ChatMessageRoleUser, Content: prompt},
+		},
+	})
+	if err != nil {
+		return "", resp.Usage, err
+	}
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		return "", resp.Usage, fmt.Errorf("empty translation")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), resp.Usage, nil
+}
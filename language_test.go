@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestTranslationRetriesTransientFailures(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.TranslationModel = "translator"
+		c.RetryBackoff = Duration{time.Millisecond}
+	})
+	translations := 0
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		if req.Model != "translator" {
+			return swayResponse(req)
+		}
+		if translations++; translations == 1 {
+			return openai.ChatCompletionResponse{}, &openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable, Message: "overloaded"}
+		}
+		return contentResponse("wave the left hand"), nil
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "помаши левой рукой", Length: 4, PromptLanguageMode: "translate"}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if translations != 2 {
+		t.Errorf("translation called %d times, want 2", translations)
+	}
+	input, err := modelInputOf(fake.requests[len(fake.requests)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input.Prompt != "wave the left hand" {
+		t.Errorf("model was sent %q, want the translation", input.Prompt)
+	}
+}
+
+func TestTranslationRespectsBreaker(t *testing.T) {
+	fake := setupServer(t, func(c *Config) { c.TranslationModel = "translator" })
+	for range cfg.BreakerThreshold {
+		upstreamBreaker.record(false)
+	}
+	if ok, _ := upstreamBreaker.allow(); ok {
+		t.Fatal("breaker did not open")
+	}
+
+	info, _, warnings := resolvePromptLanguage(t.Context(), fake, &RequestPayload{RequestPayload: api.RequestPayload{Prompt: "помаши левой рукой", PromptLanguageMode: "translate"}})
+	if got := fake.calls(); got != 0 {
+		t.Errorf("upstream called %d times while the breaker was open", got)
+	}
+	if info.Translated != "" || len(warnings) != 1 {
+		t.Errorf("translated %q with warnings %q, want a pass-through warning", info.Translated, warnings)
+	}
+}
+
+func TestAutoTranslatePrompt(t *testing.T) {
+	for _, tc := range []struct {
+		auto bool
+		mode string
+		want string
+		ok   bool
+	}{
+		{false, "", "", true},
+		{false, "hint", "hint", true},
+		{true, "", "translate", true},
+		{true, "translate", "translate", true},
+		{true, "hint", "", false},
+		{true, "off", "", false},
+	} {
+		payload := RequestPayload{RequestPayload: api.RequestPayload{AutoTranslatePrompt: tc.auto, PromptLanguageMode: tc.mode}}
+		err := applyAutoTranslate(&payload)
+		if (err == nil) != tc.ok || (tc.ok && payload.PromptLanguageMode != tc.want) {
+			t.Errorf("auto %v, mode %q: mode %q, error %v; want %q, ok %v", tc.auto, tc.mode, payload.PromptLanguageMode, err, tc.want, tc.ok)
+		}
+	}
+
+	fake := setupServer(t, func(c *Config) { c.TranslationModel = "translator" })
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		if req.Model == "translator" {
+			return contentResponse("wave the left hand"), nil
+		}
+		return swayResponse(req)
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "помаши левой рукой", Length: 4, AutoTranslatePrompt: true}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	input, err := modelInputOf(fake.requests[len(fake.requests)-1])
+	if err != nil || input.Prompt != "wave the left hand" {
+		t.Errorf("model was sent %q (%v), want the translation", input.Prompt, err)
+	}
+
+	payload.PromptLanguageMode = "hint"
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("auto_translate_prompt with hint mode: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// concurrencyLimiter caps the number of concurrent upstream calls. Callers
+// beyond the limit wait in a FIFO queue of bounded length for at most
+// maxWait; a freed slot is handed directly to the longest waiter.
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	capacity int
+	maxQueue int
+	maxWait  time.Duration
+	// Prefix of the limiter's metrics, upstream_queue unless set
+	metric string
+
+	active  int
+	waiters *list.List
+}
+
+// One queued caller; granted is set under the limiter's lock when a slot is
+// handed over, and ready is closed at the same time
+type limiterWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func newConcurrencyLimiter(capacity, maxQueue int, maxWait time.Duration) *concurrencyLimiter {
+	return &concurrencyLimiter{capacity: capacity, maxQueue: maxQueue, maxWait: maxWait, waiters: list.New()}
+}
+
+var upstreamLimiter = newConcurrencyLimiter(cfg.UpstreamConcurrency, cfg.UpstreamQueueLength, cfg.UpstreamQueueTimeout.Duration)
+
+// acquire blocks until an upstream slot is free, the queue budget runs out or
+// ctx is done. On success the returned function must be called to release
+// the slot.
+func (l *concurrencyLimiter) acquire(ctx context.Context) (func(), error) {
+	l.mu.Lock()
+	if l.active < l.capacity && l.waiters.Len() == 0 {
+		l.active++
+		l.mu.Unlock()
+		observeHistogram(l.metricName("wait_seconds"), "", "", 0)
+		return l.release, nil
+	}
+	if l.waiters.Len() >= l.maxQueue {
+		l.mu.Unlock()
+		incCounter(l.metricName("rejections_total"), "reason", "queue_full", 1)
+		return nil, l.busyError()
+	}
+	waiter := &limiterWaiter{ready: make(chan struct{})}
+	elem := l.waiters.PushBack(waiter)
+	l.mu.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	defer func() { observeHistogram(l.metricName("wait_seconds"), "", "", time.Since(start).Seconds()) }()
+
+	var err error
+	select {
+	case <-waiter.ready:
+		return l.release, nil
+	case <-timer.C:
+		incCounter(l.metricName("rejections_total"), "reason", "queue_timeout", 1)
+		err = l.busyError()
+	case <-ctx.Done():
+		err = newAPIError(http.StatusServiceUnavailable, "Request ended while waiting for an upstream slot: %v", ctx.Err()).
+			withCode("server_busy")
+	}
+
+	// Leave the queue, unless a slot was handed over in the meantime, in
+	// which case it is passed on instead of leaking
+	l.mu.Lock()
+	granted := waiter.granted
+	if !granted {
+		l.waiters.Remove(elem)
+	}
+	l.mu.Unlock()
+	if granted {
+		l.release()
+	}
+	return nil, err
+}
+
+func (l *concurrencyLimiter) metricName(suffix string) string {
+	if l.metric == "" {
+		return "upstream_queue_" + suffix
+	}
+	return l.metric + "_" + suffix
+}
+
+// release frees a slot, handing it to the first waiter if there is one
+func (l *concurrencyLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if front := l.waiters.Front(); front != nil {
+		waiter := l.waiters.Remove(front).(*limiterWaiter)
+		waiter.granted = true
+		close(waiter.ready)
+		return
+	}
+	l.active--
+}
+
+func (l *concurrencyLimiter) busyError() *apiError {
+	return newAPIError(http.StatusServiceUnavailable, "Server is busy, try again later").
+		withCode("server_busy").
+		withRetryAfter(l.maxWait)
+}
+
+// Snapshot of the limiter reported by /debug/stats
+type limiterStats struct {
+	Active   int `json:"active"`
+	Capacity int `json:"capacity"`
+	Queued   int `json:"queued"`
+	MaxQueue int `json:"max_queue"`
+}
+
+func (l *concurrencyLimiter) stats() limiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return limiterStats{Active: l.active, Capacity: l.capacity, Queued: l.waiters.Len(), MaxQueue: l.maxQueue}
+}
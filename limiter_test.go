@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n callers are queued on l
+func waitQueued(t *testing.T, l *concurrencyLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for l.stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers queued, want %d", l.stats().Queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterFIFO(t *testing.T) {
+	l := newConcurrencyLimiter(1, 3, time.Minute)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue three callers, one at a time so their arrival order is known
+	order := make(chan int, 3)
+	for i := range 3 {
+		go func() {
+			release, err := l.acquire(context.Background())
+			if err != nil {
+				t.Error(err)
+				order <- -1
+				return
+			}
+			order <- i
+			release()
+		}()
+		waitQueued(t, l, i+1)
+	}
+
+	// The queue is full: a fourth caller is turned away at once
+	_, err = l.acquire(context.Background())
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Code != "server_busy" || apiErr.RetryAfter != time.Minute {
+		t.Fatalf("caller over the queue length: %v, want server_busy retrying after a minute", err)
+	}
+
+	release()
+	for want := range 3 {
+		if got := <-order; got != want {
+			t.Fatalf("caller %d got a slot in turn %d", got, want)
+		}
+	}
+	if s := l.stats(); s.Active != 0 || s.Queued != 0 {
+		t.Errorf("after every release: %+v, want nothing active or queued", s)
+	}
+}
+
+func TestLimiterWaitEnds(t *testing.T) {
+	l := newConcurrencyLimiter(1, 2, 20*time.Millisecond)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiting too long gives up and leaves the queue
+	start := time.Now()
+	if _, err := l.acquire(context.Background()); err == nil || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("queue timeout: %v after %s", err, time.Since(start))
+	}
+
+	// So does a caller whose request ends
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := l.acquire(ctx)
+		done <- err
+	}()
+	waitQueued(t, l, 1)
+	cancel()
+	if err := <-done; err == nil {
+		t.Fatal("cancelled caller got a slot")
+	}
+	if s := l.stats(); s.Queued != 0 || s.Active != 1 {
+		t.Fatalf("after the waits ended: %+v, want one active and none queued", s)
+	}
+
+	// The slot is freed rather than handed to a caller that left
+	release()
+	if s := l.stats(); s.Active != 0 {
+		t.Errorf("after release: %d active, want 0", s.Active)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestMain(m *testing.M) {
+	// Every generation logs its prompt and response
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeUpstream stands in for OpenAI, answering every request through
+// respond and keeping the requests it was sent
+type fakeUpstream struct {
+	mu       sync.Mutex
+	requests []openai.ChatCompletionRequest
+	respond  func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+}
+
+func (f *fakeUpstream) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	respond := f.respond
+	f.mu.Unlock()
+	return respond(req)
+}
+
+// calls returns how many requests the fake was sent
+func (f *fakeUpstream) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+// setupServer resets the server's global state to the defaults with a
+// configured key, adjusted by configure, and routes every upstream call to
+// the returned fake, which animates whatever it is given with a gentle sway
+func setupServer(t testing.TB, configure func(c *Config)) *fakeUpstream {
+	t.Helper()
+	c := defaultConfig()
+	c.OpenAIAPIKey = "test-key"
+	if configure != nil {
+		configure(&c)
+	}
+	previousStore, previousClient := store, newChatClient
+	applyConfig(c)
+	store = newMemoryStore()
+	// The library's search index follows the store
+	if err := animations.rebuild(store); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeUpstream{respond: swayResponse}
+	newChatClient = func(UpstreamProfile) chatClient { return fake }
+	t.Cleanup(func() {
+		waitForBackgroundWork()
+		store, newChatClient = previousStore, previousClient
+		applyConfig(defaultConfig())
+	})
+	return fake
+}
+
+// waitForBackgroundWork waits for history pruning and cache refreshes a test
+// set off, which read the configuration the next test replaces
+func waitForBackgroundWork() {
+	for {
+		responses.mu.Lock()
+		refreshing := len(responses.refreshing)
+		responses.mu.Unlock()
+		if refreshing == 0 && !historyPruning.Load() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// modelInputOf reads the request data the service sent the model
+func modelInputOf(req openai.ChatCompletionRequest) (modelInput, error) {
+	var input modelInput
+	for _, m := range req.Messages {
+		if m.Role != openai.ChatMessageRoleUser || !strings.HasPrefix(m.Content, userDataStart) {
+			continue
+		}
+		data := strings.TrimSuffix(strings.TrimPrefix(m.Content, userDataStart), userDataEnd)
+		if err := json.Unmarshal([]byte(data), &input); err != nil {
+			return input, err
+		}
+	}
+	return input, nil
+}
+
+// swayResponse animates every point it is given along x by up to 0.05
+func swayResponse(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	input, err := modelInputOf(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	points := append(append([]ControlPoint{}, input.ControlPoints...), input.AuxiliaryPoints...)
+	return framesResponse(input.Length, func(f int) map[string]Position {
+		frame := make(map[string]Position, len(points))
+		for _, cp := range points {
+			sway := 0.05 * math.Sin(2*math.Pi*float64(f)/float64(max(input.Length, 1)))
+			frame[strconv.Itoa(cp.ID)] = Position{X: cp.Position[0] + sway, Y: cp.Position[1], Z: cp.Position[2]}
+		}
+		return frame
+	}), nil
+}
+
+// framesResponse is a completion holding length frames built by frame
+func framesResponse(length int, frame func(f int) map[string]Position) openai.ChatCompletionResponse {
+	out := OpenAIResponse{Frames: make([]map[string]Position, length)}
+	for f := range out.Frames {
+		out.Frames[f] = frame(f)
+	}
+	content, _ := json.Marshal(out)
+	return contentResponse(string(content))
+}
+
+// contentResponse is a completion with a single choice holding content
+func contentResponse(content string) openai.ChatCompletionResponse {
+	return openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content}}},
+		Usage:   openai.Usage{PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150},
+	}
+}
+
+// testRig is a small humanoid in metres, Y up
+func testRig() []ControlPoint {
+	return []ControlPoint{
+		{ID: 0, Role: "head", Position: []float64{0, 1.7, 0}},
+		{ID: 1, Role: "left hand", Position: []float64{0.6, 1.2, 0}},
+		{ID: 2, Role: "right hand", Position: []float64{-0.6, 1.2, 0}},
+		{ID: 3, Role: "left foot", Position: []float64{0.2, 0, 0}},
+		{ID: 4, Role: "right foot", Position: []float64{-0.2, 0, 0}},
+	}
+}
+
+// serve sends a request through the full router, with body encoded as JSON
+// unless it is already a string
+func serve(t testing.TB, method, target string, body any, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeBody unmarshals a response body, failing the test if it is not JSON
+func decodeBody[T any](t testing.TB, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("response is not JSON: %v\n%s", err, rec.Body)
+	}
+	return v
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Minimal Prometheus-compatible metrics: labelled counters, histograms and
+// gauges computed on demand, exposed in the text format at /metrics.
+
+var defaultBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 80}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type metricsRegistry struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+	gauges     map[string]func() float64
+	// Gauges with one value per label value
+	labelledGauges map[string]labelledGauge
+}
+
+type labelledGauge struct {
+	labelKey string
+	values   func() map[string]float64
+}
+
+var metrics = &metricsRegistry{
+	counters:       make(map[string]map[string]float64),
+	histograms:     make(map[string]map[string]*histogram),
+	gauges:         make(map[string]func() float64),
+	labelledGauges: make(map[string]labelledGauge),
+}
+
+func formatLabel(key, value string) string {
+	if key == "" {
+		return ""
+	}
+	return fmt.Sprintf(`%s="%s"`, key, strings.ReplaceAll(value, `"`, `\"`))
+}
+
+// countEvictions records entries an in-memory store dropped
+func countEvictions(store string, n int) {
+	if n > 0 {
+		incCounter("store_evictions_total", "store", store, float64(n))
+	}
+}
+
+// incCounter adds delta to a labelled counter; pass an empty key for no label
+func incCounter(name, labelKey, labelValue string, delta float64) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.counters[name] == nil {
+		metrics.counters[name] = make(map[string]float64)
+	}
+	metrics.counters[name][formatLabel(labelKey, labelValue)] += delta
+}
+
+// observeHistogram records one observation in a labelled histogram
+func observeHistogram(name, labelKey, labelValue string, v float64) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.histograms[name] == nil {
+		metrics.histograms[name] = make(map[string]*histogram)
+	}
+	label := formatLabel(labelKey, labelValue)
+	h := metrics.histograms[name][label]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(defaultBuckets))}
+		metrics.histograms[name][label] = h
+	}
+	for i, upper := range defaultBuckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// registerGauge exposes a value computed at scrape time
+func registerGauge(name string, fn func() float64) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.gauges[name] = fn
+}
+
+// registerLabelledGauge exposes values computed at scrape time, one series
+// per label value
+func registerLabelledGauge(name, labelKey string, values func() map[string]float64) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.labelledGauges[name] = labelledGauge{labelKey: labelKey, values: values}
+}
+
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	return slices.Sorted(maps.Keys(m))
+}
+
+func withLabels(name, labels string) string {
+	if labels == "" {
+		return name
+	}
+	return name + "{" + labels + "}"
+}
+
+// Handler for the /metrics endpoint
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics.mu.Lock()
+	var b strings.Builder
+	for _, name := range sortedKeys(metrics.counters) {
+		fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+		for _, labels := range sortedKeys(metrics.counters[name]) {
+			fmt.Fprintf(&b, "%s %g\n", withLabels(name, labels), metrics.counters[name][labels])
+		}
+	}
+	for _, name := range sortedKeys(metrics.histograms) {
+		fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+		for _, labels := range sortedKeys(metrics.histograms[name]) {
+			h := metrics.histograms[name][labels]
+			sep := ""
+			if labels != "" {
+				sep = ","
+			}
+			for i, upper := range defaultBuckets {
+				fmt.Fprintf(&b, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, upper, h.counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+			fmt.Fprintf(&b, "%s %g\n", withLabels(name+"_sum", labels), h.sum)
+			fmt.Fprintf(&b, "%s %d\n", withLabels(name+"_count", labels), h.count)
+		}
+	}
+	gauges := make(map[string]func() float64, len(metrics.gauges))
+	for name, fn := range metrics.gauges {
+		gauges[name] = fn
+	}
+	labelled := make(map[string]labelledGauge, len(metrics.labelledGauges))
+	for name, g := range metrics.labelledGauges {
+		labelled[name] = g
+	}
+	metrics.mu.Unlock()
+
+	// Gauge callbacks may take other locks, so evaluate them outside ours
+	for _, name := range sortedKeys(gauges) {
+		fmt.Fprintf(&b, "# TYPE %s gauge\n%s %g\n", name, name, gauges[name]())
+	}
+	for _, name := range sortedKeys(labelled) {
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+		values := labelled[name].values()
+		for _, value := range sortedKeys(values) {
+			fmt.Fprintf(&b, "%s %g\n", withLabels(name, formatLabel(labelled[name].labelKey, value)), values[value])
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
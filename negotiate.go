@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A response format that /generate-deformations can produce
+type outputFormat struct {
+	Name      string
+	MediaType string
+	// Vendor formats are never picked by a wildcard range
+	ExactOnly bool
+}
+
+// Supported formats in order of preference for wildcard Accept ranges
+var outputFormats = []outputFormat{
+	{Name: "json", MediaType: "application/json"},
+	{Name: "unity", MediaType: "application/vnd.unity.animationclip+json", ExactOnly: true},
+	{Name: "csv", MediaType: "text/csv"},
+	{Name: "unreal_curves", MediaType: "application/vnd.unreal.curves+json", ExactOnly: true},
+	{Name: "bundle", MediaType: "application/zip", ExactOnly: true},
+}
+
+// One media range from an Accept header
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept splits an Accept header into media ranges ordered by quality,
+// keeping header order for equal qualities
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+// matchesMediaRange reports whether a concrete media type falls in a range
+// such as "text/csv", "text/*" or "*/*"
+func matchesMediaRange(mediaType, mediaRange string) bool {
+	if mediaRange == "*/*" || mediaRange == "*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(mediaRange, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return mediaType == mediaRange
+}
+
+// resolveFormat picks the response format. A ?format= override wins;
+// otherwise the Accept header is negotiated, defaulting to JSON when it is
+// absent. Ranges with q=0 exclude the types they name.
+func resolveFormat(accept, override string) (outputFormat, error) {
+	if override != "" {
+		for _, f := range outputFormats {
+			if f.Name == override {
+				return f, nil
+			}
+		}
+		return outputFormat{}, newAPIError(http.StatusBadRequest, "Invalid format %q, expected %s", override, formatNames())
+	}
+	if strings.TrimSpace(accept) == "" {
+		return outputFormats[0], nil
+	}
+
+	ranges := parseAccept(accept)
+	excluded := func(f outputFormat) bool {
+		for _, r := range ranges {
+			if r.q <= 0 && r.mediaType == f.MediaType {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range ranges {
+		if r.q <= 0 {
+			continue
+		}
+		for _, f := range outputFormats {
+			if f.ExactOnly && f.MediaType != r.mediaType {
+				continue
+			}
+			if matchesMediaRange(f.MediaType, r.mediaType) && !excluded(f) {
+				return f, nil
+			}
+		}
+	}
+
+	supported := make([]string, len(outputFormats))
+	for i, f := range outputFormats {
+		supported[i] = f.MediaType
+	}
+	return outputFormat{}, newAPIError(http.StatusNotAcceptable, "None of the accepted media types can be produced").
+		withDetails(map[string]any{"supported": supported})
+}
+
+func formatNames() string {
+	names := make([]string, len(outputFormats))
+	for i, f := range outputFormats {
+		names[i] = f.Name
+	}
+	return strings.Join(names[:len(names)-1], ", ") + fmt.Sprintf(" or %s", names[len(names)-1])
+}
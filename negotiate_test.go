@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestResolveFormat(t *testing.T) {
+	for _, tc := range []struct {
+		accept, override string
+		want             string
+		status           int
+	}{
+		{"", "", "json", 0},
+		{"text/csv", "", "csv", 0},
+		{"TEXT/CSV; charset=utf-8", "", "csv", 0},
+		// Higher quality wins regardless of order
+		{"application/json;q=0.5, text/csv", "", "csv", 0},
+		{"text/csv;q=0.2, application/json;q=0.9", "", "json", 0},
+		// Equal qualities keep header order
+		{"text/csv, application/json", "", "csv", 0},
+		// Wildcards pick the preferred non-vendor format
+		{"*/*", "", "json", 0},
+		{"text/*", "", "csv", 0},
+		{"*/*, application/json;q=0", "", "csv", 0},
+		// Vendor formats must be named exactly
+		{"application/vnd.unity.animationclip+json", "", "unity", 0},
+		{"application/*", "", "json", 0},
+		{"application/vnd.unreal.curves+json;q=0.8, */*;q=0.1", "", "unreal_curves", 0},
+		// ?format= overrides the header
+		{"text/csv", "unity", "unity", 0},
+		{"", "yaml", "", http.StatusBadRequest},
+		{"image/png", "", "", http.StatusNotAcceptable},
+		{"application/zip;q=0", "", "", http.StatusNotAcceptable},
+		{"text/csv;q=0, application/json;q=0", "", "", http.StatusNotAcceptable},
+	} {
+		f, err := resolveFormat(tc.accept, tc.override)
+		if tc.status != 0 {
+			var apiErr *apiError
+			if !errors.As(err, &apiErr) || apiErr.Status != tc.status {
+				t.Errorf("Accept %q, format %q: %v, want status %d", tc.accept, tc.override, err, tc.status)
+			}
+			continue
+		}
+		if err != nil || f.Name != tc.want {
+			t.Errorf("Accept %q, format %q: %q, %v; want %q", tc.accept, tc.override, f.Name, err, tc.want)
+		}
+	}
+}
+
+func TestNotAcceptable(t *testing.T) {
+	fake := setupServer(t, nil)
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4}}
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, http.Header{"Accept": {"application/xml"}})
+	if rec.Code != http.StatusNotAcceptable {
+		t.Fatalf("status %d, want 406", rec.Code)
+	}
+	details, _ := decodeBody[errorResponse](t, rec).Error.Details.(map[string]any)
+	if supported, _ := details["supported"].([]any); len(supported) != len(outputFormats) {
+		t.Errorf("details %v, want every supported media type", details)
+	}
+	if got := fake.calls(); got != 0 {
+		t.Errorf("upstream called %d times for an unacceptable request", got)
+	}
+
+	rec = serve(t, http.MethodPost, "/generate-deformations", payload, http.Header{"Accept": {"text/csv;q=0.5, application/json"}})
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("Vary") != "Accept" {
+		t.Errorf("status %d, Content-Type %q, Vary %q; want JSON varying on Accept", rec.Code, rec.Header().Get("Content-Type"), rec.Header().Get("Vary"))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// A post-processing stage applied to a clip's deltas. The control points are
+// the rig at rest, with the client's IDs.
+type Transform func(ResponsePayload, []ControlPoint) ResponsePayload
+
+// Stages in the order they run when a request sets no pipeline
+var defaultPipeline = []string{"stabilize", "exaggerate", "clamp", "smooth", "jiggle", "ease", "freeze", "hold"}
+
+// transformStages builds the named stages for one request. Stages that
+// report problems append them to warnings.
+var transformStages = map[string]func(payload RequestPayload, t pointTables, warnings *[]string) Transform{
+	// Keep the character's centre of mass in place
+	"stabilize": func(payload RequestPayload, _ pointTables, _ *[]string) Transform {
+		return func(frames ResponsePayload, points []ControlPoint) ResponsePayload {
+			if !payload.StabilizeCOM {
+				return frames
+			}
+			return stabilizeCOM(frames, payload.COMPoints, points)
+		}
+	},
+	// Amplify or damp motion for a stylized look, measured from the pose the
+	// model started from
+	"exaggerate": func(payload RequestPayload, t pointTables, _ *[]string) Transform {
+		return func(frames ResponsePayload, _ []ControlPoint) ResponsePayload {
+			if payload.Exaggerate == 0 && len(payload.ExaggerateRoles) == 0 {
+				return frames
+			}
+			frames = exaggerateMotion(shiftFrames(frames, t.restOffsets, -1), payload.Exaggerate, payload.ExaggerateRoles, t.roles)
+			return shiftFrames(frames, t.restOffsets, 1)
+		}
+	},
+	// Hold every point to its motion budget, measured from the pose the
+	// model started from
+	"clamp": func(payload RequestPayload, t pointTables, warnings *[]string) Transform {
+		return func(frames ResponsePayload, _ []ControlPoint) ResponsePayload {
+			frames, clamped := clampMotion(shiftFrames(frames, t.restOffsets, -1), t.budgets)
+			frames = shiftFrames(frames, t.restOffsets, 1)
+			if len(clamped) > 0 {
+				*warnings = append(*warnings, fmt.Sprintf("Motion of control points %v exceeded their motion budgets and was clamped", clamped))
+			}
+			return frames
+		}
+	},
+	// Keep neighbouring points moving together
+	"smooth": func(payload RequestPayload, t pointTables, _ *[]string) Transform {
+		return func(frames ResponsePayload, _ []ControlPoint) ResponsePayload {
+			return smoothNeighbors(frames, payload.Neighbors, t.categories, payload.NeighborRigidity)
+		}
+	},
+	// Let soft points lag behind and overshoot the motion
+	"jiggle": func(payload RequestPayload, t pointTables, warnings *[]string) Transform {
+		fps := payload.FPS
+		if fps <= 0 {
+			fps = defaultExportFPS
+		}
+		return func(frames ResponsePayload, _ []ControlPoint) ResponsePayload {
+			frames, clamped := applyJiggle(frames, payload.Jiggle, t.roles, t.budgets, t.restOffsets, fps, payload.Loop)
+			if len(clamped) > 0 {
+				*warnings = append(*warnings, fmt.Sprintf("Jiggle of control points %v overshot their motion budgets and was clamped", clamped))
+			}
+			return frames
+		}
+	},
+	// Fade motion in and out of the rest pose
+	"ease": func(payload RequestPayload, t pointTables, _ *[]string) Transform {
+		return func(frames ResponsePayload, _ []ControlPoint) ResponsePayload {
+			return applyEasing(frames, payload.Easing, t.roles, payload.Loop)
+		}
+	},
+	// Zero out motion along frozen axes, even if the model ignored the hint
+	"freeze": func(payload RequestPayload, _ pointTables, _ *[]string) Transform {
+		return func(frames ResponsePayload, _ []ControlPoint) ResponsePayload {
+			return freezeAxes(frames, payload.FreezeAxes)
+		}
+	},
+	// Pause on held poses without paying the model for static frames
+	"hold": func(payload RequestPayload, _ pointTables, warnings *[]string) Transform {
+		return func(frames ResponsePayload, _ []ControlPoint) ResponsePayload {
+			// Holds were checked against length, but the model may have
+			// returned fewer frames
+			holds := make([]Hold, 0, len(payload.Holds))
+			for _, h := range payload.Holds {
+				if h.AfterFrame >= len(frames) {
+					*warnings = append(*warnings, fmt.Sprintf("The hold after frame %d was dropped: the model returned %d frames", h.AfterFrame, len(frames)))
+					continue
+				}
+				holds = append(holds, h)
+			}
+			return applyHolds(frames, holds)
+		}
+	},
+}
+
+func validatePipeline(names []string) error {
+	for i, name := range names {
+		if _, ok := transformStages[name]; !ok {
+			return fmt.Errorf("unknown pipeline stage %q, expected one of %v", name, defaultPipeline)
+		}
+		if slices.Contains(names[:i], name) {
+			return fmt.Errorf("pipeline stage %q is listed twice", name)
+		}
+	}
+	return nil
+}
+
+// buildPipeline returns the request's stages in order: those it lists, or
+// all of them in the default order
+func buildPipeline(payload RequestPayload, t pointTables, warnings *[]string) []Transform {
+	names := payload.Pipeline
+	if len(names) == 0 {
+		names = defaultPipeline
+	}
+	stages := make([]Transform, len(names))
+	for i, name := range names {
+		stages[i] = transformStages[name](payload, t, warnings)
+	}
+	return stages
+}
+
+func runPipeline(frames ResponsePayload, stages []Transform, points []ControlPoint) ResponsePayload {
+	for _, stage := range stages {
+		frames = stage(frames, points)
+	}
+	return frames
+}
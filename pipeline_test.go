@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestValidatePipeline(t *testing.T) {
+	for _, tc := range []struct {
+		names []string
+		err   string
+	}{
+		{nil, ""},
+		{defaultPipeline, ""},
+		{[]string{"hold", "clamp"}, ""},
+		{[]string{"clamp", "blur"}, `unknown pipeline stage "blur"`},
+		{[]string{"clamp", "smooth", "clamp"}, `"clamp" is listed twice`},
+	} {
+		err := validatePipeline(tc.names)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%q: %v, want %q", tc.names, err, tc.err)
+		}
+	}
+	// Every stage can be built, and the default order runs each once
+	if !slices.Equal(slices.Sorted(slices.Values(defaultPipeline)), sortedKeys(transformStages)) {
+		t.Errorf("default pipeline %q does not list every stage once", defaultPipeline)
+	}
+}
+
+func TestPipelineOrder(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	type response struct {
+		Frames ResponsePayload `json:"frames"`
+		Meta   generationMeta  `json:"meta"`
+	}
+	// The right hand rises 0.08 by the last frame; doubled it would exceed
+	// its budget of 0.1
+	for _, tc := range []struct {
+		name     string
+		pipeline []string
+		stages   []string
+		rise     float64
+	}{
+		{"default", nil, defaultPipeline, 0.1},
+		{"clamp first", []string{"clamp", "exaggerate"}, []string{"clamp", "exaggerate"}, 0.16},
+		{"without exaggerate", []string{"clamp"}, []string{"clamp"}, 0.08},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			payload := RequestPayload{RequestPayload: api.RequestPayload{
+				ControlPoints: testRig(),
+				Prompt:        "raise the right hand",
+				Length:        3,
+				Constraints:   &MotionConstraints{MotionBudgets: map[int]float64{2: 0.1}},
+				Exaggerate:    2,
+				Pipeline:      tc.pipeline,
+			}}
+			rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			body := decodeBody[response](t, rec)
+			if got := body.Frames[2][2].DeltaY; got != tc.rise {
+				t.Errorf("right hand rose %v, want %v", got, tc.rise)
+			}
+			var stages []string
+			for _, s := range body.Meta.Postprocess.Stages {
+				stages = append(stages, s.Stage)
+			}
+			if !slices.Equal(stages, tc.stages) {
+				t.Errorf("meta lists stages %q, want %q", stages, tc.stages)
+			}
+		})
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 3, Pipeline: []string{"smooth", "smooth"}}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("duplicate stage: status %d, want 400", rec.Code)
+	}
+}
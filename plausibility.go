@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+)
+
+const (
+	defaultMinDistanceRatio = 0.2
+	defaultMinGraphHops     = 3
+	defaultMinVolumeRatio   = 0.3
+	// Most problems spelled out in warnings and error details
+	maxImplausibleReports = 10
+)
+
+func validateOnImplausible(mode string) error {
+	switch mode {
+	case "", "warn", "retry", "reject":
+		return nil
+	}
+	return fmt.Errorf("invalid on_implausible %q, expected warn, retry or reject", mode)
+}
+
+func validatePlausibility(opts *PlausibilityOptions) error {
+	if opts == nil {
+		return nil
+	}
+	if opts.MinDistanceRatio < 0 || opts.MinDistanceRatio >= 1 {
+		return fmt.Errorf("plausibility.min_distance_ratio must be between 0 and 1")
+	}
+	if opts.MinVolumeRatio < 0 || opts.MinVolumeRatio >= 1 {
+		return fmt.Errorf("plausibility.min_volume_ratio must be between 0 and 1")
+	}
+	if opts.MinGraphHops < 0 || opts.MinGraphHops == 1 {
+		return fmt.Errorf("plausibility.min_graph_hops must be at least 2")
+	}
+	return nil
+}
+
+// plausibilityDefaults fills in the thresholds the request left out
+func plausibilityDefaults(o *PlausibilityOptions) PlausibilityOptions {
+	opts := PlausibilityOptions{}
+	if o != nil {
+		opts = *o
+	}
+	if opts.MinDistanceRatio == 0 {
+		opts.MinDistanceRatio = defaultMinDistanceRatio
+	}
+	if opts.MinGraphHops == 0 {
+		opts.MinGraphHops = defaultMinGraphHops
+	}
+	if opts.MinVolumeRatio == 0 {
+		opts.MinVolumeRatio = defaultMinVolumeRatio
+	}
+	return opts
+}
+
+// A frame where the character folds through itself
+type implausibleFrame struct {
+	Frame int `json:"frame"`
+	// Two distant points that came too close, with their distance and rest distance
+	Points       []int   `json:"points,omitempty"`
+	Distance     float64 `json:"distance,omitempty"`
+	RestDistance float64 `json:"rest_distance,omitempty"`
+	// Body hull volume as a fraction of the rest volume
+	VolumeRatio *float64 `json:"volume_ratio,omitempty"`
+}
+
+func (f implausibleFrame) String() string {
+	if f.VolumeRatio != nil {
+		return fmt.Sprintf("frame %d: the body's volume collapsed to %.0f%% of its rest volume", f.Frame, *f.VolumeRatio*100)
+	}
+	return fmt.Sprintf("frame %d: control points %d and %d are %.3g apart, %.3g at rest", f.Frame, f.Points[0], f.Points[1], f.Distance, f.RestDistance)
+}
+
+// graphHops returns the number of hops between every pair of points in the
+// neighbors graph; pairs that are not connected are left out
+func graphHops(neighbors map[int][]int) map[[2]int]int {
+	hops := make(map[[2]int]int)
+	for start := range neighbors {
+		dist := map[int]int{start: 0}
+		queue := []int{start}
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			for _, next := range neighbors[id] {
+				if _, seen := dist[next]; !seen {
+					dist[next] = dist[id] + 1
+					queue = append(queue, next)
+				}
+			}
+		}
+		for id, d := range dist {
+			hops[[2]int{start, id}] = d
+		}
+	}
+	return hops
+}
+
+// distantPairs returns the pairs of points that should never meet. With a
+// neighbors graph these are points at least minHops apart in it, or in parts
+// of the graph that are not connected. Without one they are points in
+// different role families, such as a hand and the torso.
+func distantPairs(points []ControlPoint, neighbors map[int][]int, minHops int) [][2]int {
+	var hops map[[2]int]int
+	if len(neighbors) > 0 {
+		hops = graphHops(neighbors)
+	}
+	var pairs [][2]int
+	for i, a := range points {
+		for _, b := range points[i+1:] {
+			if a.ID == b.ID {
+				continue
+			}
+			far := roleFamily(a.Role) != roleFamily(b.Role)
+			if hops != nil {
+				_, inGraphA := neighbors[a.ID]
+				_, inGraphB := neighbors[b.ID]
+				if !inGraphA || !inGraphB {
+					continue
+				}
+				d, connected := hops[[2]int{a.ID, b.ID}]
+				far = !connected || d >= minHops
+			}
+			if far {
+				pairs = append(pairs, [2]int{a.ID, b.ID})
+			}
+		}
+	}
+	return pairs
+}
+
+// findCollapsedPairs reports, per frame, the distant pair that came closest
+// relative to its rest distance, when that is below minRatio
+func findCollapsedPairs(frames []map[int]Position, rest map[int][]float64, pairs [][2]int, minRatio float64) []implausibleFrame {
+	var found []implausibleFrame
+	for i, frame := range frames {
+		var worst *implausibleFrame
+		worstRatio := minRatio
+		for _, pair := range pairs {
+			a, okA := frame[pair[0]]
+			b, okB := frame[pair[1]]
+			ra, rb := rest[pair[0]], rest[pair[1]]
+			if !okA || !okB || len(ra) < 3 || len(rb) < 3 {
+				continue
+			}
+			restDistance := distance3(ra[0], ra[1], ra[2], rb[0], rb[1], rb[2])
+			if restDistance == 0 {
+				continue
+			}
+			d := distance3(a.X, a.Y, a.Z, b.X, b.Y, b.Z)
+			if ratio := d / restDistance; ratio < worstRatio {
+				worstRatio = ratio
+				worst = &implausibleFrame{Frame: i, Points: []int{pair[0], pair[1]}, Distance: roundTo(d, 4), RestDistance: roundTo(restDistance, 4)}
+			}
+		}
+		if worst != nil {
+			found = append(found, *worst)
+		}
+	}
+	return found
+}
+
+// findCollapsedVolumes reports the frames where the convex hull of the body
+// points shrinks below minRatio of its rest volume. Flat rigs have no volume
+// to lose and are never reported.
+func findCollapsedVolumes(frames []map[int]Position, rest map[int][]float64, body []int, minRatio float64) []implausibleFrame {
+	restPoints := make([][3]float64, 0, len(body))
+	for _, id := range body {
+		if p := rest[id]; len(p) >= 3 {
+			restPoints = append(restPoints, [3]float64{p[0], p[1], p[2]})
+		}
+	}
+	restVolume := hullVolume(restPoints)
+	if restVolume == 0 {
+		return nil
+	}
+	var found []implausibleFrame
+	for i, frame := range frames {
+		points := make([][3]float64, 0, len(body))
+		for _, id := range body {
+			if p, ok := frame[id]; ok {
+				points = append(points, [3]float64{p.X, p.Y, p.Z})
+			}
+		}
+		if len(points) < len(restPoints) {
+			continue
+		}
+		if ratio := hullVolume(points) / restVolume; ratio < minRatio {
+			r := roundTo(ratio, 3)
+			found = append(found, implausibleFrame{Frame: i, VolumeRatio: &r})
+		}
+	}
+	return found
+}
+
+func distance3(ax, ay, az, bx, by, bz float64) float64 {
+	dx, dy, dz := ax-bx, ay-by, az-bz
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// hullVolume returns the volume of the convex hull of points, built
+// incrementally: each point outside the hull replaces the faces it can see
+// with a cone of faces from the horizon to itself. Points that do not span
+// three dimensions have no volume.
+func hullVolume(points [][3]float64) float64 {
+	if len(points) < 4 {
+		return 0
+	}
+	sub := func(a, b [3]float64) [3]float64 { return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+	cross := func(a, b [3]float64) [3]float64 {
+		return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+	}
+	dot := func(a, b [3]float64) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+
+	// Scale the tolerance to the size of the point cloud
+	var extent float64
+	for _, p := range points {
+		extent = max(extent, math.Abs(p[0]-points[0][0]), math.Abs(p[1]-points[0][1]), math.Abs(p[2]-points[0][2]))
+	}
+	eps := 1e-9 * extent * extent * extent
+	if eps == 0 {
+		return 0
+	}
+
+	// Start from a tetrahedron of four points that are not coplanar
+	i0, i1, i2, i3 := 0, -1, -1, -1
+	for i := 1; i < len(points) && i1 < 0; i++ {
+		if d := sub(points[i], points[i0]); dot(d, d) > eps {
+			i1 = i
+		}
+	}
+	for i := 1; i1 >= 0 && i < len(points) && i2 < 0; i++ {
+		n := cross(sub(points[i1], points[i0]), sub(points[i], points[i0]))
+		if dot(n, n) > eps {
+			i2 = i
+		}
+	}
+	for i := 1; i2 >= 0 && i < len(points) && i3 < 0; i++ {
+		n := cross(sub(points[i1], points[i0]), sub(points[i2], points[i0]))
+		if math.Abs(dot(n, sub(points[i], points[i0]))) > eps {
+			i3 = i
+		}
+	}
+	if i3 < 0 {
+		return 0
+	}
+	inside := [3]float64{}
+	for _, i := range []int{i0, i1, i2, i3} {
+		for k := range inside {
+			inside[k] += points[i][k] / 4
+		}
+	}
+
+	type face [3]int
+	normal := func(f face) [3]float64 {
+		return cross(sub(points[f[1]], points[f[0]]), sub(points[f[2]], points[f[0]]))
+	}
+	// Orient faces so their normals point away from the interior
+	outward := func(f face) face {
+		if dot(normal(f), sub(inside, points[f[0]])) > 0 {
+			f[1], f[2] = f[2], f[1]
+		}
+		return f
+	}
+	faces := []face{
+		outward(face{i0, i1, i2}), outward(face{i0, i1, i3}),
+		outward(face{i0, i2, i3}), outward(face{i1, i2, i3}),
+	}
+
+	for p := range points {
+		if p == i0 || p == i1 || p == i2 || p == i3 {
+			continue
+		}
+		var visible, kept []face
+		for _, f := range faces {
+			if dot(normal(f), sub(points[p], points[f[0]])) > eps {
+				visible = append(visible, f)
+			} else {
+				kept = append(kept, f)
+			}
+		}
+		if len(visible) == 0 {
+			continue
+		}
+		// Horizon edges belong to exactly one visible face
+		edges := make(map[[2]int]bool)
+		for _, f := range visible {
+			for k := range 3 {
+				edges[[2]int{f[k], f[(k+1)%3]}] = true
+			}
+		}
+		for _, f := range visible {
+			for k := range 3 {
+				a, b := f[k], f[(k+1)%3]
+				if !edges[[2]int{b, a}] {
+					kept = append(kept, face{a, b, p})
+				}
+			}
+		}
+		faces = kept
+	}
+
+	var volume float64
+	for _, f := range faces {
+		a, b, c := sub(points[f[0]], inside), sub(points[f[1]], inside), sub(points[f[2]], inside)
+		volume += dot(a, cross(b, c)) / 6
+	}
+	return math.Abs(volume)
+}
+
+// checkPlausibility looks for frames where the model folded the character
+// through itself. frames and points use the compact IDs sent to the model;
+// neighbors and the reported points use the client's IDs.
+func checkPlausibility(frames []map[int]Position, points []ControlPoint, neighbors map[int][]int, idMap map[int]int, opts PlausibilityOptions) []implausibleFrame {
+	originalID := make(map[int]int, len(idMap))
+	for original, compact := range idMap {
+		originalID[compact] = original
+	}
+	compactNeighbors := make(map[int][]int, len(neighbors))
+	for id, ns := range neighbors {
+		for _, n := range ns {
+			compactNeighbors[idMap[id]] = append(compactNeighbors[idMap[id]], idMap[n])
+		}
+		if len(ns) == 0 {
+			compactNeighbors[idMap[id]] = nil
+		}
+	}
+
+	rest := make(map[int][]float64, len(points))
+	var body []int
+	for _, cp := range points {
+		rest[cp.ID] = cp.Position
+		if pointCategory(cp) == categoryBody {
+			body = append(body, cp.ID)
+		}
+	}
+
+	found := findCollapsedPairs(frames, rest, distantPairs(points, compactNeighbors, opts.MinGraphHops), opts.MinDistanceRatio)
+	for i := range found {
+		found[i].Points = []int{originalID[found[i].Points[0]], originalID[found[i].Points[1]]}
+	}
+	found = append(found, findCollapsedVolumes(frames, rest, body, opts.MinVolumeRatio)...)
+	slices.SortStableFunc(found, func(a, b implausibleFrame) int { return a.Frame - b.Frame })
+	return found
+}
+
+// implausibleWarnings describes the first problems and counts the rest
+func implausibleWarnings(found []implausibleFrame) []string {
+	var warnings []string
+	for i, f := range found {
+		if i == maxImplausibleReports {
+			warnings = append(warnings, fmt.Sprintf("implausible_pose: %d more problems", len(found)-i))
+			break
+		}
+		warnings = append(warnings, "implausible_pose: "+f.String())
+	}
+	return warnings
+}
+
+func implausibleGenerationError(found []implausibleFrame) *apiError {
+	return newAPIError(http.StatusUnprocessableEntity, "The generated motion folds the character through itself in %d frames", len(found)).
+		withCode("implausible_generation").
+		withDetails(map[string]any{"frames": found[:min(len(found), maxImplausibleReports)]})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestHullVolume(t *testing.T) {
+	cube := [][3]float64{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {1, 1, 0}, {0, 0, 1}, {1, 0, 1}, {0, 1, 1}, {1, 1, 1}}
+	for _, tc := range []struct {
+		name   string
+		points [][3]float64
+		want   float64
+	}{
+		{"tetrahedron", [][3]float64{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {0, 0, 1}}, 1.0 / 6},
+		{"cube", cube, 1},
+		// Points inside the hull do not add to it
+		{"cube with interior points", append(slices.Clone(cube), [3]float64{0.5, 0.5, 0.5}, [3]float64{0.2, 0.7, 0.4}), 1},
+		{"flat", [][3]float64{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {1, 1, 0}, {0.5, 0.5, 0}}, 0},
+		{"too few points", cube[:3], 0},
+	} {
+		if got := hullVolume(tc.points); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s: volume %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDistantPairs(t *testing.T) {
+	points := []ControlPoint{{ID: 0, Role: "left hand"}, {ID: 1, Role: "left elbow"}, {ID: 2, Role: "chest"}, {ID: 3, Role: "left foot"}}
+	// Without a graph, points of different body-part families
+	if got := distantPairs(points, nil, 3); !slices.Equal(got, [][2]int{{0, 2}, {0, 3}, {1, 2}, {1, 3}, {2, 3}}) {
+		t.Errorf("pairs by role %v", got)
+	}
+	// With one, points enough hops apart or not connected at all; points
+	// missing from the graph are not judged
+	neighbors := map[int][]int{0: {1}, 1: {0, 2}, 2: {1}, 3: {}}
+	if got := distantPairs(points, neighbors, 2); !slices.Equal(got, [][2]int{{0, 2}, {0, 3}, {1, 3}, {2, 3}}) {
+		t.Errorf("pairs by graph %v", got)
+	}
+	delete(neighbors, 3)
+	if got := distantPairs(points, neighbors, 2); !slices.Equal(got, [][2]int{{0, 2}}) {
+		t.Errorf("pairs without point 3 in the graph %v", got)
+	}
+}
+
+// foldingResponse moves the right hand onto the left foot by the last frame
+func foldingResponse(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	input, err := modelInputOf(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	var hand, foot []float64
+	for _, cp := range input.ControlPoints {
+		switch cp.Role {
+		case "right hand":
+			hand = cp.Position
+		case "left foot":
+			foot = cp.Position
+		}
+	}
+	return framesResponse(input.Length, func(f int) map[string]Position {
+		frame := make(map[string]Position, len(input.ControlPoints))
+		w := float64(f) / float64(input.Length-1)
+		for _, cp := range input.ControlPoints {
+			p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+			if cp.Role == "right hand" {
+				p = Position{X: hand[0] + w*(foot[0]-hand[0]), Y: hand[1] + w*(foot[1]-hand[1]), Z: hand[2] + w*(foot[2]-hand[2])}
+			}
+			frame[strconv.Itoa(cp.ID)] = p
+		}
+		return frame
+	}), nil
+}
+
+func TestImplausibleGenerations(t *testing.T) {
+	// Client IDs that differ from the compact ones the model sees
+	rig := testRig()
+	for i := range rig {
+		rig[i].ID += 10
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "touch the left foot", Length: 4}}
+	send := func(t *testing.T, onImplausible string) *httptest.ResponseRecorder {
+		t.Helper()
+		payload := payload
+		payload.Plausibility = &PlausibilityOptions{}
+		payload.OnImplausible = onImplausible
+		return serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	}
+	folds := func(warnings []string) bool {
+		return slices.ContainsFunc(warnings, func(w string) bool {
+			return strings.HasPrefix(w, "implausible_pose: frame 3: control points 12 and 13")
+		})
+	}
+
+	t.Run("warn", func(t *testing.T) {
+		fake := setupServer(t, nil)
+		fake.respond = foldingResponse
+		rec := send(t, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if meta := decodeBody[struct {
+			Meta generationMeta `json:"meta"`
+		}](t, rec).Meta; !folds(meta.Warnings) {
+			t.Errorf("warnings %q, want the hand and foot meeting in frame 3", meta.Warnings)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		fake := setupServer(t, nil)
+		fake.respond = foldingResponse
+		rec := send(t, "reject")
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("status %d, want 422", rec.Code)
+		}
+		body := decodeBody[errorResponse](t, rec)
+		details, _ := body.Error.Details.(map[string]any)
+		if frames, _ := details["frames"].([]any); body.Error.Code != "implausible_generation" || len(frames) == 0 {
+			t.Errorf("error %+v, want implausible_generation with the frames", body.Error)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		fake := setupServer(t, nil)
+		fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+			if fake.calls() == 1 {
+				return foldingResponse(req)
+			}
+			return swayResponse(req)
+		}
+		rec := send(t, "retry")
+		if rec.Code != http.StatusOK || fake.calls() != 2 {
+			t.Fatalf("status %d after %d calls, want 200 after a retry", rec.Code, fake.calls())
+		}
+		if meta := decodeBody[struct {
+			Meta generationMeta `json:"meta"`
+		}](t, rec).Meta; folds(meta.Warnings) {
+			t.Errorf("warnings %q still describe the first attempt", meta.Warnings)
+		}
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		fake := setupServer(t, nil)
+		fake.respond = foldingResponse
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if meta := decodeBody[struct {
+			Meta generationMeta `json:"meta"`
+		}](t, rec).Meta; folds(meta.Warnings) {
+			t.Error("plausibility checked without being asked for")
+		}
+	})
+}
+
+func TestPlausibilityVolume(t *testing.T) {
+	// A rig with depth, whose points all gather at the origin
+	rig := append(testRig(), ControlPoint{ID: 5, Role: "chest", Position: []float64{0, 1.3, 0.2}})
+	var frames []map[int]Position
+	for _, scale := range []float64{1, 0.1} {
+		frame := make(map[int]Position)
+		for _, cp := range rig {
+			frame[cp.ID] = Position{X: cp.Position[0] * scale, Y: cp.Position[1] * scale, Z: cp.Position[2] * scale}
+		}
+		frames = append(frames, frame)
+	}
+	idMap := map[int]int{0: 0, 1: 1, 2: 2, 3: 3, 4: 4, 5: 5}
+	found := checkPlausibility(frames, rig, nil, idMap, plausibilityDefaults(nil))
+	if !slices.ContainsFunc(found, func(f implausibleFrame) bool { return f.Frame == 1 && f.VolumeRatio != nil && *f.VolumeRatio < 0.3 }) {
+		t.Errorf("found %+v, want frame 1's volume collapsed", found)
+	}
+	if slices.ContainsFunc(found, func(f implausibleFrame) bool { return f.Frame == 0 }) {
+		t.Errorf("found %+v in the rest pose", found)
+	}
+}
+
+func TestValidatePlausibility(t *testing.T) {
+	for _, opts := range []*PlausibilityOptions{{MinDistanceRatio: 1}, {MinVolumeRatio: -0.1}, {MinGraphHops: 1}} {
+		if err := validatePlausibility(opts); err == nil {
+			t.Errorf("%+v accepted", *opts)
+		}
+	}
+	if err := validateOnImplausible("ignore"); err == nil {
+		t.Error("on_implausible ignore accepted")
+	}
+}
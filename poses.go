@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+const poseCollection = "poses"
+
+// A single frame of a clip kept under a name, as absolute positions
+type Pose struct {
+	Name string `json:"name"`
+	// The rig's control points moved to the pose
+	ControlPoints []ControlPoint `json:"control_points"`
+	Source        PoseSource     `json:"source"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// Where a pose was taken from
+type PoseSource struct {
+	// Stored generation the frame came from, empty for frames sent inline
+	GenerationHash string `json:"generation_hash,omitempty"`
+	Frame          int    `json:"frame"`
+}
+
+// Input struct for the /poses endpoint. The frame comes from frames or a
+// stored generation; the rig from control_points, rig_id or, for a stored
+// generation, its request.
+type PoseRequest struct {
+	Name           string          `json:"name"`
+	Frame          int             `json:"frame"`
+	Frames         ResponsePayload `json:"frames,omitempty"`
+	GenerationHash string          `json:"generation_hash,omitempty"`
+	ControlPoints  []ControlPoint  `json:"control_points,omitempty"`
+	RigID          string          `json:"rig_id,omitempty"`
+}
+
+var validPoseName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,63}$`)
+
+// posedPoints adds a frame's deltas to the rig's rest positions. Every point
+// of the rig must be in the frame and the other way round.
+func posedPoints(points []ControlPoint, frame map[int]Deformation) ([]ControlPoint, error) {
+	if len(frame) != len(points) {
+		return nil, fmt.Errorf("the frame has %d control points but the rig has %d", len(frame), len(points))
+	}
+	posed := make([]ControlPoint, len(points))
+	for i, cp := range points {
+		d, ok := frame[cp.ID]
+		if !ok {
+			return nil, fmt.Errorf("control point %d of the rig is not in the frame", cp.ID)
+		}
+		if len(cp.Position) < 3 {
+			return nil, fmt.Errorf("control point %d needs an [x, y, z] position", cp.ID)
+		}
+		posed[i] = cp
+		posed[i].Position = []float64{cp.Position[0] + d.DeltaX, cp.Position[1] + d.DeltaY, cp.Position[2] + d.DeltaZ}
+	}
+	return posed, nil
+}
+
+// Handler for the /poses endpoint
+func createPose(w http.ResponseWriter, r *http.Request) {
+	var req PoseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	if !validPoseName.MatchString(req.Name) {
+		writeError(w, newAPIError(http.StatusBadRequest, "name must be 1-64 letters, digits, '.', '-' or '_', not starting with '.'"))
+		return
+	}
+	if (len(req.Frames) > 0) == (req.GenerationHash != "") {
+		writeError(w, newAPIError(http.StatusBadRequest, "Specify either frames or generation_hash"))
+		return
+	}
+
+	frames := req.Frames
+	rig := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: req.ControlPoints, RigID: req.RigID}}
+	if req.GenerationHash != "" {
+		record, ok := loadGeneration(w, req.GenerationHash)
+		if !ok {
+			return
+		}
+		frames = record.Frames
+		if len(rig.ControlPoints) == 0 && rig.RigID == "" {
+			rig.ControlPoints = record.Request.ControlPoints
+		}
+	}
+	if err := resolveRig(&rig); err != nil {
+		if errors.Is(err, errUnknownRig) || errors.Is(err, errRigConflict) {
+			writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+			return
+		}
+		log.Printf("Failed to resolve rig %s: %v", rig.RigID, err)
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to load rig"))
+		return
+	}
+	if len(rig.ControlPoints) == 0 {
+		writeError(w, newAPIError(http.StatusBadRequest, "Missing control_points or rig_id"))
+		return
+	}
+	if req.Frame < 0 || req.Frame >= len(frames) {
+		writeError(w, newAPIError(http.StatusBadRequest, "frame %d is out of range, the clip has %d frames", req.Frame, len(frames)))
+		return
+	}
+	points, err := posedPoints(rig.ControlPoints, frames[req.Frame])
+	if err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Rig does not match the frame: %v", err))
+		return
+	}
+
+	pose := Pose{
+		Name:          req.Name,
+		ControlPoints: points,
+		Source:        PoseSource{GenerationHash: req.GenerationHash, Frame: req.Frame},
+		CreatedAt:     time.Now(),
+	}
+	if err := store.Put(poseCollection, pose.Name, pose); err != nil {
+		log.Printf("Failed to store pose %s: %v", pose.Name, err)
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to store pose"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/poses/"+pose.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(pose)
+}
+
+// Handler for the /poses/{name} endpoint
+func getPose(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !validPoseName.MatchString(name) {
+		writeError(w, newAPIError(http.StatusNotFound, "Pose not found"))
+		return
+	}
+	var pose Pose
+	found, err := store.Get(poseCollection, name, &pose)
+	if err != nil {
+		log.Printf("Failed to load pose %s: %v", name, err)
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to load pose"))
+		return
+	}
+	if !found {
+		writeError(w, newAPIError(http.StatusNotFound, "Pose not found"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(pose); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
+
+// Entry of the /poses listing
+type poseSummary struct {
+	Name      string     `json:"name"`
+	Source    PoseSource `json:"source"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Handler for listing stored poses at /poses
+func listPoses(w http.ResponseWriter, r *http.Request) {
+	names, err := store.List(poseCollection)
+	if err != nil {
+		log.Printf("Failed to list poses: %v", err)
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to list poses"))
+		return
+	}
+	slices.Sort(names)
+	poses := []poseSummary{}
+	for _, name := range names {
+		var pose Pose
+		found, err := store.Get(poseCollection, name, &pose)
+		if err != nil || !found {
+			continue
+		}
+		poses = append(poses, poseSummary{Name: pose.Name, Source: pose.Source, CreatedAt: pose.CreatedAt})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"poses": poses}); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// stillFrame is a frame of testRig with only the right hand moved by d
+func stillFrame(d Deformation) map[int]Deformation {
+	frame := make(map[int]Deformation)
+	for _, cp := range testRig() {
+		frame[cp.ID] = Deformation{}
+	}
+	frame[2] = d
+	return frame
+}
+
+func TestPoses(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+
+	t.Run("inline frames", func(t *testing.T) {
+		req := PoseRequest{Name: "reach", Frame: 1, Frames: ResponsePayload{stillFrame(Deformation{}), stillFrame(Deformation{DeltaX: -0.1, DeltaZ: 0.3})}, ControlPoints: testRig()}
+		rec := serve(t, http.MethodPost, "/poses", req, nil)
+		if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/poses/reach" {
+			t.Fatalf("status %d, Location %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+		}
+		rec = serve(t, http.MethodGet, "/poses/reach", nil, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("fetch: status %d", rec.Code)
+		}
+		pose := decodeBody[Pose](t, rec)
+		if len(pose.ControlPoints) != 5 || pose.Source.Frame != 1 || pose.Source.GenerationHash != "" {
+			t.Fatalf("pose %+v", pose)
+		}
+		// Positions are the rest positions moved by the frame's deltas
+		hand := pose.ControlPoints[2].Position
+		if math.Abs(hand[0]+0.7) > 1e-9 || hand[1] != 1.2 || hand[2] != 0.3 {
+			t.Errorf("right hand posed at %v, want [-0.7 1.2 0.3]", hand)
+		}
+	})
+
+	t.Run("stored generation", func(t *testing.T) {
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "raise the right hand", Length: 3}}
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		hash := decodeBody[struct {
+			Meta generationMeta `json:"meta"`
+		}](t, rec).Meta.GenerationHash
+		// The rig comes from the stored request
+		rec = serve(t, http.MethodPost, "/poses", PoseRequest{Name: "raised", Frame: 2, GenerationHash: hash}, nil)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		pose := decodeBody[Pose](t, serve(t, http.MethodGet, "/poses/raised", nil, nil))
+		if got := pose.ControlPoints[2].Position[1]; math.Abs(got-1.28) > 1e-9 || pose.Source.GenerationHash != hash {
+			t.Errorf("right hand at height %v from %q, want 1.28 from %q", got, pose.Source.GenerationHash, hash)
+		}
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		listed := decodeBody[struct {
+			Poses []poseSummary `json:"poses"`
+		}](t, serve(t, http.MethodGet, "/poses", nil, nil))
+		if len(listed.Poses) != 2 || listed.Poses[0].Name != "raised" || listed.Poses[1].Name != "reach" {
+			t.Errorf("listed %+v, want raised and reach by name", listed.Poses)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		frames := ResponsePayload{stillFrame(Deformation{})}
+		for _, tc := range []struct {
+			name   string
+			req    PoseRequest
+			status int
+		}{
+			{"hidden name", PoseRequest{Name: ".reach", Frames: frames, ControlPoints: testRig()}, http.StatusBadRequest},
+			{"frames and hash", PoseRequest{Name: "p", Frames: frames, GenerationHash: "0123456789abcdef0123456789abcdef", ControlPoints: testRig()}, http.StatusBadRequest},
+			{"no rig", PoseRequest{Name: "p", Frames: frames}, http.StatusBadRequest},
+			{"frame out of range", PoseRequest{Name: "p", Frame: 1, Frames: frames, ControlPoints: testRig()}, http.StatusBadRequest},
+			{"rig mismatch", PoseRequest{Name: "p", Frames: frames, ControlPoints: testRig()[:4]}, http.StatusBadRequest},
+			{"unknown generation", PoseRequest{Name: "p", GenerationHash: "0123456789abcdef0123456789abcdef"}, http.StatusNotFound},
+		} {
+			if rec := serve(t, http.MethodPost, "/poses", tc.req, nil); rec.Code != tc.status {
+				t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.status)
+			}
+		}
+		if rec := serve(t, http.MethodGet, "/poses/missing", nil, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("unknown pose: status %d, want 404", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func validateFreezeAxes(axes []string) error {
+	for i, axis := range axes {
+		if axis != "x" && axis != "y" && axis != "z" {
+			return fmt.Errorf("invalid freeze axis %q, expected x, y or z", axis)
+		}
+		if slices.Contains(axes[:i], axis) {
+			return fmt.Errorf("freeze axis %q is listed more than once", axis)
+		}
+	}
+	return nil
+}
+
+// freezeAxes zeroes the delta components along each frozen axis
+func freezeAxes(frames ResponsePayload, axes []string) ResponsePayload {
+	if len(axes) == 0 {
+		return frames
+	}
+	for _, frame := range frames {
+		for id, d := range frame {
+			for _, axis := range axes {
+				switch axis {
+				case "x":
+					d.DeltaX = 0
+				case "y":
+					d.DeltaY = 0
+				case "z":
+					d.DeltaZ = 0
+				}
+			}
+			frame[id] = d
+		}
+	}
+	return frames
+}
+
+func validateNeighborRigidity(factor float64, neighbors map[int][]int, points []ControlPoint) error {
+	if factor < 0 || factor > 1 {
+		return fmt.Errorf("neighbor_rigidity must be between 0 and 1")
+	}
+	known := make(map[int]bool, len(points))
+	for _, cp := range points {
+		known[cp.ID] = true
+	}
+	for id, adjacent := range neighbors {
+		if !known[id] {
+			return fmt.Errorf("neighbors references unknown control point %d", id)
+		}
+		for _, n := range adjacent {
+			if !known[n] {
+				return fmt.Errorf("neighbors of %d references unknown control point %d", id, n)
+			}
+		}
+	}
+	return nil
+}
+
+// smoothNeighbors pulls each point's delta toward the average delta of its
+// neighbors in the same category by factor, approximating ARAP local
+// rigidity. Every frame is updated from its unsmoothed deltas so the result
+// does not depend on order.
+func smoothNeighbors(frames ResponsePayload, neighbors map[int][]int, categories map[int]string, factor float64) ResponsePayload {
+	if factor == 0 || len(neighbors) == 0 {
+		return frames
+	}
+	for i, frame := range frames {
+		smoothed := make(map[int]Deformation, len(frame))
+		for id, d := range frame {
+			var sum Deformation
+			count := 0
+			for _, n := range neighbors[id] {
+				if nd, ok := frame[n]; ok && n != id && categories[n] == categories[id] {
+					sum = addDelta(sum, nd)
+					count++
+				}
+			}
+			if count == 0 {
+				smoothed[id] = d
+				continue
+			}
+			smoothed[id] = lerpDelta(d, scaleDelta(sum, 1/float64(count)), factor)
+		}
+		frames[i] = smoothed
+	}
+	return frames
+}
+
+// Most frames holds may add to a clip
+const maxHoldFrames = 10000
+
+func validateHolds(holds []Hold, length int) error {
+	total := 0
+	for _, h := range holds {
+		if h.AfterFrame < 0 || h.AfterFrame >= length {
+			return fmt.Errorf("hold after_frame %d is outside the clip's %d frames", h.AfterFrame, length)
+		}
+		if h.Count <= 0 {
+			return fmt.Errorf("hold after frame %d needs a positive count", h.AfterFrame)
+		}
+		total += h.Count
+	}
+	if total > maxHoldFrames {
+		return fmt.Errorf("holds add %d frames, the limit is %d", total, maxHoldFrames)
+	}
+	return nil
+}
+
+// applyHolds repeats each held frame Count times right after it. Frame
+// numbers refer to the generated clip, so holds do not shift one another;
+// holds on the same frame add up.
+func applyHolds(frames ResponsePayload, holds []Hold) ResponsePayload {
+	if len(holds) == 0 {
+		return frames
+	}
+	extra := make(map[int]int)
+	total := 0
+	for _, h := range holds {
+		extra[h.AfterFrame] += h.Count
+		total += h.Count
+	}
+	held := make(ResponsePayload, 0, len(frames)+total)
+	for i, frame := range frames {
+		held = append(held, frame)
+		for range extra[i] {
+			held = append(held, maps.Clone(frame))
+		}
+	}
+	return held
+}
+
+func validatePlayback(mode string) error {
+	switch mode {
+	case "", "forward", "reverse", "pingpong":
+		return nil
+	}
+	return fmt.Errorf("invalid playback %q, expected forward, reverse or pingpong", mode)
+}
+
+// applyPlayback reorders frames: reverse plays the clip backwards and
+// pingpong plays it forwards then backwards without repeating the last frame.
+// The result never shares its backing array with frames, which may be a
+// cached result other requests read at the same time.
+func applyPlayback[S ~[]E, E any](frames S, mode string) S {
+	switch mode {
+	case "reverse":
+		return reverseFrames(frames)
+	case "pingpong":
+		if len(frames) < 2 {
+			return frames
+		}
+		return slices.Concat(frames, reverseFrames(frames[:len(frames)-1]))
+	}
+	return frames
+}
+
+// parseFrameSelection reads ?frames=, a comma-separated list of frame
+// indices from 0. It returns nil when every frame is wanted.
+func parseFrameSelection(raw string) ([]int, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var indices []int
+	for _, part := range strings.Split(raw, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("invalid frames %q, expected a comma-separated list of frame indices from 0", raw)
+		}
+		indices = append(indices, i)
+	}
+	return indices, nil
+}
+
+// selectFrames returns the listed frames in the order given. A frame may be
+// listed more than once.
+func selectFrames[S ~[]E, E any](frames S, indices []int) (S, error) {
+	selected := make(S, len(indices))
+	for i, index := range indices {
+		if index >= len(frames) {
+			return nil, fmt.Errorf("frames: index %d is out of range, the clip has %d frames", index, len(frames))
+		}
+		selected[i] = frames[index]
+	}
+	return selected, nil
+}
+
+// Widest moving-average window, in frames
+const maxSmoothWindow = 99
+
+// parseSmoothing reads ?smooth= and ?window= into a moving-average window
+// in frames, 1 when the clip is not smoothed
+func parseSmoothing(mode, window string) (int, error) {
+	switch mode {
+	case "":
+		return 1, nil
+	case "moving_average":
+	default:
+		return 0, fmt.Errorf("invalid smooth %q, expected moving_average", mode)
+	}
+	if window == "" {
+		return 3, nil
+	}
+	n, err := strconv.Atoi(window)
+	if err != nil || n < 1 || n > maxSmoothWindow || n%2 == 0 {
+		return 0, fmt.Errorf("window must be an odd number of frames between 1 and %d", maxSmoothWindow)
+	}
+	return n, nil
+}
+
+// movingAverage replaces every delta with the mean of the point's deltas
+// over window frames centred on it. The window is cut short at the ends of
+// the clip rather than padded, and frames without the point are skipped.
+func movingAverage(frames ResponsePayload, window int) ResponsePayload {
+	half := window / 2
+	smoothed := make(ResponsePayload, len(frames))
+	for i, frame := range frames {
+		smoothed[i] = make(map[int]Deformation, len(frame))
+		for id := range frame {
+			var sum Deformation
+			count := 0
+			for j := max(0, i-half); j <= min(len(frames)-1, i+half); j++ {
+				if d, ok := frames[j][id]; ok {
+					sum = addDelta(sum, d)
+					count++
+				}
+			}
+			smoothed[i][id] = scaleDelta(sum, 1/float64(count))
+		}
+	}
+	return smoothed
+}
+
+func validateIndexBase(base int) error {
+	if base != 0 && base != 1 {
+		return fmt.Errorf("invalid index_base %d, expected 0 or 1", base)
+	}
+	return nil
+}
+
+// rebaseFrameKeys shifts the control point keys of keyed frames by base so
+// clients indexing from 1 get keys starting at 1. Other outputs are returned
+// unchanged.
+func rebaseFrameKeys(frames any, base int) any {
+	if base == 0 {
+		return frames
+	}
+	switch f := frames.(type) {
+	case ResponsePayload:
+		return ResponsePayload(rebaseKeys(f, base))
+	case SphericalPayload:
+		return SphericalPayload(rebaseKeys(f, base))
+	case velocityPayload:
+		f.InitialPose = rebaseKeys([]map[int]Deformation{f.InitialPose}, base)[0]
+		f.Frames = rebaseKeys(f.Frames, base)
+		return f
+	}
+	return frames
+}
+
+func rebaseKeys[V any](frames []map[int]V, base int) []map[int]V {
+	rebased := make([]map[int]V, len(frames))
+	for i, frame := range frames {
+		rebased[i] = make(map[int]V, len(frame))
+		for id, v := range frame {
+			rebased[i][id+base] = v
+		}
+	}
+	return rebased
+}
+
+func reverseFrames[S ~[]E, E any](frames S) S {
+	reversed := make(S, len(frames))
+	for i, frame := range frames {
+		reversed[len(frames)-1-i] = frame
+	}
+	return reversed
+}
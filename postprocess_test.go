@@ -0,0 +1,322 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestApplyPlayback(t *testing.T) {
+	cases := []struct {
+		mode string
+		want []int
+	}{
+		{mode: "", want: []int{0, 1, 2}},
+		{mode: "forward", want: []int{0, 1, 2}},
+		{mode: "reverse", want: []int{2, 1, 0}},
+		{mode: "pingpong", want: []int{0, 1, 2, 1, 0}},
+	}
+	for _, tc := range cases {
+		// Spare capacity, as a cached clip's slice may have
+		backing := make([]int, 3, 10)
+		frames := backing[:3]
+		copy(frames, []int{0, 1, 2})
+		if got := applyPlayback(frames, tc.mode); !slices.Equal(got, tc.want) {
+			t.Errorf("playback %q gives %v, want %v", tc.mode, got, tc.want)
+		}
+		if spare := backing[3:5]; !slices.Equal(spare, []int{0, 0}) {
+			t.Errorf("playback %q wrote %v past the clip into its backing array", tc.mode, spare)
+		}
+		if !slices.Equal(frames, []int{0, 1, 2}) {
+			t.Errorf("playback %q changed the clip to %v", tc.mode, frames)
+		}
+	}
+}
+
+func TestHoldsPastShortModelOutput(t *testing.T) {
+	fake := setupServer(t, nil)
+	// The model stops after three of the six frames asked for
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		return framesResponse(3, func(f int) map[string]Position {
+			frame := make(map[string]Position)
+			for _, cp := range testRig() {
+				frame[strconv.Itoa(cp.ID)] = Position{X: cp.Position[0] + 0.01*float64(f), Y: cp.Position[1], Z: cp.Position[2]}
+			}
+			return frame
+		}), nil
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 6,
+		Holds: []Hold{{AfterFrame: 1, Count: 2}, {AfterFrame: 4, Count: 3}}}}
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, http.Header{"X-Api-Version": {"2"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	body := decodeBody[struct {
+		Frames   ResponsePayload `json:"frames"`
+		Warnings []string        `json:"warnings"`
+	}](t, rec)
+	if len(body.Frames) != 5 {
+		t.Errorf("clip has %d frames, want 3 plus the 2 the first hold adds", len(body.Frames))
+	}
+	if !slices.ContainsFunc(body.Warnings, func(w string) bool { return strings.Contains(w, "hold after frame 4 was dropped") }) {
+		t.Errorf("no warning for the dropped hold in %q", body.Warnings)
+	}
+}
+
+func TestFreezeAxes(t *testing.T) {
+	frames := ResponsePayload{
+		{0: {DeltaX: 1, DeltaY: 2, DeltaZ: 3}, 1: {DeltaX: -1, DeltaY: -2, DeltaZ: -3}},
+		{0: {DeltaX: 4, DeltaY: 5, DeltaZ: 6}},
+	}
+	got := freezeAxes(frames, []string{"x", "z"})
+	want := ResponsePayload{
+		{0: {DeltaY: 2}, 1: {DeltaY: -2}},
+		{0: {DeltaY: 5}},
+	}
+	for f := range want {
+		for id, d := range want[f] {
+			if got[f][id] != d {
+				t.Errorf("frame %d point %d: %+v, want %+v", f, id, got[f][id], d)
+			}
+		}
+	}
+	if got := freezeAxes(ResponsePayload{{0: {DeltaX: 1}}}, nil); got[0][0].DeltaX != 1 {
+		t.Error("no frozen axes changed the frames")
+	}
+}
+
+func TestValidateFreezeAxes(t *testing.T) {
+	for _, tc := range []struct {
+		axes []string
+		ok   bool
+	}{
+		{nil, true},
+		{[]string{"x", "y", "z"}, true},
+		{[]string{"w"}, false},
+		{[]string{"X"}, false},
+		{[]string{"z", "x", "z"}, false},
+	} {
+		if err := validateFreezeAxes(tc.axes); (err == nil) != tc.ok {
+			t.Errorf("%v: error %v, want ok %v", tc.axes, err, tc.ok)
+		}
+	}
+
+	setupServer(t, nil)
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4, FreezeAxes: []string{"y", "y"}}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("duplicate axes: status %d, want 400", rec.Code)
+	}
+}
+
+func TestSmoothNeighbors(t *testing.T) {
+	neighbors := map[int][]int{1: {2, 3, 4}, 2: {1, 2}, 4: {1}}
+	categories := map[int]string{1: "body", 2: "body", 3: "body", 4: "prop"}
+	frames := ResponsePayload{{1: {DeltaX: 1}, 2: {DeltaY: 1}, 3: {DeltaX: 0.4}, 4: {DeltaX: 10}}}
+
+	got := smoothNeighbors(frames, neighbors, categories, 0.5)
+	want := map[int]Deformation{
+		// Averaged with 2 and 3 but not 4, which is in another category
+		1: {DeltaX: 0.5*1 + 0.5*0.2, DeltaY: 0.25},
+		// Pulled toward 1's unsmoothed delta; listing itself is ignored
+		2: {DeltaX: 0.5, DeltaY: 0.5},
+		// No neighbors of its own
+		3: {DeltaX: 0.4},
+		// Its only neighbor is in another category
+		4: {DeltaX: 10},
+	}
+	for id, w := range want {
+		d := got[0][id]
+		if math.Abs(d.DeltaX-w.DeltaX) > 1e-9 || math.Abs(d.DeltaY-w.DeltaY) > 1e-9 || d.DeltaZ != w.DeltaZ {
+			t.Errorf("point %d: %+v, want %+v", id, d, w)
+		}
+	}
+
+	unchanged := ResponsePayload{{1: {DeltaX: 1}, 2: {}}}
+	if got := smoothNeighbors(unchanged, map[int][]int{1: {2}}, nil, 0); got[0][1].DeltaX != 1 {
+		t.Errorf("factor 0 changed the frames: %+v", got[0])
+	}
+}
+
+func TestValidateNeighborRigidity(t *testing.T) {
+	points := testRig()
+	for _, tc := range []struct {
+		factor    float64
+		neighbors map[int][]int
+		ok        bool
+	}{
+		{0.5, map[int][]int{1: {3}, 2: {4}}, true},
+		{1.5, nil, false},
+		{-0.1, nil, false},
+		{0.5, map[int][]int{9: {0}}, false},
+		{0.5, map[int][]int{0: {9}}, false},
+	} {
+		if err := validateNeighborRigidity(tc.factor, tc.neighbors, points); (err == nil) != tc.ok {
+			t.Errorf("factor %v, neighbors %v: error %v, want ok %v", tc.factor, tc.neighbors, err, tc.ok)
+		}
+	}
+}
+
+func TestRebaseFrameKeys(t *testing.T) {
+	deltas := ResponsePayload{{0: {DeltaX: 1}, 4: {DeltaY: 2}}}
+	got, ok := rebaseFrameKeys(deltas, 1).(ResponsePayload)
+	if !ok || len(got[0]) != 2 || got[0][1].DeltaX != 1 || got[0][5].DeltaY != 2 {
+		t.Errorf("deltas rebased to %v", got)
+	}
+	// The caller's frames are left alone
+	if _, ok := deltas[0][0]; !ok {
+		t.Error("rebasing changed the input frames")
+	}
+
+	spherical := SphericalPayload{{0: {DeltaR: 0.5}}}
+	if got, ok := rebaseFrameKeys(spherical, 1).(SphericalPayload); !ok || got[0][1].DeltaR != 0.5 || len(got[0]) != 1 {
+		t.Errorf("spherical frames rebased to %v", got)
+	}
+
+	velocity := velocityPayload{Units: "per_second", InitialPose: map[int]Deformation{0: {DeltaZ: 3}}, Frames: ResponsePayload{{0: {DeltaZ: 30}}}}
+	v, ok := rebaseFrameKeys(velocity, 1).(velocityPayload)
+	if !ok || v.Units != "per_second" || v.InitialPose[1].DeltaZ != 3 || v.Frames[0][1].DeltaZ != 30 || len(v.Frames[0]) != 1 {
+		t.Errorf("velocity payload rebased to %+v", v)
+	}
+	if _, ok := velocity.InitialPose[0]; !ok {
+		t.Error("rebasing changed the input initial pose")
+	}
+
+	// Base 0 and outputs keyed by something else pass through
+	if got := rebaseFrameKeys(deltas, 0).(ResponsePayload); got[0][0].DeltaX != 1 {
+		t.Errorf("base 0 rebased the frames to %v", got)
+	}
+	clip := UnityClip{FrameRate: 30}
+	if got := rebaseFrameKeys(clip, 1); got.(UnityClip).FrameRate != 30 {
+		t.Errorf("a Unity clip came back as %v", got)
+	}
+}
+
+func TestParseSmoothing(t *testing.T) {
+	for _, tc := range []struct {
+		mode, window string
+		want         int
+		ok           bool
+	}{
+		{"", "", 1, true},
+		// A window without smooth is ignored
+		{"", "5", 1, true},
+		{"moving_average", "", 3, true},
+		{"moving_average", "1", 1, true},
+		{"moving_average", "99", 99, true},
+		{"moving_average", "4", 0, false},
+		{"moving_average", "0", 0, false},
+		{"moving_average", "-3", 0, false},
+		{"moving_average", "101", 0, false},
+		{"moving_average", "three", 0, false},
+		{"gaussian", "3", 0, false},
+	} {
+		got, err := parseSmoothing(tc.mode, tc.window)
+		if got != tc.want || (err == nil) != tc.ok {
+			t.Errorf("parseSmoothing(%q, %q) = %d, %v; want %d, ok %v", tc.mode, tc.window, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestMovingAverage(t *testing.T) {
+	frames := make(ResponsePayload, 5)
+	for i := range frames {
+		frames[i] = map[int]Deformation{0: {DeltaX: float64(i * i)}}
+	}
+	// Point 1 is missing from frame 2
+	for _, i := range []int{0, 1, 3, 4} {
+		frames[i][1] = Deformation{DeltaY: float64(i)}
+	}
+
+	smoothed := movingAverage(frames, 3)
+	// Windows are cut short at the ends: frame 0 averages frames 0 and 1
+	wantX := []float64{0.5, 5.0 / 3, 14.0 / 3, 29.0 / 3, 12.5}
+	for i, want := range wantX {
+		if got := smoothed[i][0].DeltaX; math.Abs(got-want) > 1e-9 {
+			t.Errorf("frame %d: %v, want %v", i, got, want)
+		}
+	}
+	// Frames without the point are skipped, and stay without it
+	if _, ok := smoothed[2][1]; ok {
+		t.Error("smoothing added a point to a frame without it")
+	}
+	if got := smoothed[1][1].DeltaY; got != 0.5 {
+		t.Errorf("frame 1 averages %v over the frames with the point, want 0.5", got)
+	}
+	if got := smoothed[3][1].DeltaY; got != 3.5 {
+		t.Errorf("frame 3 averages %v over the frames with the point, want 3.5", got)
+	}
+
+	// A window of one, or one wider than the clip, is the identity and the
+	// overall mean respectively
+	if one := movingAverage(frames, 1); one[3][0].DeltaX != 9 {
+		t.Errorf("window 1 changed frame 3 to %v", one[3][0].DeltaX)
+	}
+	wide := movingAverage(frames, 99)
+	for i := range wide {
+		if got := wide[i][0].DeltaX; got != 6 {
+			t.Errorf("wide window: frame %d at %v, want the clip mean 6", i, got)
+		}
+	}
+	if frames[0][0].DeltaX != 0 {
+		t.Error("movingAverage modified its input")
+	}
+}
+
+func TestParseFrameSelection(t *testing.T) {
+	if indices, err := parseFrameSelection(""); indices != nil || err != nil {
+		t.Errorf("empty selection: %v, %v, want every frame", indices, err)
+	}
+	if indices, err := parseFrameSelection("3, 0,3"); err != nil || !slices.Equal(indices, []int{3, 0, 3}) {
+		t.Errorf("got %v, %v, want [3 0 3]", indices, err)
+	}
+	for _, raw := range []string{"-1", "1,,2", "one", "1.5"} {
+		if _, err := parseFrameSelection(raw); err == nil {
+			t.Errorf("frames=%s accepted", raw)
+		}
+	}
+}
+
+func TestSelectFrames(t *testing.T) {
+	frames := []string{"a", "b", "c"}
+	// Frames come back in the order listed, repeats included
+	if got, err := selectFrames(frames, []int{2, 0, 2, 2}); err != nil || !slices.Equal(got, []string{"c", "a", "c", "c"}) {
+		t.Errorf("got %v, %v, want [c a c c]", got, err)
+	}
+	if got, err := selectFrames(frames, []int{}); err != nil || len(got) != 0 {
+		t.Errorf("empty selection: %v, %v", got, err)
+	}
+	if _, err := selectFrames(frames, []int{0, 3}); err == nil || !strings.Contains(err.Error(), "index 3 is out of range, the clip has 3 frames") {
+		t.Errorf("out of range index: %v", err)
+	}
+
+	t.Run("requests", func(t *testing.T) {
+		fake := setupServer(t, nil)
+		fake.respond = raiseResponse
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "raise the right hand", Length: 4}}
+		rec := serve(t, http.MethodPost, "/generate-deformations?frames=3,1,3", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		var got []float64
+		for _, frame := range decodeBody[[]map[int]Deformation](t, rec) {
+			got = append(got, frame[2].DeltaY)
+		}
+		if want := []float64{0.12, 0.04, 0.12}; !slices.Equal(got, want) {
+			t.Errorf("hand at %v, want frames 3, 1 and 3 at %v", got, want)
+		}
+
+		// Indices count the frames after playback
+		if rec := serve(t, http.MethodPost, "/generate-deformations?frames=6&playback=pingpong", payload, nil); rec.Code != http.StatusOK {
+			t.Errorf("frame 6 of a pingpong clip: status %d: %s", rec.Code, rec.Body)
+		}
+		if rec := serve(t, http.MethodPost, "/generate-deformations?frames=4", payload, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("frame 4 of 4: status %d, want 400", rec.Code)
+		}
+	})
+}
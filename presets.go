@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// A named post-processing setup registered by the operator, so clients can
+// send postprocess_preset instead of repeating the same options. Its stages
+// run in the order listed, and each stage's params set the request fields
+// that stage reads.
+type PostprocessPreset struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Stages      []PresetStage `json:"stages"`
+}
+
+type PresetStage struct {
+	Stage string `json:"stage"`
+	// Values of the stage's request fields, keyed by their JSON names
+	Params map[string]json.RawMessage `json:"params,omitempty"`
+}
+
+// Request fields each pipeline stage reads, which a preset may set for it
+var stageParams = map[string][]string{
+	"stabilize":  {"stabilize_com", "com_points"},
+	"exaggerate": {"exaggerate", "exaggerate_roles"},
+	"clamp":      {},
+	"smooth":     {"neighbors", "neighbor_rigidity"},
+	"jiggle":     {"jiggle"},
+	"ease":       {"easing"},
+	"freeze":     {"freeze_axes"},
+	"hold":       {"holds"},
+}
+
+// payloadField returns a pointer to the request field of a stage parameter
+func payloadField(p *RequestPayload, name string) any {
+	switch name {
+	case "stabilize_com":
+		return &p.StabilizeCOM
+	case "com_points":
+		return &p.COMPoints
+	case "exaggerate":
+		return &p.Exaggerate
+	case "exaggerate_roles":
+		return &p.ExaggerateRoles
+	case "neighbors":
+		return &p.Neighbors
+	case "neighbor_rigidity":
+		return &p.NeighborRigidity
+	case "jiggle":
+		return &p.Jiggle
+	case "easing":
+		return &p.Easing
+	case "freeze_axes":
+		return &p.FreezeAxes
+	case "holds":
+		return &p.Holds
+	}
+	return nil
+}
+
+// Stages a preset must run in this order when it lists both
+var presetStageOrder = []struct {
+	before, after, reason string
+}{
+	{"stabilize", "clamp", "the centre-of-mass correction can push points past their motion budgets"},
+	{"jiggle", "hold", "jiggle after a hold would move the held frames"},
+	{"ease", "hold", "easing after a hold would move the held frames"},
+}
+
+// Presets available without configuration; configured presets of the same
+// name replace them
+var builtinPresets = []PostprocessPreset{
+	{
+		Name:        "steady",
+		Description: "Removes drift of the whole character and eases the clip in and out of rest",
+		Stages: []PresetStage{
+			{Stage: "stabilize", Params: map[string]json.RawMessage{"stabilize_com": json.RawMessage(`true`)}},
+			{Stage: "clamp"},
+			{Stage: "smooth"},
+			{Stage: "ease", Params: map[string]json.RawMessage{"easing": json.RawMessage(`{"in_frames":3,"out_frames":3,"curve":"sine"}`)}},
+			{Stage: "freeze"},
+			{Stage: "hold"},
+		},
+	},
+}
+
+// loadPresetsFile reads a JSON array of post-processing presets
+func loadPresetsFile(path string) ([]PostprocessPreset, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read postprocess presets file: %w", err)
+	}
+	var presets []PostprocessPreset
+	if err := json.Unmarshal(raw, &presets); err != nil {
+		return nil, fmt.Errorf("parse postprocess presets file %s: %w", path, err)
+	}
+	return presets, nil
+}
+
+// validatePresets describes every malformed preset
+func validatePresets(presets []PostprocessPreset) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	for i, p := range presets {
+		label := fmt.Sprintf("postprocess_presets[%d]", i)
+		if !validSectionName.MatchString(p.Name) {
+			problems = append(problems, label+": name must be 1-64 lowercase letters, digits, '-' or '_'")
+		} else if seen[p.Name] {
+			problems = append(problems, label+": duplicate name "+p.Name)
+		} else {
+			label = fmt.Sprintf("postprocess_presets[%d] (%s)", i, p.Name)
+		}
+		seen[p.Name] = true
+		if len(p.Stages) == 0 {
+			problems = append(problems, label+": must list at least one stage")
+		}
+
+		var names []string
+		for j, s := range p.Stages {
+			params, ok := stageParams[s.Stage]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: stages[%d]: unknown stage %q, expected one of %v", label, j, s.Stage, defaultPipeline))
+				continue
+			}
+			if slices.Contains(names, s.Stage) {
+				problems = append(problems, fmt.Sprintf("%s: stage %q is listed twice", label, s.Stage))
+			}
+			names = append(names, s.Stage)
+			var scratch RequestPayload
+			for _, key := range sortedKeys(s.Params) {
+				if !slices.Contains(params, key) {
+					problems = append(problems, fmt.Sprintf("%s: stage %s has no parameter %q, expected one of %v", label, s.Stage, key, params))
+				} else if err := json.Unmarshal(s.Params[key], payloadField(&scratch, key)); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: stage %s: invalid %s: %v", label, s.Stage, key, err))
+				}
+			}
+			if scratch.Easing != nil {
+				if err := validateEasingOptions(scratch.Easing); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: stage %s: %v", label, s.Stage, err))
+				}
+			}
+			if err := validateFreezeAxes(scratch.FreezeAxes); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: stage %s: %v", label, s.Stage, err))
+			}
+			if err := validateExaggeration(scratch.Exaggerate, scratch.ExaggerateRoles); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: stage %s: %v", label, s.Stage, err))
+			}
+		}
+		for _, rule := range presetStageOrder {
+			before, after := slices.Index(names, rule.before), slices.Index(names, rule.after)
+			if before >= 0 && after >= 0 && before > after {
+				problems = append(problems, fmt.Sprintf("%s: stage %s must come before %s, as %s", label, rule.before, rule.after, rule.reason))
+			}
+		}
+	}
+	return problems
+}
+
+// postprocessPresets returns the configured presets followed by the built-in
+// ones they do not replace
+func postprocessPresets() []PostprocessPreset {
+	presets := slices.Clone(cfg.PostprocessPresets)
+	for _, p := range builtinPresets {
+		if !slices.ContainsFunc(presets, func(q PostprocessPreset) bool { return q.Name == p.Name }) {
+			presets = append(presets, p)
+		}
+	}
+	return presets
+}
+
+// applyPreset sets the request's pipeline to the stages of its preset and
+// fills in their parameters. Fields the request sets itself take precedence
+// over the preset's values.
+func applyPreset(payload *RequestPayload) error {
+	if payload.PostprocessPreset == "" {
+		return nil
+	}
+	presets := postprocessPresets()
+	i := slices.IndexFunc(presets, func(p PostprocessPreset) bool { return p.Name == payload.PostprocessPreset })
+	if i < 0 {
+		var names []string
+		for _, p := range presets {
+			names = append(names, p.Name)
+		}
+		return newAPIError(http.StatusBadRequest, "Unknown postprocess_preset %q, expected one of %s", payload.PostprocessPreset, strings.Join(names, ", ")).
+			withCode("unknown_preset").
+			withDetails(map[string][]string{"available_presets": names})
+	}
+	if len(payload.Pipeline) > 0 {
+		return newAPIError(http.StatusBadRequest, "pipeline cannot be combined with postprocess_preset, which sets the stages itself")
+	}
+
+	var names []string
+	for _, s := range presets[i].Stages {
+		names = append(names, s.Stage)
+		for key, raw := range s.Params {
+			field := payloadField(payload, key)
+			if !reflect.ValueOf(field).Elem().IsZero() {
+				continue
+			}
+			// Checked when the configuration was loaded
+			_ = json.Unmarshal(raw, field)
+		}
+	}
+	payload.Pipeline = names
+	return nil
+}
+
+// A pipeline stage and the parameters it runs with
+type stageReport struct {
+	Stage  string         `json:"stage"`
+	Params map[string]any `json:"params,omitempty"`
+}
+
+// The post-processing a request runs, in meta.postprocess and dry runs
+type postprocessReport struct {
+	Preset string        `json:"preset,omitempty"`
+	Stages []stageReport `json:"stages"`
+}
+
+// describeStages lists the named stages with the values of their parameters
+// in the request
+func describeStages(names []string, payload RequestPayload) []stageReport {
+	stages := make([]stageReport, len(names))
+	for i, name := range names {
+		stages[i] = stageReport{Stage: name}
+		for _, key := range stageParams[name] {
+			if stages[i].Params == nil {
+				stages[i].Params = make(map[string]any)
+			}
+			stages[i].Params[key] = payloadField(&payload, key)
+		}
+	}
+	return stages
+}
+
+// describePipeline reports the stages a validated request runs
+func describePipeline(payload RequestPayload) *postprocessReport {
+	names := payload.Pipeline
+	if len(names) == 0 {
+		names = defaultPipeline
+	}
+	return &postprocessReport{Preset: payload.PostprocessPreset, Stages: describeStages(names, payload)}
+}
+
+// A preset as listed by /presets, with every stage parameter resolved
+type presetInfo struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Builtin     bool          `json:"builtin"`
+	Stages      []stageReport `json:"stages"`
+}
+
+// Handler for the /presets endpoint
+func listPresets(w http.ResponseWriter, r *http.Request) {
+	presets := []presetInfo{}
+	for _, p := range postprocessPresets() {
+		scratch := RequestPayload{RequestPayload: api.RequestPayload{PostprocessPreset: p.Name}}
+		_ = applyPreset(&scratch)
+		presets = append(presets, presetInfo{
+			Name:        p.Name,
+			Description: p.Description,
+			Builtin:     !slices.ContainsFunc(cfg.PostprocessPresets, func(q PostprocessPreset) bool { return q.Name == p.Name }),
+			Stages:      describeStages(scratch.Pipeline, scratch),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"presets": presets}); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
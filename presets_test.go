@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// studioPreset smooths strongly and eases in over five frames
+var studioPreset = PostprocessPreset{
+	Name: "studio",
+	Stages: []PresetStage{
+		{Stage: "smooth", Params: map[string]json.RawMessage{"neighbor_rigidity": json.RawMessage(`0.5`)}},
+		{Stage: "ease", Params: map[string]json.RawMessage{"easing": json.RawMessage(`{"in_frames":5,"out_frames":0}`)}},
+		{Stage: "hold"},
+	},
+}
+
+func TestValidatePresets(t *testing.T) {
+	if problems := validatePresets(append([]PostprocessPreset{studioPreset}, builtinPresets...)); problems != nil {
+		t.Errorf("valid presets reported %q", problems)
+	}
+
+	stage := func(name, key, value string) PresetStage {
+		s := PresetStage{Stage: name}
+		if key != "" {
+			s.Params = map[string]json.RawMessage{key: json.RawMessage(value)}
+		}
+		return s
+	}
+	for want, presets := range map[string][]PostprocessPreset{
+		"name must be":                     {{Name: "Studio Look", Stages: []PresetStage{stage("clamp", "", "")}}},
+		"duplicate name studio":            {studioPreset, studioPreset},
+		"at least one stage":               {{Name: "empty"}},
+		`unknown stage "blur"`:             {{Name: "p", Stages: []PresetStage{stage("blur", "", "")}}},
+		`stage "clamp" is listed twice`:    {{Name: "p", Stages: []PresetStage{stage("clamp", "", ""), stage("clamp", "", "")}}},
+		`has no parameter "easing"`:        {{Name: "p", Stages: []PresetStage{stage("smooth", "easing", `{}`)}}},
+		"invalid neighbor_rigidity":        {{Name: "p", Stages: []PresetStage{stage("smooth", "neighbor_rigidity", `"high"`)}}},
+		"stage ease":                       {{Name: "p", Stages: []PresetStage{stage("ease", "easing", `{"in_frames":-1}`)}}},
+		"stage ease must come before hold": {{Name: "p", Stages: []PresetStage{stage("hold", "", ""), stage("ease", "", "")}}},
+	} {
+		problems := validatePresets(presets)
+		if !slices.ContainsFunc(problems, func(p string) bool { return strings.Contains(p, want) }) {
+			t.Errorf("problems %q, want one mentioning %q", problems, want)
+		}
+	}
+}
+
+func TestApplyPreset(t *testing.T) {
+	setupServer(t, func(c *Config) { c.PostprocessPresets = []PostprocessPreset{studioPreset} })
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{
+		PostprocessPreset: "studio",
+		Easing:            &EasingOptions{Easing: api.Easing{InFrames: 2, OutFrames: 2}},
+	}}
+	if err := applyPreset(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"smooth", "ease", "hold"}; !slices.Equal(payload.Pipeline, want) {
+		t.Errorf("pipeline %v, want %v", payload.Pipeline, want)
+	}
+	if payload.NeighborRigidity != 0.5 {
+		t.Errorf("neighbor_rigidity %v, want the preset's 0.5", payload.NeighborRigidity)
+	}
+	// The request's own easing takes precedence
+	if payload.Easing.InFrames != 2 {
+		t.Errorf("easing %+v, want the request's 2 frames kept", payload.Easing)
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4, PostprocessPreset: "cinematic"}}
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+		body := decodeBody[errorResponse](t, rec)
+		if rec.Code != http.StatusBadRequest || body.Error.Code != "unknown_preset" {
+			t.Fatalf("status %d, code %q, want 400 unknown_preset", rec.Code, body.Error.Code)
+		}
+		details, _ := body.Error.Details.(map[string]any)
+		if available, _ := details["available_presets"].([]any); len(available) != 2 || available[0] != "studio" || available[1] != "steady" {
+			t.Errorf("available_presets %v, want [studio steady]", details["available_presets"])
+		}
+	})
+
+	t.Run("with pipeline", func(t *testing.T) {
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4, PostprocessPreset: "studio", Pipeline: []string{"clamp"}}}
+		if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", rec.Code)
+		}
+	})
+}
+
+func TestPresetOnRequests(t *testing.T) {
+	setupServer(t, func(c *Config) { c.PostprocessPresets = []PostprocessPreset{studioPreset} })
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 8, PostprocessPreset: "studio"}}
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	report := decodeBody[ResponseEnvelope](t, rec).Meta.Postprocess
+	if report == nil || report.Preset != "studio" || len(report.Stages) != 3 || report.Stages[1].Stage != "ease" {
+		t.Fatalf("meta.postprocess %+v, want the studio stages", report)
+	}
+	easing, _ := report.Stages[1].Params["easing"].(map[string]any)
+	if easing["in_frames"] != 5.0 {
+		t.Errorf("ease params %v, want the preset's 5 frames", report.Stages[1].Params)
+	}
+}
+
+func TestListPresets(t *testing.T) {
+	type listing struct {
+		Presets []presetInfo `json:"presets"`
+	}
+	steady := PostprocessPreset{Name: "steady", Stages: []PresetStage{{Stage: "clamp"}}}
+	setupServer(t, func(c *Config) { c.PostprocessPresets = []PostprocessPreset{studioPreset, steady} })
+
+	rec := serve(t, http.MethodGet, "/presets", nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	presets := decodeBody[listing](t, rec).Presets
+	if len(presets) != 2 || presets[0].Name != "studio" || presets[1].Name != "steady" {
+		t.Fatalf("presets %+v, want studio then the configured steady", presets)
+	}
+	// The configured steady replaces the built-in one
+	if presets[1].Builtin || len(presets[1].Stages) != 1 {
+		t.Errorf("steady %+v, want the configured single clamp stage", presets[1])
+	}
+	if got := presets[0].Stages[0].Params["neighbor_rigidity"]; got != 0.5 {
+		t.Errorf("smooth params %v, want neighbor_rigidity 0.5", presets[0].Stages[0].Params)
+	}
+
+	setupServer(t, nil)
+	presets = decodeBody[listing](t, serve(t, http.MethodGet, "/presets", nil, nil)).Presets
+	if len(presets) != 1 || presets[0].Name != "steady" || !presets[0].Builtin || len(presets[0].Stages) != 6 {
+		t.Errorf("presets %+v, want only the built-in steady", presets)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Motion budgets: how far each control point may move from its rest
+// position, as a fraction of the character's height. More specific body
+// parts come first since roles such as "left hand" also contain "left".
+var roleBudgetFractions = []struct {
+	keywords []string
+	fraction float64
+}{
+	{[]string{"finger", "thumb", "hand", "wrist"}, 0.35},
+	{[]string{"elbow", "forearm"}, 0.3},
+	{[]string{"toe", "foot", "paw", "hoof", "ankle", "heel"}, 0.3},
+	{[]string{"tail"}, 0.3},
+	{[]string{"knee", "shin", "calf"}, 0.25},
+	{[]string{"arm"}, 0.25},
+	{[]string{"leg", "thigh"}, 0.2},
+	{[]string{"head", "face", "jaw", "eye", "ear", "nose", "mouth", "brow", "lip"}, 0.12},
+	{[]string{"shoulder", "clavicle", "neck"}, 0.12},
+	{[]string{"hip", "pelvis", "root", "waist"}, 0.1},
+	{[]string{"spine", "chest", "torso", "back", "body", "belly", "abdomen"}, 0.1},
+}
+
+// Fraction used for roles that match no known body part
+const defaultBudgetFraction = 0.2
+
+func validateConstraints(c *MotionConstraints, points []ControlPoint) error {
+	if c == nil {
+		return nil
+	}
+	known := make(map[int]bool, len(points))
+	for _, cp := range points {
+		known[cp.ID] = true
+	}
+	for id, budget := range c.MotionBudgets {
+		if !known[id] {
+			return fmt.Errorf("constraints.motion_budgets references unknown control point %d", id)
+		}
+		if !(budget > 0) || math.IsInf(budget, 0) {
+			return fmt.Errorf("constraints.motion_budgets for point %d must be a positive number", id)
+		}
+	}
+	for role, budget := range c.RoleBudgets {
+		if !(budget > 0) || math.IsInf(budget, 0) {
+			return fmt.Errorf("constraints.role_budgets for %q must be a positive number", role)
+		}
+	}
+	return nil
+}
+
+// characterHeight is the rig's vertical extent, falling back to its
+// bounding-box diagonal for flat rigs and to one unit for a single point
+func characterHeight(points []ControlPoint) float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, cp := range points {
+		if len(cp.Position) < 3 {
+			continue
+		}
+		lo = math.Min(lo, cp.Position[1])
+		hi = math.Max(hi, cp.Position[1])
+	}
+	if hi > lo {
+		return hi - lo
+	}
+	return math.Max(rigDiagonal(points), 1)
+}
+
+func roleBudgetFraction(role string) float64 {
+	role = strings.ToLower(role)
+	for _, r := range roleBudgetFractions {
+		for _, k := range r.keywords {
+			if strings.Contains(role, k) {
+				return r.fraction
+			}
+		}
+	}
+	return defaultBudgetFraction
+}
+
+// motionBudgets computes each control point's maximum displacement from its
+// category or role and the rig's height, applying any client overrides. It depends only
+// on its arguments.
+func motionBudgets(points []ControlPoint, c *MotionConstraints) map[int]float64 {
+	roles := make(map[int]string, len(points))
+	for _, cp := range points {
+		roles[cp.ID] = strings.ToLower(cp.Role)
+	}
+	return constrainBudgets(priorBudgets(points), roles, c)
+}
+
+// priorBudgets computes each control point's budget from its category or
+// role and the rig's height alone
+func priorBudgets(points []ControlPoint) map[int]float64 {
+	height := characterHeight(points)
+	budgets := make(map[int]float64, len(points))
+	for _, cp := range points {
+		budget, ok := categoryBudget(cp, height)
+		if !ok {
+			budget = roundDelta(roleBudgetFraction(cp.Role) * height)
+		}
+		budgets[cp.ID] = budget
+	}
+	return budgets
+}
+
+// constrainBudgets applies the client's overrides to budgets in place, given
+// the lowercased role of each point
+func constrainBudgets(budgets map[int]float64, roles map[int]string, c *MotionConstraints) map[int]float64 {
+	if c == nil {
+		return budgets
+	}
+	if len(c.RoleBudgets) > 0 {
+		roleOverrides := make(map[string]float64, len(c.RoleBudgets))
+		for role, budget := range c.RoleBudgets {
+			roleOverrides[strings.ToLower(role)] = budget
+		}
+		for id, role := range roles {
+			if budget, ok := roleOverrides[role]; ok {
+				budgets[id] = budget
+			}
+		}
+	}
+	for id, budget := range c.MotionBudgets {
+		budgets[id] = budget
+	}
+	return budgets
+}
+
+// clampMotion shortens any delta longer than its point's budget, keeping its
+// direction, and returns the IDs of the points that were clamped
+func clampMotion(frames ResponsePayload, budgets map[int]float64) (ResponsePayload, []int) {
+	clamped := make(map[int]bool)
+	for _, frame := range frames {
+		for id, d := range frame {
+			budget, ok := budgets[id]
+			if !ok {
+				continue
+			}
+			length := math.Sqrt(d.DeltaX*d.DeltaX + d.DeltaY*d.DeltaY + d.DeltaZ*d.DeltaZ)
+			if length > budget {
+				frame[id] = scaleDelta(d, budget/length)
+				clamped[id] = true
+			}
+		}
+	}
+	return frames, sortedKeys(clamped)
+}
+
+func validateMaxRange(r float64) error {
+	if r < 0 || math.IsInf(r, 0) || math.IsNaN(r) {
+		return fmt.Errorf("max_range must be a non-negative number")
+	}
+	return nil
+}
+
+// clampToRange keeps every coordinate within r of the point's original
+// position along each axis, and returns the IDs of the points that strayed
+func clampToRange(frames []map[int]Position, original map[int][]float64, r float64) ([]map[int]Position, []int) {
+	clamped := make(map[int]bool)
+	result := make([]map[int]Position, len(frames))
+	for i, frame := range frames {
+		result[i] = make(map[int]Position, len(frame))
+		for id, p := range frame {
+			if o := original[id]; len(o) >= 3 {
+				limited := Position{
+					X: min(max(p.X, o[0]-r), o[0]+r),
+					Y: min(max(p.Y, o[1]-r), o[1]+r),
+					Z: min(max(p.Z, o[2]-r), o[2]+r),
+				}
+				if limited != p {
+					clamped[id] = true
+				}
+				p = limited
+			}
+			result[i][id] = p
+		}
+	}
+	return result, sortedKeys(clamped)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestPriorBudgets(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		points []ControlPoint
+		want   map[int]float64
+	}{
+		{
+			// Two units tall, so budgets are twice the role fractions
+			name: "humanoid",
+			points: []ControlPoint{
+				{ID: 0, Role: "head", Position: []float64{0, 2, 0}},
+				{ID: 1, Role: "Left Hand", Position: []float64{0.6, 1.3, 0}},
+				{ID: 2, Role: "right elbow", Position: []float64{-0.5, 1.5, 0}},
+				{ID: 3, Role: "pelvis", Position: []float64{0, 1, 0}},
+				{ID: 4, Role: "left foot", Position: []float64{0.2, 0, 0}},
+				{ID: 5, Role: "antenna", Position: []float64{0, 1.9, 0}},
+				{ID: 6, Role: "left brow", Category: categoryFace, Position: []float64{0.05, 1.95, 0.1}},
+				{ID: 7, Role: "sword tip", Category: categoryProp, Position: []float64{1, 1.2, 0}},
+			},
+			want: map[int]float64{0: 0.24, 1: 0.7, 2: 0.6, 3: 0.2, 4: 0.6, 5: 0.4, 6: 0.02, 7: 1},
+		},
+		{
+			name: "quadruped",
+			points: []ControlPoint{
+				{ID: 10, Role: "head", Position: []float64{1, 1, 0}},
+				{ID: 11, Role: "spine", Position: []float64{0, 0.8, 0}},
+				{ID: 12, Role: "front left paw", Position: []float64{0.6, 0, 0.2}},
+				{ID: 13, Role: "back right paw", Position: []float64{-0.6, 0, -0.2}},
+				{ID: 14, Role: "hind left knee", Position: []float64{-0.6, 0.4, 0.2}},
+				{ID: 15, Role: "tail tip", Position: []float64{-1.2, 0.9, 0}},
+			},
+			want: map[int]float64{10: 0.12, 11: 0.1, 12: 0.3, 13: 0.3, 14: 0.25, 15: 0.3},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := slices.Clone(tc.points)
+			got := motionBudgets(tc.points, nil)
+			if !maps.Equal(got, tc.want) {
+				t.Errorf("budgets %v, want %v", got, tc.want)
+			}
+			// A pure function of the points
+			if again := motionBudgets(tc.points, nil); !maps.Equal(again, got) || !slices.EqualFunc(before, tc.points, func(a, b ControlPoint) bool { return a.ID == b.ID && a.Role == b.Role }) {
+				t.Error("budgets changed between calls or changed the points")
+			}
+		})
+	}
+}
+
+func TestBudgetOverrides(t *testing.T) {
+	points := testRig()
+	got := motionBudgets(points, &MotionConstraints{
+		RoleBudgets:   map[string]float64{"LEFT HAND": 0.05, "right hand": 0.07},
+		MotionBudgets: map[int]float64{2: 0.01, 0: 0.5},
+	})
+	// Point budgets win over role budgets, which match case-insensitively
+	if got[1] != 0.05 || got[2] != 0.01 || got[0] != 0.5 || got[3] != roundDelta(0.3*1.7) {
+		t.Errorf("budgets %v", got)
+	}
+
+	for _, c := range []*MotionConstraints{
+		{MotionBudgets: map[int]float64{9: 0.1}},
+		{MotionBudgets: map[int]float64{0: 0}},
+		{MotionBudgets: map[int]float64{0: math.Inf(1)}},
+		{RoleBudgets: map[string]float64{"head": -1}},
+	} {
+		if err := validateConstraints(c, points); err == nil {
+			t.Errorf("constraints %+v accepted", c)
+		}
+	}
+}
+
+func TestClampMotion(t *testing.T) {
+	frames := ResponsePayload{{1: {DeltaX: 0.3, DeltaY: 0.4}, 2: {DeltaZ: 0.05}, 3: {DeltaX: 9}}}
+	got, clamped := clampMotion(frames, map[int]float64{1: 0.1, 2: 0.1})
+	// Shortened along the same direction
+	if d := got[0][1]; math.Abs(d.DeltaX-0.06) > 1e-9 || math.Abs(d.DeltaY-0.08) > 1e-9 {
+		t.Errorf("point 1 clamped to %+v, want (0.06, 0.08, 0)", d)
+	}
+	if got[0][2].DeltaZ != 0.05 || got[0][3].DeltaX != 9 {
+		t.Errorf("points within or without a budget changed: %+v", got[0])
+	}
+	if !slices.Equal(clamped, []int{1}) {
+		t.Errorf("clamped %v, want [1]", clamped)
+	}
+}
+
+func TestBudgetsOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	payload := RequestPayload{RequestPayload: api.RequestPayload{
+		ControlPoints: testRig(),
+		Prompt:        "raise the right hand high",
+		Length:        3,
+		Constraints:   &MotionConstraints{MotionBudgets: map[int]float64{2: 0.1}},
+	}}
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	body := decodeBody[struct {
+		Frames []map[string]Deformation `json:"frames"`
+		Meta   generationMeta           `json:"meta"`
+	}](t, rec)
+	if got := body.Frames[2]["2"].DeltaY; got != 0.1 {
+		t.Errorf("right hand rose %v, want it clamped to 0.1", got)
+	}
+	if !slices.ContainsFunc(body.Meta.Warnings, func(w string) bool { return strings.Contains(w, "[2] exceeded their motion budgets") }) {
+		t.Errorf("warnings %q lack the clamp", body.Meta.Warnings)
+	}
+
+	// The model is shown the same budgets
+	system := fake.requests[0].Messages[0].Content
+	for _, line := range []string{`- 2, "right hand": 0.1`, `- 0, "head": 0.2`} {
+		if !strings.Contains(system, line) {
+			t.Errorf("system prompt lacks the budget line %s", line)
+		}
+	}
+}
+
+func TestClampToRange(t *testing.T) {
+	original := map[int][]float64{0: {0, 1, 0}, 1: {1, 0, 0}}
+	frames := []map[int]Position{
+		{0: {X: 0.05, Y: 1.05}, 1: {X: 1}},
+		{0: {X: 0.3, Y: 0.5, Z: -0.05}, 1: {X: 1}},
+	}
+	got, clamped := clampToRange(frames, original, 0.1)
+	if got[0][0] != frames[0][0] {
+		t.Errorf("in-range position moved to %+v", got[0][0])
+	}
+	// Each axis is limited on its own
+	if want := (Position{X: 0.1, Y: 0.9, Z: -0.05}); got[1][0] != want {
+		t.Errorf("clamped to %+v, want %+v", got[1][0], want)
+	}
+	if !slices.Equal(clamped, []int{0}) {
+		t.Errorf("clamped %v, want [0]", clamped)
+	}
+	if frames[1][0].X != 0.3 {
+		t.Error("clampToRange modified its input")
+	}
+}
+
+func TestMaxRangeOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	payload := RequestPayload{RequestPayload: api.RequestPayload{
+		ControlPoints: testRig(),
+		Prompt:        "raise the right hand high",
+		Length:        3,
+		MaxRange:      0.15,
+		// A generous budget, so only max_range limits the hand
+		Constraints: &MotionConstraints{MotionBudgets: map[int]float64{2: 1}},
+	}}
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	body := decodeBody[struct {
+		Frames []map[string]Deformation `json:"frames"`
+		Meta   generationMeta           `json:"meta"`
+	}](t, rec)
+	for i, frame := range body.Frames[1:] {
+		if got := frame["2"].DeltaY; got != 0.15 {
+			t.Errorf("frame %d: right hand rose %v, want it clamped to 0.15", i+1, got)
+		}
+	}
+	if !slices.ContainsFunc(body.Meta.Warnings, func(w string) bool { return strings.Contains(w, "Control points [2] strayed more than max_range") }) {
+		t.Errorf("warnings %q lack the clamp", body.Meta.Warnings)
+	}
+
+	payload.MaxRange = -1
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative max_range: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// A named upstream account requests can be routed through, e.g. to bill
+// prototype and production traffic to different OpenAI projects
+type UpstreamProfile struct {
+	Name         string `json:"name"`
+	APIKey       string `json:"api_key"`
+	BaseURL      string `json:"base_url,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	Project      string `json:"project,omitempty"`
+	// Model used when a request names none; default_model when empty
+	DefaultModel string `json:"default_model,omitempty"`
+	// Client API keys allowed to select the profile; empty allows everyone
+	AllowedKeys []string `json:"allowed_keys,omitempty"`
+	// Set when APIKey is the caller's own key from X-OpenAI-Key
+	clientKey bool
+}
+
+// Name of the profile built from openai_api_key and default_model
+const defaultProfileName = "default"
+
+var validProfileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+func validateProfiles(profiles []UpstreamProfile, defaultProfile string, allowedModels []string, keyRequired bool) []string {
+	var problems []string
+	seen := make(map[string]bool, len(profiles))
+	for i, p := range profiles {
+		if !validProfileName.MatchString(p.Name) {
+			problems = append(problems, fmt.Sprintf("upstream_profiles[%d]: name %q must be lowercase letters, digits, '-' or '_'", i, p.Name))
+		}
+		if seen[p.Name] {
+			problems = append(problems, fmt.Sprintf("upstream_profiles[%d]: duplicate name %q", i, p.Name))
+		}
+		seen[p.Name] = true
+		if keyRequired && p.APIKey == "" {
+			problems = append(problems, fmt.Sprintf("upstream_profiles[%d]: %s needs an api_key", i, p.Name))
+		}
+		if p.DefaultModel != "" && !slices.Contains(allowedModels, p.DefaultModel) {
+			problems = append(problems, fmt.Sprintf("upstream_profiles[%d]: default_model %q is not in allowed_models", i, p.DefaultModel))
+		}
+	}
+	if defaultProfile != defaultProfileName && !seen[defaultProfile] {
+		problems = append(problems, fmt.Sprintf("default_profile: %q is not a configured profile", defaultProfile))
+	}
+	return problems
+}
+
+// upstreamProfiles returns every profile: the configured ones plus the one
+// built from openai_api_key, unless a configured profile takes its name
+func upstreamProfiles() []UpstreamProfile {
+	profiles := slices.Clone(cfg.UpstreamProfiles)
+	if !slices.ContainsFunc(profiles, func(p UpstreamProfile) bool { return p.Name == defaultProfileName }) {
+		profiles = append(profiles, UpstreamProfile{Name: defaultProfileName, APIKey: cfg.OpenAIAPIKey})
+	}
+	return profiles
+}
+
+// permits reports whether the client behind clientKey may use the profile
+func (p UpstreamProfile) permits(clientKey string) bool {
+	if len(p.AllowedKeys) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(p.AllowedKeys, func(key string) bool { return hashClientKey(key) == clientKey })
+}
+
+// resolveProfile picks the profile a request names, or the default one, and
+// checks the caller may use it
+func resolveProfile(ctx context.Context, name string) (UpstreamProfile, error) {
+	if name == "" {
+		name = cfg.DefaultProfile
+	}
+	clientKey := clientKeyFrom(ctx)
+	var allowed []string
+	var selected *UpstreamProfile
+	for _, p := range upstreamProfiles() {
+		if !p.permits(clientKey) {
+			continue
+		}
+		allowed = append(allowed, p.Name)
+		if p.Name == name {
+			selected = &p
+		}
+	}
+	if selected == nil {
+		return UpstreamProfile{}, newAPIError(http.StatusForbidden, "Profile %q is unknown or not available to this API key", name).
+			withCode("profile_forbidden").
+			withDetails(map[string]any{"allowed_profiles": allowed})
+	}
+	return *selected, nil
+}
+
+// applyProfile resolves the request's profile, recording its name in the
+// payload and filling in its default model when the request names none
+func applyProfile(ctx context.Context, payload *RequestPayload) (UpstreamProfile, error) {
+	profile, err := resolveProfile(ctx, payload.Profile)
+	if err != nil {
+		return profile, err
+	}
+	payload.Profile = profile.Name
+	if payload.Model == "" {
+		payload.Model = profile.DefaultModel
+	}
+	return profile, nil
+}
+
+// Upstream clients, one per profile, created on first use and kept for the
+// life of the configuration
+var profileClients = struct {
+	mu      sync.Mutex
+	clients map[string]chatClient
+}{clients: make(map[string]chatClient)}
+
+func clientForProfile(p UpstreamProfile) chatClient {
+	profileClients.mu.Lock()
+	defer profileClients.mu.Unlock()
+	client, ok := profileClients.clients[p.Name]
+	if !ok {
+		client = newChatClient(p)
+		profileClients.clients[p.Name] = client
+	}
+	return client
+}
+
+// upstreamClient returns the client a request calls the model through: the
+// profile's shared one, or a client of its own when the caller brought an
+// OpenAI key
+func upstreamClient(ctx context.Context, p UpstreamProfile) chatClient {
+	if key := upstreamKeyFrom(ctx); key != "" {
+		p.APIKey = key
+		p.clientKey = true
+		return newChatClient(p)
+	}
+	return clientForProfile(p)
+}
+
+func resetProfileClients() {
+	profileClients.mu.Lock()
+	defer profileClients.mu.Unlock()
+	clear(profileClients.clients)
+	resetBudgets()
+}
+
+// openAIConfig builds the go-openai configuration of a profile
+func openAIConfig(p UpstreamProfile) openai.ClientConfig {
+	config := openai.DefaultConfig(p.APIKey)
+	if p.BaseURL != "" {
+		config.BaseURL = p.BaseURL
+	}
+	config.OrgID = p.Organization
+	var doer openai.HTTPDoer = http.DefaultClient
+	if p.Project != "" {
+		doer = projectDoer{next: doer, project: p.Project}
+	}
+	// A caller's own key has its own rate limits, which say nothing about ours
+	budget := budgetFor(p.Name)
+	if p.clientKey {
+		budget = newTokenBudget()
+	}
+	config.HTTPClient = rateLimitDoer{next: doer, budget: budget}
+	return config
+}
+
+type upstreamKeyKey struct{}
+
+func withUpstreamKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, upstreamKeyKey{}, key)
+}
+
+// upstreamKeyFrom returns the OpenAI key the caller sent, or "" to use the
+// profile's
+func upstreamKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(upstreamKeyKey{}).(string)
+	return key
+}
+
+// clientUpstreamKey picks up the caller's own OpenAI key from X-OpenAI-Key
+// when allow_client_key is set. Otherwise requests carrying one are refused
+// instead of silently running on the server's key. The key only ever lives
+// in the request context; it is never logged or stored.
+func clientUpstreamKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get("X-OpenAI-Key"))
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !cfg.AllowClientKey {
+			writeError(w, newAPIError(http.StatusBadRequest, "X-OpenAI-Key is not accepted by this server").withCode("client_key_disabled"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withUpstreamKey(r.Context(), key)))
+	})
+}
+
+// projectDoer adds the OpenAI-Project header, which go-openai has no setting for
+type projectDoer struct {
+	next    openai.HTTPDoer
+	project string
+}
+
+func (d projectDoer) Do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("OpenAI-Project", d.project)
+	return d.next.Do(req)
+}
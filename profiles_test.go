@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestClientKeyRequiresAllowClientKey(t *testing.T) {
+	fake := setupServer(t, nil)
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+	for _, target := range []string{"/generate-deformations", "/jobs"} {
+		rec := serve(t, http.MethodPost, target, payload, http.Header{"X-Openai-Key": {"sk-caller"}})
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want 400: %s", target, rec.Code, rec.Body)
+		}
+		if body := decodeBody[errorResponse](t, rec); body.Error.Code != "client_key_disabled" {
+			t.Errorf("%s: error code %q, want client_key_disabled", target, body.Error.Code)
+		}
+	}
+	waitForBackgroundWork()
+	if fake.calls() != 0 {
+		t.Error("a refused request reached the upstream")
+	}
+}
+
+func TestClientKeyBypassesCache(t *testing.T) {
+	var keys []string
+	fake := setupServer(t, func(c *Config) { c.AllowClientKey = true })
+	newChatClient = func(p UpstreamProfile) chatClient {
+		keys = append(keys, p.APIKey)
+		return fake
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+
+	for _, key := range []string{"sk-first", "sk-second"} {
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, http.Header{"X-Openai-Key": {key}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("X-Cache"); got != "BYPASS" {
+			t.Errorf("X-Cache %q, want BYPASS", got)
+		}
+	}
+	if fake.calls() != 2 {
+		t.Errorf("upstream called %d times, want once per caller", fake.calls())
+	}
+	if want := []string{"sk-first", "sk-second"}; !slices.Equal(keys, want) {
+		t.Errorf("clients built with keys %v, want %v", keys, want)
+	}
+	if responses.size() != 0 {
+		t.Errorf("cache holds %d entries, want none", responses.size())
+	}
+
+	// The server's own key still shares results
+	serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("server key: X-Cache %q, want HIT", rec.Header().Get("X-Cache"))
+	}
+}
+
+func TestClientKeyUsedUpstream(t *testing.T) {
+	logs := captureLog(t)
+	var mu sync.Mutex
+	var keys []string
+	fake := setupServer(t, func(c *Config) { c.AllowClientKey = true })
+	newChatClient = func(p UpstreamProfile) chatClient {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, p.APIKey)
+		return fake
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+	header := http.Header{"X-Openai-Key": {" sk-caller "}}
+
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, header); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	// Jobs carry the key into the background
+	rec := serve(t, http.MethodPost, "/jobs", payload, header)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("job: status %d: %s", rec.Code, rec.Body)
+	}
+	id := decodeBody[Job](t, rec).ID
+	if job := decodeBody[Job](t, serve(t, http.MethodGet, "/jobs/"+id+"?wait=5", nil, nil)); job.Status != jobDone {
+		t.Fatalf("job %s: %s", job.Status, job.Error)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"sk-caller", "sk-caller"}; !slices.Equal(keys, want) {
+		t.Errorf("clients built with keys %v, want %v", keys, want)
+	}
+	if strings.Contains(logs.String(), "sk-caller") {
+		t.Error("the caller's key was logged")
+	}
+}
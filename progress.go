@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Progress of one generation carried through the context. Like
+// requestTimings, a nil *progressTracker is valid and reports nothing.
+type progressTracker struct {
+	mu       sync.Mutex
+	hook     func(ProgressUpdate)
+	update   ProgressUpdate
+	upstream time.Time
+}
+
+type progressKey struct{}
+
+// withProgress makes the pipeline report its progress to hook. The hook is
+// called synchronously from the generating goroutines and must be quick.
+func withProgress(ctx context.Context, hook func(ProgressUpdate)) context.Context {
+	return context.WithValue(ctx, progressKey{}, &progressTracker{hook: hook})
+}
+
+func progressFrom(ctx context.Context) *progressTracker {
+	p, _ := ctx.Value(progressKey{}).(*progressTracker)
+	return p
+}
+
+// report passes the current state to the hook; callers hold p.mu
+func (p *progressTracker) report() {
+	p.hook(p.update)
+}
+
+func (p *progressTracker) stage(name string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.update.Stage = name
+	p.report()
+}
+
+// expectChunks announces n more upstream calls
+func (p *progressTracker) expectChunks(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.upstream.IsZero() {
+		p.upstream = time.Now()
+	}
+	p.update.ChunksTotal += n
+	p.report()
+}
+
+// chunkDone records a finished upstream call and re-estimates the time left.
+// Chunks run concurrently, so the estimate scales the time since the first
+// chunk started rather than summing chunk durations.
+func (p *progressTracker) chunkDone(frames, tokens int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.update.ChunksCompleted++
+	p.update.FramesParsed += frames
+	p.update.TokensUsed += tokens
+	if done, total := p.update.ChunksCompleted, p.update.ChunksTotal; done > 0 && total >= done {
+		elapsed := time.Since(p.upstream).Seconds()
+		eta := roundTo(elapsed/float64(done)*float64(total-done), 1)
+		p.update.ETASeconds = &eta
+	}
+	p.report()
+}
+
+// throttleProgress wraps hook so it runs at most once per interval, except
+// for stage changes which always get through
+func throttleProgress(interval time.Duration, hook func(ProgressUpdate)) func(ProgressUpdate) {
+	var (
+		mu    sync.Mutex
+		last  time.Time
+		stage string
+	)
+	return func(u ProgressUpdate) {
+		mu.Lock()
+		now := time.Now()
+		if u.Stage == stage && now.Sub(last) < interval {
+			mu.Unlock()
+			return
+		}
+		last, stage = now, u.Stage
+		mu.Unlock()
+		hook(u)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents parses a server-sent event stream as it arrives
+func readEvents(body *bufio.Reader) <-chan sseEvent {
+	events := make(chan sseEvent)
+	go func() {
+		defer close(events)
+		var e sseEvent
+		for {
+			line, err := body.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			case line == "":
+				events <- e
+				e = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func TestJobProgressEvents(t *testing.T) {
+	fake := setupServer(t, nil)
+	release := make(chan struct{})
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		<-release
+		return swayResponse(req)
+	}
+	srv := httptest.NewServer(newRouter())
+	defer srv.Close()
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+	id := decodeBody[Job](t, serve(t, http.MethodPost, "/jobs", payload, nil)).ID
+	resp, err := http.Get(srv.URL + "/jobs/" + id + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q, want text/event-stream", ct)
+	}
+	events := readEvents(bufio.NewReader(resp.Body))
+	next := func() sseEvent {
+		t.Helper()
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("stream ended early")
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event within 5s")
+		}
+		return sseEvent{}
+	}
+	progress := func(e sseEvent) ProgressUpdate {
+		t.Helper()
+		var u ProgressUpdate
+		if e.name != "progress" || json.Unmarshal([]byte(e.data), &u) != nil {
+			t.Fatalf("event %s %s, want progress", e.name, e.data)
+		}
+		return u
+	}
+
+	// While the model is busy the stream reports the upstream stage
+	for progress(next()).Stage != "upstream" {
+	}
+	close(release)
+
+	// Then the final progress, one event per frame and done
+	var last ProgressUpdate
+	e := next()
+	for ; e.name == "progress"; e = next() {
+		last = progress(e)
+	}
+	if last.Stage != "done" || last.ChunksCompleted != 1 || last.FramesParsed != 4 || last.ETASeconds != nil {
+		t.Errorf("last progress %+v, want done after 1 chunk of 4 frames", last)
+	}
+	for i := range 4 {
+		if e.name != "frame" || !strings.Contains(e.data, `"index":`+strconv.Itoa(i)) {
+			t.Fatalf("event %d: %s %s, want frame %d", i, e.name, e.data, i)
+		}
+		e = next()
+	}
+	if e.name != "done" || !strings.Contains(e.data, id) {
+		t.Errorf("final event %s %s, want done", e.name, e.data)
+	}
+}
+
+func TestProgressTracker(t *testing.T) {
+	var updates []ProgressUpdate
+	ctx := withProgress(t.Context(), func(u ProgressUpdate) { updates = append(updates, u) })
+	p := progressFrom(ctx)
+	p.stage("upstream")
+	p.expectChunks(2)
+	p.chunkDone(4, 100)
+	p.chunkDone(4, 120)
+	got := updates[len(updates)-1]
+	if len(updates) != 4 || got.ChunksCompleted != 2 || got.ChunksTotal != 2 || got.FramesParsed != 8 || got.TokensUsed != 220 {
+		t.Errorf("%d updates ending in %+v, want 4 ending with both chunks counted", len(updates), got)
+	}
+	if got.ETASeconds == nil || *got.ETASeconds != 0 {
+		t.Errorf("eta %v once every chunk is done, want 0", got.ETASeconds)
+	}
+
+	// Without a tracker nothing is reported, and nothing panics
+	var none *progressTracker
+	none.stage("upstream")
+	none.chunkDone(1, 1)
+}
+
+func TestThrottleProgress(t *testing.T) {
+	var got []string
+	hook := throttleProgress(time.Hour, func(u ProgressUpdate) { got = append(got, u.Stage) })
+	for _, stage := range []string{"validate", "upstream", "upstream", "upstream", "postprocess"} {
+		hook(ProgressUpdate{Stage: stage})
+	}
+	// Updates within a stage are dropped, stage changes never are
+	if want := "validate upstream postprocess"; strings.Join(got, " ") != want {
+		t.Errorf("hook saw %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// tokenBudget tracks OpenAI's per-minute token budget from the rate-limit
+// headers of every upstream response. Calls that would overrun what is left
+// wait for the window to reset instead of being sent only to come back 429,
+// and a 429's retry-after is honoured before the next attempt. Calls that
+// start between two responses reserve their estimate against the last known
+// remainder, so a burst does not spend the same budget several times.
+type tokenBudget struct {
+	mu  sync.Mutex
+	now func() time.Time
+
+	known     bool
+	limit     int
+	remaining int
+	resetAt   time.Time
+	retryAt   time.Time
+}
+
+// Snapshot of the budget for /debug/stats
+type tokenBudgetState struct {
+	Known             bool    `json:"known"`
+	LimitTokens       int     `json:"limit_tokens,omitempty"`
+	RemainingTokens   int     `json:"remaining_tokens,omitempty"`
+	ResetInSeconds    float64 `json:"reset_in_seconds,omitempty"`
+	RetryAfterSeconds float64 `json:"retry_after_seconds,omitempty"`
+}
+
+func newTokenBudget() *tokenBudget {
+	return &tokenBudget{now: time.Now}
+}
+
+// Token budgets by profile name. Each profile calls OpenAI with its own
+// key, which has rate limits of its own.
+var upstreamBudgets = struct {
+	mu      sync.Mutex
+	budgets map[string]*tokenBudget
+}{budgets: make(map[string]*tokenBudget)}
+
+// budgetFor returns the budget of a profile, created on first use
+func budgetFor(profile string) *tokenBudget {
+	upstreamBudgets.mu.Lock()
+	defer upstreamBudgets.mu.Unlock()
+	budget, ok := upstreamBudgets.budgets[profile]
+	if !ok {
+		budget = newTokenBudget()
+		upstreamBudgets.budgets[profile] = budget
+	}
+	return budget
+}
+
+// budgetStates snapshots every profile's budget by profile name
+func budgetStates() map[string]tokenBudgetState {
+	upstreamBudgets.mu.Lock()
+	budgets := make(map[string]*tokenBudget, len(upstreamBudgets.budgets))
+	for name, budget := range upstreamBudgets.budgets {
+		budgets[name] = budget
+	}
+	upstreamBudgets.mu.Unlock()
+	states := make(map[string]tokenBudgetState, len(budgets))
+	for name, budget := range budgets {
+		states[name] = budget.currentState()
+	}
+	return states
+}
+
+// budgetGauge reports one figure of every profile's budget, labelled by
+// profile
+func budgetGauge(figure func(tokenBudgetState) float64) func() map[string]float64 {
+	return func() map[string]float64 {
+		values := make(map[string]float64)
+		for name, state := range budgetStates() {
+			values[name] = figure(state)
+		}
+		return values
+	}
+}
+
+func resetBudgets() {
+	upstreamBudgets.mu.Lock()
+	defer upstreamBudgets.mu.Unlock()
+	clear(upstreamBudgets.budgets)
+}
+
+// observe records the rate-limit headers of an upstream response
+func (b *tokenBudget) observe(status int, h http.Header) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if remaining, err := strconv.Atoi(h.Get("x-ratelimit-remaining-tokens")); err == nil {
+		b.known = true
+		b.remaining = remaining
+		b.limit, _ = strconv.Atoi(h.Get("x-ratelimit-limit-tokens"))
+		b.resetAt = now
+		if reset, err := time.ParseDuration(h.Get("x-ratelimit-reset-tokens")); err == nil {
+			b.resetAt = now.Add(reset)
+		}
+	}
+	if status == http.StatusTooManyRequests {
+		if wait, ok := parseRetryAfter(h, now); ok {
+			b.retryAt = now.Add(wait)
+		}
+	}
+}
+
+// parseRetryAfter reads retry-after-ms, or retry-after in seconds or as an
+// HTTP date
+func parseRetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(h.Get("retry-after-ms"), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	value := h.Get("retry-after")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(0, at.Sub(now)), true
+	}
+	return 0, false
+}
+
+// reserve returns how long a call expected to use estimate tokens should
+// wait before it is sent. A call allowed through at once is charged against
+// the remaining budget until the next response reports the real figure.
+func (b *tokenBudget) reserve(estimate int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if now.Before(b.retryAt) {
+		return b.retryAt.Sub(now)
+	}
+	if !b.known || !now.Before(b.resetAt) {
+		// Nothing reported, or the window has reset since
+		b.known = false
+		return 0
+	}
+	if b.remaining < estimate {
+		return b.resetAt.Sub(now)
+	}
+	b.remaining -= estimate
+	return 0
+}
+
+// retryAfter returns how long the last 429 asked callers to wait, if it is
+// still in the future
+func (b *tokenBudget) retryAfter() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wait := b.retryAt.Sub(b.now())
+	return wait, wait > 0
+}
+
+func (b *tokenBudget) currentState() tokenBudgetState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	state := tokenBudgetState{RetryAfterSeconds: max(0, b.retryAt.Sub(now).Seconds())}
+	if b.known && now.Before(b.resetAt) {
+		state.Known = true
+		state.LimitTokens = b.limit
+		state.RemainingTokens = b.remaining
+		state.ResetInSeconds = b.resetAt.Sub(now).Seconds()
+	}
+	return state
+}
+
+// estimateRequestTokens approximates the tokens a completion request uses:
+// about four characters of prompt per token, plus its completion allowance
+func estimateRequestTokens(request openai.ChatCompletionRequest) int {
+	chars := 0
+	for _, m := range request.Messages {
+		chars += len(m.Content)
+	}
+	return chars/4 + max(request.MaxTokens, request.MaxCompletionTokens)
+}
+
+// waitForBudget holds a call back until the profile's budget allows it, for
+// at most rate_limit_max_wait. Calls on the caller's own key are not held
+// back.
+func waitForBudget(ctx context.Context, profile string, request openai.ChatCompletionRequest) error {
+	if upstreamKeyFrom(ctx) != "" {
+		return nil
+	}
+	wait := min(budgetFor(profile).reserve(estimateRequestTokens(request)), cfg.RateLimitMaxWait.Duration)
+	if wait <= 0 {
+		return nil
+	}
+	incCounter("upstream_ratelimit_delays_total", "reason", "budget", 1)
+	incCounter("upstream_ratelimit_delay_seconds_total", "", "", wait.Seconds())
+	return sleepContext(ctx, wait)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitDoer feeds the rate-limit headers of every upstream response to
+// a budget; go-openai drops them before returning
+type rateLimitDoer struct {
+	next   openai.HTTPDoer
+	budget *tokenBudget
+}
+
+func (d rateLimitDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.next.Do(req)
+	if err == nil {
+		d.budget.observe(resp.StatusCode, resp.Header)
+	}
+	return resp, err
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestBudgetPerProfile(t *testing.T) {
+	// An OpenAI stand-in that reports key-a's budget spent and key-b's full
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := swayResponse(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		remaining := "90000"
+		if r.Header.Get("Authorization") == "Bearer key-a" {
+			remaining = "0"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-ratelimit-limit-tokens", "90000")
+		w.Header().Set("x-ratelimit-remaining-tokens", remaining)
+		w.Header().Set("x-ratelimit-reset-tokens", "1m")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer upstream.Close()
+
+	setupServer(t, func(c *Config) {
+		c.UpstreamProfiles = []UpstreamProfile{
+			{Name: "a", APIKey: "key-a", BaseURL: upstream.URL},
+			{Name: "b", APIKey: "key-b", BaseURL: upstream.URL},
+		}
+		c.RateLimitMaxWait = Duration{10 * time.Millisecond}
+		c.AdminAPIKey = "admin"
+		c.EnableDebugEndpoints = true
+	})
+	newChatClient = realChatClient
+	registerGauges()
+	generate := func(profile, prompt string) {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: prompt, Length: 4, Profile: profile}}
+		if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+			t.Fatalf("profile %s: status %d: %s", profile, rec.Code, rec.Body)
+		}
+	}
+	delays := func() float64 {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		return metrics.counters["upstream_ratelimit_delays_total"][formatLabel("reason", "budget")]
+	}
+
+	generate("a", "sway gently")
+	before := delays()
+	generate("b", "sway gently")
+	if got := delays() - before; got != 0 {
+		t.Errorf("profile b waited %v times on profile a's spent budget", got)
+	}
+	generate("a", "sway again")
+	if got := delays() - before; got != 1 {
+		t.Errorf("profile a waited %v times on its spent budget, want 1", got)
+	}
+
+	rec := serve(t, http.MethodGet, "/debug/stats", nil, http.Header{"X-Admin-Key": {"admin"}})
+	stats := decodeBody[struct {
+		RateLimit map[string]tokenBudgetState `json:"upstream_rate_limit"`
+	}](t, rec)
+	if a, b := stats.RateLimit["a"], stats.RateLimit["b"]; !a.Known || a.RemainingTokens != 0 || !b.Known || b.RemainingTokens != 90000 {
+		t.Errorf("stats report a %+v and b %+v", a, b)
+	}
+
+	body := serve(t, http.MethodGet, "/metrics", nil, nil).Body.String()
+	for _, line := range []string{
+		`upstream_ratelimit_remaining_tokens{profile="a"} 0`,
+		`upstream_ratelimit_remaining_tokens{profile="b"} 90000`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+}
+
+func TestTokenBudget(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	budget := newTokenBudget()
+	budget.now = func() time.Time { return now }
+	if wait := budget.reserve(1000); wait != 0 {
+		t.Errorf("unknown budget waits %s", wait)
+	}
+
+	budget.observe(http.StatusOK, http.Header{
+		"X-Ratelimit-Limit-Tokens":     {"10000"},
+		"X-Ratelimit-Remaining-Tokens": {"1500"},
+		"X-Ratelimit-Reset-Tokens":     {"30s"},
+	})
+	// The first call fits and is charged; the second no longer does and
+	// waits for the window to reset
+	if wait := budget.reserve(1000); wait != 0 {
+		t.Errorf("call within the budget waits %s", wait)
+	}
+	if wait := budget.reserve(1000); wait != 30*time.Second {
+		t.Errorf("call past the budget waits %s, want 30s", wait)
+	}
+	now = now.Add(31 * time.Second)
+	if wait := budget.reserve(1000); wait != 0 {
+		t.Errorf("call after the reset waits %s", wait)
+	}
+
+	// A 429's retry-after holds back every call until it has passed
+	budget.observe(http.StatusTooManyRequests, http.Header{"Retry-After": {"2"}})
+	if wait, ok := budget.retryAfter(); !ok || wait != 2*time.Second {
+		t.Errorf("retry after %s (%v), want 2s", wait, ok)
+	}
+	if wait := budget.reserve(1); wait != 2*time.Second {
+		t.Errorf("call during retry-after waits %s, want 2s", wait)
+	}
+	now = now.Add(2 * time.Second)
+	if _, ok := budget.retryAfter(); ok {
+		t.Error("retry-after still reported once it has passed")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		{"milliseconds", http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"9"}}, 250 * time.Millisecond, true},
+		{"seconds", http.Header{"Retry-After": {"1.5"}}, 1500 * time.Millisecond, true},
+		{"date", http.Header{"Retry-After": {now.Add(3 * time.Second).Format(http.TimeFormat)}}, 3 * time.Second, true},
+		{"past date", http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, 0, true},
+		{"negative", http.Header{"Retry-After": {"-1"}}, 0, false},
+		{"missing", http.Header{}, 0, false},
+	} {
+		if got, ok := parseRetryAfter(tc.header, now); got != tc.want || ok != tc.ok {
+			t.Errorf("%s: %s (%v), want %s (%v)", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestRetryAfterHonoured(t *testing.T) {
+	// An OpenAI stand-in that rate limits the first call for 20ms
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) == 1 {
+			w.Header().Set("retry-after-ms", "20")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": {"message": "Rate limit reached", "type": "tokens"}}`))
+			return
+		}
+		resp, _ := swayResponse(req)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer upstream.Close()
+
+	setupServer(t, func(c *Config) {
+		c.UpstreamProfiles = []UpstreamProfile{{Name: "a", APIKey: "key-a", BaseURL: upstream.URL}}
+		// Backing off instead would outlast the test
+		c.RetryBackoff = Duration{time.Minute}
+		c.MaxRetries = 1
+		c.RateLimitMaxWait = Duration{time.Second}
+	})
+	newChatClient = realChatClient
+	delays := func() float64 {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		return metrics.counters["upstream_ratelimit_delays_total"][formatLabel("reason", "retry_after")]
+	}
+	before := delays()
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4, Profile: "a"}}
+	start := time.Now()
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 10*time.Second {
+		t.Errorf("request took %s, want the advised 20ms wait", elapsed)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("upstream called %d times, want 2", calls.Load())
+	}
+	if got := delays() - before; got != 1 {
+		t.Errorf("%v retry-after delays counted, want 1", got)
+	}
+}
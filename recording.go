@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// A recorded upstream exchange
+type Fixture struct {
+	// Hash of the full request
+	Key string `json:"key"`
+	// Hash of the request without its system prompt, for fuzzy matching
+	FuzzyKey string                        `json:"fuzzy_key"`
+	Request  openai.ChatCompletionRequest  `json:"request"`
+	Response openai.ChatCompletionResponse `json:"response"`
+}
+
+// RecordingClient sits between the service and the model. In record mode it
+// passes requests upstream and saves each request/response pair as a
+// fixture; in replay mode it answers from the fixtures alone, so the full
+// handler path runs deterministically without a key or network. With fuzzy
+// matching, a request with no exact fixture is served the fixture whose
+// messages differ only in the system prompt, so rewording the instructions
+// does not invalidate every recording.
+type RecordingClient struct {
+	Mode  string
+	Dir   string
+	Fuzzy bool
+	// Upstream client, only used when recording
+	Next chatClient
+
+	mu sync.Mutex
+}
+
+// Fixture problems are setup mistakes, never retried or held against the
+// upstream's health
+var (
+	errNoFixture    = errors.New("no recorded fixture for request")
+	errFixtureWrite = errors.New("failed to record fixture")
+)
+
+func validateFixtureMode(mode string) error {
+	switch mode {
+	case "", "record", "replay":
+		return nil
+	}
+	return fmt.Errorf("invalid fixture mode %q, expected record or replay", mode)
+}
+
+// fixtureKeys hashes a request exactly and without its system messages
+func fixtureKeys(req openai.ChatCompletionRequest) (string, string, error) {
+	exact, err := json.Marshal(req)
+	if err != nil {
+		return "", "", err
+	}
+	stripped := req
+	stripped.Messages = nil
+	for _, m := range req.Messages {
+		if m.Role != openai.ChatMessageRoleSystem {
+			stripped.Messages = append(stripped.Messages, m)
+		}
+	}
+	fuzzy, err := json.Marshal(stripped)
+	if err != nil {
+		return "", "", err
+	}
+	a, b := sha256.Sum256(exact), sha256.Sum256(fuzzy)
+	return hex.EncodeToString(a[:16]), hex.EncodeToString(b[:16]), nil
+}
+
+func (c *RecordingClient) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	key, fuzzyKey, err := fixtureKeys(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("hash request: %w", err)
+	}
+	if c.Mode == "replay" {
+		fixture, err := c.find(key, fuzzyKey)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		return fixture.Response, nil
+	}
+
+	resp, err := c.Next.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	if err := c.save(Fixture{Key: key, FuzzyKey: fuzzyKey, Request: req, Response: resp}); err != nil {
+		return resp, fmt.Errorf("%w: %v", errFixtureWrite, err)
+	}
+	return resp, nil
+}
+
+func (c *RecordingClient) save(fixture Fixture) error {
+	raw, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.Dir, fixture.Key+".json"), raw, 0o644)
+}
+
+// find loads the fixture recorded for key, falling back to the first one,
+// by file name, sharing fuzzyKey when fuzzy matching is on
+func (c *RecordingClient) find(key, fuzzyKey string) (*Fixture, error) {
+	fixture, err := readFixture(filepath.Join(c.Dir, key+".json"))
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return fixture, err
+	}
+	if c.Fuzzy {
+		paths, err := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			fixture, err := readFixture(path)
+			if err != nil {
+				return nil, err
+			}
+			if fixture.FuzzyKey == fuzzyKey {
+				return fixture, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w %s in %s", errNoFixture, key, c.Dir)
+}
+
+func readFixture(path string) (*Fixture, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture Fixture
+	if err := json.Unmarshal(raw, &fixture); err != nil {
+		return nil, fmt.Errorf("parse fixture %s: %w", strings.TrimSuffix(filepath.Base(path), ".json"), err)
+	}
+	return &fixture, nil
+}
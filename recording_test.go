@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files from the current output")
+
+// The client main builds, before setupServer swaps in a fake
+var realChatClient = newChatClient
+
+// Requests whose model calls are recorded in testdata/fixtures
+var replayCases = []struct {
+	name    string
+	query   string
+	payload RequestPayload
+}{
+	{
+		name:    "wave",
+		payload: RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave the right hand", Length: 6}},
+	},
+	{
+		name:    "walk_loop_events",
+		query:   "?include_meta=true",
+		payload: RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "walk in place", Length: 8, Loop: true, Events: "computed"}},
+	},
+	{
+		// The AnimationClip curves for the same recording as "wave"
+		name:    "wave_unity",
+		query:   "?format=unity&fps=24",
+		payload: RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave the right hand", Length: 6}},
+	},
+	{
+		name:    "wave_unreal_curves",
+		query:   "?format=unreal_curves",
+		payload: RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave the right hand", Length: 6}},
+	},
+	{
+		name:    "wave_wide.csv",
+		query:   "?format=csv&layout=wide",
+		payload: RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave the right hand", Length: 6}},
+	},
+}
+
+// setupReplay serves model calls from the recordings in testdata/fixtures
+func setupReplay(t *testing.T) {
+	t.Helper()
+	setupServer(t, func(c *Config) {
+		c.OpenAIAPIKey = ""
+		c.FixtureMode = "replay"
+		c.FixtureDir = filepath.Join("testdata", "fixtures")
+		// Prompt wording changes should not invalidate the recordings
+		c.FixtureFuzzy = true
+	})
+	newChatClient = realChatClient
+}
+
+// TestReplayFixtures runs recorded model responses through the full handler
+// path and compares the responses with golden files. Run with -update after
+// an intended change to the output.
+func TestReplayFixtures(t *testing.T) {
+	setupReplay(t)
+	for _, tc := range replayCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(t, http.MethodPost, "/generate-deformations"+tc.query, tc.payload, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			// Names with an extension hold the body as it is, others
+			// reformatted JSON
+			got, path := rec.Body.Bytes(), filepath.Join("testdata", "golden", tc.name)
+			if filepath.Ext(tc.name) == "" {
+				got, path = normalizeJSON(t, got), path+".json"
+			}
+			if *updateGolden {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("response differs from %s:\n%s", path, got)
+			}
+		})
+	}
+}
+
+// normalizeJSON reformats a JSON response without its timings, which differ
+// on every run
+func normalizeJSON(t *testing.T, raw []byte) []byte {
+	t.Helper()
+	var body any
+	if err := json.Unmarshal(raw, &body); err != nil {
+		t.Fatal(err)
+	}
+	if envelope, ok := body.(map[string]any); ok {
+		if meta, ok := envelope["meta"].(map[string]any); ok {
+			delete(meta, "timings")
+		}
+	}
+	formatted, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(formatted, '\n')
+}
+
+// TestV1ResponseBytes pins the version 1 response byte for byte: old clients
+// depend on its exact shape, so unlike the golden files above it is compared
+// without reformatting and must not be regenerated casually.
+func TestV1ResponseBytes(t *testing.T) {
+	setupReplay(t)
+	path := filepath.Join("testdata", "golden", "wave_v1.bin")
+	for _, version := range []string{"", "1"} {
+		rec := serve(t, http.MethodPost, "/generate-deformations", replayCases[0].payload, http.Header{"X-Api-Version": {version}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("version %q: status %d: %s", version, rec.Code, rec.Body)
+		}
+		if *updateGolden && version == "" {
+			if err := os.WriteFile(path, rec.Body.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%v (run with -update to create it)", err)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), want) {
+			t.Errorf("version %q response differs from %s:\n%s", version, path, rec.Body)
+		}
+	}
+}
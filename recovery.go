@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+)
+
+type requestInfoKey struct{}
+
+// The request a context belongs to, for logs and panic reports
+type requestInfo struct {
+	ID     string
+	Method string
+	Path   string
+}
+
+// Client-supplied request IDs are kept when they look like IDs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestInfo tags ctx with the request it serves. Background work
+// such as a job tags its own context, so its panics are reported against it.
+func withRequestInfo(ctx context.Context, info requestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// requestInfoFrom returns the request ctx belongs to, or the zero value
+func requestInfoFrom(ctx context.Context) requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(requestInfo)
+	return info
+}
+
+// requestIDFrom returns the ID of the request ctx belongs to, or ""
+func requestIDFrom(ctx context.Context) string {
+	return requestInfoFrom(ctx).ID
+}
+
+// withRequestID tags every request with an ID, taken from X-Request-ID when
+// the client sent a usable one, and echoes it in the response
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := withRequestInfo(r.Context(), requestInfo{ID: id, Method: r.Method, Path: r.URL.Path})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// A recovered panic, as handed to the panic reporter
+type PanicReport struct {
+	RequestID string
+	Method    string
+	Path      string
+	Value     any
+	Stack     []byte
+}
+
+// panicReporter, when set, receives every recovered panic, e.g. to forward
+// it to an error tracker. It is called synchronously; its own panics are
+// logged and dropped.
+var panicReporter func(PanicReport)
+
+// reportPanic logs a recovered panic with its stack trace and passes it on
+// to the panic reporter
+func reportPanic(requestID, method, path string, value any) {
+	stack := debug.Stack()
+	log.Printf("Panic serving %s %s (request %s): %v\n%s", method, path, requestID, value, stack)
+	incCounter("panics_total", "", "", 1)
+	if panicReporter == nil {
+		return
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("Panic reporter failed: %v", v)
+		}
+	}()
+	panicReporter(PanicReport{RequestID: requestID, Method: method, Path: path, Value: value, Stack: stack})
+}
+
+// panicError is what clients see after a panic: the request ID to quote in
+// a bug report, never the stack
+func panicError(requestID string) *apiError {
+	return newAPIError(http.StatusInternalServerError, "Internal server error").
+		withDetails(map[string]string{"request_id": requestID})
+}
+
+// recoverAsError turns a panic in a goroutine the request spawned, which
+// recoverPanics never sees, into that goroutine's error instead of a crashed
+// process. Defer it directly at the top of the goroutine.
+func recoverAsError(ctx context.Context, err *error) {
+	if v := recover(); v != nil {
+		info := requestInfoFrom(ctx)
+		reportPanic(info.ID, info.Method, info.Path, v)
+		*err = panicError(info.ID)
+	}
+}
+
+// recoverPanics turns a panicking handler into a 500 instead of a crashed
+// process. When the response has already started, an event stream gets a
+// final error event and any other response is aborted, so the client sees a
+// failed transfer rather than a truncated success.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &trackingWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			requestID := requestIDFrom(r.Context())
+			reportPanic(requestID, r.Method, r.URL.Path, v)
+			switch {
+			case tw.hijacked:
+				// The connection belongs to the handler and is gone with it
+			case !tw.wroteHeader:
+				writeError(tw, panicError(requestID))
+			case strings.HasPrefix(tw.Header().Get("Content-Type"), "text/event-stream"):
+				writeErrorEvent(tw, panicError(requestID))
+			default:
+				panic(http.ErrAbortHandler)
+			}
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
+
+// writeErrorEvent ends an event stream with an error event carrying the
+// usual error body
+func writeErrorEvent(w http.ResponseWriter, apiErr *apiError) {
+	writeEvent(w, "error", errorResponse{Error: errorBody{Code: apiErr.Code, Message: apiErr.Message, Details: apiErr.Details}})
+}
+
+// trackingWriter records whether the response has started. It keeps the
+// Flusher and Hijacker interfaces that streaming and WebSocket handlers need.
+type trackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (w *trackingWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *trackingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (w *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *trackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
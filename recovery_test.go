@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	fake := setupServer(t, nil)
+	logs := captureLog(t)
+	fake.respond = func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		panic("frames out of range")
+	}
+	var reports []PanicReport
+	panicReporter = func(r PanicReport) { reports = append(reports, r) }
+	t.Cleanup(func() { panicReporter = nil })
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4}}
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, http.Header{"X-Request-Id": {"req-42"}})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", rec.Code)
+	}
+	body := decodeBody[errorResponse](t, rec)
+	if details, _ := body.Error.Details.(map[string]any); details["request_id"] != "req-42" {
+		t.Errorf("details %v, want the request ID", body.Error.Details)
+	}
+	// The client never sees the panic value or the stack
+	if strings.Contains(rec.Body.String(), "out of range") || strings.Contains(rec.Body.String(), "goroutine") {
+		t.Errorf("body %s exposes the panic", rec.Body)
+	}
+
+	if len(reports) != 1 {
+		t.Fatalf("reporter called %d times, want once", len(reports))
+	}
+	r := reports[0]
+	if r.RequestID != "req-42" || r.Method != http.MethodPost || r.Path != "/generate-deformations" || r.Value != "frames out of range" || len(r.Stack) == 0 {
+		t.Errorf("report %+v, want the request and the panic with its stack", r)
+	}
+	if !strings.Contains(logs.String(), "frames out of range") {
+		t.Error("panic not logged")
+	}
+
+	// A panicking reporter does not take the response down with it
+	panicReporter = func(PanicReport) { panic("tracker down") }
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusInternalServerError {
+		t.Errorf("with a failing reporter: status %d, want 500", rec.Code)
+	}
+}
+
+func TestPanicInSpawnedGoroutines(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.BatchThreshold = 10
+		c.BatchSize = 6
+		c.CacheTTL = Duration{time.Minute}
+		c.CacheMaxStale = Duration{time.Hour}
+	})
+	captureLog(t)
+	var (
+		mu      sync.Mutex
+		reports []PanicReport
+	)
+	panicReporter = func(r PanicReport) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, r)
+	}
+	t.Cleanup(func() { panicReporter = nil })
+	panicking := func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		panic("frames out of range")
+	}
+	fake.respond = panicking
+
+	// Each of these generates on goroutines of its own, out of reach of the
+	// handler's recovery
+	for _, tc := range []struct {
+		name, path string
+		body       any
+	}{
+		{"scene", "/generate-scene", ScenePayload{
+			Characters: []SceneCharacter{{Name: "alice", ControlPoints: testRig()}, {Name: "bob", ControlPoints: testRig()}},
+			Prompt:     "two friends meet",
+			Length:     3,
+			Mode:       "separate",
+		}},
+		{"blend", "/generate-deformations", RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "walk", SecondaryPrompt: "limp", Length: 3}}},
+		{"batch", "/generate-deformations", RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: limbRig(), Prompt: "sway", Length: 3}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mu.Lock()
+			reports = nil
+			mu.Unlock()
+			rec := serve(t, http.MethodPost, tc.path, tc.body, http.Header{"X-Request-Id": {"req-" + tc.name}})
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("status %d: %s, want 500", rec.Code, rec.Body)
+			}
+			body := decodeBody[errorResponse](t, rec)
+			if details, _ := body.Error.Details.(map[string]any); body.Error.Code != "internal_error" || details["request_id"] != "req-"+tc.name {
+				t.Errorf("error %+v, want internal_error with the request ID", body.Error)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(reports) == 0 {
+				t.Fatal("panic not reported")
+			}
+			if r := reports[0]; r.RequestID != "req-"+tc.name || r.Path != tc.path || r.Value != "frames out of range" {
+				t.Errorf("report %+v, want it against the request", r)
+			}
+		})
+	}
+
+	t.Run("cache refresh", func(t *testing.T) {
+		failed := func() float64 {
+			metrics.mu.Lock()
+			defer metrics.mu.Unlock()
+			return metrics.counters["cache_refreshes_total"][formatLabel("outcome", "failed")]
+		}
+		before := failed()
+		fake.respond = swayResponse
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+		serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+		ageCache(2 * time.Minute)
+
+		// The stale entry is served while its refresh panics and fails
+		fake.respond = panicking
+		payload.CacheMode = cacheStaleOK
+		if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "STALE" {
+			t.Fatalf("status %d, X-Cache %q; want the stale entry", rec.Code, rec.Header().Get("X-Cache"))
+		}
+		waitForBackgroundWork()
+		if got := failed(); got != before+1 {
+			t.Errorf("%v failed refreshes, want one", got-before)
+		}
+	})
+}
+
+func TestPanicAfterResponseStarted(t *testing.T) {
+	captureLog(t)
+	t.Run("event stream", func(t *testing.T) {
+		handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			writeEvent(w, "progress", map[string]string{"stage": "validate"})
+			panic("mid-stream")
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/generate-deformations", nil))
+		if !strings.Contains(rec.Body.String(), "event: error") {
+			t.Errorf("stream %q does not end with an error event", rec.Body)
+		}
+	})
+
+	t.Run("other", func(t *testing.T) {
+		handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`[{"0":`))
+			panic("mid-body")
+		}))
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want the response aborted", v)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
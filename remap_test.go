@@ -0,0 +1,202 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestRemapIndependentOfInputOrder(t *testing.T) {
+	fake := setupServer(t, nil)
+	rig := testRig()
+	// Duplicate IDs get fresh compact ones, which must not depend on order
+	rig = append(rig, ControlPoint{ID: 2, Role: "right elbow", Position: []float64{-0.4, 1.4, 0}})
+	reversed := slices.Clone(rig)
+	slices.Reverse(reversed)
+
+	var idMaps []map[int]int
+	var inputs []modelInput
+	for _, points := range [][]ControlPoint{rig, reversed} {
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: points, Prompt: "sway gently", Length: 4, CacheMode: cacheFresh}}
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_id_map=true", payload, http.Header{"X-Api-Version": {"2"}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		idMaps = append(idMaps, decodeBody[struct {
+			IDMap map[int]int `json:"id_map"`
+		}](t, rec).IDMap)
+		input, err := modelInputOf(fake.requests[len(fake.requests)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, input)
+	}
+
+	if !maps.Equal(idMaps[0], idMaps[1]) {
+		t.Errorf("id maps differ by input order: %v and %v", idMaps[0], idMaps[1])
+	}
+	same := slices.EqualFunc(inputs[0].ControlPoints, inputs[1].ControlPoints, func(a, b ControlPoint) bool {
+		return a.ID == b.ID && a.Role == b.Role && slices.Equal(a.Position, b.Position)
+	})
+	if !same {
+		t.Errorf("model was sent\n%v\nand\n%v", inputs[0].ControlPoints, inputs[1].ControlPoints)
+	}
+}
+
+func TestRemapOrder(t *testing.T) {
+	setupServer(t, nil)
+	points := []ControlPoint{
+		{ID: 2, Role: "right hand", Position: []float64{-0.6, 1.2, 0}},
+		{ID: 0, Role: "head", Position: []float64{0, 1.7, 0}},
+		// Duplicate IDs fall back to role, then position
+		{ID: 2, Role: "right elbow", Position: []float64{-0.4, 1.4, 0.1}},
+		{ID: 2, Role: "right elbow", Position: []float64{-0.4, 1.4, 0}},
+	}
+	got := remapOrder(points)
+	want := []ControlPoint{points[1], points[3], points[2], points[0]}
+	if !slices.EqualFunc(got, want, func(a, b ControlPoint) bool {
+		return a.ID == b.ID && a.Role == b.Role && slices.Equal(a.Position, b.Position)
+	}) {
+		t.Errorf("sorted order %v, want %v", got, want)
+	}
+	if points[0].ID != 2 {
+		t.Error("remapOrder reordered the caller's slice")
+	}
+
+	cfg.RemapOrder = "input"
+	if got := remapOrder(points); got[0].Role != "right hand" || got[3].Position[2] != 0 {
+		t.Errorf("remap_order input: order %v, want the request's", got)
+	}
+}
+
+func TestCacheKeyIndependentOfInputOrder(t *testing.T) {
+	fake := setupServer(t, nil)
+	reversed := testRig()
+	slices.Reverse(reversed)
+	for _, tc := range []struct {
+		points []ControlPoint
+		cache  string
+	}{{testRig(), "MISS"}, {reversed, "HIT"}} {
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: tc.points, Prompt: "sway gently", Length: 4}}
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+		if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != tc.cache {
+			t.Fatalf("status %d, X-Cache %q; want %s", rec.Code, rec.Header().Get("X-Cache"), tc.cache)
+		}
+	}
+	if got := fake.calls(); got != 1 {
+		t.Errorf("upstream called %d times, want the reordered rig served from the cache", got)
+	}
+}
+
+func TestIncludeIDMapRoundTrips(t *testing.T) {
+	fake := setupServer(t, nil)
+	rig := testRig()
+	sparse := []int{42, 7, 100, 3, 9}
+	for i := range rig {
+		rig[i].ID = sparse[i]
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "sway gently", Length: 4}}
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_id_map=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	body := decodeBody[struct {
+		IDMap  map[int]int     `json:"id_map"`
+		Frames ResponsePayload `json:"frames"`
+	}](t, rec)
+
+	// Every client ID maps to its own compact ID in 0..n-1
+	if got := slices.Sorted(maps.Keys(body.IDMap)); !slices.Equal(got, slices.Sorted(slices.Values(sparse))) {
+		t.Errorf("id_map keys %v, want the request's IDs %v", got, sparse)
+	}
+	if got := slices.Sorted(maps.Values(body.IDMap)); !slices.Equal(got, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("id_map values %v, want 0..4", got)
+	}
+
+	// The model saw each point under its compact ID
+	input, err := modelInputOf(fake.requests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := make(map[int]string)
+	for _, cp := range input.ControlPoints {
+		roles[cp.ID] = cp.Role
+	}
+	for _, cp := range rig {
+		if got := roles[body.IDMap[cp.ID]]; got != cp.Role {
+			t.Errorf("point %d maps to compact ID %d, which the model saw as %q, want %q", cp.ID, body.IDMap[cp.ID], got, cp.Role)
+		}
+	}
+
+	// And the frames come back under the client's IDs
+	for f, frame := range body.Frames {
+		if got := slices.Sorted(maps.Keys(frame)); !slices.Equal(got, slices.Sorted(slices.Values(sparse))) {
+			t.Errorf("frame %d has IDs %v, want %v", f, got, sparse)
+		}
+	}
+
+	// Without the flag, version 1 answers with the bare frames
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Body.Bytes()[0] != '[' {
+		t.Errorf("without include_id_map: body %s, want a frame array", rec.Body)
+	}
+}
+
+func TestDisableIDRemap(t *testing.T) {
+	fake := setupServer(t, nil)
+	sparse := testRig()
+	for i := range sparse {
+		sparse[i].ID = 10 * (i + 1)
+	}
+	send := func(points []ControlPoint, disable bool) *httptest.ResponseRecorder {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: points, Prompt: "sway gently", Length: 4, CacheMode: cacheFresh, DisableIDRemap: disable}}
+		return serve(t, http.MethodPost, "/generate-deformations?include_id_map=true", payload, http.Header{"X-Api-Version": {"2"}})
+	}
+	check := func(name string, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", name, rec.Code, rec.Body)
+		}
+		input, err := modelInputOf(fake.requests[len(fake.requests)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, cp := range input.ControlPoints {
+			if cp.ID != sparse[i].ID {
+				t.Errorf("%s: model was sent ID %d for point %d, want %d", name, cp.ID, i, sparse[i].ID)
+			}
+		}
+		body := decodeBody[struct {
+			Frames []map[int]Deformation `json:"frames"`
+			IDMap  map[int]int           `json:"id_map"`
+		}](t, rec)
+		for id, compact := range body.IDMap {
+			if id != compact {
+				t.Errorf("%s: id map sends %d to %d, want the identity", name, id, compact)
+			}
+		}
+		if got := slices.Sorted(maps.Keys(body.Frames[0])); !slices.Equal(got, []int{10, 20, 30, 40, 50}) {
+			t.Errorf("%s: frames hold IDs %v", name, got)
+		}
+	}
+
+	check("per request", send(sparse, true))
+	cfg.DisableIDRemap = true
+	check("config", send(sparse, false))
+
+	// Duplicates cannot be told apart without the remap
+	duplicated := append(slices.Clone(sparse), ControlPoint{ID: 20, Role: "left elbow", Position: []float64{0.4, 1.4, 0}})
+	rec := send(duplicated, false)
+	body := decodeBody[errorResponse](t, rec)
+	if rec.Code != http.StatusBadRequest || body.Error.Code != "duplicate_point_ids" {
+		t.Fatalf("duplicate IDs: status %d, code %q; want 400 duplicate_point_ids", rec.Code, body.Error.Code)
+	}
+	details, _ := body.Error.Details.(map[string]any)
+	if ids, _ := details["duplicate_ids"].([]any); len(ids) != 1 || ids[0] != float64(20) {
+		t.Errorf("details %v, want duplicate_ids [20]", details)
+	}
+}
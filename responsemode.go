@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Name of the function the model calls with the frames in tool_call mode
+const framesToolName = "emit_frames"
+
+// Parameters of the frames function: the same object json_object mode asks
+// for in the message content
+var framesToolSchema = json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "frames": {
+      "type": "array",
+      "description": "One object per frame, mapping each control point ID to its absolute position",
+      "items": {
+        "type": "object",
+        "additionalProperties": {
+          "type": "object",
+          "properties": {"x": {"type": "number"}, "y": {"type": "number"}, "z": {"type": "number"}},
+          "required": ["x", "y", "z"]
+        }
+      }
+    },
+    "affected_points": {"type": "array", "items": {"type": "integer"}},
+    "confidence": {"type": "object", "additionalProperties": {"type": "number"}},
+    "events": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "properties": {
+          "frame": {"type": "integer"},
+          "point_id": {"type": "integer"},
+          "type": {"type": "string", "enum": ["contact", "apex", "release"]},
+          "label": {"type": "string"}
+        },
+        "required": ["frame", "point_id", "type"]
+      }
+    }
+  },
+  "required": ["frames"]
+}`)
+
+func validateResponseMode(mode string) error {
+	switch mode {
+	case "", "json_object", "tool_call":
+		return nil
+	}
+	return fmt.Errorf("invalid response_mode %q, expected json_object or tool_call", mode)
+}
+
+// responseMode returns how a request asks the model for structured output,
+// falling back to the configured default
+func responseMode(payload RequestPayload) string {
+	if payload.ResponseMode != "" {
+		return payload.ResponseMode
+	}
+	return cfg.ResponseMode
+}
+
+// setResponseMode asks for the frames as a JSON object in the message
+// content, or as the arguments of a forced call to the frames function
+func setResponseMode(request *openai.ChatCompletionRequest, mode string) {
+	if mode != "tool_call" {
+		request.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+		}
+		return
+	}
+	request.Tools = []openai.Tool{{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:        framesToolName,
+			Description: "Return the generated animation frames",
+			Parameters:  framesToolSchema,
+		},
+	}}
+	request.ToolChoice = openai.ToolChoice{Type: openai.ToolTypeFunction, Function: openai.ToolFunction{Name: framesToolName}}
+}
+
+// choiceContent returns the JSON a choice carries the frames in: the
+// arguments of its call to the frames function when it made one, and its
+// message content otherwise
+func choiceContent(choice openai.ChatCompletionChoice) string {
+	for _, call := range choice.Message.ToolCalls {
+		if call.Function.Name == framesToolName {
+			return call.Function.Arguments
+		}
+	}
+	return choice.Message.Content
+}
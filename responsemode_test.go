@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestValidateResponseMode(t *testing.T) {
+	for mode, valid := range map[string]bool{
+		"":            true,
+		"json_object": true,
+		"tool_call":   true,
+		"json_schema": false,
+		"TOOL_CALL":   false,
+	} {
+		if err := validateResponseMode(mode); (err == nil) != valid {
+			t.Errorf("response_mode %q: %v, want valid %v", mode, err, valid)
+		}
+	}
+}
+
+func TestChoiceContent(t *testing.T) {
+	message := openai.ChatCompletionMessage{
+		Content: `{"frames":[]}`,
+		ToolCalls: []openai.ToolCall{
+			{Function: openai.FunctionCall{Name: "describe", Arguments: `{"text":"hi"}`}},
+			{Function: openai.FunctionCall{Name: framesToolName, Arguments: `{"frames":[{}]}`}},
+		},
+	}
+	if got := choiceContent(openai.ChatCompletionChoice{Message: message}); got != `{"frames":[{}]}` {
+		t.Errorf("content %q, want the frames call's arguments", got)
+	}
+	// A call to another function leaves the frames in the content
+	message.ToolCalls = message.ToolCalls[:1]
+	if got := choiceContent(openai.ChatCompletionChoice{Message: message}); got != `{"frames":[]}` {
+		t.Errorf("content %q, want the message content", got)
+	}
+}
+
+func TestResponseModeOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	// Answer the way the request asked: as a call to the frames function
+	// when one was forced, and in the message content otherwise
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		resp, err := swayResponse(req)
+		if err != nil || len(req.Tools) == 0 {
+			return resp, err
+		}
+		message := &resp.Choices[0].Message
+		message.ToolCalls = []openai.ToolCall{{
+			ID:       "call_0",
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: framesToolName, Arguments: message.Content},
+		}}
+		message.Content = ""
+		return resp, nil
+	}
+	send := func(mode string) openai.ChatCompletionRequest {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway", Length: 4, CacheMode: cacheFresh, ResponseMode: mode}}
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status %d: %s", mode, rec.Code, rec.Body)
+		}
+		if frames := decodeBody[[]map[int]Deformation](t, rec); len(frames) != 4 || len(frames[1]) != 5 {
+			t.Errorf("%q: frames %v, want 4 of 5 points", mode, frames)
+		}
+		return fake.requests[len(fake.requests)-1]
+	}
+
+	for _, mode := range []string{"", "json_object"} {
+		req := send(mode)
+		if req.ResponseFormat == nil || req.ResponseFormat.Type != openai.ChatCompletionResponseFormatTypeJSONObject || req.Tools != nil {
+			t.Errorf("%q: response format %+v with tools %+v, want a JSON object", mode, req.ResponseFormat, req.Tools)
+		}
+	}
+	req := send("tool_call")
+	if req.ResponseFormat != nil || len(req.Tools) != 1 || req.Tools[0].Function.Name != framesToolName {
+		t.Errorf("tool_call: response format %+v with tools %+v, want the frames function alone", req.ResponseFormat, req.Tools)
+	}
+	if choice, ok := req.ToolChoice.(openai.ToolChoice); !ok || choice.Function.Name != framesToolName {
+		t.Errorf("tool_call: tool choice %+v, want the frames function forced", req.ToolChoice)
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4, ResponseMode: "xml"}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("response_mode xml: status %d, want 400", rec.Code)
+	}
+}
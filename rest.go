@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	defaultRestBlendFrames = 5
+	maxRestBlendFrames     = 120
+	// A first frame further than this fraction of the rig's diagonal from
+	// rest is blended in rather than snapped to rest
+	restPopFraction = 0.02
+)
+
+// restBlendEnabled reports whether the request asked for start_at_rest
+func restBlendEnabled(o *StartAtRest) bool {
+	return o != nil && !o.Disabled
+}
+
+func validateStartAtRest(o *StartAtRest) error {
+	if !restBlendEnabled(o) {
+		return nil
+	}
+	if o.BlendFrames < 0 || o.BlendFrames > maxRestBlendFrames {
+		return fmt.Errorf("start_at_rest.blend_frames must be between 0 and %d", maxRestBlendFrames)
+	}
+	switch o.BlendMode {
+	case "", "extend", "within":
+		return nil
+	}
+	return fmt.Errorf("invalid start_at_rest.blend_mode %q, expected extend or within", o.BlendMode)
+}
+
+// What start_at_rest did to a clip, reported in meta.start_at_rest
+type restBlendReport struct {
+	BlendMode string `json:"blend_mode"`
+	// Blend frames at the start, and at the end of a loop; 0 where the
+	// model's frame was only snapped to rest
+	StartBlendFrames int `json:"start_blend_frames"`
+	EndBlendFrames   int `json:"end_blend_frames,omitempty"`
+	// Frames the blends added to the clip in extend mode
+	AddedFrames int `json:"added_frames"`
+	// Largest distance from rest of any point in the model's first frame
+	StartOffset float64 `json:"start_offset"`
+}
+
+// maxOffset returns the largest delta length in a frame
+func maxOffset(frame map[int]Deformation) float64 {
+	largest := 0.0
+	for _, d := range frame {
+		largest = math.Max(largest, math.Sqrt(d.DeltaX*d.DeltaX+d.DeltaY*d.DeltaY+d.DeltaZ*d.DeltaZ))
+	}
+	return largest
+}
+
+// restBlend fades the clip in from rest at its start. A first frame within
+// popDistance of rest is set to rest; one further away is blended into over
+// the given number of frames, either added before the clip (extend) or laid
+// over its first frames (within). It returns the clip and the blend frames
+// used, 0 when the frame was snapped.
+func restBlend(frames ResponsePayload, frameCount int, mode string, popDistance float64) (ResponsePayload, int) {
+	first := frames[0]
+	if mode == "within" {
+		frameCount = min(frameCount, len(frames)-1)
+	}
+	if maxOffset(first) <= popDistance || frameCount == 0 {
+		frames[0] = scaleFrame(first, 0)
+		return frames, 0
+	}
+	if mode == "within" {
+		for i := range frameCount {
+			frames[i] = scaleFrame(frames[i], easingCurve("sine", float64(i)/float64(frameCount)))
+		}
+		return frames, frameCount
+	}
+	blend := make(ResponsePayload, frameCount, frameCount+len(frames))
+	for i := range frameCount {
+		blend[i] = scaleFrame(first, easingCurve("sine", float64(i)/float64(frameCount)))
+	}
+	return append(blend, frames...), frameCount
+}
+
+func scaleFrame(frame map[int]Deformation, w float64) map[int]Deformation {
+	scaled := make(map[int]Deformation, len(frame))
+	for id, d := range frame {
+		scaled[id] = scaleDelta(d, w)
+	}
+	return scaled
+}
+
+// applyStartAtRest makes the clip's first frame exactly the rest pose. A
+// loop must also come back to rest, so its last frame is treated the same
+// way, with the blend running towards rest. Blended frames are rounded to
+// each point's precision.
+func applyStartAtRest(frames ResponsePayload, payload RequestPayload, t pointTables) (ResponsePayload, *restBlendReport, []string) {
+	opts := payload.StartAtRest
+	if !restBlendEnabled(opts) || len(frames) == 0 {
+		return frames, nil, nil
+	}
+	blendFrames := opts.BlendFrames
+	if blendFrames == 0 {
+		blendFrames = defaultRestBlendFrames
+	}
+	report := &restBlendReport{BlendMode: opts.BlendMode, StartOffset: roundTo(maxOffset(frames[0]), 6)}
+	if report.BlendMode == "" {
+		report.BlendMode = "extend"
+	}
+	popDistance := restPopFraction * rigDiagonal(t.rest)
+	length := len(frames)
+
+	frames = copyFrames(frames)
+	frames, report.StartBlendFrames = restBlend(frames, blendFrames, report.BlendMode, popDistance)
+	if payload.Loop && len(frames) > 1 {
+		frames = reverseFrames(frames)
+		frames, report.EndBlendFrames = restBlend(frames, blendFrames, report.BlendMode, popDistance)
+		frames = reverseFrames(frames)
+	}
+	report.AddedFrames = len(frames) - length
+
+	var warnings []string
+	if report.StartBlendFrames > 0 {
+		warnings = append(warnings, fmt.Sprintf("The model's first frame was %g from rest; start_at_rest blended into it over %d frames", report.StartOffset, report.StartBlendFrames))
+	}
+	if report.EndBlendFrames > 0 {
+		warnings = append(warnings, fmt.Sprintf("The looping clip ended away from rest; start_at_rest blended back to rest over its last %d frames", report.EndBlendFrames))
+	}
+	return roundFrames(frames, t.places), report, warnings
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+// offsetResponse starts the right hand offset above its rest position and
+// raises it further each frame
+func offsetResponse(offset float64) func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		return framesResponse(input.Length, func(f int) map[string]Position {
+			frame := make(map[string]Position, len(input.ControlPoints))
+			for _, cp := range input.ControlPoints {
+				p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+				if cp.ID == 2 {
+					p.Y += offset + 0.01*float64(f)
+				}
+				frame[strconv.Itoa(cp.ID)] = p
+			}
+			return frame
+		}), nil
+	}
+}
+
+func TestStartAtRest(t *testing.T) {
+	fake := setupServer(t, nil)
+	type response struct {
+		Frames []map[int]Deformation `json:"frames"`
+		Meta   generationMeta        `json:"meta"`
+	}
+	send := func(offset float64, opts *StartAtRest, loop bool) response {
+		t.Helper()
+		fake.respond = offsetResponse(offset)
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "raise the right hand", Length: 8, CacheMode: cacheFresh, StartAtRest: opts, Loop: loop}}
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("start_at_rest %+v: status %d: %s", opts, rec.Code, rec.Body)
+		}
+		return decodeBody[response](t, rec)
+	}
+	atRest := func(frame map[int]Deformation) bool {
+		for _, d := range frame {
+			if d != (Deformation{}) {
+				return false
+			}
+		}
+		return true
+	}
+
+	t.Run("snap", func(t *testing.T) {
+		// Within 2% of the rig's size the first frame is simply set to rest
+		body := send(0.01, &StartAtRest{}, false)
+		if len(body.Frames) != 8 || !atRest(body.Frames[0]) {
+			t.Errorf("%d frames starting at %v, want 8 starting at rest", len(body.Frames), body.Frames[0])
+		}
+		if r := body.Meta.StartAtRest; r == nil || r.StartBlendFrames != 0 || r.AddedFrames != 0 || r.BlendMode != "extend" {
+			t.Errorf("report %+v, want a snap in extend mode", r)
+		}
+		if !strings.Contains(fake.requests[len(fake.requests)-1].Messages[0].Content, "Frame 0 must be the rest pose") {
+			t.Error("the model was not asked to start at rest")
+		}
+	})
+
+	t.Run("extend", func(t *testing.T) {
+		body := send(0.3, &StartAtRest{BlendFrames: 4}, false)
+		if len(body.Frames) != 12 || !atRest(body.Frames[0]) {
+			t.Fatalf("%d frames starting at %v, want 12 starting at rest", len(body.Frames), body.Frames[0])
+		}
+		// The blend rises steadily into the model's first frame
+		for i := 1; i < 5; i++ {
+			if body.Frames[i][2].DeltaY <= body.Frames[i-1][2].DeltaY {
+				t.Errorf("frame %d: hand at %v, not above frame %d", i, body.Frames[i][2].DeltaY, i-1)
+			}
+		}
+		if got := body.Frames[4][2].DeltaY; got != 0.3 {
+			t.Errorf("model's first frame at %v after the blend, want 0.3", got)
+		}
+		want := restBlendReport{BlendMode: "extend", StartBlendFrames: 4, AddedFrames: 4, StartOffset: 0.3}
+		if r := body.Meta.StartAtRest; r == nil || *r != want {
+			t.Errorf("report %+v, want %+v", r, want)
+		}
+		if !slices.ContainsFunc(body.Meta.Warnings, func(w string) bool { return strings.Contains(w, "blended into it over 4 frames") }) {
+			t.Errorf("warnings %q lack the blend", body.Meta.Warnings)
+		}
+	})
+
+	t.Run("within", func(t *testing.T) {
+		body := send(0.3, &StartAtRest{BlendFrames: 4, BlendMode: "within"}, false)
+		if len(body.Frames) != 8 || !atRest(body.Frames[0]) {
+			t.Fatalf("%d frames starting at %v, want 8 starting at rest", len(body.Frames), body.Frames[0])
+		}
+		if got := body.Frames[4][2].DeltaY; got != 0.34 {
+			t.Errorf("frame 4 at %v, want the model's 0.34 once the blend is over", got)
+		}
+	})
+
+	t.Run("loop", func(t *testing.T) {
+		body := send(0.3, &StartAtRest{BlendFrames: 3}, true)
+		last := body.Frames[len(body.Frames)-1]
+		if len(body.Frames) != 14 || !atRest(body.Frames[0]) || !atRest(last) {
+			t.Errorf("%d frames from %v to %v, want 14 starting and ending at rest", len(body.Frames), body.Frames[0], last)
+		}
+		if r := body.Meta.StartAtRest; r == nil || r.EndBlendFrames != 3 || r.AddedFrames != 6 {
+			t.Errorf("report %+v, want 3 end blend frames and 6 added", r)
+		}
+	})
+
+	t.Run("off", func(t *testing.T) {
+		body := send(0.3, &StartAtRest{Disabled: true}, false)
+		if len(body.Frames) != 8 || body.Frames[0][2].DeltaY != 0.3 || body.Meta.StartAtRest != nil {
+			t.Errorf("start_at_rest false changed the clip: %d frames from %v, report %+v", len(body.Frames), body.Frames[0], body.Meta.StartAtRest)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, opts := range []*StartAtRest{
+			{BlendMode: "fade"},
+			{BlendFrames: maxRestBlendFrames + 1},
+			{BlendFrames: -1},
+		} {
+			payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 8, StartAtRest: opts}}
+			if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+				t.Errorf("%+v: status %d, want 400", opts, rec.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// validateRestPose checks that rest_pose only gives positions for control
+// points in the request
+func validateRestPose(rest map[int]Position, points []ControlPoint) error {
+	for _, id := range sortedKeys(rest) {
+		if !slices.ContainsFunc(points, func(cp ControlPoint) bool { return cp.ID == id }) {
+			return fmt.Errorf("rest_pose: %d is not a control point", id)
+		}
+		p := rest[id]
+		for i, v := range []float64{p.X, p.Y, p.Z} {
+			if !isFinite(v) {
+				return fmt.Errorf("rest_pose: %d: %s is %v, positions must be finite numbers", id, positionAxis(i), v)
+			}
+		}
+	}
+	return nil
+}
+
+// restPosePoints returns a copy of the control points in their rest pose:
+// at their rest_pose positions where the request gives one and where they
+// are otherwise
+func restPosePoints(points []ControlPoint, rest map[int]Position) []ControlPoint {
+	points = slices.Clone(points)
+	for i, cp := range points {
+		if p, ok := rest[cp.ID]; ok {
+			points[i].Position = []float64{p.X, p.Y, p.Z}
+		}
+	}
+	return points
+}
+
+// restOffsets returns how far each point's input position is from its rest
+// position, for the points rest_pose moves
+func restOffsets(points []ControlPoint, rest map[int]Position) map[int]Deformation {
+	offsets := make(map[int]Deformation)
+	for _, cp := range points {
+		if p, ok := rest[cp.ID]; ok && len(cp.Position) >= 3 {
+			offsets[cp.ID] = Deformation{DeltaX: cp.Position[0] - p.X, DeltaY: cp.Position[1] - p.Y, DeltaZ: cp.Position[2] - p.Z}
+		}
+	}
+	return offsets
+}
+
+// shiftFrames adds sign times each point's offset to its deltas in every
+// frame, in place
+func shiftFrames(frames ResponsePayload, offsets map[int]Deformation, sign float64) ResponsePayload {
+	for _, frame := range frames {
+		for id, o := range offsets {
+			if d, ok := frame[id]; ok {
+				frame[id] = Deformation{DeltaX: d.DeltaX + sign*o.DeltaX, DeltaY: d.DeltaY + sign*o.DeltaY, DeltaZ: d.DeltaZ + sign*o.DeltaZ}
+			}
+		}
+	}
+	return frames
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestValidateRestPose(t *testing.T) {
+	if err := validateRestPose(map[int]Position{2: {X: -0.6, Y: 1, Z: 0}}, testRig()); err != nil {
+		t.Errorf("valid rest pose: %v", err)
+	}
+	for name, rest := range map[string]map[int]Position{
+		"unknown point": {9: {}},
+		"NaN":           {2: {X: -0.6, Y: math.NaN()}},
+		"infinite":      {2: {Z: math.Inf(-1)}},
+	} {
+		if err := validateRestPose(rest, testRig()); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestRestPose(t *testing.T) {
+	fake := setupServer(t, nil)
+	// The right hand is given raised 0.2 above its rest, and the model
+	// raises it 0.01 a frame further
+	fake.respond = offsetResponse(0)
+	send := func(rest *StartAtRest) []map[int]Deformation {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{
+			ControlPoints: testRig(),
+			RestPose:      map[int]Position{2: {X: -0.6, Y: 1.0, Z: 0}},
+			Prompt:        "keep raising the right hand",
+			Length:        6,
+			CacheMode:     cacheFresh,
+			StartAtRest:   rest,
+		}}
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		return decodeBody[[]map[int]Deformation](t, rec)
+	}
+
+	// Deltas are measured from the rest pose, not the given positions
+	for f, frame := range send(nil) {
+		if got, want := frame[2].DeltaY, 0.2+0.01*float64(f); math.Abs(got-want) > 1e-9 {
+			t.Errorf("frame %d: hand at %v, want %v from rest", f, got, want)
+		}
+		if frame[1] != (Deformation{}) {
+			t.Errorf("frame %d: left hand at %+v, want it at rest", f, frame[1])
+		}
+	}
+	system := fake.requests[len(fake.requests)-1].Messages[0].Content
+	if !strings.Contains(system, "current pose, partway through its motion") {
+		t.Error("the model was not told the positions are the current pose")
+	}
+
+	t.Run("start_at_rest", func(t *testing.T) {
+		// The blend starts from the rest pose, not the given one
+		frames := send(&StartAtRest{BlendFrames: 2})
+		if len(frames) != 8 || frames[0][2] != (Deformation{}) {
+			t.Fatalf("%d frames starting at %+v, want 8 starting at rest", len(frames), frames[0][2])
+		}
+		if got := frames[2][2].DeltaY; math.Abs(got-0.2) > 1e-9 {
+			t.Errorf("model's first frame at %v after the blend, want 0.2", got)
+		}
+		if system := fake.requests[len(fake.requests)-1].Messages[0].Content; strings.Contains(system, "Frame 0 must be the rest pose") {
+			t.Error("the model was asked to start at a rest pose it never sees")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), RestPose: map[int]Position{9: {}}, Prompt: "wave", Length: 4}}
+		if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("unknown point: status %d, want 400", rec.Code)
+		}
+	})
+}
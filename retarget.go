@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Input struct for the /retarget endpoint
+type RetargetRequest struct {
+	Frames       ResponsePayload `json:"frames"`
+	SourcePoints []ControlPoint  `json:"source_control_points"`
+	TargetPoints []ControlPoint  `json:"target_control_points"`
+}
+
+// How a clip was mapped onto the target rig
+type RetargetMeta struct {
+	// Factor applied to every delta: the ratio of the rigs' bounding-box diagonals
+	Scale float64 `json:"scale"`
+	// Target point ID → source point ID it copies
+	Matches map[int]int `json:"matches"`
+	// Target points with no source point of the same role; they stay static
+	Unmatched []int `json:"unmatched"`
+}
+
+type RetargetResponse struct {
+	Frames ResponsePayload `json:"frames"`
+	Meta   RetargetMeta    `json:"meta"`
+}
+
+func validateRetarget(req RetargetRequest) error {
+	if len(req.Frames) == 0 || len(req.SourcePoints) == 0 || len(req.TargetPoints) == 0 {
+		return fmt.Errorf("missing frames, source_control_points or target_control_points")
+	}
+	for _, points := range [][]ControlPoint{req.SourcePoints, req.TargetPoints} {
+		for _, cp := range points {
+			if len(cp.Position) < 3 {
+				return fmt.Errorf("control point %d needs an [x, y, z] position", cp.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// matchRoles pairs target points with source points of the same role,
+// ignoring case and surrounding spaces. Points sharing a role are paired in
+// the order they are listed.
+func matchRoles(source, target []ControlPoint) (map[int]int, []int) {
+	byRole := make(map[string][]int)
+	for _, cp := range source {
+		role := strings.ToLower(strings.TrimSpace(cp.Role))
+		byRole[role] = append(byRole[role], cp.ID)
+	}
+	matches := make(map[int]int)
+	unmatched := []int{}
+	for _, cp := range target {
+		role := strings.ToLower(strings.TrimSpace(cp.Role))
+		if ids := byRole[role]; len(ids) > 0 {
+			matches[cp.ID] = ids[0]
+			byRole[role] = ids[1:]
+		} else {
+			unmatched = append(unmatched, cp.ID)
+		}
+	}
+	return matches, unmatched
+}
+
+// retargetFrames copies each matched source point's deltas to its target
+// point, scaled from the source rig's size to the target's. Only matched
+// points count towards the sizes, so extra points on either rig do not skew
+// the scale. Unmatched target points get zero deltas in every frame.
+func retargetFrames(frames ResponsePayload, source, target []ControlPoint) (ResponsePayload, RetargetMeta) {
+	matches, unmatched := matchRoles(source, target)
+
+	sourceByID := make(map[int]ControlPoint, len(source))
+	for _, cp := range source {
+		sourceByID[cp.ID] = cp
+	}
+	var matchedSource, matchedTarget []ControlPoint
+	for _, cp := range target {
+		if sourceID, ok := matches[cp.ID]; ok {
+			matchedSource = append(matchedSource, sourceByID[sourceID])
+			matchedTarget = append(matchedTarget, cp)
+		}
+	}
+	scale := 1.0
+	if d := rigDiagonal(matchedSource); d > 0 {
+		scale = rigDiagonal(matchedTarget) / d
+	}
+
+	result := make(ResponsePayload, len(frames))
+	for i, frame := range frames {
+		result[i] = make(map[int]Deformation, len(target))
+		for _, cp := range target {
+			var d Deformation
+			if sourceID, ok := matches[cp.ID]; ok {
+				src := frame[sourceID]
+				d = Deformation{DeltaX: src.DeltaX * scale, DeltaY: src.DeltaY * scale, DeltaZ: src.DeltaZ * scale}
+			}
+			result[i][cp.ID] = d
+		}
+	}
+	result = roundFrames(result, deltaPlaces(target))
+	return result, RetargetMeta{Scale: roundTo(scale, 4), Matches: matches, Unmatched: unmatched}
+}
+
+// Handler for the /retarget endpoint
+func retarget(w http.ResponseWriter, r *http.Request) {
+	var req RetargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	if err := validateRetarget(req); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+
+	frames, meta := retargetFrames(req.Frames, req.SourcePoints, req.TargetPoints)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(RetargetResponse{Frames: frames, Meta: meta}); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
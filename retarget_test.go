@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestRetarget(t *testing.T) {
+	setupServer(t, nil)
+	// An unmatched source point far from the rig must not change the scale
+	source := append(testRig(), ControlPoint{ID: 5, Role: "sword tip", Position: []float64{5, 5, 5}})
+	// The target is half the size, with its own IDs, order and role spelling
+	var target []ControlPoint
+	for _, cp := range slices.Backward(testRig()) {
+		target = append(target, ControlPoint{
+			ID:       cp.ID + 10,
+			Role:     " " + cp.Role + " ",
+			Position: []float64{cp.Position[0] / 2, cp.Position[1] / 2, cp.Position[2] / 2},
+		})
+	}
+	target[0].Role = "RIGHT FOOT"
+	target = append(target, ControlPoint{ID: 20, Role: "tail", Position: []float64{0, 0.4, -0.3}})
+
+	frames := ResponsePayload{
+		{0: {}, 2: {}, 5: {}},
+		{0: {DeltaX: 0.2}, 2: {DeltaY: 0.4, DeltaZ: -0.1}, 5: {DeltaY: 1}},
+	}
+	rec := serve(t, http.MethodPost, "/retarget", RetargetRequest{Frames: frames, SourcePoints: source, TargetPoints: target}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	got := decodeBody[RetargetResponse](t, rec)
+	if got.Meta.Scale != 0.5 {
+		t.Errorf("scale %v, want 0.5", got.Meta.Scale)
+	}
+	if len(got.Meta.Matches) != 5 || got.Meta.Matches[12] != 2 || got.Meta.Matches[14] != 4 {
+		t.Errorf("matches %v, want each target point paired with the source point of its role", got.Meta.Matches)
+	}
+	if !slices.Equal(got.Meta.Unmatched, []int{20}) {
+		t.Errorf("unmatched %v, want [20]", got.Meta.Unmatched)
+	}
+	want := map[int]Deformation{10: {DeltaX: 0.1}, 12: {DeltaY: 0.2, DeltaZ: -0.05}, 20: {}}
+	for id, d := range want {
+		if got.Frames[1][id] != d {
+			t.Errorf("point %d moved %+v, want %+v", id, got.Frames[1][id], d)
+		}
+	}
+	// Every target point is present in every frame
+	for f, frame := range got.Frames {
+		if len(frame) != len(target) {
+			t.Errorf("frame %d has %d points, want %d", f, len(frame), len(target))
+		}
+	}
+
+	target[1].Position = []float64{0, 1}
+	if rec := serve(t, http.MethodPost, "/retarget", RetargetRequest{Frames: frames, SourcePoints: source, TargetPoints: target}, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("target point without a z: status %d, want 400", rec.Code)
+	}
+}
+
+func TestMatchRolesPairsInOrder(t *testing.T) {
+	source := []ControlPoint{{ID: 0, Role: "finger"}, {ID: 1, Role: "finger"}, {ID: 2, Role: "thumb"}}
+	target := []ControlPoint{{ID: 7, Role: "Finger"}, {ID: 8, Role: "finger"}, {ID: 9, Role: "finger"}}
+	matches, unmatched := matchRoles(source, target)
+	if len(matches) != 2 || matches[7] != 0 || matches[8] != 1 {
+		t.Errorf("matches %v, want 7→0 and 8→1", matches)
+	}
+	if !slices.Equal(unmatched, []int{9}) {
+		t.Errorf("unmatched %v, want the third finger", unmatched)
+	}
+}
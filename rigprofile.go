@@ -0,0 +1,143 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"maps"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Statistics of a control point set that depend on nothing but its points.
+// They are computed once per rig and shared by every request that sends the
+// same points, inline or by rig_id, so nothing may modify them.
+type RigProfile struct {
+	// Content hash of the points
+	Hash     string
+	Height   float64
+	Diagonal float64
+	// Corners of the bounding box
+	Min, Max [3]float64
+	// Lowercased roles, by control point ID
+	Roles      map[int]string
+	Categories map[int]string
+	Places     map[int]int
+	// Motion budgets from roles and categories alone, before constraints
+	Budgets map[int]float64
+}
+
+func newRigProfile(hash string, points []ControlPoint) *RigProfile {
+	p := &RigProfile{
+		Hash:       hash,
+		Height:     characterHeight(points),
+		Diagonal:   rigDiagonal(points),
+		Roles:      make(map[int]string, len(points)),
+		Categories: make(map[int]string, len(points)),
+		Places:     deltaPlaces(points),
+		Budgets:    priorBudgets(points),
+	}
+	for axis := range 3 {
+		p.Min[axis], p.Max[axis] = math.Inf(1), math.Inf(-1)
+	}
+	for _, cp := range points {
+		p.Roles[cp.ID] = strings.ToLower(cp.Role)
+		p.Categories[cp.ID] = pointCategory(cp)
+		if len(cp.Position) < 3 {
+			continue
+		}
+		for axis := range 3 {
+			p.Min[axis] = math.Min(p.Min[axis], cp.Position[axis])
+			p.Max[axis] = math.Max(p.Max[axis], cp.Position[axis])
+		}
+	}
+	return p
+}
+
+// In-memory LRU cache of rig profiles keyed by content hash. A rig that is
+// sent again moves to the front; the least recently used profile is evicted
+// once the cache holds maxEntries.
+type rigProfileCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+func newRigProfileCache(maxEntries int) *rigProfileCache {
+	return &rigProfileCache{maxEntries: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+var rigProfiles = newRigProfileCache(cfg.RigProfileCacheSize)
+
+// profileHash returns the content hash of a control point set. It hashes
+// the points' binary form rather than their JSON as rigHash does, which would
+// cost more than the statistics it saves.
+func profileHash(points []ControlPoint) string {
+	h := sha256.New()
+	var buf []byte
+	for _, cp := range points {
+		buf = binary.LittleEndian.AppendUint64(buf[:0], uint64(cp.ID))
+		for _, s := range []string{cp.Role, cp.Category} {
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+			buf = append(buf, s...)
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(cp.Position)))
+		for _, v := range cp.Position {
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+		}
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// profile returns the profile of a control point set, computing and caching
+// it on first sight
+func (c *rigProfileCache) profile(points []ControlPoint) *RigProfile {
+	hash := profileHash(points)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[hash]; ok {
+		c.order.MoveToFront(elem)
+		c.mu.Unlock()
+		incCounter("rig_profile_cache_total", "outcome", "hit", 1)
+		return elem.Value.(*RigProfile)
+	}
+	c.mu.Unlock()
+
+	// Computed outside the lock; a rig sent twice at once is profiled twice
+	// and the first to finish is kept
+	p := newRigProfile(hash, points)
+	incCounter("rig_profile_cache_total", "outcome", "miss", 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[hash]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*RigProfile)
+	}
+	c.entries[hash] = c.order.PushFront(p)
+	evicted := 0
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*RigProfile).Hash)
+		evicted++
+	}
+	countEvictions("rig_profiles", evicted)
+	return p
+}
+
+func (c *rigProfileCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// budgets returns the profile's motion budgets with the request's
+// constraints applied, as a map the caller owns
+func (p *RigProfile) budgets(c *MotionConstraints) map[int]float64 {
+	return constrainBudgets(maps.Clone(p.Budgets), p.Roles, c)
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestRigProfileCache(t *testing.T) {
+	setupServer(t, nil)
+	evictions := func() float64 {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		return metrics.counters["store_evictions_total"][formatLabel("store", "rig_profiles")]
+	}
+	// rig returns testRig moved offset along x
+	rig := func(offset float64) []ControlPoint {
+		points := testRig()
+		for i := range points {
+			points[i].Position = []float64{points[i].Position[0] + offset, points[i].Position[1], points[i].Position[2]}
+		}
+		return points
+	}
+	before := evictions()
+	cache := newRigProfileCache(2)
+
+	a, b := cache.profile(rig(0)), cache.profile(rig(1))
+	if a.Hash == b.Hash || a.Height != 1.7 {
+		t.Fatalf("profiles %+v and %+v, want different hashes and a height of 1.7", a, b)
+	}
+	// The same points sent again share the profile, and move to the front
+	if again := cache.profile(rig(0)); again != a {
+		t.Error("an identical rig was profiled again")
+	}
+	cache.profile(rig(2))
+	if cache.size() != 2 || evictions() != before+1 {
+		t.Fatalf("%d profiles after %v evictions, want 2 after one", cache.size(), evictions()-before)
+	}
+	// The least recently used rig went
+	if cache.profile(rig(0)) != a {
+		t.Error("the most recently used rig was evicted")
+	}
+	if cache.profile(rig(1)) == b {
+		t.Error("the least recently used rig was kept")
+	}
+
+	// Roles are part of the content
+	renamed := rig(0)
+	renamed[0].Role = "hat"
+	if profileHash(renamed) == a.Hash {
+		t.Error("a renamed point kept the hash")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+const rigCollection = "rigs"
+
+// A registered control point set, addressed by the hash of its contents
+type Rig struct {
+	RigID         string         `json:"rig_id"`
+	ControlPoints []ControlPoint `json:"control_points"`
+}
+
+var validRigID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+var (
+	errUnknownRig  = errors.New("unknown rig_id")
+	errRigConflict = errors.New("specify either rig_id or control_points, not both")
+)
+
+// rigHash returns the content-addressed ID of a control point set
+func rigHash(points []ControlPoint) (string, error) {
+	raw, err := json.Marshal(points)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// resolveRig replaces a rig_id reference with the stored control points
+func resolveRig(payload *RequestPayload) error {
+	if payload.RigID == "" {
+		return nil
+	}
+	if len(payload.ControlPoints) > 0 {
+		return errRigConflict
+	}
+	if !validRigID.MatchString(payload.RigID) {
+		return errUnknownRig
+	}
+	var rig Rig
+	found, err := store.Get(rigCollection, payload.RigID, &rig)
+	if err != nil {
+		return fmt.Errorf("load rig: %w", err)
+	}
+	if !found {
+		return errUnknownRig
+	}
+	payload.ControlPoints = rig.ControlPoints
+	return nil
+}
+
+// Handler for the /rigs endpoint
+func registerRig(w http.ResponseWriter, r *http.Request) {
+	var rig Rig
+	if err := json.NewDecoder(r.Body).Decode(&rig); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	if len(rig.ControlPoints) == 0 {
+		writeError(w, newAPIError(http.StatusBadRequest, "Missing control_points"))
+		return
+	}
+	if err := validateFinitePositions(rig.ControlPoints); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+
+	id, err := rigHash(rig.ControlPoints)
+	if err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to hash rig"))
+		return
+	}
+	rig.RigID = id
+
+	// Identical rigs share an ID, so re-registering is a no-op
+	status := http.StatusOK
+	var existing Rig
+	found, err := store.Get(rigCollection, id, &existing)
+	if err != nil {
+		log.Printf("Failed to load rig %s: %v", id, err)
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to load rig"))
+		return
+	}
+	if !found {
+		if err := store.Put(rigCollection, id, rig); err != nil {
+			log.Printf("Failed to store rig %s: %v", id, err)
+			writeError(w, newAPIError(http.StatusInternalServerError, "Failed to store rig"))
+			return
+		}
+		status = http.StatusCreated
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"rig_id": id})
+}
+
+// Handler for the /rigs/{id} endpoint
+func getRig(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !validRigID.MatchString(id) {
+		writeError(w, newAPIError(http.StatusNotFound, "Rig not found"))
+		return
+	}
+	var rig Rig
+	found, err := store.Get(rigCollection, id, &rig)
+	if err != nil {
+		log.Printf("Failed to load rig %s: %v", id, err)
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to load rig"))
+		return
+	}
+	if !found {
+		writeError(w, newAPIError(http.StatusNotFound, "Rig not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rig); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
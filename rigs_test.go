@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestRigRegistration(t *testing.T) {
+	fake := setupServer(t, nil)
+	body := map[string]any{"control_points": testRig()}
+
+	first := serve(t, http.MethodPost, "/rigs", body, nil)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first registration: status %d, want 201: %s", first.Code, first.Body)
+	}
+	id := decodeBody[Rig](t, first).RigID
+	if !validRigID.MatchString(id) {
+		t.Fatalf("rig_id %q is not a content hash", id)
+	}
+
+	// The same points get the same ID without being stored again
+	again := serve(t, http.MethodPost, "/rigs", body, nil)
+	if again.Code != http.StatusOK || decodeBody[Rig](t, again).RigID != id {
+		t.Errorf("re-registration: status %d, body %s; want 200 with rig_id %s", again.Code, again.Body, id)
+	}
+	moved := testRig()
+	moved[0].Position = []float64{0, 1.75, 0}
+	if other := serve(t, http.MethodPost, "/rigs", map[string]any{"control_points": moved}, nil); decodeBody[Rig](t, other).RigID == id {
+		t.Error("a different rig got the same rig_id")
+	}
+
+	fetched := serve(t, http.MethodGet, "/rigs/"+id, nil, nil)
+	if rig := decodeBody[Rig](t, fetched); fetched.Code != http.StatusOK || rig.RigID != id || len(rig.ControlPoints) != len(testRig()) {
+		t.Errorf("GET: status %d, body %s", fetched.Code, fetched.Body)
+	}
+
+	// A rig_id stands in for the control points
+	payload := RequestPayload{RequestPayload: api.RequestPayload{RigID: id, Prompt: "sway gently", Length: 4}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+		t.Fatalf("generate by rig_id: status %d: %s", rec.Code, rec.Body)
+	}
+	input, err := modelInputOf(fake.requests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := make([]string, len(input.ControlPoints))
+	for i, cp := range input.ControlPoints {
+		roles[i] = cp.Role
+	}
+	if !slices.Equal(roles, []string{"head", "left hand", "right hand", "left foot", "right foot"}) {
+		t.Errorf("model was sent roles %v, want the registered rig's", roles)
+	}
+}
+
+func TestRigReferenceErrors(t *testing.T) {
+	setupServer(t, nil)
+	id := decodeBody[Rig](t, serve(t, http.MethodPost, "/rigs", map[string]any{"control_points": testRig()}, nil)).RigID
+
+	for _, tc := range []struct {
+		name    string
+		payload api.RequestPayload
+		message string
+	}{
+		{"both", api.RequestPayload{RigID: id, ControlPoints: testRig(), Prompt: "wave", Length: 4}, errRigConflict.Error()},
+		{"unknown", api.RequestPayload{RigID: strings.Repeat("0", 32), Prompt: "wave", Length: 4}, errUnknownRig.Error()},
+		{"malformed", api.RequestPayload{RigID: "../../etc/passwd", Prompt: "wave", Length: 4}, errUnknownRig.Error()},
+	} {
+		rec := serve(t, http.MethodPost, "/generate-deformations", RequestPayload{RequestPayload: tc.payload}, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", tc.name, rec.Code)
+			continue
+		}
+		if got := decodeBody[errorResponse](t, rec).Error.Message; !strings.Contains(got, tc.message) {
+			t.Errorf("%s: message %q, want %q", tc.name, got, tc.message)
+		}
+	}
+
+	for _, path := range []string{"/rigs/" + strings.Repeat("0", 32), "/rigs/not-a-hash"} {
+		if rec := serve(t, http.MethodGet, path, nil, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", path, rec.Code)
+		}
+	}
+	if rec := serve(t, http.MethodPost, "/rigs", map[string]any{"control_points": []ControlPoint{}}, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty rig: status %d, want 400", rec.Code)
+	}
+}
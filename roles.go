@@ -0,0 +1,225 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// Role given to the points inference cannot place
+const genericRole = "point"
+
+// Layout thresholds, as fractions of the character's height
+const (
+	// Points this close to the vertical centre line are on the spine
+	centreColumnWidth = 0.1
+	// Pairs further apart than this from mirror images are not symmetric
+	symmetryTolerance = 0.1
+	// Rigs that stray less than this from one line are degenerate
+	minRigSpread = 0.05
+)
+
+// Normalised layout of a point: height from the lowest point (0) to the
+// highest (1), and lateral offset from the centre line, positive on the
+// character's left
+type bodyCoords struct {
+	height, lateral float64
+}
+
+// bodyLayout finds the character's orientation: the declared axes when the
+// request has them, otherwise from the bounding box of the positioned
+// points, upright along y or z, whichever is taller. Its left is the
+// declared one, or +x as in the API examples (+y when x is up). Fewer than
+// three points, a flat height or points on one line give no layout.
+func bodyLayout(points []ControlPoint, axes *AxesConvention) (map[int]bodyCoords, bool) {
+	lo := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	var positioned []int
+	for i, cp := range points {
+		if len(cp.Position) < 3 {
+			continue
+		}
+		positioned = append(positioned, i)
+		for axis := range 3 {
+			lo[axis] = math.Min(lo[axis], cp.Position[axis])
+			hi[axis] = math.Max(hi[axis], cp.Position[axis])
+		}
+	}
+	if len(positioned) < 3 {
+		return nil, false
+	}
+	up := axisDirection{index: 1, sign: 1}
+	if axes != nil {
+		up = upAxis(axes)
+	} else if hi[2]-lo[2] > hi[1]-lo[1] {
+		up.index = 2
+	}
+	left, declared := leftAxis(axes)
+	if !declared {
+		left = [3]float64{1, 0, 0}
+		if up.index == 0 {
+			left = [3]float64{0, 1, 0}
+		}
+	}
+	height := hi[up.index] - lo[up.index]
+	if height <= 0 {
+		return nil, false
+	}
+	base := lo[up.index]
+	if up.sign < 0 {
+		base = -hi[up.index]
+	}
+
+	// Distance of every point from the line through the lowest and highest
+	lowest, highest := positioned[0], positioned[0]
+	for _, i := range positioned {
+		if up.of(points[i].Position) < up.of(points[lowest].Position) {
+			lowest = i
+		}
+		if up.of(points[i].Position) > up.of(points[highest].Position) {
+			highest = i
+		}
+	}
+	a, b := points[lowest].Position, points[highest].Position
+	axis := [3]float64{b[0] - a[0], b[1] - a[1], b[2] - a[2]}
+	axisLength := math.Sqrt(axis[0]*axis[0] + axis[1]*axis[1] + axis[2]*axis[2])
+	spread := 0.0
+	for _, i := range positioned {
+		p := points[i].Position
+		v := [3]float64{p[0] - a[0], p[1] - a[1], p[2] - a[2]}
+		cross := [3]float64{v[1]*axis[2] - v[2]*axis[1], v[2]*axis[0] - v[0]*axis[2], v[0]*axis[1] - v[1]*axis[0]}
+		spread = math.Max(spread, math.Sqrt(cross[0]*cross[0]+cross[1]*cross[1]+cross[2]*cross[2])/axisLength)
+	}
+	if spread < minRigSpread*height {
+		return nil, false
+	}
+
+	centre := 0.0
+	for axis, c := range left {
+		centre += c * (lo[axis] + hi[axis]) / 2
+	}
+	layout := make(map[int]bodyCoords, len(positioned))
+	for _, i := range positioned {
+		p := points[i].Position
+		lateral := left[0]*p[0] + left[1]*p[1] + left[2]*p[2]
+		layout[i] = bodyCoords{height: (up.of(p) - base) / height, lateral: (lateral - centre) / height}
+	}
+	return layout, true
+}
+
+// inferRoles fills in the roles of the points that have none, in place, and
+// returns what it assigned. Only empty roles are touched. From the layout
+// along the declared axes, or the guessed ones:
+//
+//   - the highest point, alone at the top of the centre line, is the head
+//   - the lowest point on each side, in the bottom fifth, is a foot
+//   - the point furthest out on each side at mid height is a hand
+//   - the centre point nearest half height is the pelvis, and centre points
+//     between it and the head are the spine
+//
+// Feet and hands found as a mirror-image pair score higher than a lone one.
+// Every other point, and every point of a rig with no usable layout, gets
+// the generic role "point" with confidence 0.
+func inferRoles(points []ControlPoint, axes *AxesConvention) []inferredRole {
+	assigned := make(map[int]inferredRole)
+	var unlabelled []int
+	for i, cp := range points {
+		if strings.TrimSpace(cp.Role) == "" {
+			unlabelled = append(unlabelled, i)
+			assigned[i] = inferredRole{ID: cp.ID, Role: genericRole}
+		}
+	}
+	if len(unlabelled) == 0 {
+		return nil
+	}
+
+	if layout, ok := bodyLayout(points, axes); ok {
+		free := func(i int) (bodyCoords, bool) {
+			c, positioned := layout[i]
+			return c, positioned && assigned[i].Role == genericRole
+		}
+		assign := func(i int, role string, confidence float64) {
+			assigned[i] = inferredRole{ID: points[i].ID, Role: role, Confidence: roundTo(math.Max(0, confidence), 2)}
+		}
+
+		// Head: the top point, if nothing else comes close
+		top, runnerUp := -1, -1
+		for i := range layout {
+			if top < 0 || layout[i].height > layout[top].height || (layout[i].height == layout[top].height && i < top) {
+				top, runnerUp = i, top
+			} else if runnerUp < 0 || layout[i].height > layout[runnerUp].height {
+				runnerUp = i
+			}
+		}
+		if c, ok := free(top); ok && math.Abs(c.lateral) <= 1.5*centreColumnWidth {
+			if gap := c.height - layout[runnerUp].height; gap > 0 {
+				assign(top, "head", 0.5+0.5*math.Min(1, gap/centreColumnWidth))
+			}
+		}
+
+		// Feet and hands: the best candidate on either side of the centre line
+		pair := func(part string, better func(a, b bodyCoords) bool, eligible func(c bodyCoords) bool) {
+			var chosen [2]int
+			for s, side := range [2]float64{1, -1} {
+				chosen[s] = -1
+				for _, i := range unlabelled {
+					c, ok := free(i)
+					if !ok || side*c.lateral <= centreColumnWidth/2 || !eligible(c) {
+						continue
+					}
+					if chosen[s] < 0 || better(c, layout[chosen[s]]) {
+						chosen[s] = i
+					}
+				}
+			}
+			confidence := 0.5
+			if chosen[0] >= 0 && chosen[1] >= 0 {
+				l, r := layout[chosen[0]], layout[chosen[1]]
+				asymmetry := math.Max(math.Abs(l.lateral+r.lateral), math.Abs(l.height-r.height))
+				if asymmetry <= symmetryTolerance {
+					confidence = 0.9 - 2*asymmetry
+				}
+			}
+			for s, name := range [2]string{"left ", "right "} {
+				if chosen[s] >= 0 {
+					assign(chosen[s], name+part, confidence)
+				}
+			}
+		}
+		pair("foot",
+			func(a, b bodyCoords) bool { return a.height < b.height },
+			func(c bodyCoords) bool { return c.height <= 0.2 })
+		pair("hand",
+			func(a, b bodyCoords) bool { return math.Abs(a.lateral) > math.Abs(b.lateral) },
+			func(c bodyCoords) bool { return c.height >= 0.3 && c.height <= 0.9 && math.Abs(c.lateral) >= 0.15 })
+
+		// Pelvis and spine along the centre line
+		pelvis := -1
+		for _, i := range unlabelled {
+			c, ok := free(i)
+			if !ok || math.Abs(c.lateral) > centreColumnWidth || math.Abs(c.height-0.5) > 0.15 {
+				continue
+			}
+			if pelvis < 0 || math.Abs(c.height-0.5) < math.Abs(layout[pelvis].height-0.5) {
+				pelvis = i
+			}
+		}
+		spineFrom := 0.5
+		if pelvis >= 0 {
+			assign(pelvis, "pelvis", 0.7-2*math.Abs(layout[pelvis].height-0.5))
+			spineFrom = layout[pelvis].height
+		}
+		for _, i := range unlabelled {
+			c, ok := free(i)
+			if ok && math.Abs(c.lateral) <= centreColumnWidth && c.height > spineFrom && c.height < 0.85 {
+				assign(i, "spine", 0.5)
+			}
+		}
+	}
+
+	report := make([]inferredRole, len(unlabelled))
+	for n, i := range unlabelled {
+		report[n] = assigned[i]
+		points[i].Role = assigned[i].Role
+	}
+	return report
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// bareRig is testRig with a pelvis and a spine point, and no roles
+func bareRig() []ControlPoint {
+	points := append(testRig(),
+		ControlPoint{ID: 5, Position: []float64{0, 0.85, 0}},
+		ControlPoint{ID: 6, Position: []float64{0, 1.3, 0}},
+	)
+	for i := range points {
+		points[i].Role = ""
+	}
+	return points
+}
+
+func TestInferRoles(t *testing.T) {
+	points := bareRig()
+	got := inferRoles(points, nil)
+	want := []inferredRole{
+		{ID: 0, Role: "head", Confidence: 1},
+		// Mirror-image pairs, with the character's left on +x
+		{ID: 1, Role: "left hand", Confidence: 0.9},
+		{ID: 2, Role: "right hand", Confidence: 0.9},
+		{ID: 3, Role: "left foot", Confidence: 0.9},
+		{ID: 4, Role: "right foot", Confidence: 0.9},
+		{ID: 5, Role: "pelvis", Confidence: 0.7},
+		{ID: 6, Role: "spine", Confidence: 0.5},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("inferred %+v, want %+v", got, want)
+	}
+	for i, cp := range points {
+		if cp.Role != want[i].Role {
+			t.Errorf("point %d has role %q, want %q filled in", cp.ID, cp.Role, want[i].Role)
+		}
+	}
+
+	t.Run("keeps given roles", func(t *testing.T) {
+		points := bareRig()
+		points[0].Role = "hat"
+		got := inferRoles(points, nil)
+		if points[0].Role != "hat" || slices.ContainsFunc(got, func(r inferredRole) bool { return r.ID == 0 }) {
+			t.Errorf("given role replaced or reported: %q, %+v", points[0].Role, got)
+		}
+		if none := inferRoles(testRig(), nil); none != nil {
+			t.Errorf("fully labelled rig reported %+v", none)
+		}
+	})
+
+	t.Run("lone foot", func(t *testing.T) {
+		points := bareRig()
+		points[4].Role = "tail"
+		got := inferRoles(points, nil)
+		if i := slices.IndexFunc(got, func(r inferredRole) bool { return r.ID == 3 }); got[i] != (inferredRole{ID: 3, Role: "left foot", Confidence: 0.5}) {
+			t.Errorf("lone foot inferred as %+v, want left foot at 0.5", got[i])
+		}
+	})
+
+	t.Run("degenerate", func(t *testing.T) {
+		line := []ControlPoint{
+			{ID: 0, Position: []float64{0, 0, 0}},
+			{ID: 1, Position: []float64{0, 1, 0}},
+			{ID: 2, Position: []float64{0, 2, 0}},
+		}
+		for _, r := range inferRoles(line, nil) {
+			if r.Role != genericRole || r.Confidence != 0 {
+				t.Errorf("point %d on a line inferred as %+v, want %q at 0", r.ID, r, genericRole)
+			}
+		}
+	})
+}
+
+func TestInferRolesOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: bareRig(), Prompt: "wave", Length: 4, InferRoles: true}}
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	body := decodeBody[ResponseEnvelope](t, rec)
+	if len(body.Meta.InferredRoles) != 7 || body.Meta.InferredRoles[0].Role != "head" {
+		t.Errorf("meta.inferred_roles %+v, want all seven points starting with the head", body.Meta.InferredRoles)
+	}
+
+	// The model sees the inferred roles
+	input, err := modelInputOf(fake.requests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cp := range input.ControlPoints {
+		if cp.Role == "" || cp.Role == genericRole {
+			t.Errorf("point %d was sent with role %q", cp.ID, cp.Role)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+func validateRootMotion(mode string, yaw bool) error {
+	switch mode {
+	case "", "baked":
+		if yaw {
+			return fmt.Errorf("root_yaw requires root_motion separate or none")
+		}
+		return nil
+	case "separate", "none":
+		return nil
+	}
+	return fmt.Errorf("invalid root_motion %q, expected baked, separate or none", mode)
+}
+
+// fitRootMotion finds the rigid transform that best maps the rest pose onto
+// a frame in the least-squares sense: the translation between the centroids
+// and, with yaw, the rotation about the vertical axis that best aligns the
+// points around them
+func fitRootMotion(frame map[int]Deformation, rest []ControlPoint, yaw bool) (RootTransform, [3]float64) {
+	var restCentroid, posedCentroid [3]float64
+	n := 0
+	for _, cp := range rest {
+		d, ok := frame[cp.ID]
+		if !ok || len(cp.Position) < 3 {
+			continue
+		}
+		restCentroid[0] += cp.Position[0]
+		restCentroid[1] += cp.Position[1]
+		restCentroid[2] += cp.Position[2]
+		posedCentroid[0] += cp.Position[0] + d.DeltaX
+		posedCentroid[1] += cp.Position[1] + d.DeltaY
+		posedCentroid[2] += cp.Position[2] + d.DeltaZ
+		n++
+	}
+	if n == 0 {
+		return RootTransform{}, restCentroid
+	}
+	for k := range 3 {
+		restCentroid[k] /= float64(n)
+		posedCentroid[k] /= float64(n)
+	}
+	root := RootTransform{
+		DeltaX: posedCentroid[0] - restCentroid[0],
+		DeltaY: posedCentroid[1] - restCentroid[1],
+		DeltaZ: posedCentroid[2] - restCentroid[2],
+	}
+	if !yaw {
+		return root, restCentroid
+	}
+
+	// Heading that maximises the sum of dot products between the centred rest
+	// and posed points in the horizontal plane
+	var cos, sin float64
+	for _, cp := range rest {
+		d, ok := frame[cp.ID]
+		if !ok || len(cp.Position) < 3 {
+			continue
+		}
+		ax, az := cp.Position[0]-restCentroid[0], cp.Position[2]-restCentroid[2]
+		bx := cp.Position[0] + d.DeltaX - posedCentroid[0]
+		bz := cp.Position[2] + d.DeltaZ - posedCentroid[2]
+		cos += ax*bx + az*bz
+		sin += ax*bz - az*bx
+	}
+	if cos != 0 || sin != 0 {
+		root.Yaw = math.Atan2(sin, cos)
+	}
+	return root, restCentroid
+}
+
+// separateRootMotion splits every frame into a root transform and deltas in
+// the root's space, so the local deltas of a character walking forward stay
+// in place
+func separateRootMotion(frames ResponsePayload, rest []ControlPoint, yaw bool) (ResponsePayload, []RootTransform) {
+	local := make(ResponsePayload, len(frames))
+	roots := make([]RootTransform, len(frames))
+	for i, frame := range frames {
+		root, pivot := fitRootMotion(frame, rest, yaw)
+		cos, sin := math.Cos(-root.Yaw), math.Sin(-root.Yaw)
+		local[i] = make(map[int]Deformation, len(frame))
+		for _, cp := range rest {
+			d, ok := frame[cp.ID]
+			if !ok {
+				continue
+			}
+			if len(cp.Position) < 3 {
+				local[i][cp.ID] = d
+				continue
+			}
+			// Undo the translation, then the rotation about the rest centroid
+			x := cp.Position[0] + d.DeltaX - root.DeltaX - pivot[0]
+			y := cp.Position[1] + d.DeltaY - root.DeltaY
+			z := cp.Position[2] + d.DeltaZ - root.DeltaZ - pivot[2]
+			x, z = x*cos-z*sin, x*sin+z*cos
+			local[i][cp.ID] = Deformation{
+				DeltaX: x + pivot[0] - cp.Position[0],
+				DeltaY: y - cp.Position[1],
+				DeltaZ: z + pivot[2] - cp.Position[2],
+			}
+		}
+		roots[i] = root
+	}
+	return local, roots
+}
+
+// applyRootMotion handles the request's root_motion option on post-processed
+// frames: separate returns the root track alongside local deltas, none drops
+// it so the character moves in place, and baked leaves the frames alone
+func applyRootMotion(frames ResponsePayload, payload RequestPayload, t pointTables) (ResponsePayload, []RootTransform) {
+	if payload.RootMotion != "separate" && payload.RootMotion != "none" {
+		return frames, nil
+	}
+	local, roots := separateRootMotion(frames, t.rest, payload.RootYaw)
+	local = roundFrames(local, t.places)
+	if payload.RootMotion == "none" {
+		return local, nil
+	}
+	for i, root := range roots {
+		roots[i] = RootTransform{
+			DeltaX: roundTo(root.DeltaX, 4),
+			DeltaY: roundTo(root.DeltaY, 4),
+			DeltaZ: roundTo(root.DeltaZ, 4),
+			Yaw:    roundTo(root.Yaw, 4),
+		}
+	}
+	return local, roots
+}
+
+// blendRoots mixes two root tracks by weight like blendFrames; tracks of
+// different lengths keep the primary one
+func blendRoots(primary, secondary []RootTransform, weight float64) []RootTransform {
+	if len(primary) != len(secondary) {
+		return primary
+	}
+	mixed := make([]RootTransform, len(primary))
+	for i, a := range primary {
+		b := secondary[i]
+		mixed[i] = RootTransform{
+			DeltaX: roundTo(a.DeltaX+(b.DeltaX-a.DeltaX)*weight, 4),
+			DeltaY: roundTo(a.DeltaY+(b.DeltaY-a.DeltaY)*weight, 4),
+			DeltaZ: roundTo(a.DeltaZ+(b.DeltaZ-a.DeltaZ)*weight, 4),
+			Yaw:    roundTo(a.Yaw+(b.Yaw-a.Yaw)*weight, 4),
+		}
+	}
+	return mixed
+}
+
+// sliceRoot returns the root track of frames [start, end), if there is one
+func sliceRoot(root []RootTransform, start, end int) []RootTransform {
+	if root == nil {
+		return nil
+	}
+	return root[start:end]
+}
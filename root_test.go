@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+// movedFrame moves every point of rig by the rotation yaw about the rig's
+// centroid, from +X towards +Z, then by the translation, with the right
+// hand raised by lift on top
+func movedFrame(rig []ControlPoint, yaw float64, translation [3]float64, lift float64) map[int]Deformation {
+	var cx, cz float64
+	for _, cp := range rig {
+		cx += cp.Position[0] / float64(len(rig))
+		cz += cp.Position[2] / float64(len(rig))
+	}
+	cos, sin := math.Cos(yaw), math.Sin(yaw)
+	frame := make(map[int]Deformation, len(rig))
+	for _, cp := range rig {
+		x, z := cp.Position[0]-cx, cp.Position[2]-cz
+		x, z = x*cos-z*sin, x*sin+z*cos
+		d := Deformation{
+			DeltaX: x + cx + translation[0] - cp.Position[0],
+			DeltaY: translation[1],
+			DeltaZ: z + cz + translation[2] - cp.Position[2],
+		}
+		if cp.Role == "right hand" {
+			d.DeltaY += lift
+		}
+		frame[cp.ID] = d
+	}
+	return frame
+}
+
+func TestSeparateRootMotion(t *testing.T) {
+	rig := testRig()
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	// The root carries the centroid's translation; the raised hand stays in
+	// the local deltas, less its share of the centroid
+	local, roots := separateRootMotion(ResponsePayload{movedFrame(rig, 0, [3]float64{0.3, 0, 0.5}, 0.1)}, rig, false)
+	if r := roots[0]; !near(r.DeltaX, 0.3) || !near(r.DeltaY, 0.02) || !near(r.DeltaZ, 0.5) || r.Yaw != 0 {
+		t.Errorf("root %+v, want (0.3, 0.02, 0.5) without yaw", r)
+	}
+	if d := local[0][2]; !near(d.DeltaX, 0) || !near(d.DeltaY, 0.08) || !near(d.DeltaZ, 0) {
+		t.Errorf("right hand moves %+v locally, want 0.08 up", d)
+	}
+	if d := local[0][3]; !near(d.DeltaY, -0.02) {
+		t.Errorf("left foot moves %+v locally, want 0.02 down", d)
+	}
+
+	// With yaw a turning character stays in place locally
+	local, roots = separateRootMotion(ResponsePayload{movedFrame(rig, math.Pi/6, [3]float64{0, 0, 1}, 0)}, rig, true)
+	if r := roots[0]; !near(r.Yaw, math.Pi/6) || !near(r.DeltaZ, 1) {
+		t.Errorf("root %+v, want yaw π/6 and 1 forward", r)
+	}
+	for id, d := range local[0] {
+		if !near(d.DeltaX, 0) || !near(d.DeltaY, 0) || !near(d.DeltaZ, 0) {
+			t.Errorf("point %d moves %+v locally, want nothing", id, d)
+		}
+	}
+}
+
+func TestRootMotionOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	// Every point walks 0.05 along +Z per frame while the right hand rises
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		return framesResponse(input.Length, func(f int) map[string]Position {
+			frame := make(map[string]Position, len(input.ControlPoints))
+			for _, cp := range input.ControlPoints {
+				p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2] + 0.05*float64(f)}
+				if cp.Role == "right hand" {
+					p.Y += 0.05 * float64(f)
+				}
+				frame[strconv.Itoa(cp.ID)] = p
+			}
+			return frame
+		}), nil
+	}
+	type response struct {
+		Frames ResponsePayload `json:"frames"`
+		Root   []RootTransform `json:"root"`
+	}
+	send := func(mode string) response {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "walk forward waving", Length: 3, RootMotion: mode}}
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, http.Header{"X-Api-Version": {"2"}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", mode, rec.Code, rec.Body)
+		}
+		return decodeBody[response](t, rec)
+	}
+
+	baked := send("baked")
+	if baked.Root != nil || baked.Frames[2][0].DeltaZ != 0.1 {
+		t.Errorf("baked: root %v, head moves %+v; want the walk in the frames", baked.Root, baked.Frames[2][0])
+	}
+	separate := send("separate")
+	if len(separate.Root) != 3 || separate.Root[2].DeltaZ != 0.1 || separate.Root[2].DeltaY != 0.02 {
+		t.Fatalf("separate: root %+v, want the walk in the root track", separate.Root)
+	}
+	if head, hand := separate.Frames[2][0], separate.Frames[2][2]; head.DeltaZ != 0 || hand.DeltaY != 0.08 {
+		t.Errorf("separate: head moves %+v and hand %+v, want the walk removed", head, hand)
+	}
+	// none drops the root track and keeps the local deltas
+	if none := send("none"); none.Root != nil || none.Frames[2][2] != separate.Frames[2][2] {
+		t.Errorf("none: root %v, hand %+v; want separate's local deltas alone", none.Root, none.Frames[2][2])
+	}
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "turn", Length: 3, RootYaw: true}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("root_yaw with baked motion: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// router registers handlers on a ServeMux with method-aware patterns such as
+// "POST /generate-deformations", so the mux itself matches methods and
+// answers HEAD with the GET handler. Requests no pattern accepts reach a
+// fallback that keeps every endpoint's errors uniform: a path served under
+// other methods gets a 405 with an Allow header and a structured error body,
+// OPTIONS lists the allowed methods, and anything else is a structured 404.
+type router struct {
+	mux *http.ServeMux
+	// Every method some route accepts, probed to build Allow headers
+	methods []string
+}
+
+// Pattern of the fallback route, which matches any method and path
+const fallbackPattern = "/"
+
+func newMethodRouter() *router {
+	rt := &router{mux: http.NewServeMux(), methods: []string{http.MethodHead}}
+	rt.mux.HandleFunc(fallbackPattern, rt.fallback)
+	return rt
+}
+
+// handle registers h for method on the given ServeMux path pattern
+func (rt *router) handle(method, pattern string, h http.HandlerFunc) {
+	rt.mux.HandleFunc(method+" "+pattern, h)
+	if !slices.Contains(rt.methods, method) {
+		rt.methods = append(rt.methods, method)
+	}
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// allowed lists the methods the mux would route r's path under, plus
+// OPTIONS; it is empty when no route serves the path at all
+func (rt *router) allowed(r *http.Request) []string {
+	var list []string
+	for _, method := range rt.methods {
+		probe := *r
+		probe.Method = method
+		if _, pattern := rt.mux.Handler(&probe); pattern != fallbackPattern && pattern != "" {
+			list = append(list, method)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	list = append(list, http.MethodOptions)
+	slices.Sort(list)
+	return list
+}
+
+func (rt *router) fallback(w http.ResponseWriter, r *http.Request) {
+	allow := rt.allowed(r)
+	if allow == nil {
+		writeError(w, newAPIError(http.StatusNotFound, "No endpoint at %s", r.URL.Path))
+		return
+	}
+
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeError(w, newAPIError(http.StatusMethodNotAllowed, "Method %s not allowed", r.Method).
+		withDetails(map[string][]string{"allowed_methods": allow}))
+}
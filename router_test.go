@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouterMethods(t *testing.T) {
+	setupServer(t, nil)
+	// A real server, which drops the body of HEAD responses as clients see
+	srv := httptest.NewServer(newRouter())
+	defer srv.Close()
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{http.MethodGet, "/generate-deformations", http.StatusMethodNotAllowed, "OPTIONS, POST"},
+		{http.MethodDelete, "/jobs", http.StatusMethodNotAllowed, "OPTIONS, POST"},
+		{http.MethodPut, "/animations/walk", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS, PATCH"},
+		{http.MethodPost, "/metrics", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{http.MethodPatch, "/poses", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS, POST"},
+		{http.MethodOptions, "/generate-deformations", http.StatusNoContent, "OPTIONS, POST"},
+		{http.MethodOptions, "/rigs/abc", http.StatusNoContent, "GET, HEAD, OPTIONS"},
+		{http.MethodGet, "/no-such-endpoint", http.StatusNotFound, ""},
+		{http.MethodOptions, "/no-such-endpoint", http.StatusNotFound, ""},
+		{http.MethodHead, "/metrics", http.StatusOK, ""},
+		{http.MethodHead, "/presets", http.StatusOK, ""},
+	} {
+		req, err := http.NewRequest(tc.method, srv.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, resp.StatusCode, tc.status)
+			continue
+		}
+		if got := resp.Header.Get("Allow"); got != tc.allow {
+			t.Errorf("%s %s: Allow %q, want %q", tc.method, tc.path, got, tc.allow)
+		}
+		switch tc.status {
+		case http.StatusMethodNotAllowed, http.StatusNotFound:
+			var e errorResponse
+			if err := json.Unmarshal(body, &e); err != nil {
+				t.Fatalf("%s %s: body is not JSON: %s", tc.method, tc.path, body)
+			}
+			want := map[int]string{http.StatusMethodNotAllowed: "method_not_allowed", http.StatusNotFound: "not_found"}[tc.status]
+			if e.Error.Code != want {
+				t.Errorf("%s %s: error code %q, want %q", tc.method, tc.path, e.Error.Code, want)
+			}
+		default:
+			// HEAD and OPTIONS answer without a body
+			if len(body) != 0 {
+				t.Errorf("%s %s: body %q, want none", tc.method, tc.path, body)
+			}
+			if tc.method == http.MethodHead && resp.Header.Get("Content-Type") == "" {
+				t.Errorf("HEAD %s: no Content-Type, want the GET response's headers", tc.path)
+			}
+		}
+	}
+}
+
+func TestRouteTable(t *testing.T) {
+	setupServer(t, nil)
+	// Every route newRouter registers, with a path it serves
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/generate-deformations"},
+		{http.MethodPost, "/generate-deformations/dry-run"},
+		{http.MethodPost, "/generate-scene"},
+		{http.MethodPost, "/transform/timestretch"},
+		{http.MethodPost, "/retarget"},
+		{http.MethodPost, "/compose"},
+		{http.MethodPost, "/trajectory"},
+		{http.MethodPost, "/rigs"},
+		{http.MethodGet, "/rigs/abc"},
+		{http.MethodPost, "/poses"},
+		{http.MethodGet, "/poses"},
+		{http.MethodGet, "/poses/idle"},
+		{http.MethodPost, "/animations"},
+		{http.MethodGet, "/animations"},
+		{http.MethodGet, "/animations/walk"},
+		{http.MethodPatch, "/animations/walk"},
+		{http.MethodGet, "/generations/b26b"},
+		{http.MethodPost, "/generations/b26b/replay"},
+		{http.MethodPost, "/jobs"},
+		{http.MethodGet, "/jobs/j1"},
+		{http.MethodGet, "/jobs/j1/events"},
+		{http.MethodGet, "/prompt-sections"},
+		{http.MethodGet, "/presets"},
+		{http.MethodGet, "/metrics"},
+		{http.MethodGet, "/ws"},
+		{http.MethodGet, "/readyz"},
+		{http.MethodGet, "/config"},
+		{http.MethodPost, "/admin/warmup"},
+	} {
+		rec := serve(t, http.MethodOptions, route.path, nil, nil)
+		if rec.Code != http.StatusNoContent || !strings.Contains(rec.Header().Get("Allow"), route.method) {
+			t.Errorf("%s %s is not routed: OPTIONS gave %d with Allow %q", route.method, route.path, rec.Code, rec.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestMethodPatterns(t *testing.T) {
+	rt := newMethodRouter()
+	// Each stub echoes its name and the wildcard it matched
+	stub := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, name+":"+r.PathValue("id"))
+		}
+	}
+	rt.handle(http.MethodGet, "/items", stub("list"))
+	rt.handle(http.MethodPost, "/items", stub("create"))
+	rt.handle(http.MethodGet, "/items/{id}", stub("get"))
+	rt.handle(http.MethodGet, "/items/{id}/events", stub("events"))
+	rt.handle(http.MethodGet, "/items/special", stub("special"))
+
+	for _, tc := range []struct {
+		method, path, want string
+	}{
+		{http.MethodGet, "/items", "list:"},
+		{http.MethodPost, "/items", "create:"},
+		{http.MethodGet, "/items/42", "get:42"},
+		{http.MethodGet, "/items/42/events", "events:42"},
+		// Literal segments win over wildcards
+		{http.MethodGet, "/items/special", "special:"},
+	} {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != tc.want {
+			t.Errorf("%s %s: status %d, body %q; want %q", tc.method, tc.path, rec.Code, rec.Body, tc.want)
+		}
+	}
+
+	// HEAD is served by GET, and methods no route takes reach the fallback
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/items/42", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("HEAD /items/42: status %d, want 200", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/items/42", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("DELETE /items/42: status %d, Allow %q; want 405 with GET, HEAD, OPTIONS", rec.Code, rec.Header().Get("Allow"))
+	}
+	rec = httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items/42/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /items/42/other: status %d, want 404", rec.Code)
+	}
+}
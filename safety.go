@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Built-in patterns for prompts that try to override the system instructions
+// or change the JSON output contract
+var defaultPromptDenylist = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b.{0,30}\b(previous|prior|above|earlier|system|all)\b.{0,20}\b(instructions?|prompts?|rules?)\b`),
+	regexp.MustCompile(`(?i)\bsystem\s+prompt\b`),
+	regexp.MustCompile(`(?i)\byou\s+are\s+now\b`),
+	regexp.MustCompile(`(?i)\bnew\s+instructions?\b`),
+	regexp.MustCompile(`(?i)\b(respond|reply|answer|output|return)\b.{0,20}\b(in|as|with)\b.{0,20}\b(plain\s+text|markdown|yaml|xml|html|csv|prose)\b`),
+	regexp.MustCompile(`(?i)\b(do\s+not|don't|never)\s+(output|return|respond\s+with)\s+json\b`),
+}
+
+// Prompt filter settings derived from the configuration
+type promptFilter struct {
+	maxLength     int
+	roleMaxLength int
+	denylist      []*regexp.Regexp
+}
+
+var promptSafety = newPromptFilter(cfg.PromptMaxLength, cfg.RoleMaxLength, cfg.PromptDenylist)
+
+// newPromptFilter extends the built-in denylist with extra case-insensitive
+// phrases
+func newPromptFilter(maxLength, roleMaxLength int, phrases []string) promptFilter {
+	f := promptFilter{maxLength: maxLength, roleMaxLength: roleMaxLength, denylist: slices.Clone(defaultPromptDenylist)}
+	for _, phrase := range phrases {
+		f.denylist = append(f.denylist, regexp.MustCompile(`(?i)`+regexp.QuoteMeta(phrase)))
+	}
+	return f
+}
+
+// sanitizePrompt normalizes a user prompt and rejects ones that exceed the
+// length limit or match the denylist
+func sanitizePrompt(prompt string) (string, error) {
+	return promptSafety.sanitize(prompt)
+}
+
+func (f promptFilter) sanitize(prompt string) (string, error) {
+	// Replace control characters (newlines included) with spaces so the
+	// prompt can't fake extra message sections, then collapse whitespace
+	cleaned := collapseSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, prompt))
+
+	if cleaned == "" {
+		return "", fmt.Errorf("prompt is empty")
+	}
+	if n := len([]rune(cleaned)); n > f.maxLength {
+		return "", fmt.Errorf("prompt is %d characters, the limit is %d", n, f.maxLength)
+	}
+	for _, pattern := range f.denylist {
+		if pattern.MatchString(cleaned) {
+			return "", fmt.Errorf("prompt rejected: it appears to contain instructions for the model rather than an animation description")
+		}
+	}
+	return cleaned, nil
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// sanitizeRole neutralizes a control point role or character name before it
+// is embedded in the prompt. Roles come straight from asset files, so rather
+// than rejecting suspicious ones, control characters, braces, brackets,
+// quotes and backticks are removed, leaving nothing that can close the data
+// fence or pass for JSON. Roles over the length limit are rejected.
+func sanitizeRole(role string) (string, error) {
+	return promptSafety.sanitizeRole(role)
+}
+
+func (f promptFilter) sanitizeRole(role string) (string, error) {
+	cleaned := collapseSpace(strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r):
+			return ' '
+		case strings.ContainsRune("{}[]<>\"`", r):
+			return -1
+		}
+		return r
+	}, role))
+	if n := len([]rune(cleaned)); n > f.roleMaxLength {
+		return "", fmt.Errorf("role %q is %d characters, the limit is %d", truncateRunes(cleaned, 32)+"...", n, f.roleMaxLength)
+	}
+	return cleaned, nil
+}
+
+func truncateRunes(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// Markers around the request data in the user message. The system prompt
+// tells the model that everything between them is data, never instructions.
+const (
+	userDataStart = "<<<REQUEST_DATA"
+	userDataEnd   = "REQUEST_DATA>>>"
+)
+
+// fenceUserData wraps the JSON request data for the model in the data markers
+func fenceUserData(data string) string {
+	return userDataStart + "\n" + data + "\n" + userDataEnd
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// A control point coordinate in the model output that cannot be trusted
+type corruptPoint struct {
+	Frame   int    `json:"frame"`
+	PointID int    `json:"point_id"`
+	Reason  string `json:"reason"`
+}
+
+func validateOnCorrupt(mode string) error {
+	switch mode {
+	case "", "fail", "repair", "interpolate":
+		return nil
+	}
+	return fmt.Errorf("invalid on_corrupt %q, expected fail, repair or interpolate", mode)
+}
+
+// rigDiagonal returns the length of the bounding-box diagonal of the points
+func rigDiagonal(points []ControlPoint) float64 {
+	lo := []float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	hi := []float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, cp := range points {
+		if len(cp.Position) < 3 {
+			continue
+		}
+		for axis := 0; axis < 3; axis++ {
+			lo[axis] = math.Min(lo[axis], cp.Position[axis])
+			hi[axis] = math.Max(hi[axis], cp.Position[axis])
+		}
+	}
+	if math.IsInf(lo[0], 1) {
+		return 0
+	}
+	return math.Sqrt((hi[0]-lo[0])*(hi[0]-lo[0]) + (hi[1]-lo[1])*(hi[1]-lo[1]) + (hi[2]-lo[2])*(hi[2]-lo[2]))
+}
+
+// sanityBound is the largest plausible displacement for a rig. Degenerate
+// rigs (a single point) fall back to a unit diagonal.
+func sanityBound(points []ControlPoint, factor float64) float64 {
+	return factor * math.Max(rigDiagonal(points), 1)
+}
+
+// findCorruptPoints lists every non-finite coordinate and every displacement
+// from the original position larger than bound, in frame then ID order
+func findCorruptPoints(frames []map[int]Position, original map[int][]float64, bound float64) []corruptPoint {
+	var corrupt []corruptPoint
+	for frameIndex, frame := range frames {
+		for _, id := range sortedPositionIDs(frame) {
+			p := frame[id]
+			o, known := original[id]
+			if !known {
+				// Invented points are dropped or rejected on their own
+				continue
+			}
+			if !isFinite(p.X) || !isFinite(p.Y) || !isFinite(p.Z) {
+				corrupt = append(corrupt, corruptPoint{Frame: frameIndex, PointID: id, Reason: "non_finite"})
+				continue
+			}
+			if len(o) < 3 {
+				continue
+			}
+			dx, dy, dz := p.X-o[0], p.Y-o[1], p.Z-o[2]
+			if math.Sqrt(dx*dx+dy*dy+dz*dz) > bound {
+				corrupt = append(corrupt, corruptPoint{Frame: frameIndex, PointID: id, Reason: "out_of_bounds"})
+			}
+		}
+	}
+	return corrupt
+}
+
+// sortedPositionIDs returns the control point IDs of a frame in ascending order
+func sortedPositionIDs(frame map[int]Position) []int {
+	ids := make([]int, 0, len(frame))
+	for id := range frame {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// validateFinitePositions rejects control points with a NaN or infinite
+// coordinate, which would turn every delta computed from them into garbage
+func validateFinitePositions(points []ControlPoint) error {
+	for _, cp := range points {
+		for i, v := range cp.Position {
+			if !isFinite(v) {
+				return fmt.Errorf("control point %d: %s is %v, positions must be finite numbers", cp.ID, positionAxis(i), v)
+			}
+		}
+	}
+	return nil
+}
+
+// positionAxis names a position component in validation errors
+func positionAxis(i int) string {
+	if i < len(axisNames) {
+		return axisNames[i]
+	}
+	return fmt.Sprintf("component %d", i)
+}
+
+// repairCorruptPoints replaces each corrupt coordinate with the same point's
+// value in the previous frame, or its original position in the first frame
+func repairCorruptPoints(frames []map[int]Position, corrupt []corruptPoint, original map[int][]float64) []map[int]Position {
+	bad := make(map[[2]int]bool, len(corrupt))
+	for _, c := range corrupt {
+		bad[[2]int{c.Frame, c.PointID}] = true
+	}
+	repaired := make([]map[int]Position, len(frames))
+	for i, frame := range frames {
+		repaired[i] = make(map[int]Position, len(frame))
+		for id, p := range frame {
+			if bad[[2]int{i, id}] {
+				if prev, ok := previousPosition(repaired, i, id); ok {
+					p = prev
+				} else if o := original[id]; len(o) >= 3 {
+					p = Position{X: o[0], Y: o[1], Z: o[2]}
+				}
+			}
+			repaired[i][id] = p
+		}
+	}
+	return repaired
+}
+
+func previousPosition(frames []map[int]Position, frameIndex, id int) (Position, bool) {
+	if frameIndex == 0 {
+		return Position{}, false
+	}
+	p, ok := frames[frameIndex-1][id]
+	return p, ok
+}
+
+// interpolateCorruptFrames drops every frame with a corrupt point and
+// rebuilds it by linear interpolation between the nearest good frames,
+// holding the nearest good frame at either end. It reports false when no
+// good frame is left to interpolate from.
+func interpolateCorruptFrames(frames []map[int]Position, corrupt []corruptPoint) ([]map[int]Position, bool) {
+	badFrames := make(map[int]bool)
+	for _, c := range corrupt {
+		badFrames[c.Frame] = true
+	}
+	var good []int
+	for i := range frames {
+		if !badFrames[i] {
+			good = append(good, i)
+		}
+	}
+	if len(good) == 0 {
+		return nil, false
+	}
+
+	result := make([]map[int]Position, len(frames))
+	for i := range frames {
+		if !badFrames[i] {
+			result[i] = frames[i]
+			continue
+		}
+		// Find the good frames on either side
+		prev, next := -1, -1
+		for _, g := range good {
+			if g < i {
+				prev = g
+			} else if next == -1 {
+				next = g
+			}
+		}
+		switch {
+		case prev == -1:
+			result[i] = frames[next]
+		case next == -1:
+			result[i] = frames[prev]
+		default:
+			t := float64(i-prev) / float64(next-prev)
+			frame := make(map[int]Position, len(frames[prev]))
+			for id, a := range frames[prev] {
+				b, ok := frames[next][id]
+				if !ok {
+					frame[id] = a
+					continue
+				}
+				frame[id] = Position{
+					X: a.X + (b.X-a.X)*t,
+					Y: a.Y + (b.Y-a.Y)*t,
+					Z: a.Z + (b.Z-a.Z)*t,
+				}
+			}
+			result[i] = frame
+		}
+	}
+	return result, true
+}
+
+// sanitizeModelFrames applies the request's on_corrupt policy to the parsed
+// model output. Corrupt point IDs are reported in the client's ID space.
+func sanitizeModelFrames(frames []map[int]Position, payload RequestPayload, original map[int][]float64, idMap map[int]int) ([]map[int]Position, error) {
+	corrupt := findCorruptPoints(frames, original, sanityBound(payload.ControlPoints, cfg.SanityBoundFactor))
+	if len(corrupt) == 0 {
+		return frames, nil
+	}
+	log.Printf("Model output contains %d corrupt coordinates (on_corrupt=%q)", len(corrupt), payload.OnCorrupt)
+
+	switch payload.OnCorrupt {
+	case "repair":
+		return repairCorruptPoints(frames, corrupt, original), nil
+	case "interpolate":
+		if repaired, ok := interpolateCorruptFrames(frames, corrupt); ok {
+			return repaired, nil
+		}
+	}
+
+	// Report IDs the way the client sent them
+	reverse := make(map[int]int, len(idMap))
+	for originalID, compactID := range idMap {
+		reverse[compactID] = originalID
+	}
+	for i := range corrupt {
+		if originalID, ok := reverse[corrupt[i].PointID]; ok {
+			corrupt[i].PointID = originalID
+		}
+	}
+	return nil, newAPIError(http.StatusBadGateway, "The model returned %d invalid coordinates", len(corrupt)).
+		withCode("corrupt_generation").
+		withDetails(map[string]any{"corrupt_points": corrupt})
+}
+
+// findUnknownPointIDs lists the IDs in the model output that were not in
+// the request, in ascending order
+func findUnknownPointIDs(frames []map[int]Position, original map[int][]float64) []int {
+	unknown := make(map[int]bool)
+	for _, frame := range frames {
+		for id := range frame {
+			if _, ok := original[id]; !ok {
+				unknown[id] = true
+			}
+		}
+	}
+	return sortedKeys(unknown)
+}
+
+// likelyInjectionArtifact reports whether most of the point IDs in the
+// model output were not in the request, which points to the request data
+// having derailed the model rather than the odd stray ID
+func likelyInjectionArtifact(frames []map[int]Position, points []ControlPoint) bool {
+	known := make(map[int][]float64, len(points))
+	for _, cp := range points {
+		known[cp.ID] = cp.Position
+	}
+	seen := make(map[int]bool)
+	for _, frame := range frames {
+		for id := range frame {
+			seen[id] = true
+		}
+	}
+	unknown := len(findUnknownPointIDs(frames, known))
+	return unknown > 0 && 2*unknown > len(seen)
+}
+
+// checkUnknownPointIDs drops control points the model invented, or fails
+// with a 502 when strict_point_ids is set
+func checkUnknownPointIDs(frames []map[int]Position, original map[int][]float64) ([]map[int]Position, []string, error) {
+	unknown := findUnknownPointIDs(frames, original)
+	if len(unknown) == 0 {
+		return frames, nil, nil
+	}
+	log.Printf("Model output contains unknown control point IDs %v (strict_point_ids=%t)", unknown, cfg.StrictPointIDs)
+	incCounter("unknown_point_ids_total", "", "", float64(len(unknown)))
+	if cfg.StrictPointIDs {
+		return nil, nil, newAPIError(http.StatusBadGateway, "The model returned %d control points that were not in the request", len(unknown)).
+			withCode("unknown_point_ids").
+			withDetails(map[string]any{"unknown_point_ids": unknown})
+	}
+	// Copy rather than delete in place: the model output is kept for replay
+	kept := make([]map[int]Position, len(frames))
+	for i, frame := range frames {
+		kept[i] = make(map[int]Position, len(frame))
+		for id, p := range frame {
+			if _, ok := original[id]; ok {
+				kept[i][id] = p
+			}
+		}
+	}
+	return kept, []string{fmt.Sprintf("The model returned control points %v that were not in the request; they were ignored", unknown)}, nil
+}
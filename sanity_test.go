@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+// waveWithCorruptHead waves the left hand and, in frame 2, throws the head
+// far outside any plausible range
+func waveWithCorruptHead(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	input, err := modelInputOf(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	return framesResponse(input.Length, func(f int) map[string]Position {
+		frame := make(map[string]Position)
+		for _, cp := range input.ControlPoints {
+			p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+			switch {
+			case cp.Role == "left hand":
+				p.Y += 0.3 * math.Sin(2*math.Pi*float64(f)/float64(input.Length))
+			case cp.Role == "head" && f == 2:
+				p.X = 1e5
+			}
+			frame[strconv.Itoa(cp.ID)] = p
+		}
+		return frame
+	}), nil
+}
+
+func TestCorruptFramesSanitizedBeforeChecks(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = waveWithCorruptHead
+
+	// Repaired, the head stays put, so it does not count as drift
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave the left hand", Length: 8, OnCorrupt: "repair", OnDrift: "retry"}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got := fake.calls(); got != 1 {
+		t.Errorf("upstream called %d times, want 1: the corrupt head triggered a drift retry", got)
+	}
+
+	// Rejected, the corruption is reported rather than a drift retry made
+	payload.OnCorrupt, payload.Prompt = "", "wave the left hand high"
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status %d, want 502: %s", rec.Code, rec.Body)
+	}
+	if body := decodeBody[errorResponse](t, rec); body.Error.Code != "corrupt_generation" {
+		t.Errorf("error code %q, want corrupt_generation", body.Error.Code)
+	}
+	if got := fake.calls(); got != 2 {
+		t.Errorf("upstream called %d times in all, want 2", got)
+	}
+}
+
+func TestFindCorruptPoints(t *testing.T) {
+	original := map[int][]float64{0: {0, 0, 0}, 1: {1, 0, 0}}
+	frames := []map[int]Position{
+		{0: {X: 0.5}, 1: {X: 1}},
+		{0: {X: math.NaN()}, 1: {X: 1, Y: math.Inf(-1)}},
+		// Point 7 was not in the request, so it is not this check's concern
+		{0: {X: 2.01}, 1: {X: 3}, 7: {X: 1e9}},
+	}
+	got := findCorruptPoints(frames, original, 2)
+	want := []corruptPoint{
+		{Frame: 1, PointID: 0, Reason: "non_finite"},
+		{Frame: 1, PointID: 1, Reason: "non_finite"},
+		{Frame: 2, PointID: 0, Reason: "out_of_bounds"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("corrupt points %+v, want %+v", got, want)
+	}
+}
+
+func TestRepairCorruptPoints(t *testing.T) {
+	original := map[int][]float64{0: {0, 1, 0}, 1: {1, 0, 0}}
+	frames := []map[int]Position{
+		{0: {X: math.NaN()}, 1: {X: 1.1}},
+		{0: {X: 0.2, Y: 1}, 1: {X: 1e9}},
+		{0: {X: math.Inf(1)}, 1: {X: 1e9}},
+	}
+	corrupt := findCorruptPoints(frames, original, 5)
+	got := repairCorruptPoints(frames, corrupt, original)
+	want := []map[int]Position{
+		// The first frame falls back to the rest position
+		{0: {Y: 1}, 1: {X: 1.1}},
+		// Later frames hold the previous frame's value, repaired or not
+		{0: {X: 0.2, Y: 1}, 1: {X: 1.1}},
+		{0: {X: 0.2, Y: 1}, 1: {X: 1.1}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("repaired %+v, want %+v", got, want)
+	}
+	if !math.IsNaN(frames[0][0].X) {
+		t.Error("repair changed the frames it was given")
+	}
+}
+
+func TestInterpolateCorruptFrames(t *testing.T) {
+	frames := []map[int]Position{
+		{0: {X: math.NaN()}},
+		{0: {X: 1}},
+		{0: {X: 1e9}},
+		{0: {X: 1e9}},
+		{0: {X: 4, Y: 2}},
+		{0: {X: math.NaN()}},
+	}
+	corrupt := []corruptPoint{{Frame: 0}, {Frame: 2}, {Frame: 3}, {Frame: 5}}
+	got, ok := interpolateCorruptFrames(frames, corrupt)
+	if !ok {
+		t.Fatal("no good frames found")
+	}
+	// Ends hold the nearest good frame; gaps are filled linearly
+	want := []Position{{X: 1}, {X: 1}, {X: 2, Y: 2.0 / 3}, {X: 3, Y: 4.0 / 3}, {X: 4, Y: 2}, {X: 4, Y: 2}}
+	for f, w := range want {
+		p := got[f][0]
+		if math.Abs(p.X-w.X) > 1e-12 || math.Abs(p.Y-w.Y) > 1e-12 || p.Z != w.Z {
+			t.Errorf("frame %d: %+v, want %+v", f, p, w)
+		}
+	}
+
+	if _, ok := interpolateCorruptFrames(frames[:1], corrupt[:1]); ok {
+		t.Error("interpolated a clip with no good frame")
+	}
+}
+
+func TestCorruptPolicies(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = waveWithCorruptHead
+	rig := testRig()
+	// Sparse IDs, so compact and client IDs differ
+	for i := range rig {
+		rig[i].ID = 10 * (i + 1)
+	}
+	headID, handID := rig[0].ID, rig[1].ID
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: rig, Prompt: "wave the left hand", Length: 8, CacheMode: cacheFresh}}
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("fail: status %d, want 502: %s", rec.Code, rec.Body)
+	}
+	body := decodeBody[struct {
+		Error struct {
+			Code    string `json:"code"`
+			Details struct {
+				CorruptPoints []corruptPoint `json:"corrupt_points"`
+			} `json:"details"`
+		} `json:"error"`
+	}](t, rec)
+	want := []corruptPoint{{Frame: 2, PointID: headID, Reason: "out_of_bounds"}}
+	if body.Error.Code != "corrupt_generation" || !reflect.DeepEqual(body.Error.Details.CorruptPoints, want) {
+		t.Errorf("fail: error %+v, want corrupt_generation listing %+v", body.Error, want)
+	}
+
+	for _, mode := range []string{"repair", "interpolate"} {
+		payload.OnCorrupt = mode
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", mode, rec.Code, rec.Body)
+		}
+		frames := decodeBody[ResponsePayload](t, rec)
+		if d := frames[2][headID]; d != (Deformation{}) {
+			t.Errorf("%s: head at frame 2 moved by %+v, want its neighbours' rest", mode, d)
+		}
+		// Repair touches only the corrupt point; interpolation rebuilds the
+		// whole frame from its neighbours
+		hand := frames[2][handID].DeltaY
+		wantHand := 0.3 * math.Sin(2*math.Pi*2/8)
+		if mode == "interpolate" {
+			wantHand = 0.3 * (math.Sin(2*math.Pi/8) + math.Sin(2*math.Pi*3/8)) / 2
+		}
+		// Body deltas are written to two decimal places
+		if math.Abs(hand-wantHand) > 0.005 {
+			t.Errorf("%s: hand at frame 2 delta_y %v, want %v", mode, hand, wantHand)
+		}
+	}
+}
+
+func TestUnknownPointIDs(t *testing.T) {
+	// The sway plus a point 99 the request never had
+	strayPoint := func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		return framesResponse(input.Length, func(f int) map[string]Position {
+			frame := make(map[string]Position)
+			for _, cp := range input.ControlPoints {
+				frame[strconv.Itoa(cp.ID)] = Position{X: cp.Position[0] + 0.01*float64(f), Y: cp.Position[1], Z: cp.Position[2]}
+			}
+			frame["99"] = Position{X: 5, Y: 5, Z: 5}
+			return frame
+		}), nil
+	}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 3}}
+
+	t.Run("lenient", func(t *testing.T) {
+		fake := setupServer(t, nil)
+		fake.respond = strayPoint
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		body := decodeBody[struct {
+			Frames []map[string]Deformation `json:"frames"`
+			Meta   generationMeta           `json:"meta"`
+		}](t, rec)
+		if _, ok := body.Frames[0]["99"]; ok || len(body.Frames[0]) != len(payload.ControlPoints) {
+			t.Errorf("frame 0 %v, want only the request's points", body.Frames[0])
+		}
+		want := "The model returned control points [99] that were not in the request; they were ignored"
+		if !reflect.DeepEqual(body.Meta.Warnings, []string{want}) {
+			t.Errorf("warnings %q, want %q", body.Meta.Warnings, want)
+		}
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		fake := setupServer(t, func(c *Config) { c.StrictPointIDs = true })
+		fake.respond = strayPoint
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+		if rec.Code != http.StatusBadGateway {
+			t.Fatalf("status %d, want 502: %s", rec.Code, rec.Body)
+		}
+		body := decodeBody[errorResponse](t, rec)
+		details, _ := body.Error.Details.(map[string]any)
+		if body.Error.Code != "unknown_point_ids" || !reflect.DeepEqual(details["unknown_point_ids"], []any{99.0}) {
+			t.Errorf("error %+v, want unknown_point_ids listing 99", body.Error)
+		}
+	})
+
+	// Dropping the points copies the frames, leaving the model output intact
+	frames := []map[int]Position{{0: {X: 1}, 7: {X: 2}}}
+	kept, warnings, err := checkUnknownPointIDs(frames, map[int][]float64{0: {0, 0, 0}})
+	if err != nil || len(kept[0]) != 1 || len(frames[0]) != 2 || len(warnings) != 1 {
+		t.Errorf("kept %v, input %v, warnings %q, error %v", kept, frames, warnings, err)
+	}
+}
+
+func TestValidateFinitePositions(t *testing.T) {
+	if err := validateFinitePositions(testRig()); err != nil {
+		t.Errorf("finite rig: %v", err)
+	}
+	for _, tc := range []struct {
+		position []float64
+		want     string
+	}{
+		{[]float64{0, math.NaN(), 0}, "control point 3: y is NaN, positions must be finite numbers"},
+		{[]float64{math.Inf(1), 0, 0}, "control point 3: x is +Inf, positions must be finite numbers"},
+		{[]float64{0, 0, 0, math.Inf(-1)}, "control point 3: component 3 is -Inf, positions must be finite numbers"},
+	} {
+		points := testRig()
+		points[3].Position = tc.position
+		if err := validateFinitePositions(points); err == nil || err.Error() != tc.want {
+			t.Errorf("%v: error %v, want %q", tc.position, err, tc.want)
+		}
+	}
+
+	// JSON cannot carry NaN, but request validation checks for it all the
+	// same
+	setupServer(t, nil)
+	points := testRig()
+	points[1].Position = []float64{0.6, math.NaN(), 0}
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: points, Prompt: "wave", Length: 4}}
+	var apiErr *apiError
+	if err := validatePayload(&payload); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Errorf("NaN position: %v, want a 400", err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// One character in a multi-character scene
+type SceneCharacter struct {
+	Name          string         `json:"name"`
+	ControlPoints []ControlPoint `json:"control_points"`
+	// Optional direction for this character, added to the scene prompt
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// Request body of /generate-scene
+type ScenePayload struct {
+	Characters []SceneCharacter `json:"characters"`
+	Prompt     string           `json:"prompt"`
+	Length     int              `json:"length"`
+	Loop       bool             `json:"loop,omitempty"`
+	Model      string           `json:"model,omitempty"`
+	// joint (default) animates the whole scene in one generation so
+	// interactions stay coherent; separate animates each character on its own
+	Mode string `json:"mode,omitempty"`
+}
+
+// Scene frames keyed by character name, then frame
+type SceneResponse map[string]ResponsePayload
+
+// Response envelope used with ?include_meta=true
+type SceneEnvelope struct {
+	Characters SceneResponse `json:"characters"`
+	Warnings   []string      `json:"warnings"`
+}
+
+func validateScene(scene ScenePayload) error {
+	if len(scene.Characters) == 0 || scene.Prompt == "" || scene.Length <= 0 {
+		return fmt.Errorf("missing characters, prompt, or invalid length")
+	}
+	switch scene.Mode {
+	case "", "joint", "separate":
+	default:
+		return fmt.Errorf("invalid mode %q, expected joint or separate", scene.Mode)
+	}
+	seen := make(map[string]bool, len(scene.Characters))
+	for _, c := range scene.Characters {
+		if strings.TrimSpace(c.Name) == "" {
+			return fmt.Errorf("every character needs a name")
+		}
+		// Names are embedded in the prompt as they are
+		if clean, err := sanitizeRole(c.Name); err != nil || clean != c.Name {
+			return fmt.Errorf("character name %q must be at most %d characters without line breaks, quotes, brackets or braces", c.Name, cfg.RoleMaxLength)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("duplicate character name %q", c.Name)
+		}
+		seen[c.Name] = true
+		if len(c.ControlPoints) == 0 {
+			return fmt.Errorf("character %q has no control points", c.Name)
+		}
+	}
+	return nil
+}
+
+// scenePrompt joins the scene prompt with each character's own direction
+func scenePrompt(scene ScenePayload) string {
+	parts := []string{scene.Prompt}
+	for _, c := range scene.Characters {
+		if c.Prompt != "" {
+			parts = append(parts, fmt.Sprintf("%s: %s", c.Name, c.Prompt))
+		}
+	}
+	return strings.Join(parts, ". ")
+}
+
+// A point of the merged scene rig and where it came from
+type scenePoint struct {
+	Character string
+	ID        int
+}
+
+// generateJointScene merges every character into one rig with scene-unique
+// IDs and roles prefixed by the character's name, generates it in one go and
+// splits the frames back per character
+func generateJointScene(ctx context.Context, scene ScenePayload) (SceneResponse, []string, error) {
+	var (
+		points  []ControlPoint
+		origins []scenePoint
+		names   []string
+	)
+	for _, c := range scene.Characters {
+		names = append(names, c.Name)
+		for _, cp := range c.ControlPoints {
+			origins = append(origins, scenePoint{Character: c.Name, ID: cp.ID})
+			points = append(points, ControlPoint{
+				ID:       len(points),
+				Role:     fmt.Sprintf("%s: %s", c.Name, cp.Role),
+				Position: cp.Position,
+				Category: cp.Category,
+			})
+		}
+	}
+
+	result, err := generate(ctx, RequestPayload{
+		RequestPayload: api.RequestPayload{
+			ControlPoints: points,
+			Prompt:        scenePrompt(scene),
+			Length:        scene.Length,
+			Loop:          scene.Loop,
+			Model:         scene.Model,
+		},
+		scene: names,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response := make(SceneResponse, len(scene.Characters))
+	for _, c := range scene.Characters {
+		response[c.Name] = make(ResponsePayload, len(result.Frames))
+		for i := range result.Frames {
+			response[c.Name][i] = make(map[int]Deformation)
+		}
+	}
+	for i, frame := range result.Frames {
+		for sceneID, d := range frame {
+			origin := origins[sceneID]
+			response[origin.Character][i][origin.ID] = d
+		}
+	}
+	return response, result.Warnings, nil
+}
+
+// generateSeparateScene animates every character concurrently on its own
+func generateSeparateScene(ctx context.Context, scene ScenePayload) (SceneResponse, []string, error) {
+	results := make([]*generationResult, len(scene.Characters))
+	errs := make([]error, len(scene.Characters))
+	var wg sync.WaitGroup
+	for i, c := range scene.Characters {
+		prompt := scene.Prompt
+		if c.Prompt != "" {
+			prompt = fmt.Sprintf("%s. %s", scene.Prompt, c.Prompt)
+		}
+		payload := RequestPayload{RequestPayload: api.RequestPayload{
+			ControlPoints: c.ControlPoints,
+			Prompt:        prompt,
+			Length:        scene.Length,
+			Loop:          scene.Loop,
+			Model:         scene.Model,
+		}}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer recoverAsError(ctx, &errs[i])
+			results[i], errs[i] = generate(ctx, payload)
+		}()
+	}
+	wg.Wait()
+
+	response := make(SceneResponse, len(scene.Characters))
+	var warnings []string
+	for i, c := range scene.Characters {
+		if errs[i] != nil {
+			return nil, nil, errs[i]
+		}
+		response[c.Name] = results[i].Frames
+		for _, w := range results[i].Warnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", c.Name, w))
+		}
+	}
+	return response, warnings, nil
+}
+
+// Handler for the /generate-scene endpoint
+func generateScene(w http.ResponseWriter, r *http.Request) {
+	ctx, timings := withTimings(r.Context())
+	defer timings.finish(r.URL.Path)
+
+	var scene ScenePayload
+	if err := json.NewDecoder(r.Body).Decode(&scene); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	if err := validateScene(scene); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+
+	generateFn := generateJointScene
+	if scene.Mode == "separate" {
+		generateFn = generateSeparateScene
+	}
+	response, warnings, err := generateFn(ctx, scene)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var body any = response
+	if r.URL.Query().Get("include_meta") == "true" {
+		body = SceneEnvelope{Characters: response, Warnings: append([]string{}, warnings...)}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
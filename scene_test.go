@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// bobBows sways everyone a little and lowers bob: the points whose role is
+// bob's in a joint scene, or every point when bob is generated alone
+func bobBows(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	input, err := modelInputOf(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	joint := strings.Contains(input.ControlPoints[0].Role, ": ")
+	return framesResponse(input.Length, func(f int) map[string]Position {
+		frame := make(map[string]Position, len(input.ControlPoints))
+		for _, cp := range input.ControlPoints {
+			p := Position{X: cp.Position[0] + 0.01*float64(f), Y: cp.Position[1], Z: cp.Position[2]}
+			if joint && strings.HasPrefix(cp.Role, "bob: ") || !joint && strings.Contains(input.Prompt, "bob bows") {
+				p.Y -= 0.05 * float64(f)
+			}
+			frame[strconv.Itoa(cp.ID)] = p
+		}
+		return frame
+	}), nil
+}
+
+func TestGenerateScene(t *testing.T) {
+	scene := func(mode string) ScenePayload {
+		return ScenePayload{
+			Characters: []SceneCharacter{
+				{Name: "alice", ControlPoints: testRig()},
+				{Name: "bob", ControlPoints: testRig(), Prompt: "bob bows"},
+			},
+			Prompt: "two friends meet",
+			Length: 3,
+			Mode:   mode,
+		}
+	}
+
+	t.Run("joint", func(t *testing.T) {
+		fake := setupServer(t, nil)
+		fake.respond = bobBows
+		rec := serve(t, http.MethodPost, "/generate-scene?include_meta=true", scene(""), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if got := fake.calls(); got != 1 {
+			t.Fatalf("upstream called %d times, want one generation for the scene", got)
+		}
+		input, err := modelInputOf(fake.requests[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(input.ControlPoints) != 10 || input.ControlPoints[5].Role != "bob: head" || input.Prompt != "two friends meet. bob: bob bows" {
+			t.Errorf("model input %+v, want both rigs with prefixed roles and bob's direction", input)
+		}
+
+		// Frames go back to each character under its own IDs
+		body := decodeBody[struct {
+			Characters map[string][]map[string]Deformation `json:"characters"`
+			Warnings   []string                            `json:"warnings"`
+		}](t, rec)
+		alice, bob := body.Characters["alice"], body.Characters["bob"]
+		if len(alice) != 3 || len(bob) != 3 || len(alice[2]) != 5 || len(bob[2]) != 5 {
+			t.Fatalf("characters %v, want three frames of five points each", body.Characters)
+		}
+		if alice[2]["0"].DeltaY != 0 || bob[2]["0"].DeltaY != -0.1 {
+			t.Errorf("head delta_y: alice %v, bob %v; want 0 and -0.1", alice[2]["0"].DeltaY, bob[2]["0"].DeltaY)
+		}
+		if body.Warnings == nil {
+			t.Error("envelope has no warnings list")
+		}
+	})
+
+	t.Run("separate", func(t *testing.T) {
+		fake := setupServer(t, nil)
+		fake.respond = bobBows
+		rec := serve(t, http.MethodPost, "/generate-scene", scene("separate"), nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		if got := fake.calls(); got != 2 {
+			t.Fatalf("upstream called %d times, want one per character", got)
+		}
+		body := decodeBody[map[string][]map[string]Deformation](t, rec)
+		if body["alice"][2]["0"].DeltaY != 0 || body["bob"][2]["0"].DeltaY != -0.1 {
+			t.Errorf("head delta_y: alice %v, bob %v; want 0 and -0.1", body["alice"][2]["0"].DeltaY, body["bob"][2]["0"].DeltaY)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		fake := setupServer(t, nil)
+		for name, change := range map[string]func(s *ScenePayload){
+			"no characters":  func(s *ScenePayload) { s.Characters = nil },
+			"unknown mode":   func(s *ScenePayload) { s.Mode = "parallel" },
+			"duplicate name": func(s *ScenePayload) { s.Characters[1].Name = "alice" },
+			"quoted name":    func(s *ScenePayload) { s.Characters[0].Name = `al"ice` },
+			"no points":      func(s *ScenePayload) { s.Characters[1].ControlPoints = nil },
+		} {
+			s := scene("")
+			change(&s)
+			if rec := serve(t, http.MethodPost, "/generate-scene", s, nil); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: status %d, want 400", name, rec.Code)
+			}
+		}
+		if rec := serve(t, http.MethodPost, "/generate-scene", "{", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("invalid JSON: status %d, want 400", rec.Code)
+		}
+		if got := fake.calls(); got != 0 {
+			t.Errorf("invalid scenes reached the model %d times", got)
+		}
+	})
+}
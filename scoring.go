@@ -0,0 +1,187 @@
+package main
+
+import "math"
+
+// Upper bound on completion choices requested per generation
+const maxCandidates = 8
+
+// motionJerk sums the magnitude of the third finite difference of every
+// control point trajectory, scaled by the point's weight (1 when absent).
+// Lower values mean smoother motion; clips with fewer than four frames have
+// no measurable jerk and score zero.
+func motionJerk(frames []map[int]Position, weights map[int]float64) float64 {
+	total := 0.0
+	for i := 0; i+3 < len(frames); i++ {
+		// Sum in ID order: float addition is not associative, and candidates
+		// must rank the same way on every run
+		for _, id := range sortedKeys(frames[i]) {
+			p0 := frames[i][id]
+			p1, ok1 := frames[i+1][id]
+			p2, ok2 := frames[i+2][id]
+			p3, ok3 := frames[i+3][id]
+			if !ok1 || !ok2 || !ok3 {
+				continue
+			}
+			jx := p3.X - 3*p2.X + 3*p1.X - p0.X
+			jy := p3.Y - 3*p2.Y + 3*p1.Y - p0.Y
+			jz := p3.Z - 3*p2.Z + 3*p1.Z - p0.Z
+			weight, ok := weights[id]
+			if !ok {
+				weight = 1
+			}
+			total += weight * math.Sqrt(jx*jx+jy*jy+jz*jz)
+		}
+	}
+	return total
+}
+
+// Quality score returned with ?score=true. Each sub-score maps a measured
+// error e onto (0, 1] as 1 / (1 + e/scale), where the scale is a fraction of
+// the rig's bounding-box diagonal, so the score does not depend on the rig's
+// units:
+//
+//   - smoothness: mean magnitude of the third difference of each point's
+//     deltas (its jerk per frame³), against 1% of the diagonal
+//   - rigidity: mean displacement of the points the animation should leave
+//     alone, against 2% of the diagonal. The target points are the model's
+//     affected_points, else the limb the prompt names; with neither, every
+//     point is a target and rigidity is 1.
+//   - loop_continuity, for loops only: how much more the points accelerate
+//     across the seam from the last frame back to the first than they do on
+//     average within the clip, against 1% of the diagonal
+//
+// The score is the weighted mean of the sub-scores: smoothness 0.4, rigidity
+// 0.3 and loop continuity 0.3, or 4/7 and 3/7 for clips that do not loop.
+type animationScore struct {
+	Score          float64  `json:"score"`
+	Smoothness     float64  `json:"smoothness"`
+	Rigidity       float64  `json:"rigidity"`
+	LoopContinuity *float64 `json:"loop_continuity,omitempty"`
+}
+
+const (
+	smoothnessScale     = 0.01
+	rigidityScale       = 0.02
+	loopContinuityScale = 0.01
+	smoothnessWeight    = 0.4
+	rigidityWeight      = 0.3
+	loopWeight          = 0.3
+)
+
+// subScore maps an error onto (0, 1], 1 for no error at all
+func subScore(err, scale float64) float64 {
+	if scale <= 0 {
+		return 1
+	}
+	return 1 / (1 + err/scale)
+}
+
+func deltaLength(d Deformation) float64 {
+	return math.Sqrt(d.DeltaX*d.DeltaX + d.DeltaY*d.DeltaY + d.DeltaZ*d.DeltaZ)
+}
+
+// scoreAnimation computes the quality score of a finished clip
+func scoreAnimation(frames ResponsePayload, positions map[int][]float64, targets []int, loop bool) animationScore {
+	rest := make([]ControlPoint, 0, len(positions))
+	for _, id := range sortedKeys(positions) {
+		rest = append(rest, ControlPoint{ID: id, Position: positions[id]})
+	}
+	diagonal := rigDiagonal(rest)
+	if diagonal == 0 {
+		diagonal = 1
+	}
+	ids := frameIDs(frames)
+
+	// Smoothness: mean jerk over every point and window of four frames
+	jerk, windows := 0.0, 0
+	for i := 0; i+3 < len(frames); i++ {
+		for _, id := range ids {
+			d0, ok0 := frames[i][id]
+			d1, ok1 := frames[i+1][id]
+			d2, ok2 := frames[i+2][id]
+			d3, ok3 := frames[i+3][id]
+			if !ok0 || !ok1 || !ok2 || !ok3 {
+				continue
+			}
+			jerk += deltaLength(Deformation{
+				DeltaX: d3.DeltaX - 3*d2.DeltaX + 3*d1.DeltaX - d0.DeltaX,
+				DeltaY: d3.DeltaY - 3*d2.DeltaY + 3*d1.DeltaY - d0.DeltaY,
+				DeltaZ: d3.DeltaZ - 3*d2.DeltaZ + 3*d1.DeltaZ - d0.DeltaZ,
+			})
+			windows++
+		}
+	}
+	score := animationScore{Smoothness: 1, Rigidity: 1}
+	if windows > 0 {
+		score.Smoothness = subScore(jerk/float64(windows), smoothnessScale*diagonal)
+	}
+
+	// Rigidity: mean displacement of the points outside the targets
+	if len(targets) > 0 {
+		isTarget := make(map[int]bool, len(targets))
+		for _, id := range targets {
+			isTarget[id] = true
+		}
+		displacement, samples := 0.0, 0
+		for _, frame := range frames {
+			for _, id := range ids {
+				if d, ok := frame[id]; ok && !isTarget[id] {
+					displacement += deltaLength(d)
+					samples++
+				}
+			}
+		}
+		if samples > 0 {
+			score.Rigidity = subScore(displacement/float64(samples), rigidityScale*diagonal)
+		}
+	}
+
+	weighted := smoothnessWeight*score.Smoothness + rigidityWeight*score.Rigidity
+	weights := smoothnessWeight + rigidityWeight
+
+	// Loop continuity: the acceleration across the seam beyond the clip's
+	// average, so a loop that keeps moving through its seam scores like any
+	// other frame
+	if n := len(frames); loop && n >= 3 && len(ids) > 0 {
+		accel := func(a, b, c, id int) float64 {
+			return deltaLength(addDelta(addDelta(frames[a][id], frames[c][id]), scaleDelta(frames[b][id], -2)))
+		}
+		inside, seam := 0.0, 0.0
+		for _, id := range ids {
+			for i := 0; i+2 < n; i++ {
+				inside += accel(i, i+1, i+2, id)
+			}
+			seam += accel(n-2, n-1, 0, id) + accel(n-1, 0, 1, id)
+		}
+		inside /= float64(len(ids) * (n - 2))
+		seam /= float64(2 * len(ids))
+		continuity := subScore(max(0, seam-inside), loopContinuityScale*diagonal)
+		weighted += loopWeight * continuity
+		weights += loopWeight
+		rounded := roundTo(continuity, 3)
+		score.LoopContinuity = &rounded
+	}
+
+	score.Score = roundTo(weighted/weights, 3)
+	score.Smoothness, score.Rigidity = roundTo(score.Smoothness, 3), roundTo(score.Rigidity, 3)
+	return score
+}
+
+// scoreTargets returns the points a clip is meant to move: the ones the
+// model reported, else those of the limb the prompt names
+func scoreTargets(result *generationResult, prompt string) []int {
+	if len(result.AffectedPoints) > 0 {
+		return result.AffectedPoints
+	}
+	limb := expectedLimb(prompt)
+	if limb == "" {
+		return nil
+	}
+	var targets []int
+	for _, id := range sortedKeys(result.Roles) {
+		if roleFamily(result.Roles[id]) == limb {
+			targets = append(targets, id)
+		}
+	}
+	return targets
+}
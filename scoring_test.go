@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestScoreAnimation(t *testing.T) {
+	// A 3-4-5 rig, so scales are fractions of 5
+	positions := map[int][]float64{0: {0, 0, 0}, 1: {3, 4, 0}}
+	// Point 0 moves steadily and point 1 sits 0.1 off its rest
+	frames := make(ResponsePayload, 4)
+	for i := range frames {
+		frames[i] = map[int]Deformation{0: {DeltaX: 0.1 * float64(i)}, 1: {DeltaY: 0.1}}
+	}
+
+	score := scoreAnimation(frames, positions, []int{0}, false)
+	// Rigidity 1 / (1 + 0.1/(0.02·5)) = 0.5, weighted 0.4 and 0.3
+	want := animationScore{Score: 0.786, Smoothness: 1, Rigidity: 0.5}
+	if score != want {
+		t.Errorf("score %+v, want %+v", score, want)
+	}
+
+	// With no targets nothing counts against rigidity
+	if score := scoreAnimation(frames, positions, nil, false); score.Score != 1 || score.Rigidity != 1 {
+		t.Errorf("untargeted score %+v, want 1 throughout", score)
+	}
+
+	// Looping jumps point 0 back from 0.3 to 0 across the seam: an
+	// acceleration of 0.4 on either side against none inside the clip
+	score = scoreAnimation(frames, positions, []int{0}, true)
+	if score.LoopContinuity == nil || *score.LoopContinuity != 0.2 {
+		t.Fatalf("loop continuity %v, want 0.2", score.LoopContinuity)
+	}
+	if score.Score != 0.61 {
+		t.Errorf("loop score %v, want 0.61", score.Score)
+	}
+
+	// Jerk lowers smoothness
+	frames[2][0] = Deformation{DeltaX: 0.5}
+	if score := scoreAnimation(frames, positions, []int{0}, false); score.Smoothness >= 1 {
+		t.Errorf("jerky clip scored smoothness %v", score.Smoothness)
+	}
+}
+
+func TestScoreOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "raise the right hand", Length: 5}}
+
+	rec := serve(t, http.MethodPost, "/generate-deformations?score=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	// score=true implies include_meta
+	body := decodeBody[ResponseEnvelope](t, rec)
+	if body.Meta == nil || body.Meta.Score == nil {
+		t.Fatalf("no score in %s", rec.Body)
+	}
+	// Only the right hand moves, steadily, as the prompt asks
+	if want := (animationScore{Score: 1, Smoothness: 1, Rigidity: 1}); *body.Meta.Score != want {
+		t.Errorf("score %+v, want %+v", *body.Meta.Score, want)
+	}
+
+	rec = serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if body := decodeBody[ResponseEnvelope](t, rec); body.Meta.Score != nil {
+		t.Errorf("score %+v reported without score=true", body.Meta.Score)
+	}
+}
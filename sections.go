@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// An operator-supplied block of system prompt text describing a deployment's
+// own rig conventions. Required sections go into every prompt; optional ones
+// only when a request lists them in prompt_sections.
+type PromptSection struct {
+	Name string `json:"name"`
+	// Heading shown above the text in the prompt, the name when empty
+	Heading string `json:"heading,omitempty"`
+	// The text, inline or read from file at startup
+	Content  string `json:"content,omitempty"`
+	File     string `json:"file,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+var validSectionName = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// loadPromptSectionFiles reads the content of sections given as files
+func loadPromptSectionFiles(sections []PromptSection) error {
+	for i, s := range sections {
+		if s.File == "" {
+			continue
+		}
+		raw, err := os.ReadFile(s.File)
+		if err != nil {
+			return fmt.Errorf("read prompt section %s: %w", s.Name, err)
+		}
+		sections[i].Content = string(raw)
+	}
+	return nil
+}
+
+// validatePromptSections describes every malformed section
+func validatePromptSections(sections []PromptSection) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	for i, s := range sections {
+		label := fmt.Sprintf("prompt_sections[%d]", i)
+		if !validSectionName.MatchString(s.Name) {
+			problems = append(problems, label+": name must be 1-64 lowercase letters, digits, '-' or '_'")
+		} else if seen[s.Name] {
+			problems = append(problems, label+": duplicate name "+s.Name)
+		}
+		seen[s.Name] = true
+		if strings.TrimSpace(s.Content) == "" {
+			problems = append(problems, label+": content or file must not be empty")
+		}
+	}
+	return problems
+}
+
+// validateSectionSelection checks that a request only names registered
+// optional sections
+func validateSectionSelection(names []string) error {
+	for _, name := range names {
+		section, ok := findPromptSection(name)
+		if !ok {
+			return fmt.Errorf("unknown prompt section %q", name)
+		}
+		if !section.Optional {
+			return fmt.Errorf("prompt section %q is always included and cannot be selected", name)
+		}
+	}
+	return nil
+}
+
+func findPromptSection(name string) (PromptSection, bool) {
+	for _, s := range cfg.PromptSections {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return PromptSection{}, false
+}
+
+// promptSectionsFor returns the required sections and the optional ones the
+// request selected, in configuration order
+func promptSectionsFor(payload RequestPayload) []PromptSection {
+	var sections []PromptSection
+	for _, s := range cfg.PromptSections {
+		if !s.Optional || slices.Contains(payload.PromptSections, s.Name) {
+			sections = append(sections, s)
+		}
+	}
+	return sections
+}
+
+// Public description of a prompt section; the content itself stays on the
+// server
+type promptSectionInfo struct {
+	Name     string `json:"name"`
+	Heading  string `json:"heading"`
+	Optional bool   `json:"optional"`
+	// SHA-256 of the content, to tell when an operator changed it
+	ContentHash string `json:"content_hash"`
+}
+
+// Handler for the /prompt-sections endpoint
+func listPromptSections(w http.ResponseWriter, r *http.Request) {
+	sections := []promptSectionInfo{}
+	for _, s := range cfg.PromptSections {
+		sum := sha256.Sum256([]byte(s.Content))
+		sections = append(sections, promptSectionInfo{
+			Name:        s.Name,
+			Heading:     sectionHeading(s),
+			Optional:    s.Optional,
+			ContentHash: hex.EncodeToString(sum[:]),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"sections": sections}); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
+
+func sectionHeading(s PromptSection) string {
+	if s.Heading != "" {
+		return s.Heading
+	}
+	return s.Name
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// Words that tie a prompt to a limb. Body-part nouns are trusted over verbs
+// that imply a limb, so "kick with the left hand" is about the arm.
+var (
+	limbNouns = map[string]string{
+		"arm": "arm", "arms": "arm", "hand": "arm", "hands": "arm", "wrist": "arm",
+		"elbow": "arm", "finger": "arm", "fingers": "arm", "fist": "arm",
+		"leg": "leg", "legs": "leg", "foot": "leg", "feet": "leg", "knee": "leg",
+		"ankle": "leg", "toe": "leg", "toes": "leg",
+	}
+	limbVerbs = map[string]string{
+		"wave": "arm", "waves": "arm", "waving": "arm", "punch": "arm", "punches": "arm",
+		"punching": "arm", "reach": "arm", "reaches": "arm", "reaching": "arm",
+		"kick": "leg", "kicks": "leg", "kicking": "leg", "step": "leg", "steps": "leg",
+		"stomp": "leg", "stomps": "leg", "stomping": "leg",
+	}
+)
+
+func validateOnMismatch(mode string) error {
+	switch mode {
+	case "", "warn", "retry", "reject":
+		return nil
+	}
+	return fmt.Errorf("invalid on_mismatch %q, expected warn, retry or reject", mode)
+}
+
+// A prompt that names one side of the body while the output moves the other
+type semanticMismatch struct {
+	Expected string `json:"expected"`
+	Observed string `json:"observed"`
+	// Peak displacement of the expected and observed role families
+	Displacement map[string]float64 `json:"displacement"`
+}
+
+func (m *semanticMismatch) String() string {
+	return fmt.Sprintf("semantic_mismatch: the prompt names the %s but the %s moves more (peak displacement %.3g vs %.3g)",
+		m.Expected, m.Observed, m.Displacement[m.Expected], m.Displacement[m.Observed])
+}
+
+// expectedLimb returns the role family a prompt singles out, such as "left
+// arm", or "" when it names no side, both sides, or no particular limb.
+// Whole-body prompts like "jump" therefore never produce an expectation.
+func expectedLimb(prompt string) string {
+	words := strings.FieldsFunc(strings.ToLower(prompt), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	})
+	sides := make(map[string]bool)
+	nouns := make(map[string]bool)
+	verbs := make(map[string]bool)
+	for _, w := range words {
+		switch {
+		case w == "left" || w == "right":
+			sides[w] = true
+		case limbNouns[w] != "":
+			nouns[limbNouns[w]] = true
+		case limbVerbs[w] != "":
+			verbs[limbVerbs[w]] = true
+		}
+	}
+	if len(sides) != 1 {
+		return ""
+	}
+	limbs := nouns
+	if len(limbs) == 0 {
+		limbs = verbs
+	}
+	if len(limbs) != 1 {
+		return ""
+	}
+	for side := range sides {
+		for limb := range limbs {
+			return side + " " + limb
+		}
+	}
+	return ""
+}
+
+// peakByFamily returns the largest displacement of any point in each role
+// family over the model frames
+func peakByFamily(frames []map[int]Position, points []ControlPoint) map[string]float64 {
+	peaks := make(map[string]float64)
+	for _, cp := range points {
+		family := roleFamily(cp.Role)
+		peaks[family] = math.Max(peaks[family], 0)
+		if len(cp.Position) < 3 {
+			continue
+		}
+		for _, frame := range frames {
+			p, ok := frame[cp.ID]
+			if !ok {
+				continue
+			}
+			dx, dy, dz := p.X-cp.Position[0], p.Y-cp.Position[1], p.Z-cp.Position[2]
+			if d := math.Sqrt(dx*dx + dy*dy + dz*dz); !math.IsNaN(d) {
+				peaks[family] = math.Max(peaks[family], d)
+			}
+		}
+	}
+	return peaks
+}
+
+// detectSemanticMismatch compares the limb a prompt names with the motion:
+// it fires when the opposite limb moves noticeably (over 1% of the rig's
+// bounding-box diagonal) and more than the named one
+func detectSemanticMismatch(prompt string, frames []map[int]Position, points []ControlPoint) *semanticMismatch {
+	expected := expectedLimb(prompt)
+	if expected == "" {
+		return nil
+	}
+	side, limb, _ := strings.Cut(expected, " ")
+	opposite := "right " + limb
+	if side == "right" {
+		opposite = "left " + limb
+	}
+
+	peaks := peakByFamily(frames, points)
+	expectedPeak, hasExpected := peaks[expected]
+	observedPeak, hasOpposite := peaks[opposite]
+	if !hasExpected || !hasOpposite {
+		return nil
+	}
+	threshold := math.Max(0.01, 0.01*rigDiagonal(points))
+	if observedPeak < threshold || observedPeak <= expectedPeak {
+		return nil
+	}
+	return &semanticMismatch{
+		Expected: expected,
+		Observed: opposite,
+		Displacement: map[string]float64{
+			expected: roundDelta(expectedPeak),
+			opposite: roundDelta(observedPeak),
+		},
+	}
+}
+
+func semanticMismatchError(m *semanticMismatch) *apiError {
+	return newAPIError(http.StatusUnprocessableEntity, "The model moved the %s although the prompt names the %s", m.Observed, m.Expected).
+		withCode("semantic_mismatch").
+		withDetails(m)
+}
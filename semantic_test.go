@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestExpectedLimb(t *testing.T) {
+	for prompt, want := range map[string]string{
+		"wave the right hand":          "right arm",
+		"Kick with the LEFT foot":      "left leg",
+		"kick with the left hand":      "left arm",
+		"stomp left":                   "left leg",
+		"wave both hands":              "",
+		"raise the left and right arm": "",
+		"left hand and left foot":      "",
+		"jump":                         "",
+		"nod to the left":              "",
+	} {
+		if got := expectedLimb(prompt); got != want {
+			t.Errorf("expectedLimb(%q) = %q, want %q", prompt, got, want)
+		}
+	}
+}
+
+// wrongHand lifts the left hand (ID 1) until told it moved the wrong arm,
+// then the right hand (ID 2)
+func wrongHand(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	input, err := modelInputOf(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	moving := 1
+	if strings.Contains(req.Messages[0].Content, "A previous attempt moved the left arm") {
+		moving = 2
+	}
+	return framesResponse(input.Length, func(f int) map[string]Position {
+		frame := make(map[string]Position, len(input.ControlPoints))
+		for _, cp := range input.ControlPoints {
+			p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+			if cp.ID == moving {
+				p.Y += 0.1 * float64(f)
+			}
+			frame[strconv.Itoa(cp.ID)] = p
+		}
+		return frame
+	}), nil
+}
+
+func TestSemanticMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		mode     string
+		status   int
+		calls    int
+		mismatch bool
+	}{
+		{"", http.StatusOK, 1, true},
+		{"warn", http.StatusOK, 1, true},
+		{"retry", http.StatusOK, 2, false},
+		{"reject", http.StatusUnprocessableEntity, 1, false},
+	} {
+		t.Run("on_mismatch="+tc.mode, func(t *testing.T) {
+			fake := setupServer(t, nil)
+			fake.respond = wrongHand
+			payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave the right hand", Length: 3, OnMismatch: tc.mode}}
+			rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+			if rec.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			if got := fake.calls(); got != tc.calls {
+				t.Errorf("upstream called %d times, want %d", got, tc.calls)
+			}
+			if tc.status != http.StatusOK {
+				body := decodeBody[errorResponse](t, rec)
+				details, _ := body.Error.Details.(map[string]any)
+				if body.Error.Code != "semantic_mismatch" || details["expected"] != "right arm" || details["observed"] != "left arm" {
+					t.Errorf("error %+v, want semantic_mismatch from the right to the left arm", body.Error)
+				}
+				return
+			}
+
+			meta := decodeBody[struct {
+				Meta generationMeta `json:"meta"`
+			}](t, rec).Meta
+			warned := slices.ContainsFunc(meta.Warnings, func(w string) bool {
+				return strings.HasPrefix(w, "semantic_mismatch: the prompt names the right arm but the left arm moves more")
+			})
+			if (meta.Mismatch != nil) != tc.mismatch || warned != tc.mismatch {
+				t.Errorf("mismatch %+v, warnings %q; want reported %v", meta.Mismatch, meta.Warnings, tc.mismatch)
+			}
+			if tc.mismatch && meta.Mismatch.Displacement["left arm"] != 0.2 {
+				t.Errorf("displacement %v, want 0.2 for the left arm", meta.Mismatch.Displacement)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// Interactive editing sessions over GET /ws. A client binds a control point
+// set once, then starts generations tagged with its own correlation IDs;
+// several may be in flight at once and their messages are interleaved.
+
+// Message from the client
+type SessionRequest struct {
+	// bind, generate, refine or cancel
+	Op string `json:"op"`
+	// Correlation ID chosen by the client, echoed on every reply
+	ID string `json:"id,omitempty"`
+
+	// bind: inline control points or a registered rig
+	ControlPoints []ControlPoint `json:"control_points,omitempty"`
+	RigID         string         `json:"rig_id,omitempty"`
+
+	// generate
+	Prompt string `json:"prompt,omitempty"`
+	Length int    `json:"length,omitempty"`
+	Loop   bool   `json:"loop,omitempty"`
+
+	// refine: revise the generation with correlation ID Ref, or the most
+	// recent one, according to Feedback
+	Feedback string `json:"feedback,omitempty"`
+	Ref      string `json:"ref,omitempty"`
+}
+
+// Message to the client
+type SessionMessage struct {
+	// bound, progress, frames, done or error
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	// progress: what the generation is doing
+	Stage string `json:"stage,omitempty"`
+	// frames: a batch of frames starting at Offset, out of Total
+	Offset int             `json:"offset,omitempty"`
+	Total  int             `json:"total,omitempty"`
+	Frames ResponsePayload `json:"frames,omitempty"`
+	// frames: the root track for the same frames with root_motion separate
+	Root []RootTransform `json:"root,omitempty"`
+	// bound: number of control points bound
+	Points int `json:"points,omitempty"`
+	// done: response metadata
+	Meta *generationMeta `json:"meta,omitempty"`
+	// error: the same body HTTP endpoints return
+	Error *errorBody `json:"error,omitempty"`
+}
+
+// Frames sent per frames message
+const sessionFrameBatch = 16
+
+// Completed generations remembered per session for refine
+const sessionHistorySize = 16
+
+// Registry of the open sessions. Each session owns its own state behind its
+// mutex; the store only tracks which sessions are open.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[*editSession]struct{}
+}
+
+var sessions = &sessionStore{sessions: make(map[*editSession]struct{})}
+
+func (s *sessionStore) add(session *editSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session] = struct{}{}
+}
+
+func (s *sessionStore) remove(session *editSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, session)
+}
+
+func (s *sessionStore) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+type editSession struct {
+	conn      *wsConn
+	ctx       context.Context
+	requestID string
+
+	mu       sync.Mutex
+	points   []ControlPoint
+	inFlight map[string]context.CancelFunc
+	history  map[string]RequestPayload
+	order    []string
+	wg       sync.WaitGroup
+}
+
+// Handler for the /ws endpoint
+func sessionSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r, 2*cfg.SessionPingInterval.Duration, cfg.WebSocketAllowedOrigins)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	s := &editSession{
+		conn:      conn,
+		ctx:       ctx,
+		requestID: requestIDFrom(r.Context()),
+		inFlight:  make(map[string]context.CancelFunc),
+		history:   make(map[string]RequestPayload),
+	}
+	sessions.add(s)
+	defer sessions.remove(s)
+	go s.keepAlive(cfg.SessionPingInterval.Duration)
+	closeCode := uint16(closeNormal)
+	func() {
+		// The connection is hijacked, so a panic here must close it itself
+		defer func() {
+			if v := recover(); v != nil {
+				reportPanic(s.requestID, r.Method, r.URL.Path, v)
+				closeCode = closeInternal
+			}
+		}()
+		s.serve()
+	}()
+
+	// The client is gone: stop its upstream calls before releasing the socket
+	cancel()
+	s.wg.Wait()
+	conn.close(closeCode, "")
+}
+
+// keepAlive pings the client until the session ends; the read timeout closes
+// sessions whose client stops answering
+func (s *editSession) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.conn.writeFrame(opPing, nil); err != nil {
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *editSession) serve() {
+	for {
+		opcode, data, err := s.conn.readMessage()
+		if err != nil {
+			if !errors.Is(err, errWebSocketClosed) {
+				log.Printf("WebSocket session ended: %v", err)
+			}
+			return
+		}
+		if opcode != opText {
+			s.sendError("", newAPIError(http.StatusBadRequest, "Only text messages are supported"))
+			continue
+		}
+		var req SessionRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.sendError("", newAPIError(http.StatusBadRequest, "Invalid JSON message"))
+			continue
+		}
+		s.handle(req)
+	}
+}
+
+func (s *editSession) handle(req SessionRequest) {
+	switch req.Op {
+	case "bind":
+		s.bind(req)
+	case "generate":
+		s.start(req.ID, RequestPayload{RequestPayload: api.RequestPayload{Prompt: req.Prompt, Length: req.Length, Loop: req.Loop}})
+	case "refine":
+		s.refine(req)
+	case "cancel":
+		s.mu.Lock()
+		cancel, ok := s.inFlight[req.ID]
+		s.mu.Unlock()
+		if !ok {
+			s.sendError(req.ID, newAPIError(http.StatusNotFound, "No generation %q in flight", req.ID))
+			return
+		}
+		cancel()
+	default:
+		s.sendError(req.ID, newAPIError(http.StatusBadRequest, "Unknown op %q, expected bind, generate, refine or cancel", req.Op))
+	}
+}
+
+func (s *editSession) bind(req SessionRequest) {
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: req.ControlPoints, RigID: req.RigID}}
+	if err := resolveRig(&payload); err != nil {
+		if errors.Is(err, errUnknownRig) || errors.Is(err, errRigConflict) {
+			s.sendError(req.ID, newAPIError(http.StatusBadRequest, "%v", err))
+		} else {
+			log.Printf("Failed to resolve rig %s: %v", req.RigID, err)
+			s.sendError(req.ID, newAPIError(http.StatusInternalServerError, "Failed to load rig"))
+		}
+		return
+	}
+	if len(payload.ControlPoints) == 0 {
+		s.sendError(req.ID, newAPIError(http.StatusBadRequest, "bind requires control_points or rig_id"))
+		return
+	}
+	s.mu.Lock()
+	s.points = payload.ControlPoints
+	s.mu.Unlock()
+	s.send(SessionMessage{Type: "bound", ID: req.ID, Points: len(payload.ControlPoints)})
+}
+
+func (s *editSession) refine(req SessionRequest) {
+	s.mu.Lock()
+	ref := req.Ref
+	if ref == "" && len(s.order) > 0 {
+		ref = s.order[len(s.order)-1]
+	}
+	previous, ok := s.history[ref]
+	s.mu.Unlock()
+	if !ok {
+		s.sendError(req.ID, newAPIError(http.StatusNotFound, "No completed generation to refine"))
+		return
+	}
+	if req.Feedback == "" {
+		s.sendError(req.ID, newAPIError(http.StatusBadRequest, "refine requires feedback"))
+		return
+	}
+	previous.Prompt = fmt.Sprintf("%s\nRevision requested: %s", previous.Prompt, req.Feedback)
+	s.start(req.ID, previous)
+}
+
+// start runs a generation in the background, subject to the per-session
+// concurrency limit
+func (s *editSession) start(id string, payload RequestPayload) {
+	if id == "" {
+		s.sendError("", newAPIError(http.StatusBadRequest, "Generations require a correlation id"))
+		return
+	}
+	s.mu.Lock()
+	switch {
+	case s.points == nil:
+		s.mu.Unlock()
+		s.sendError(id, newAPIError(http.StatusBadRequest, "Send a bind message first"))
+		return
+	case s.inFlight[id] != nil:
+		s.mu.Unlock()
+		s.sendError(id, newAPIError(http.StatusConflict, "Generation %q is already in flight", id))
+		return
+	case len(s.inFlight) >= cfg.SessionMaxInFlight:
+		s.mu.Unlock()
+		s.sendError(id, newAPIError(http.StatusTooManyRequests, "At most %d generations may be in flight per session", cfg.SessionMaxInFlight).
+			withCode("too_many_in_flight"))
+		return
+	}
+	payload.ControlPoints = s.points
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.inFlight[id] = cancel
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			cancel()
+			s.mu.Lock()
+			delete(s.inFlight, id)
+			s.mu.Unlock()
+		}()
+		// A panicking generation fails on its own without ending the session
+		defer func() {
+			if v := recover(); v != nil {
+				reportPanic(s.requestID, http.MethodGet, "/ws", v)
+				s.sendError(id, panicError(s.requestID))
+			}
+		}()
+		s.run(ctx, id, payload)
+	}()
+}
+
+func (s *editSession) run(ctx context.Context, id string, payload RequestPayload) {
+	ctx, timings := withTimings(ctx)
+	defer timings.finish("/ws")
+
+	s.send(SessionMessage{Type: "progress", ID: id, Stage: "generating"})
+	result, cache, err := generateCached(ctx, payload)
+	if err != nil {
+		if ctx.Err() != nil && s.ctx.Err() == nil {
+			err = newAPIError(http.StatusConflict, "Generation %q was cancelled", id).withCode("cancelled")
+		}
+		s.sendError(id, err)
+		return
+	}
+
+	for offset := 0; offset < len(result.Frames); offset += sessionFrameBatch {
+		end := min(offset+sessionFrameBatch, len(result.Frames))
+		s.send(SessionMessage{Type: "frames", ID: id, Offset: offset, Total: len(result.Frames), Frames: result.Frames[offset:end], Root: sliceRoot(result.Root, offset, end)})
+	}
+	s.send(SessionMessage{Type: "done", ID: id, Meta: &generationMeta{
+		Timings:  timings.breakdown(),
+		Usage:    &result.Usage,
+		Prompt:   result.Prompt,
+		Warnings: result.Warnings,
+		Cache:    cache,
+		// Points sent without a role, with infer_roles
+		InferredRoles: result.InferredRoles,
+		// Key for /generations/{hash}
+		GenerationHash: result.Hash,
+		Constraints:    result.Constraints,
+	}})
+
+	// A refinement of this generation tells the model which limits it broke
+	payload.constraintFeedback = constraintFeedback(result.Constraints)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.history[id]; !exists {
+		s.order = append(s.order, id)
+	}
+	s.history[id] = payload
+	if len(s.order) > sessionHistorySize {
+		delete(s.history, s.order[0])
+		s.order = s.order[1:]
+		countEvictions("session_history", 1)
+	}
+}
+
+func (s *editSession) send(msg SessionMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to encode session message: %v", err)
+		return
+	}
+	s.conn.writeFrame(opText, data)
+}
+
+func (s *editSession) sendError(id string, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		apiErr = newAPIError(http.StatusInternalServerError, "%v", err)
+	}
+	s.send(SessionMessage{Type: "error", ID: id, Error: &errorBody{Code: apiErr.Code, Message: apiErr.Message, Details: apiErr.Details}})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+)
+
+// A clip in the sparse encoding. Every keyframe_interval-th frame, starting
+// with the first, is a keyframe listing every point. Other frames list only
+// the points whose delta moved more than epsilon on some axis away from the
+// value last sent for that point; the rest hold that value.
+type ResponsePayloadSparse struct {
+	Encoding         string                `json:"encoding"`
+	Epsilon          float64               `json:"epsilon"`
+	KeyframeInterval int                   `json:"keyframe_interval"`
+	Frames           []map[int]Deformation `json:"frames"`
+}
+
+// validateEncoding checks the encoding against the other output options of
+// a request. Sparse frames only carry cartesian deltas.
+func validateEncoding(encoding, coords, units, unchanged string) error {
+	switch encoding {
+	case "", "dense":
+		return nil
+	case "sparse":
+		switch {
+		case coords == "spherical":
+			return fmt.Errorf("encoding=sparse requires cartesian output_coords")
+		case units == "per_second":
+			return fmt.Errorf("output_units=per_second requires dense encoding")
+		// Sparse frames already leave out points that did not change, and
+		// would read an omitted zero delta as "same as before"
+		case unchanged == "omit":
+			return fmt.Errorf("encoding=sparse cannot be combined with unchanged_points=omit")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid encoding %q, expected dense or sparse", encoding)
+}
+
+// encodeSparse drops the points of each non-key frame that have not moved
+// more than epsilon since they were last sent. Points are compared with the
+// last sent value rather than the previous frame, so slow drift is still
+// sent once it adds up and a reconstructed delta is never more than epsilon
+// off on any axis.
+func encodeSparse(frames ResponsePayload, epsilon float64, interval int) ResponsePayloadSparse {
+	sparse := ResponsePayloadSparse{
+		Encoding:         "sparse",
+		Epsilon:          epsilon,
+		KeyframeInterval: interval,
+		Frames:           make([]map[int]Deformation, len(frames)),
+	}
+	sent := make(map[int]Deformation)
+	for i, frame := range frames {
+		if i%interval == 0 {
+			sparse.Frames[i] = maps.Clone(frame)
+			sent = maps.Clone(frame)
+			continue
+		}
+		changed := make(map[int]Deformation)
+		for id, d := range frame {
+			if last, ok := sent[id]; ok && !deltaMoved(last, d, epsilon) {
+				continue
+			}
+			changed[id] = d
+			sent[id] = d
+		}
+		sparse.Frames[i] = changed
+	}
+	return sparse
+}
+
+func deltaMoved(a, b Deformation, epsilon float64) bool {
+	return math.Abs(a.DeltaX-b.DeltaX) > epsilon ||
+		math.Abs(a.DeltaY-b.DeltaY) > epsilon ||
+		math.Abs(a.DeltaZ-b.DeltaZ) > epsilon
+}
+
+// ExpandSparse reconstructs dense frames from the sparse encoding. Keyframes
+// are taken as they are; every other frame starts from the frame before it
+// and applies the points it lists. Each delta is within the clip's epsilon
+// of the original.
+func ExpandSparse(sparse ResponsePayloadSparse) ResponsePayload {
+	interval := max(sparse.KeyframeInterval, 1)
+	frames := make(ResponsePayload, len(sparse.Frames))
+	for i, changed := range sparse.Frames {
+		if i%interval == 0 || i == 0 {
+			frames[i] = maps.Clone(changed)
+			continue
+		}
+		frame := maps.Clone(frames[i-1])
+		maps.Copy(frame, changed)
+		frames[i] = frame
+	}
+	return frames
+}
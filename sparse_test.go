@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// sparseTestClip has points that sway, drift slowly and hold still
+func sparseTestClip() ResponsePayload {
+	clip := benchmarkFrames(90, 6)
+	for f, frame := range clip {
+		frame[6] = Deformation{DeltaY: roundTo(0.0004*float64(f), 4)}
+		frame[7] = Deformation{DeltaZ: 0.25}
+	}
+	return clip
+}
+
+func TestSparseRoundTrip(t *testing.T) {
+	clip := sparseTestClip()
+	for _, epsilon := range []float64{0, 1e-3, 0.02} {
+		for _, interval := range []int{1, 7, 30} {
+			sparse := encodeSparse(clip, epsilon, interval)
+			// Round trip through JSON as clients receive it
+			raw, err := json.Marshal(sparse)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded ResponsePayloadSparse
+			if err := json.Unmarshal(raw, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			expanded := ExpandSparse(decoded)
+			if len(expanded) != len(clip) {
+				t.Fatalf("epsilon %g, interval %d: %d frames, want %d", epsilon, interval, len(expanded), len(clip))
+			}
+			for f, frame := range clip {
+				if f%interval == 0 && len(sparse.Frames[f]) != len(frame) {
+					t.Errorf("epsilon %g, interval %d: keyframe %d lists %d points, want all %d", epsilon, interval, f, len(sparse.Frames[f]), len(frame))
+				}
+				for id, want := range frame {
+					got, ok := expanded[f][id]
+					if !ok || deltaMoved(got, want, epsilon) {
+						t.Fatalf("epsilon %g, interval %d: frame %d point %d is %+v, want within %g of %+v", epsilon, interval, f, id, got, epsilon, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSparseDropsHeldPoints(t *testing.T) {
+	clip := sparseTestClip()
+	sparse := encodeSparse(clip, 1e-3, 30)
+	for f, frame := range sparse.Frames {
+		if _, ok := frame[7]; ok != (f%30 == 0) {
+			t.Errorf("frame %d: held point listed %v, want only on keyframes", f, ok)
+		}
+	}
+	// Slow drift is sent once it adds up to more than epsilon
+	sent := 0
+	for f := 1; f < 30; f++ {
+		if _, ok := sparse.Frames[f][6]; ok {
+			sent++
+		}
+	}
+	if sent == 0 || sent == 29 {
+		t.Errorf("drifting point sent in %d of 29 frames, want some but not all", sent)
+	}
+}
+
+func TestSparseResponse(t *testing.T) {
+	fake := setupServer(t, func(c *Config) { c.SparseKeyframeInterval = 3 })
+	fake.respond = raiseResponse
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "raise the right hand", Length: 8}}
+	dense := decodeBody[ResponsePayload](t, serve(t, http.MethodPost, "/generate-deformations", payload, nil))
+
+	payload.Encoding = "sparse"
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	sparse := decodeBody[ResponsePayloadSparse](t, rec)
+	if sparse.Encoding != "sparse" || sparse.KeyframeInterval != 3 {
+		t.Errorf("encoding %q, keyframe_interval %d; want sparse and 3", sparse.Encoding, sparse.KeyframeInterval)
+	}
+	// Only the raised hand is listed between keyframes
+	if _, ok := sparse.Frames[1][2]; !ok || len(sparse.Frames[1]) != 1 {
+		t.Errorf("frame 1 lists %v, want only the right hand", sparse.Frames[1])
+	}
+	for f, frame := range ExpandSparse(sparse) {
+		for id, d := range frame {
+			if deltaMoved(d, dense[f][id], sparse.Epsilon) {
+				t.Errorf("frame %d point %d expands to %+v, dense %+v", f, id, d, dense[f][id])
+			}
+		}
+	}
+}
+
+func BenchmarkEncodeSparse(b *testing.B) {
+	frames := benchmarkFrames(benchFrames, benchPoints)
+	b.ReportAllocs()
+	for b.Loop() {
+		encodeSparse(frames, 1e-3, 30)
+	}
+}
+
+func BenchmarkExpandSparse(b *testing.B) {
+	sparse := encodeSparse(benchmarkFrames(benchFrames, benchPoints), 1e-3, 30)
+	b.ReportAllocs()
+	for b.Loop() {
+		ExpandSparse(sparse)
+	}
+}
+
+func BenchmarkSparseMarshal(b *testing.B) {
+	sparse := encodeSparse(benchmarkFrames(benchFrames, benchPoints), 1e-3, 30)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := json.Marshal(sparse); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+func validateStabilizeCOM(enabled bool, ids []int, points []ControlPoint) error {
+	if len(ids) > 0 && !enabled {
+		return fmt.Errorf("com_points requires stabilize_com")
+	}
+	for _, id := range ids {
+		if !slices.ContainsFunc(points, func(cp ControlPoint) bool { return cp.ID == id }) {
+			return fmt.Errorf("com_points: %d is not a control point", id)
+		}
+	}
+	return nil
+}
+
+// stabilizeCOM keeps the character in place: every frame is shifted so the
+// centroid of the given points (all of them when none are given) stays where
+// it was in the first frame. The points move relative to one another as
+// before. A point missing from a frame counts at its previous delta.
+func stabilizeCOM(frames ResponsePayload, ids []int, points []ControlPoint) ResponsePayload {
+	if len(frames) < 2 {
+		return frames
+	}
+	if len(ids) == 0 {
+		ids = make([]int, len(points))
+		for i, cp := range points {
+			ids[i] = cp.ID
+		}
+	}
+	if len(ids) == 0 {
+		return frames
+	}
+	held, _ := backfillPoints(copyFrames(frames), ids)
+	centroid := func(frame map[int]Deformation) Deformation {
+		var sum Deformation
+		for _, id := range ids {
+			sum = addDelta(sum, frame[id])
+		}
+		return scaleDelta(sum, 1/float64(len(ids)))
+	}
+
+	origin := centroid(held[0])
+	result := copyFrames(frames)
+	for i, frame := range result {
+		drift := addDelta(centroid(held[i]), scaleDelta(origin, -1))
+		for id, d := range frame {
+			frame[id] = addDelta(d, scaleDelta(drift, -1))
+		}
+	}
+	return result
+}
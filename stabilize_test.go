@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// centroidOf averages the deltas of the given points in one frame
+func centroidOf(frame map[int]Deformation, ids []int) Deformation {
+	var sum Deformation
+	for _, id := range ids {
+		sum = addDelta(sum, frame[id])
+	}
+	return scaleDelta(sum, 1/float64(len(ids)))
+}
+
+func closeDelta(a, b Deformation) bool {
+	return math.Abs(a.DeltaX-b.DeltaX) < 1e-9 && math.Abs(a.DeltaY-b.DeltaY) < 1e-9 && math.Abs(a.DeltaZ-b.DeltaZ) < 1e-9
+}
+
+func TestStabilizeCOM(t *testing.T) {
+	points := testRig()
+	all := []int{0, 1, 2, 3, 4}
+	// The whole rig drifts along x while the right hand also rises
+	frames := make(ResponsePayload, 5)
+	for i := range frames {
+		drift := 0.05 * float64(i)
+		frames[i] = map[int]Deformation{}
+		for _, id := range all {
+			frames[i][id] = Deformation{DeltaX: drift, DeltaZ: 0.01}
+		}
+		frames[i][2] = Deformation{DeltaX: drift, DeltaY: 0.1 * float64(i), DeltaZ: 0.01}
+	}
+
+	stable := stabilizeCOM(frames, nil, points)
+	origin := centroidOf(frames[0], all)
+	for i, frame := range stable {
+		if c := centroidOf(frame, all); !closeDelta(c, origin) {
+			t.Errorf("frame %d: centre of mass at %+v, want it held at %+v", i, c, origin)
+		}
+		// Points keep their places relative to one another
+		if got, want := addDelta(frame[2], scaleDelta(frame[0], -1)), addDelta(frames[i][2], scaleDelta(frames[i][0], -1)); !closeDelta(got, want) {
+			t.Errorf("frame %d: hand moved to %+v from the head, want %+v", i, got, want)
+		}
+	}
+	if frames[4][0].DeltaX != 0.2 {
+		t.Error("stabilizeCOM modified its input")
+	}
+
+	// Measured on the feet only, which just drift, the drift goes and the
+	// hand's rise stays
+	stable = stabilizeCOM(frames, []int{3, 4}, points)
+	for i, frame := range stable {
+		if c := centroidOf(frame, []int{3, 4}); !closeDelta(c, origin) {
+			t.Errorf("frame %d: feet centre at %+v, want it held at %+v", i, c, origin)
+		}
+		if want := (Deformation{DeltaY: 0.1 * float64(i), DeltaZ: 0.01}); !closeDelta(frame[2], want) {
+			t.Errorf("frame %d: hand at %+v, want %+v", i, frame[2], want)
+		}
+	}
+}
+
+func TestStabilizeCOMOnRequests(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	payload := RequestPayload{RequestPayload: api.RequestPayload{
+		ControlPoints: testRig(),
+		Prompt:        "raise the right hand high",
+		Length:        3,
+		StabilizeCOM:  true,
+		// Generous budgets, so only stabilizing changes the deltas
+		Constraints: &MotionConstraints{MotionBudgets: map[int]float64{0: 1, 1: 1, 2: 1, 3: 1, 4: 1}},
+	}}
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	// The hand rises 0.2 a frame; a fifth of that moves the centre of mass,
+	// which the other points give back
+	for i, frame := range decodeBody[[]map[int]Deformation](t, rec) {
+		if got, want := frame[2].DeltaY, 0.16*float64(i); math.Abs(got-want) > 1e-9 {
+			t.Errorf("frame %d: hand rose %v, want %v", i, got, want)
+		}
+		if got, want := frame[0].DeltaY, -0.04*float64(i); math.Abs(got-want) > 1e-9 {
+			t.Errorf("frame %d: head moved %v, want %v", i, got, want)
+		}
+	}
+
+	for name, invalid := range map[string]api.RequestPayload{
+		"com_points alone": {ControlPoints: testRig(), Prompt: "wave", Length: 4, COMPoints: []int{0}},
+		"unknown point":    {ControlPoints: testRig(), Prompt: "wave", Length: 4, StabilizeCOM: true, COMPoints: []int{9}},
+	} {
+		if rec := serve(t, http.MethodPost, "/generate-deformations", RequestPayload{RequestPayload: invalid}, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+	}
+}
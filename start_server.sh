@@ -10,4 +10,4 @@ echo "Starting descriptive-rigidity server..."
 echo "OPENAI_API_KEY is set: $([ -n "$OPENAI_API_KEY" ] && echo "Yes" || echo "No")"
 echo ""
 
-go run main.go
\ No newline at end of file
+go run .
\ No newline at end of file
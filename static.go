@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+)
+
+func validateStaticPolicy(policy string) error {
+	switch policy {
+	case "retry", "fail", "allow":
+		return nil
+	}
+	return fmt.Errorf("%q is not one of retry, fail, allow", policy)
+}
+
+// totalMotion sums every control point's displacement from its rest position
+// over all frames
+func totalMotion(frames []map[int]Position, points []ControlPoint) float64 {
+	rest := make(map[int][]float64, len(points))
+	for _, cp := range points {
+		rest[cp.ID] = cp.Position
+	}
+	total := 0.0
+	for _, frame := range frames {
+		// ID order keeps the sum, and so the static check, reproducible
+		for _, id := range sortedKeys(frame) {
+			p := frame[id]
+			r := rest[id]
+			if len(r) < 3 {
+				continue
+			}
+			dx, dy, dz := p.X-r[0], p.Y-r[1], p.Z-r[2]
+			if d := math.Sqrt(dx*dx + dy*dy + dz*dz); !math.IsNaN(d) {
+				total += d
+			}
+		}
+	}
+	return total
+}
+
+// isStaticGeneration reports whether the model left every point at rest
+func isStaticGeneration(frames []map[int]Position, points []ControlPoint) bool {
+	return totalMotion(frames, points) < cfg.StaticEpsilon
+}
+
+func staticGenerationError() *apiError {
+	return newAPIError(http.StatusUnprocessableEntity, "The model returned no motion; every control point stayed at its rest position. Try a more specific prompt.").
+		withCode("static_generation")
+}
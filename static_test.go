@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+// restResponse returns every point at its input position in every frame
+func restResponse(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	input, err := modelInputOf(req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	return framesResponse(input.Length, func(int) map[string]Position {
+		frame := make(map[string]Position, len(input.ControlPoints))
+		for _, cp := range input.ControlPoints {
+			frame[strconv.Itoa(cp.ID)] = Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+		}
+		return frame
+	}), nil
+}
+
+func TestStaticGenerationPolicies(t *testing.T) {
+	type responder = func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	for _, tc := range []struct {
+		name      string
+		policy    string
+		responses []responder
+		status    int
+		calls     int
+	}{
+		{"retry recovers", "retry", []responder{restResponse, swayResponse}, http.StatusOK, 2},
+		{"retry still static", "retry", []responder{restResponse, restResponse}, http.StatusUnprocessableEntity, 2},
+		{"fail", "fail", []responder{restResponse}, http.StatusUnprocessableEntity, 1},
+		{"allow", "allow", []responder{restResponse}, http.StatusOK, 1},
+		{"moving", "fail", []responder{swayResponse}, http.StatusOK, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := setupServer(t, func(c *Config) { c.StaticPolicy = tc.policy })
+			fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+				return tc.responses[min(fake.calls(), len(tc.responses))-1](req)
+			}
+			payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4}}
+			rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+			if rec.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			if tc.status == http.StatusUnprocessableEntity {
+				if code := decodeBody[errorResponse](t, rec).Error.Code; code != "static_generation" {
+					t.Errorf("error code %q, want static_generation", code)
+				}
+			}
+			if got := fake.calls(); got != tc.calls {
+				t.Fatalf("upstream called %d times, want %d", got, tc.calls)
+			}
+			// Only the retry is told that the previous attempt did not move
+			for i, req := range fake.requests {
+				reinforced := strings.Contains(req.Messages[0].Content, "must visibly move away")
+				if reinforced != (i == 1) {
+					t.Errorf("call %d: reinforced prompt %v", i+1, reinforced)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store persists JSON documents grouped into named collections. It backs
+// every server-side library (rigs, stored animations, ...) so they share one
+// persistence configuration.
+type Store interface {
+	Put(collection, key string, value any) error
+	Get(collection, key string, value any) (bool, error)
+	List(collection string) ([]string, error)
+	Delete(collection, key string) error
+}
+
+var validStoreKey = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+func checkStoreKey(collection, key string) error {
+	if !validStoreKey.MatchString(collection) || !validStoreKey.MatchString(key) {
+		return fmt.Errorf("invalid store key %q/%q", collection, key)
+	}
+	return nil
+}
+
+// newStore returns a file-backed store rooted at dir, or an in-memory store
+// when dir is empty
+func newStore(dir string) (Store, error) {
+	if dir == "" {
+		return newMemoryStore(), nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+// In-memory store; contents are lost on restart
+type memoryStore struct {
+	mu   sync.RWMutex
+	data map[string]map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{data: make(map[string]map[string][]byte)}
+}
+
+func (s *memoryStore) Put(collection, key string, value any) error {
+	if err := checkStoreKey(collection, key); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data[collection] == nil {
+		s.data[collection] = make(map[string][]byte)
+	}
+	s.data[collection][key] = raw
+	return nil
+}
+
+func (s *memoryStore) Get(collection, key string, value any) (bool, error) {
+	if err := checkStoreKey(collection, key); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	raw, ok := s.data[collection][key]
+	s.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, value)
+}
+
+func (s *memoryStore) List(collection string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.data[collection]))
+	for key := range s.data[collection] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *memoryStore) Delete(collection, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data[collection], key)
+	return nil
+}
+
+// File-backed store: one JSON file per document under <dir>/<collection>/
+type fileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func (s *fileStore) path(collection, key string) string {
+	return filepath.Join(s.dir, collection, key+".json")
+}
+
+func (s *fileStore) Put(collection, key string, value any) error {
+	if err := checkStoreKey(collection, key); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Join(s.dir, collection), 0o755); err != nil {
+		return err
+	}
+	// Write to a temp file and rename so readers never see a partial document
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, collection), "."+key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(collection, key))
+}
+
+func (s *fileStore) Get(collection, key string, value any) (bool, error) {
+	if err := checkStoreKey(collection, key); err != nil {
+		return false, err
+	}
+	raw, err := os.ReadFile(s.path(collection, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(raw, value)
+}
+
+func (s *fileStore) List(collection string) ([]string, error) {
+	if !validStoreKey.MatchString(collection) {
+		return nil, fmt.Errorf("invalid store collection %q", collection)
+	}
+	entries, err := os.ReadDir(filepath.Join(s.dir, collection))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		keys = append(keys, strings.TrimSuffix(name, ".json"))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *fileStore) Delete(collection, key string) error {
+	if err := checkStoreKey(collection, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path(collection, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
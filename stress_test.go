@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// TestConcurrentState hammers the job registry, the result cache and the
+// session store from many goroutines at once. It asserts little beyond
+// consistent counts; its value is running under go test -race.
+func TestConcurrentState(t *testing.T) {
+	setupServer(t, func(c *Config) { c.CacheMaxEntries = 8 })
+	const workers, rounds = 16, 50
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(3)
+
+		// Jobs: create, update, watch, wait, read and expire
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				job := jobs.create("")
+				events, stop := jobs.watch(job.ID)
+				jobs.update(job.ID, func(j *Job) { j.Status = jobRunning })
+				jobs.update(job.ID, func(j *Job) { j.Status = jobDone })
+				<-events
+				stop()
+				if got, _ := jobs.wait(context.Background(), job.ID, time.Second); got.Status != jobDone {
+					t.Errorf("job %s is %s after finishing", job.ID, got.Status)
+				}
+				jobs.countByStatus()
+				jobs.cleanup(time.Now().Add(2 * time.Hour))
+			}
+		}()
+
+		// Cache: entries shared between workers, evicted, swept and served
+		go func() {
+			defer wg.Done()
+			for i := range rounds {
+				key := fmt.Sprint((w + i) % 12)
+				responses.put(key, &generationResult{Hash: key})
+				if entry, _ := responses.get(key); entry != nil && entry.result.Hash != key {
+					t.Errorf("cache entry %s holds result %s", key, entry.result.Hash)
+				}
+				responses.sweep(time.Now())
+				responses.size()
+			}
+		}()
+
+		// Sessions: open and close while others count them
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				s := &editSession{inFlight: make(map[string]context.CancelFunc), history: make(map[string]RequestPayload)}
+				sessions.add(s)
+				sessions.size()
+				sessions.remove(s)
+			}
+		}()
+	}
+
+	// Real generations share the cache and the upstream limiters meanwhile
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: fmt.Sprintf("sway %d", i%4), Length: 4}}
+			if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+				t.Errorf("generation: status %d: %s", rec.Code, rec.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := sessions.size(); n != 0 {
+		t.Errorf("%d sessions left open", n)
+	}
+	if n := responses.size(); n > 8 {
+		t.Errorf("cache holds %d entries, above its limit of 8", n)
+	}
+}
@@ -41,7 +41,7 @@ echo "Testing the descriptive-rigidity API..."
 echo "API URL: $API_URL"
 echo ""
 echo "Note: Make sure your Go server is running with:"
-echo "  go run main.go"
+echo "  go run ."
 echo "And that OPENAI_API_KEY is set in your environment or .env file"
 echo ""
 
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Duration of one pipeline stage
+type stageTiming struct {
+	Stage      string  `json:"stage"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Per-request timing breakdown carried through the context. A nil
+// *requestTimings is valid and records nothing, so pipeline code can time
+// stages unconditionally.
+type requestTimings struct {
+	mu     sync.Mutex
+	start  time.Time
+	stages []stageTiming
+}
+
+type timingsKey struct{}
+
+func withTimings(ctx context.Context) (context.Context, *requestTimings) {
+	t := &requestTimings{start: time.Now()}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+func timingsFrom(ctx context.Context) *requestTimings {
+	t, _ := ctx.Value(timingsKey{}).(*requestTimings)
+	return t
+}
+
+// stage starts timing a named stage and returns the function that ends it:
+//
+//	defer timingsFrom(ctx).stage("parse")()
+func (t *requestTimings) stage(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+	started := time.Now()
+	return func() {
+		elapsed := time.Since(started)
+		t.mu.Lock()
+		t.stages = append(t.stages, stageTiming{Stage: name, DurationMS: durationMS(elapsed)})
+		t.mu.Unlock()
+		observeHistogram("stage_duration_seconds", "stage", name, elapsed.Seconds())
+	}
+}
+
+// Snapshot of the stages recorded so far and the total elapsed time
+type timingBreakdown struct {
+	Stages  []stageTiming `json:"stages"`
+	TotalMS float64       `json:"total_ms"`
+}
+
+func (t *requestTimings) breakdown() *timingBreakdown {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &timingBreakdown{
+		Stages:  append([]stageTiming(nil), t.stages...),
+		TotalMS: durationMS(time.Since(t.start)),
+	}
+}
+
+// finish records the request total and logs the breakdown for slow requests
+func (t *requestTimings) finish(path string) {
+	if t == nil {
+		return
+	}
+	total := time.Since(t.start)
+	observeHistogram("request_duration_seconds", "path", path, total.Seconds())
+	if total < cfg.SlowRequestThreshold.Duration {
+		return
+	}
+	b := t.breakdown()
+	parts := make([]string, len(b.Stages))
+	for i, s := range b.Stages {
+		parts[i] = s.Stage + "=" + time.Duration(s.DurationMS*float64(time.Millisecond)).String()
+	}
+	log.Printf("WARN slow request %s took %s: %s", path, total, strings.Join(parts, " "))
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
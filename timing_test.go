@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// captureLog collects what the server logs until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+	return &buf
+}
+
+func TestTimingBreakdown(t *testing.T) {
+	setupServer(t, nil)
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+	rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	timings := decodeBody[struct {
+		Meta struct {
+			Timings *timingBreakdown `json:"timings"`
+		} `json:"meta"`
+	}](t, rec).Meta.Timings
+	if timings == nil {
+		t.Fatal("meta has no timings")
+	}
+
+	var stages []string
+	sum := 0.0
+	for _, s := range timings.Stages {
+		stages = append(stages, s.Stage)
+		sum += s.DurationMS
+		if s.DurationMS < 0 {
+			t.Errorf("stage %s took %v ms", s.Stage, s.DurationMS)
+		}
+	}
+	for _, want := range []string{"decode", "validate", "language", "prompt_build", "upstream", "parse", "postprocess"} {
+		if !slices.Contains(stages, want) {
+			t.Errorf("stages %v lack %s", stages, want)
+		}
+	}
+	// Prompt expansion was not asked for
+	if slices.Contains(stages, "expand") {
+		t.Errorf("stages %v include expand", stages)
+	}
+	if timings.TotalMS < sum {
+		t.Errorf("total %v ms is less than its stages' %v ms", timings.TotalMS, sum)
+	}
+
+	body := serve(t, http.MethodGet, "/metrics", nil, nil).Body.String()
+	for _, series := range []string{
+		`stage_duration_seconds_count{stage="upstream"}`,
+		`request_duration_seconds_count{path="/generate-deformations"}`,
+	} {
+		if !strings.Contains(body, series) {
+			t.Errorf("metrics lack %s", series)
+		}
+	}
+}
+
+func TestSlowRequestLog(t *testing.T) {
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+	for _, tc := range []struct {
+		threshold time.Duration
+		logged    bool
+	}{
+		{time.Hour, false},
+		{time.Nanosecond, true},
+	} {
+		t.Run(tc.threshold.String(), func(t *testing.T) {
+			setupServer(t, func(c *Config) { c.SlowRequestThreshold = Duration{tc.threshold} })
+			logs := captureLog(t)
+			serve(t, http.MethodPost, "/generate-deformations", payload, nil)
+			line := ""
+			for _, l := range strings.Split(logs.String(), "\n") {
+				if strings.Contains(l, "WARN slow request") {
+					line = l
+				}
+			}
+			if (line != "") != tc.logged {
+				t.Fatalf("slow request line %q, want logged %v", line, tc.logged)
+			}
+			if tc.logged && (!strings.Contains(line, "/generate-deformations") || !strings.Contains(line, "upstream=")) {
+				t.Errorf("slow request line %q lacks the path or the stage breakdown", line)
+			}
+		})
+	}
+}
+
+func TestNilTimings(t *testing.T) {
+	// Pipeline code times stages without checking for a breakdown
+	var timings *requestTimings
+	timings.stage("parse")()
+	timings.finish("/generate-deformations")
+	if timings.breakdown() != nil {
+		t.Error("nil timings returned a breakdown")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// Input struct for the /trajectory endpoint
+type TrajectoryRequest struct {
+	Frames        ResponsePayload `json:"frames"`
+	ControlPoints []ControlPoint  `json:"control_points"`
+	// Axes mapped onto the 2D x and y, e.g. "xz" for a top view (default "xy")
+	Plane string `json:"plane,omitempty"`
+}
+
+// A projected position
+type TrajectoryPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Extent of every trajectory in the plane, for fitting them to a canvas
+type TrajectoryBounds struct {
+	MinX float64 `json:"min_x"`
+	MinY float64 `json:"min_y"`
+	MaxX float64 `json:"max_x"`
+	MaxY float64 `json:"max_y"`
+}
+
+type TrajectoryResponse struct {
+	Plane string `json:"plane"`
+	// Control point ID → its projected position in every frame, in order
+	Trajectories map[int][]TrajectoryPoint `json:"trajectories"`
+	Bounds       TrajectoryBounds          `json:"bounds"`
+}
+
+// parsePlane returns the position indices of the plane's two axes
+func parsePlane(plane string) ([2]int, error) {
+	if len(plane) == 2 {
+		u, v := strings.IndexByte("xyz", plane[0]), strings.IndexByte("xyz", plane[1])
+		if u >= 0 && v >= 0 && u != v {
+			return [2]int{u, v}, nil
+		}
+	}
+	return [2]int{}, fmt.Errorf("invalid plane %q, expected two different axes such as xy, xz or zy", plane)
+}
+
+func validateTrajectory(req TrajectoryRequest) error {
+	if len(req.Frames) == 0 || len(req.ControlPoints) == 0 {
+		return fmt.Errorf("missing frames or control_points")
+	}
+	for _, cp := range req.ControlPoints {
+		if len(cp.Position) < 3 {
+			return fmt.Errorf("control point %d needs an [x, y, z] position", cp.ID)
+		}
+	}
+	_, err := parsePlane(req.Plane)
+	return err
+}
+
+// projectTrajectories returns every control point's path through the clip,
+// projected onto the plane: one point per frame, rest position plus delta.
+// A point missing from a frame holds its previous position there.
+func projectTrajectories(frames ResponsePayload, points []ControlPoint, axes [2]int) (map[int][]TrajectoryPoint, TrajectoryBounds) {
+	ids := make([]int, len(points))
+	for i, cp := range points {
+		ids[i] = cp.ID
+	}
+	frames, _ = backfillPoints(copyFrames(frames), ids)
+
+	trajectories := make(map[int][]TrajectoryPoint, len(points))
+	bounds := TrajectoryBounds{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+	for _, cp := range points {
+		path := make([]TrajectoryPoint, len(frames))
+		for i, frame := range frames {
+			d := frame[cp.ID]
+			position := [3]float64{cp.Position[0] + d.DeltaX, cp.Position[1] + d.DeltaY, cp.Position[2] + d.DeltaZ}
+			p := TrajectoryPoint{X: roundTo(position[axes[0]], 6), Y: roundTo(position[axes[1]], 6)}
+			bounds.MinX, bounds.MaxX = min(bounds.MinX, p.X), max(bounds.MaxX, p.X)
+			bounds.MinY, bounds.MaxY = min(bounds.MinY, p.Y), max(bounds.MaxY, p.Y)
+			path[i] = p
+		}
+		trajectories[cp.ID] = path
+	}
+	return trajectories, bounds
+}
+
+// Handler for the /trajectory endpoint
+func trajectory(w http.ResponseWriter, r *http.Request) {
+	var req TrajectoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+	if req.Plane == "" {
+		req.Plane = "xy"
+	}
+	if err := validateTrajectory(req); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "%v", err))
+		return
+	}
+
+	axes, _ := parsePlane(req.Plane)
+	trajectories, bounds := projectTrajectories(req.Frames, req.ControlPoints, axes)
+	response := TrajectoryResponse{Plane: req.Plane, Trajectories: trajectories, Bounds: bounds}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestTrajectory(t *testing.T) {
+	setupServer(t, nil)
+	points := []ControlPoint{
+		{ID: 0, Role: "head", Position: []float64{0, 1.7, 0.1}},
+		{ID: 7, Role: "right hand", Position: []float64{-0.6, 1.2, 0}},
+	}
+	// The hand is missing from the last frame and holds its place there
+	frames := ResponsePayload{
+		{0: {}, 7: {}},
+		{0: {DeltaX: 0.1}, 7: {DeltaY: 0.2, DeltaZ: 0.3}},
+		{0: {DeltaX: 0.2}},
+	}
+
+	t.Run("top view", func(t *testing.T) {
+		rec := serve(t, http.MethodPost, "/trajectory", TrajectoryRequest{Frames: frames, ControlPoints: points, Plane: "xz"}, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		body := decodeBody[TrajectoryResponse](t, rec)
+		if body.Plane != "xz" {
+			t.Errorf("plane %q, want xz", body.Plane)
+		}
+		want := map[int][]TrajectoryPoint{
+			0: {{X: 0, Y: 0.1}, {X: 0.1, Y: 0.1}, {X: 0.2, Y: 0.1}},
+			7: {{X: -0.6, Y: 0}, {X: -0.6, Y: 0.3}, {X: -0.6, Y: 0.3}},
+		}
+		for id, path := range want {
+			if !slices.Equal(body.Trajectories[id], path) {
+				t.Errorf("point %d: path %v, want %v", id, body.Trajectories[id], path)
+			}
+		}
+		if wantBounds := (TrajectoryBounds{MinX: -0.6, MinY: 0, MaxX: 0.2, MaxY: 0.3}); body.Bounds != wantBounds {
+			t.Errorf("bounds %+v, want %+v", body.Bounds, wantBounds)
+		}
+	})
+
+	t.Run("default plane", func(t *testing.T) {
+		rec := serve(t, http.MethodPost, "/trajectory", TrajectoryRequest{Frames: frames, ControlPoints: points}, nil)
+		body := decodeBody[TrajectoryResponse](t, rec)
+		if body.Plane != "xy" || body.Trajectories[7][1] != (TrajectoryPoint{X: -0.6, Y: 1.4}) {
+			t.Errorf("plane %q, hand at %v in frame 1; want xy and (-0.6, 1.4)", body.Plane, body.Trajectories[7][1])
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, req := range map[string]TrajectoryRequest{
+			"no frames":      {ControlPoints: points},
+			"no points":      {Frames: frames},
+			"short position": {Frames: frames, ControlPoints: []ControlPoint{{ID: 0, Position: []float64{0, 1}}}},
+			"repeated axis":  {Frames: frames, ControlPoints: points, Plane: "xx"},
+			"unknown axis":   {Frames: frames, ControlPoints: points, Plane: "xw"},
+			"three axes":     {Frames: frames, ControlPoints: points, Plane: "xyz"},
+		} {
+			if rec := serve(t, http.MethodPost, "/trajectory", req, nil); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: status %d, want 400", name, rec.Code)
+			}
+		}
+	})
+}
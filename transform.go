@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// Input struct for the /transform/timestretch endpoint
+type TimeStretchRequest struct {
+	Frames         ResponsePayload `json:"frames"`
+	FPS            float64         `json:"fps"`
+	TargetFPS      float64         `json:"target_fps,omitempty"`
+	TargetDuration float64         `json:"target_duration,omitempty"`
+	Speed          float64         `json:"speed,omitempty"`
+	Interpolation  string          `json:"interpolation,omitempty"`
+	AntiJitter     bool            `json:"anti_jitter,omitempty"`
+	Loop           bool            `json:"loop,omitempty"`
+}
+
+// Timeline information for a resampled clip
+type TimeStretchMeta struct {
+	FPS              float64 `json:"fps"`
+	Duration         float64 `json:"duration"`
+	FrameCount       int     `json:"frame_count"`
+	SourceFrameCount int     `json:"source_frame_count"`
+}
+
+type TimeStretchResponse struct {
+	Frames ResponsePayload `json:"frames"`
+	Meta   TimeStretchMeta `json:"meta"`
+}
+
+// Handler for the /transform/timestretch endpoint
+func timeStretch(w http.ResponseWriter, r *http.Request) {
+	var req TimeStretchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid JSON payload"))
+		return
+	}
+
+	if len(req.Frames) == 0 || req.FPS <= 0 {
+		writeError(w, newAPIError(http.StatusBadRequest, "Missing frames or invalid fps"))
+		return
+	}
+	if req.Interpolation != "" && req.Interpolation != "linear" && req.Interpolation != "cubic" {
+		writeError(w, newAPIError(http.StatusBadRequest, "Invalid interpolation, expected linear or cubic"))
+		return
+	}
+
+	// Exactly one of target_fps, target_duration or speed picks the new timeline
+	targets := 0
+	for _, v := range []float64{req.TargetFPS, req.TargetDuration, req.Speed} {
+		if v < 0 {
+			writeError(w, newAPIError(http.StatusBadRequest, "target_fps, target_duration and speed must be positive"))
+			return
+		}
+		if v > 0 {
+			targets++
+		}
+	}
+	if targets != 1 {
+		writeError(w, newAPIError(http.StatusBadRequest, "Specify exactly one of target_fps, target_duration or speed"))
+		return
+	}
+
+	sourceCount := len(req.Frames)
+	duration := float64(sourceCount) / req.FPS
+	fps := req.FPS
+	switch {
+	case req.TargetFPS > 0:
+		fps = req.TargetFPS
+	case req.TargetDuration > 0:
+		duration = req.TargetDuration
+	case req.Speed > 0:
+		duration = duration / req.Speed
+	}
+
+	// Capped before the conversion so a vast timeline still fails the check
+	// below rather than overflowing
+	targetCount := int(min(max(math.Round(duration*fps), 1), math.MaxInt32))
+	ids := frameIDs(req.Frames)
+	if err := checkFramePoints(targetCount, max(len(ids), 1)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	frames := resampleFrames(req.Frames, targetCount, req.Interpolation, req.AntiJitter, req.Loop)
+	if targetCount != sourceCount {
+		// Interpolation leaves float noise, dropped at a precision finer
+		// than any the clips are sent with
+		places := make(map[int]int, len(ids))
+		for _, id := range ids {
+			places[id] = 6
+		}
+		frames = roundFrames(frames, places)
+	}
+	resp := TimeStretchResponse{
+		Frames: frames,
+		Meta: TimeStretchMeta{
+			FPS:              fps,
+			Duration:         float64(targetCount) / fps,
+			FrameCount:       targetCount,
+			SourceFrameCount: sourceCount,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
+
+// resampleFrames resamples every control point trajectory onto a timeline of
+// targetCount frames. Non-looping clips keep their first and last frames
+// pinned; looping clips are treated as periodic so the seam stays closed.
+// Resampling onto the identical timeline returns the input unchanged.
+func resampleFrames(frames ResponsePayload, targetCount int, interpolation string, antiJitter bool, loop bool) ResponsePayload {
+	sourceCount := len(frames)
+	if sourceCount == targetCount {
+		return copyFrames(frames)
+	}
+
+	ids := frameIDs(frames)
+	tracks := make(map[int][]Deformation, len(ids))
+	for _, id := range ids {
+		track := make([]Deformation, sourceCount)
+		for i, frame := range frames {
+			track[i] = frame[id]
+		}
+		tracks[id] = track
+	}
+
+	// Source frames covered by a single target frame, used by the anti-jitter box filter
+	step := float64(sourceCount) / float64(targetCount)
+	if !loop && targetCount > 1 {
+		step = float64(sourceCount-1) / float64(targetCount-1)
+	}
+
+	result := make(ResponsePayload, targetCount)
+	for j := range result {
+		x := float64(j) * step
+		frame := make(map[int]Deformation, len(ids))
+		for _, id := range ids {
+			var d Deformation
+			if antiJitter && targetCount < sourceCount {
+				d = boxSample(tracks[id], x, step, loop)
+			} else if interpolation == "cubic" {
+				d = cubicSample(tracks[id], x, loop)
+			} else {
+				d = linearSample(tracks[id], x, loop)
+			}
+			frame[id] = d
+		}
+		result[j] = frame
+	}
+	return result
+}
+
+// trackAt returns the sample at index i, wrapping for loops and clamping otherwise
+func trackAt(track []Deformation, i int, loop bool) Deformation {
+	n := len(track)
+	if loop {
+		return track[((i%n)+n)%n]
+	}
+	if i < 0 {
+		return track[0]
+	}
+	if i >= n {
+		return track[n-1]
+	}
+	return track[i]
+}
+
+func linearSample(track []Deformation, x float64, loop bool) Deformation {
+	i := int(math.Floor(x))
+	t := x - float64(i)
+	a := trackAt(track, i, loop)
+	if t == 0 {
+		return a
+	}
+	b := trackAt(track, i+1, loop)
+	return lerpDelta(a, b, t)
+}
+
+// cubicSample evaluates a Catmull-Rom spline through the neighbouring samples
+func cubicSample(track []Deformation, x float64, loop bool) Deformation {
+	i := int(math.Floor(x))
+	t := x - float64(i)
+	p1 := trackAt(track, i, loop)
+	if t == 0 {
+		return p1
+	}
+	p0 := trackAt(track, i-1, loop)
+	p2 := trackAt(track, i+1, loop)
+	p3 := trackAt(track, i+2, loop)
+	catmullRom := func(v0, v1, v2, v3 float64) float64 {
+		return 0.5 * (2*v1 +
+			(-v0+v2)*t +
+			(2*v0-5*v1+4*v2-v3)*t*t +
+			(-v0+3*v1-3*v2+v3)*t*t*t)
+	}
+	return Deformation{
+		DeltaX: catmullRom(p0.DeltaX, p1.DeltaX, p2.DeltaX, p3.DeltaX),
+		DeltaY: catmullRom(p0.DeltaY, p1.DeltaY, p2.DeltaY, p3.DeltaY),
+		DeltaZ: catmullRom(p0.DeltaZ, p1.DeltaZ, p2.DeltaZ, p3.DeltaZ),
+	}
+}
+
+// boxSample averages the source frames within the window covered by one
+// target frame, suppressing jitter that plain decimation would alias
+func boxSample(track []Deformation, x, width float64, loop bool) Deformation {
+	lo := int(math.Ceil(x - width/2))
+	hi := int(math.Floor(x + width/2))
+	if !loop {
+		lo = max(lo, 0)
+		hi = min(hi, len(track)-1)
+	}
+	if hi < lo {
+		return linearSample(track, x, loop)
+	}
+	var sum Deformation
+	for i := lo; i <= hi; i++ {
+		sum = addDelta(sum, trackAt(track, i, loop))
+	}
+	return scaleDelta(sum, 1/float64(hi-lo+1))
+}
+
+// frameIDs returns the sorted set of control point IDs present in any frame
+func frameIDs(frames ResponsePayload) []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, frame := range frames {
+		for id := range frame {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func copyFrames(frames ResponsePayload) ResponsePayload {
+	result := make(ResponsePayload, len(frames))
+	for i, frame := range frames {
+		copied := make(map[int]Deformation, len(frame))
+		for id, d := range frame {
+			copied[id] = d
+		}
+		result[i] = copied
+	}
+	return result
+}
+
+func addDelta(a, b Deformation) Deformation {
+	return Deformation{DeltaX: a.DeltaX + b.DeltaX, DeltaY: a.DeltaY + b.DeltaY, DeltaZ: a.DeltaZ + b.DeltaZ}
+}
+
+func scaleDelta(d Deformation, s float64) Deformation {
+	return Deformation{DeltaX: d.DeltaX * s, DeltaY: d.DeltaY * s, DeltaZ: d.DeltaZ * s}
+}
+
+func lerpDelta(a, b Deformation, t float64) Deformation {
+	return Deformation{
+		DeltaX: a.DeltaX*(1-t) + b.DeltaX*t,
+		DeltaY: a.DeltaY*(1-t) + b.DeltaY*t,
+		DeltaZ: a.DeltaZ*(1-t) + b.DeltaZ*t,
+	}
+}
+
+// roundDelta rounds a delta component to two decimal places
+func roundDelta(v float64) float64 {
+	return roundTo(v, 2)
+}
+
+// roundTo rounds v to the given number of decimal places
+func roundTo(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	r := math.Round(v*scale) / scale
+	if r == 0 {
+		// Normalize negative zero so it never serializes as -0
+		return 0
+	}
+	return r
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// rampFrames is a clip of one point moving along x by step per frame
+func rampFrames(count int, step float64) ResponsePayload {
+	frames := make(ResponsePayload, count)
+	for f := range frames {
+		frames[f] = map[int]Deformation{0: {DeltaX: step * float64(f)}}
+	}
+	return frames
+}
+
+func TestResampleFrames(t *testing.T) {
+	// A loop of 0, 1, 2, 3 closes back onto 0 after frame 3
+	loop := ResponsePayload{{0: {DeltaX: 0}}, {0: {DeltaX: 1}}, {0: {DeltaX: 2}}, {0: {DeltaX: 3}}}
+	for _, tc := range []struct {
+		name          string
+		frames        ResponsePayload
+		count         int
+		interpolation string
+		antiJitter    bool
+		loop          bool
+		want          []float64
+	}{
+		{"same length", rampFrames(3, 1), 3, "", false, false, []float64{0, 1, 2}},
+		{"longer", rampFrames(3, 1), 5, "", false, false, []float64{0, 0.5, 1, 1.5, 2}},
+		{"shorter", rampFrames(5, 1), 3, "", false, false, []float64{0, 2, 4}},
+		// Catmull-Rom passes through the source frames and follows a line
+		// between them, except next to the ends, whose missing neighbours are
+		// clamped
+		{"cubic", rampFrames(4, 1), 7, "cubic", false, false, []float64{0, 0.4375, 1, 1.5, 2, 2.5625, 3}},
+		// Plain decimation would keep only the zeros
+		{"anti-jitter averages", ResponsePayload{{0: {DeltaX: 0}}, {0: {DeltaX: 1}}, {0: {DeltaX: 0}}, {0: {DeltaX: 1}}, {0: {DeltaX: 0}}}, 3, "", true, false, []float64{0.5, 2.0 / 3, 0.5}},
+		// A loop's last new frame sits half way between 3 and the wrapped 0
+		{"loop longer", loop, 8, "", false, true, []float64{0, 0.5, 1, 1.5, 2, 2.5, 3, 1.5}},
+		{"loop shorter", loop, 2, "", false, true, []float64{0, 2}},
+	} {
+		got := resampleFrames(tc.frames, tc.count, tc.interpolation, tc.antiJitter, tc.loop)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: %d frames, want %d", tc.name, len(got), len(tc.want))
+			continue
+		}
+		for f, want := range tc.want {
+			if math.Abs(got[f][0].DeltaX-want) > 1e-9 {
+				t.Errorf("%s: frame %d delta_x %v, want %v", tc.name, f, got[f][0].DeltaX, want)
+			}
+		}
+	}
+}
+
+func TestTimeStretchEndpoint(t *testing.T) {
+	setupServer(t, nil)
+	for _, tc := range []struct {
+		name       string
+		body       TimeStretchRequest
+		wantFrames int
+		wantFPS    float64
+	}{
+		{"target fps", TimeStretchRequest{Frames: rampFrames(10, 0.1), FPS: 10, TargetFPS: 20}, 20, 20},
+		{"target duration", TimeStretchRequest{Frames: rampFrames(10, 0.1), FPS: 10, TargetDuration: 0.5}, 5, 10},
+		{"speed", TimeStretchRequest{Frames: rampFrames(10, 0.1), FPS: 10, Speed: 0.5}, 20, 10},
+	} {
+		rec := serve(t, http.MethodPost, "/transform/timestretch", tc.body, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", tc.name, rec.Code, rec.Body)
+			continue
+		}
+		resp := decodeBody[TimeStretchResponse](t, rec)
+		if len(resp.Frames) != tc.wantFrames || resp.Meta.FrameCount != tc.wantFrames || resp.Meta.FPS != tc.wantFPS || resp.Meta.SourceFrameCount != 10 {
+			t.Errorf("%s: %d frames, meta %+v; want %d frames at %v fps", tc.name, len(resp.Frames), resp.Meta, tc.wantFrames, tc.wantFPS)
+		}
+		// Non-looping clips keep their ends
+		if last := resp.Frames[len(resp.Frames)-1][0].DeltaX; last != 0.9 {
+			t.Errorf("%s: last frame delta_x %v, want 0.9", tc.name, last)
+		}
+	}
+
+	// The identical timeline reproduces the input exactly, and a new one
+	// keeps its precision
+	precise := ResponsePayload{{0: {DeltaX: 0.1234, DeltaY: -0.56789012}}, {0: {DeltaX: 0.2468}}}
+	for _, tc := range []struct {
+		speed float64
+		want  ResponsePayload
+	}{
+		{1, precise},
+		{2.0 / 3, ResponsePayload{{0: {DeltaX: 0.1234, DeltaY: -0.56789}}, {0: {DeltaX: 0.1851, DeltaY: -0.283945}}, {0: {DeltaX: 0.2468}}}},
+	} {
+		rec := serve(t, http.MethodPost, "/transform/timestretch", TimeStretchRequest{Frames: precise, FPS: 10, Speed: tc.speed}, nil)
+		got := decodeBody[TimeStretchResponse](t, rec).Frames
+		if rec.Code != http.StatusOK || !slices.EqualFunc(got, tc.want, maps.Equal) {
+			t.Errorf("speed %v: status %d, frames %v; want %v", tc.speed, rec.Code, got, tc.want)
+		}
+	}
+
+	for _, body := range []TimeStretchRequest{
+		{Frames: rampFrames(4, 1), FPS: 10},
+		{Frames: rampFrames(4, 1), FPS: 10, TargetFPS: 20, Speed: 2},
+		{Frames: rampFrames(4, 1), FPS: 10, Speed: -1},
+		{Frames: rampFrames(4, 1), FPS: 0, Speed: 2},
+		{Frames: rampFrames(4, 1), FPS: 10, Speed: 2, Interpolation: "nearest"},
+	} {
+		if rec := serve(t, http.MethodPost, "/transform/timestretch", body, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%+v: status %d, want 400", body, rec.Code)
+		}
+	}
+	// Timelines too long to hold are refused before any is built
+	for _, body := range []TimeStretchRequest{
+		{Frames: rampFrames(2, 1), FPS: 10, Speed: 1e-6},
+		{Frames: rampFrames(2, 1), FPS: 10, TargetDuration: 1e12},
+		{Frames: rampFrames(2, 1), FPS: 10, TargetFPS: 1e300},
+		{Frames: ResponsePayload{{}, {}}, FPS: 10, Speed: 1e-300},
+	} {
+		rec := serve(t, http.MethodPost, "/transform/timestretch", body, nil)
+		if rec.Code != http.StatusBadRequest || decodeBody[errorResponse](t, rec).Error.Code != "too_many_frame_points" {
+			t.Errorf("target_fps %v, target_duration %v, speed %v: status %d, want 400 too_many_frame_points", body.TargetFPS, body.TargetDuration, body.Speed, rec.Code)
+		}
+	}
+}
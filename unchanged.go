@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+)
+
+func validateUnchangedPoints(mode string) error {
+	switch mode {
+	case "", "include_zero", "omit":
+		return nil
+	}
+	return fmt.Errorf("invalid unchanged_points %q, expected include_zero or omit", mode)
+}
+
+// backfillPoints gives every frame a delta for every control point. A point
+// the model left out of a frame holds its delta from the frame before, or
+// stays at rest until it first appears. The IDs of the points that needed
+// filling in are returned.
+func backfillPoints(frames ResponsePayload, ids []int) (ResponsePayload, []int) {
+	var filled []int
+	for _, id := range ids {
+		var last Deformation
+		missing := false
+		for _, frame := range frames {
+			if d, ok := frame[id]; ok {
+				last = d
+				continue
+			}
+			frame[id] = last
+			missing = true
+		}
+		if missing {
+			filled = append(filled, id)
+		}
+	}
+	return frames, filled
+}
+
+// omitUnchanged returns a copy of the frames without the points whose
+// rounded delta is exactly zero, for unchanged_points omit
+func omitUnchanged(frames ResponsePayload) ResponsePayload {
+	omitted := make(ResponsePayload, len(frames))
+	for i, frame := range frames {
+		omitted[i] = maps.Clone(frame)
+		maps.DeleteFunc(omitted[i], func(_ int, d Deformation) bool { return d == Deformation{} })
+	}
+	return omitted
+}
+
+// unchangedPoints returns the sorted IDs of the points omit mode leaves out
+// of every frame, so clients can tell points that never moved from points
+// that were forgotten
+func unchangedPoints(frames ResponsePayload, ids []int) []int {
+	var unchanged []int
+	for _, id := range ids {
+		moved := false
+		for _, frame := range frames {
+			if d, ok := frame[id]; ok && d != (Deformation{}) {
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			unchanged = append(unchanged, id)
+		}
+	}
+	return unchanged
+}
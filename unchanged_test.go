@@ -0,0 +1,122 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestBackfillPoints(t *testing.T) {
+	frames := ResponsePayload{
+		{0: {DeltaX: 1}},
+		{},
+		{0: {DeltaX: 3}, 1: {DeltaY: 2}},
+		{2: {DeltaZ: 1}},
+	}
+	frames, filled := backfillPoints(frames, []int{0, 1, 2})
+	if !slices.Equal(filled, []int{0, 1, 2}) {
+		t.Errorf("filled %v, want [0 1 2]", filled)
+	}
+	want := ResponsePayload{
+		// Points stay at rest until they first appear, then hold their delta
+		{0: {DeltaX: 1}, 1: {}, 2: {}},
+		{0: {DeltaX: 1}, 1: {}, 2: {}},
+		{0: {DeltaX: 3}, 1: {DeltaY: 2}, 2: {}},
+		{0: {DeltaX: 3}, 1: {DeltaY: 2}, 2: {DeltaZ: 1}},
+	}
+	for i := range want {
+		if !maps.Equal(frames[i], want[i]) {
+			t.Errorf("frame %d: %v, want %v", i, frames[i], want[i])
+		}
+	}
+
+	if _, filled := backfillPoints(want, []int{0, 1, 2}); len(filled) != 0 {
+		t.Errorf("complete frames reported %v as filled", filled)
+	}
+}
+
+func TestUnchangedPoints(t *testing.T) {
+	fake := setupServer(t, nil)
+	// Only the right hand moves, and the model forgets the left hand in odd
+	// frames
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		return framesResponse(input.Length, func(f int) map[string]Position {
+			frame := make(map[string]Position, len(input.ControlPoints))
+			for _, cp := range input.ControlPoints {
+				if cp.ID == 1 && f%2 == 1 {
+					continue
+				}
+				p := Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+				if cp.ID == 2 {
+					p.Y += 0.04 * float64(f)
+				}
+				frame[strconv.Itoa(cp.ID)] = p
+			}
+			return frame
+		}), nil
+	}
+	type response struct {
+		Frames []map[int]Deformation `json:"frames"`
+		Meta   generationMeta        `json:"meta"`
+	}
+	send := func(mode string) response {
+		t.Helper()
+		payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "raise the right hand", Length: 4, UnchangedPoints: mode}}
+		rec := serve(t, http.MethodPost, "/generate-deformations?include_meta=true", payload, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unchanged_points %q: status %d: %s", mode, rec.Code, rec.Body)
+		}
+		return decodeBody[response](t, rec)
+	}
+
+	t.Run("include_zero", func(t *testing.T) {
+		body := send("include_zero")
+		for i, frame := range body.Frames {
+			if got := slices.Sorted(maps.Keys(frame)); !slices.Equal(got, []int{0, 1, 2, 3, 4}) {
+				t.Errorf("frame %d holds points %v, want all five", i, got)
+			}
+		}
+		if !slices.ContainsFunc(body.Meta.Warnings, func(w string) bool { return strings.Contains(w, "control points [1] out of some frames") }) {
+			t.Errorf("warnings %q do not mention the backfilled point", body.Meta.Warnings)
+		}
+		if body.Meta.UnchangedPoints != nil {
+			t.Errorf("unchanged_points %v reported without omit", body.Meta.UnchangedPoints)
+		}
+	})
+
+	t.Run("omit", func(t *testing.T) {
+		body := send("omit")
+		if len(body.Frames[0]) != 0 {
+			t.Errorf("frame 0 holds %v, want nothing as no point has moved yet", body.Frames[0])
+		}
+		for i, frame := range body.Frames[1:] {
+			if got := slices.Sorted(maps.Keys(frame)); !slices.Equal(got, []int{2}) {
+				t.Errorf("frame %d holds points %v, want only the moving point", i+1, got)
+			}
+		}
+		if !slices.Equal(body.Meta.UnchangedPoints, []int{0, 1, 3, 4}) {
+			t.Errorf("unchanged_points %v, want [0 1 3 4]", body.Meta.UnchangedPoints)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, payload := range map[string]RequestPayload{
+			"unknown mode": {RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4, UnchangedPoints: "drop"}},
+			"with sparse":  {RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4, UnchangedPoints: "omit", Encoding: "sparse"}},
+		} {
+			if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: status %d, want 400", name, rec.Code)
+			}
+		}
+	})
+}
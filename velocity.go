@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+)
+
+// Digits velocities carry beyond the offsets' precision, so integrating them
+// lands well within rounding distance of the original offsets
+const velocityExtraPlaces = 4
+
+func validateOutputUnits(units string, fps float64, coords string) error {
+	switch units {
+	case "", "per_frame":
+		return nil
+	case "per_second":
+		if fps < 1 {
+			return fmt.Errorf("output_units per_second requires an fps of at least 1")
+		}
+		if coords == "spherical" {
+			return fmt.Errorf("output_units per_second requires cartesian output_coords")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid output_units %q, expected per_frame or per_second", units)
+}
+
+// offsetsToVelocities converts rounded per-frame offsets from rest into
+// velocities between consecutive frames
+func offsetsToVelocities(frames ResponsePayload, places map[int]int, fps float64) velocityPayload {
+	precision := 0
+	for _, p := range places {
+		precision = max(precision, p)
+	}
+	v := velocityPayload{Units: "per_second", FPS: fps, Precision: precision, InitialPose: map[int]Deformation{}}
+	if len(frames) == 0 {
+		return v
+	}
+	v.InitialPose = maps.Clone(frames[0])
+	velocityPlaces := make(map[int]int)
+	v.Frames = make(ResponsePayload, len(frames))
+	for i, frame := range frames {
+		v.Frames[i] = make(map[int]Deformation, len(frame))
+		for id, d := range frame {
+			velocityPlaces[id] = precision + velocityExtraPlaces
+			if i > 0 {
+				d = scaleDelta(addDelta(d, scaleDelta(frames[i-1][id], -1)), fps)
+			} else {
+				d = Deformation{}
+			}
+			v.Frames[i][id] = d
+		}
+	}
+	v.Frames = roundFrames(v.Frames, velocityPlaces)
+	return v
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"maps"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestVelocityRoundTrip(t *testing.T) {
+	frames := ResponsePayload{
+		{0: {DeltaX: 0.01}, 1: {}},
+		{0: {DeltaX: 0.05, DeltaY: -0.02}, 1: {DeltaZ: 0.0003}},
+		{0: {DeltaX: 0.06, DeltaY: -0.07}, 1: {DeltaZ: 0.0004}},
+		{0: {DeltaX: 0.03, DeltaY: 0.11}, 1: {DeltaZ: 0.0001}},
+	}
+	places := map[int]int{0: 2, 1: 4}
+	v := offsetsToVelocities(frames, places, 24)
+	if v.Units != "per_second" || v.FPS != 24 || v.Precision != 4 {
+		t.Errorf("header %s at %v fps with precision %d, want per_second at 24 with 4", v.Units, v.FPS, v.Precision)
+	}
+	if !maps.Equal(v.InitialPose, frames[0]) {
+		t.Errorf("initial pose %v, want frame 0 %v", v.InitialPose, frames[0])
+	}
+	if !maps.Equal(v.Frames[0], map[int]Deformation{0: {}, 1: {}}) {
+		t.Errorf("frame 0 velocities %v, want zero", v.Frames[0])
+	}
+	// 0.04 over one frame at 24 fps
+	if got := v.Frames[1][0].DeltaX; math.Abs(got-0.96) > 1e-9 {
+		t.Errorf("frame 1 velocity %v, want 0.96 per second", got)
+	}
+
+	back := api.VelocitiesToOffsets(v)
+	for i := range frames {
+		if !maps.Equal(back[i], frames[i]) {
+			t.Errorf("frame %d integrates back to %v, want %v", i, back[i], frames[i])
+		}
+	}
+}
+
+func TestPerSecondOutput(t *testing.T) {
+	fake := setupServer(t, nil)
+	fake.respond = raiseResponse
+	payload := func(units string, fps float64) RequestPayload {
+		return RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "raise the right hand", Length: 4, OutputUnits: units, FPS: fps}}
+	}
+
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload("per_second", 30), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	body := decodeBody[velocityPayload](t, rec)
+	// The hand rises 0.04 a frame, 1.2 a second at 30 fps
+	for i, frame := range body.Frames[1:] {
+		if got := frame[2].DeltaY; math.Abs(got-1.2) > 1e-9 {
+			t.Errorf("frame %d: hand velocity %v, want 1.2", i+1, got)
+		}
+	}
+	if body.Frames[1][0] != (Deformation{}) {
+		t.Errorf("resting head has velocity %v", body.Frames[1][0])
+	}
+	if got := api.VelocitiesToOffsets(body)[3][2].DeltaY; got != 0.12 {
+		t.Errorf("integrated hand offset %v in frame 3, want 0.12", got)
+	}
+
+	for name, tc := range map[string]struct {
+		payload RequestPayload
+		target  string
+	}{
+		"unknown units": {payload("per_minute", 30), "/generate-deformations"},
+		"no fps":        {payload("per_second", 0), "/generate-deformations"},
+		"csv":           {payload("per_second", 30), "/generate-deformations?format=csv"},
+	} {
+		if rec := serve(t, http.MethodPost, tc.target, tc.payload, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response schema versions, selected with the X-API-Version header. Version
+// 1 is the original shape and must never change.
+const defaultAPIVersion = 1
+
+// Which optional parts of a response the client asked for
+type responseOptions struct {
+	IncludeIDMap bool
+	IncludeMeta  bool
+}
+
+// responseEncoder shapes a generation into the response body of one schema
+// version
+type responseEncoder func(frames any, result *generationResult, meta *generationMeta, opts responseOptions) any
+
+var responseEncoders = map[int]responseEncoder{
+	1: encodeResponseV1,
+	2: encodeResponseV2,
+}
+
+func supportedAPIVersions() []int {
+	return sortedKeys(responseEncoders)
+}
+
+// resolveAPIVersion parses X-API-Version, defaulting to version 1
+func resolveAPIVersion(header string) (int, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return defaultAPIVersion, nil
+	}
+	version, err := strconv.Atoi(header)
+	if _, ok := responseEncoders[version]; err != nil || !ok {
+		return 0, newAPIError(http.StatusBadRequest, "Unsupported API version %q", header).
+			withCode("unsupported_api_version").
+			withDetails(map[string]any{"supported_versions": supportedAPIVersions()})
+	}
+	return version, nil
+}
+
+// encodeResponseV1 returns the bare frames, or an envelope carrying only the
+// parts requested with include_id_map and include_meta plus any root track
+func encodeResponseV1(frames any, result *generationResult, meta *generationMeta, opts responseOptions) any {
+	if !opts.IncludeIDMap && !opts.IncludeMeta && result.Root == nil {
+		return frames
+	}
+	envelope := ResponseEnvelope{Frames: frames, Root: result.Root}
+	if opts.IncludeIDMap {
+		envelope.IDMap = result.IDMap
+	}
+	if opts.IncludeMeta {
+		envelope.Meta = meta
+	}
+	return envelope
+}
+
+// Version 2 response: always an envelope with metadata and warnings
+type ResponseEnvelopeV2 struct {
+	Frames   any             `json:"frames"`
+	Root     []RootTransform `json:"root,omitempty"`
+	IDMap    map[int]int     `json:"id_map,omitempty"`
+	Meta     *generationMeta `json:"meta"`
+	Warnings []string        `json:"warnings"`
+}
+
+func encodeResponseV2(frames any, result *generationResult, meta *generationMeta, opts responseOptions) any {
+	envelope := ResponseEnvelopeV2{Frames: frames, Root: result.Root, Warnings: []string{}}
+	if opts.IncludeIDMap {
+		envelope.IDMap = result.IDMap
+	}
+	// Warnings move out of meta to the top level
+	trimmed := *meta
+	trimmed.Warnings = nil
+	envelope.Meta = &trimmed
+	if len(meta.Warnings) > 0 {
+		envelope.Warnings = meta.Warnings
+	}
+	return envelope
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+func TestAPIVersions(t *testing.T) {
+	setupServer(t, nil)
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "sway gently", Length: 4}}
+
+	// Version 2 always has an envelope, with warnings at the top level
+	rec := serve(t, http.MethodPost, "/generate-deformations", payload, http.Header{"X-Api-Version": {"2"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("version 2: status %d: %s", rec.Code, rec.Body)
+	}
+	var v2 map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &v2); err != nil {
+		t.Fatalf("version 2 body is not an object: %s", rec.Body)
+	}
+	for _, field := range []string{"frames", "meta", "warnings"} {
+		if _, ok := v2[field]; !ok {
+			t.Errorf("version 2 body lacks %s", field)
+		}
+	}
+	var meta map[string]any
+	json.Unmarshal(v2["meta"], &meta)
+	if _, ok := meta["warnings"]; ok {
+		t.Error("version 2 meta still carries the warnings")
+	}
+
+	for _, version := range []string{"3", "0", "v1", "1.0"} {
+		rec := serve(t, http.MethodPost, "/generate-deformations", payload, http.Header{"X-Api-Version": {version}})
+		body := decodeBody[errorResponse](t, rec)
+		if rec.Code != http.StatusBadRequest || body.Error.Code != "unsupported_api_version" {
+			t.Errorf("version %q: status %d, code %q; want 400 unsupported_api_version", version, rec.Code, body.Error.Code)
+			continue
+		}
+		details, _ := body.Error.Details.(map[string]any)
+		if supported, _ := details["supported_versions"].([]any); len(supported) != 2 {
+			t.Errorf("version %q: details %v, want the supported versions", version, details)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+)
+
+// Published prices in USD per million tokens, used to pick the cheapest
+// allowed model for the warm-up and to estimate its cost
+var modelPrices = map[string]struct{ Input, Output float64 }{
+	"gpt-4.1":      {2.00, 8.00},
+	"gpt-4.1-mini": {0.40, 1.60},
+	"gpt-4.1-nano": {0.10, 0.40},
+	"gpt-4o":       {2.50, 10.00},
+	"gpt-4o-mini":  {0.15, 0.60},
+}
+
+// cheapestModel returns the allowed model with the lowest known price.
+// Models without a price are only used when nothing else is allowed.
+func cheapestModel(allowed []string) string {
+	best, bestPrice := "", math.Inf(1)
+	for _, model := range allowed {
+		price, ok := modelPrices[model]
+		if !ok {
+			if best == "" {
+				best = model
+			}
+			continue
+		}
+		if total := price.Input + price.Output; total < bestPrice {
+			best, bestPrice = model, total
+		}
+	}
+	return best
+}
+
+// estimateCost returns the approximate USD cost of the given usage, or 0 when
+// the model's price is unknown
+func estimateCost(model string, usage usageReport) float64 {
+	price := modelPrices[model]
+	return (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1e6
+}
+
+// Outcome of a warm-up generation
+type WarmupResult struct {
+	OK               bool        `json:"ok"`
+	Model            string      `json:"model"`
+	DurationMS       float64     `json:"duration_ms"`
+	Usage            usageReport `json:"usage"`
+	EstimatedCostUSD float64     `json:"estimated_cost_usd"`
+	Error            string      `json:"error,omitempty"`
+	At               time.Time   `json:"at"`
+}
+
+// Readiness as seen by /readyz: a failed warm-up marks the server unready
+// until the next successful generation
+type readinessState struct {
+	mu         sync.Mutex
+	lastWarmup *WarmupResult
+	ready      bool
+}
+
+var readiness = &readinessState{ready: true}
+
+func (s *readinessState) recordWarmup(result WarmupResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastWarmup = &result
+	s.ready = result.OK
+}
+
+func (s *readinessState) recordSuccess() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready = true
+}
+
+func (s *readinessState) snapshot() (bool, *WarmupResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready, s.lastWarmup
+}
+
+// runWarmup sends a minimal request through the generation pipeline using
+// the cheapest allowed model and records the outcome for /readyz. It tests
+// the key and the model, so it passes when the upstream call and parse
+// succeed whatever motion comes back: a single frame at rest is a fine
+// answer to it.
+func runWarmup(ctx context.Context) WarmupResult {
+	ctx, cancel := context.WithTimeout(ctx, cfg.WarmupTimeout.Duration)
+	defer cancel()
+
+	payload := RequestPayload{RequestPayload: api.RequestPayload{
+		ControlPoints: []ControlPoint{
+			{ID: 0, Role: "head", Position: []float64{0, 1.7, 0}},
+			{ID: 1, Role: "root", Position: []float64{0, 0, 0}},
+		},
+		Prompt:             "nod the head once",
+		Length:             1,
+		Model:              cheapestModel(cfg.AllowedModels),
+		PromptLanguageMode: "off",
+	}, warmup: true}
+	start := time.Now()
+	generated, err := generate(ctx, payload)
+	result := WarmupResult{
+		OK:         err == nil,
+		Model:      payload.Model,
+		DurationMS: durationMS(time.Since(start)),
+		At:         start.UTC(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Usage = generated.Usage
+		result.EstimatedCostUSD = estimateCost(payload.Model, generated.Usage)
+	}
+	readiness.recordWarmup(result)
+
+	if result.OK {
+		log.Printf("Warm-up generation with %s succeeded in %.0fms using %d tokens (~$%.6f)",
+			result.Model, result.DurationMS, result.Usage.TotalTokens, result.EstimatedCostUSD)
+	} else {
+		log.Printf("WARN warm-up generation with %s failed after %.0fms: %s", result.Model, result.DurationMS, result.Error)
+	}
+	return result
+}
+
+// Handler for the /readyz endpoint
+func readyz(w http.ResponseWriter, r *http.Request) {
+	ready, warmup := readiness.snapshot()
+	status := http.StatusOK
+	body := map[string]any{"status": "ready"}
+	if !ready {
+		status = http.StatusServiceUnavailable
+		body["status"] = "not_ready"
+	}
+	if warmup != nil {
+		body["warmup"] = warmup
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Handler for the /admin/warmup endpoint
+func triggerWarmup(w http.ResponseWriter, r *http.Request) {
+	result := runWarmup(r.Context())
+	if !result.OK {
+		writeError(w, newAPIError(http.StatusServiceUnavailable, "Warm-up generation failed: %s", result.Error).
+			withCode("warmup_failed").
+			withDetails(result))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		writeError(w, newAPIError(http.StatusInternalServerError, "Failed to encode response"))
+		return
+	}
+}
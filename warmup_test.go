@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/Joshimello/descriptive-rigidity/api"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestWarmupReadiness(t *testing.T) {
+	fake := setupServer(t, func(c *Config) {
+		c.AdminAPIKey = "admin-secret"
+		c.AllowedModels = []string{"gpt-4.1", "gpt-4o-mini", "gpt-4.1-nano"}
+		c.DefaultModel = "gpt-4.1"
+		c.MaxRetries = 0
+	})
+	previous := readiness
+	readiness = &readinessState{ready: true}
+	t.Cleanup(func() { readiness = previous })
+	admin := http.Header{"X-Admin-Key": {"admin-secret"}}
+
+	type readyBody struct {
+		Status string        `json:"status"`
+		Warmup *WarmupResult `json:"warmup"`
+	}
+	expectReady := func(step string, status int, state string) readyBody {
+		t.Helper()
+		rec := serve(t, http.MethodGet, "/readyz", nil, nil)
+		body := decodeBody[readyBody](t, rec)
+		if rec.Code != status || body.Status != state {
+			t.Fatalf("%s: /readyz %d %q, want %d %q", step, rec.Code, body.Status, status, state)
+		}
+		return body
+	}
+
+	// Ready before any warm-up has run
+	if body := expectReady("before warm-up", http.StatusOK, "ready"); body.Warmup != nil {
+		t.Errorf("before warm-up: /readyz reports %+v", body.Warmup)
+	}
+	if rec := serve(t, http.MethodPost, "/admin/warmup", nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("warm-up without the admin key: status %d, want 401", rec.Code)
+	}
+
+	fake.respond = func(openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		return openai.ChatCompletionResponse{}, &openai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "model not found"}
+	}
+	rec := serve(t, http.MethodPost, "/admin/warmup", nil, admin)
+	if rec.Code != http.StatusServiceUnavailable || decodeBody[errorResponse](t, rec).Error.Code != "warmup_failed" {
+		t.Fatalf("failed warm-up: status %d: %s", rec.Code, rec.Body)
+	}
+	if body := expectReady("after a failed warm-up", http.StatusServiceUnavailable, "not_ready"); body.Warmup == nil || body.Warmup.OK || body.Warmup.Error == "" {
+		t.Errorf("after a failed warm-up: /readyz reports %+v", body.Warmup)
+	}
+	// The warm-up uses the cheapest allowed model
+	if got := fake.requests[0].Model; got != "gpt-4.1-nano" {
+		t.Errorf("warm-up used %s, want gpt-4.1-nano", got)
+	}
+
+	// Any successful generation makes the server ready again
+	fake.respond = swayResponse
+	payload := RequestPayload{RequestPayload: api.RequestPayload{ControlPoints: testRig(), Prompt: "wave", Length: 4}}
+	if rec := serve(t, http.MethodPost, "/generate-deformations", payload, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	expectReady("after a generation", http.StatusOK, "ready")
+
+	// A single frame at rest passes: the warm-up is judged on the call and
+	// the parse, not on the motion
+	fake.respond = func(req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+		input, err := modelInputOf(req)
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		return framesResponse(input.Length, func(int) map[string]Position {
+			frame := make(map[string]Position, len(input.ControlPoints))
+			for _, cp := range input.ControlPoints {
+				frame[strconv.Itoa(cp.ID)] = Position{X: cp.Position[0], Y: cp.Position[1], Z: cp.Position[2]}
+			}
+			return frame
+		}), nil
+	}
+	calls := fake.calls()
+	rec = serve(t, http.MethodPost, "/admin/warmup", nil, admin)
+	result := decodeBody[WarmupResult](t, rec)
+	if rec.Code != http.StatusOK || !result.OK || result.Model != "gpt-4.1-nano" || result.Usage.TotalTokens == 0 {
+		t.Fatalf("successful warm-up: status %d, result %+v", rec.Code, result)
+	}
+	if got := fake.calls() - calls; got != 1 {
+		t.Errorf("successful warm-up made %d upstream calls, want 1 without a static retry", got)
+	}
+	if body := expectReady("after a successful warm-up", http.StatusOK, "ready"); body.Warmup == nil || !body.Warmup.OK {
+		t.Errorf("after a successful warm-up: /readyz reports %+v", body.Warmup)
+	}
+}
+
+func TestCheapestModel(t *testing.T) {
+	for _, tc := range []struct {
+		allowed []string
+		want    string
+	}{
+		{[]string{"gpt-4o", "gpt-4.1-mini", "gpt-4o-mini"}, "gpt-4o-mini"},
+		// Unpriced models are a last resort
+		{[]string{"my-finetune", "gpt-4.1"}, "gpt-4.1"},
+		{[]string{"my-finetune", "other-finetune"}, "my-finetune"},
+	} {
+		if got := cheapestModel(tc.allowed); got != tc.want {
+			t.Errorf("cheapestModel(%v) = %q, want %q", tc.allowed, got, tc.want)
+		}
+	}
+}
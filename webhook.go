@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Client for callback deliveries; each attempt is bounded by
+// webhook_timeout. It connects directly, never through a proxy, and checks
+// every address it dials, so a callback host that resolved to a public
+// address when the job was submitted cannot be rebound to an internal one
+// by the time it is called, nor redirect there.
+var webhookClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: checkCallbackDial}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// validateCallbackURL checks a job's callback_url before the job is created,
+// resolving its host so that URLs pointing into the server's own network
+// are refused up front
+func validateCallbackURL(ctx context.Context, raw string) error {
+	if cfg.WebhookSecret == "" {
+		return fmt.Errorf("callback_url is not enabled on this server")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("callback_url must be an absolute http or https URL")
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("callback_url host %q does not resolve", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !callbackAddressAllowed(addr) {
+			return fmt.Errorf("callback_url host %q resolves to %s, which is not a public address", u.Hostname(), addr.Unmap())
+		}
+	}
+	return nil
+}
+
+// callbackAddressAllowed reports whether callbacks may be sent to addr: any
+// public address, or any at all with webhook_allow_private
+func callbackAddressAllowed(addr netip.Addr) bool {
+	if cfg.WebhookAllowPrivate {
+		return true
+	}
+	addr = addr.Unmap()
+	return !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsUnspecified() &&
+		!addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() && !addr.IsMulticast()
+}
+
+// checkCallbackDial refuses a callback connection to an address that is not
+// allowed, after DNS resolution and just before connecting
+func checkCallbackDial(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("callback address %q: %w", address, err)
+	}
+	if !callbackAddressAllowed(addrPort.Addr()) {
+		return fmt.Errorf("callback address %s is not a public address", addrPort.Addr().Unmap())
+	}
+	return nil
+}
+
+// signWebhook returns the signature of a callback body sent at timestamp:
+// the hex HMAC-SHA256, keyed with the webhook secret, of the timestamp, a
+// dot and the body
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverCallback POSTs a finished job to its callback URL, retrying with
+// exponential backoff until a 2xx response or webhook_max_attempts, or until
+// the server shuts down. Every attempt is signed afresh, so a retry carries
+// its own timestamp.
+func deliverCallback(ctx context.Context, job Job) {
+	id, target := job.ID, job.callbackURL
+	job.Callback = nil
+	body, err := json.Marshal(job)
+	if err != nil {
+		log.Printf("Failed to encode callback for job %s: %v", id, err)
+		return
+	}
+
+	backoff := cfg.WebhookRetryBackoff.Duration
+	for attempt := 1; attempt <= cfg.WebhookMaxAttempts; attempt++ {
+		err := postCallback(ctx, target, id, body)
+		// Copies of the job share the pointer, so swap in a new one rather
+		// than editing it
+		state := jobCallback{Status: "pending", Attempts: attempt}
+		switch {
+		case err == nil:
+			state.Status = "delivered"
+		case attempt == cfg.WebhookMaxAttempts:
+			state.Status = "failed"
+			state.LastError = err.Error()
+		default:
+			state.LastError = err.Error()
+		}
+		jobs.update(id, func(j *Job) { j.Callback = &state })
+		if err == nil {
+			incCounter("job_callbacks_total", "outcome", "delivered", 1)
+			return
+		}
+		log.Printf("Callback for job %s failed (attempt %d of %d): %v", id, attempt, cfg.WebhookMaxAttempts, err)
+		if attempt < cfg.WebhookMaxAttempts {
+			if sleepContext(ctx, backoff) != nil {
+				log.Printf("Gave up on the callback for job %s: the server is shutting down", id)
+				jobs.update(id, func(j *Job) {
+					j.Callback = &jobCallback{Status: "failed", Attempts: attempt, LastError: "server shut down"}
+				})
+				break
+			}
+			backoff *= 2
+		}
+	}
+	incCounter("job_callbacks_total", "outcome", "failed", 1)
+}
+
+func postCallback(ctx context.Context, target, id string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "descriptive-rigidity-webhook")
+	req.Header.Set("X-Job-ID", id)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", signWebhook(cfg.WebhookSecret, timestamp, body))
+
+	client := *webhookClient
+	client.Timeout = cfg.WebhookTimeout.Duration
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCallbackDeliverySigned(t *testing.T) {
+	setupServer(t, func(c *Config) {
+		c.WebhookSecret = "hook-secret"
+		c.WebhookAllowPrivate = true
+		c.WebhookRetryBackoff = Duration{time.Millisecond}
+	})
+
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	var mu sync.Mutex
+	var deliveries []delivery
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		deliveries = append(deliveries, delivery{r.Header.Clone(), body})
+		first := len(deliveries) == 1
+		mu.Unlock()
+		// Fail the first attempt to exercise the retry
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	rec := serve(t, http.MethodPost, "/jobs", map[string]any{
+		"control_points": testRig(),
+		"prompt":         "sway gently",
+		"length":         4,
+		"callback_url":   receiver.URL + "/hook",
+	}, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", rec.Code, rec.Body)
+	}
+	id := decodeBody[Job](t, rec).ID
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, _ := jobs.get(id)
+		if job.Callback != nil && job.Callback.Status == "delivered" {
+			if job.Callback.Attempts != 2 {
+				t.Errorf("delivered after %d attempts, want 2", job.Callback.Attempts)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("callback not delivered: %+v", job.Callback)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, d := range deliveries {
+		timestamp, err := strconv.ParseInt(d.header.Get("X-Webhook-Timestamp"), 10, 64)
+		if err != nil {
+			t.Fatalf("delivery %d: bad timestamp %q", i, d.header.Get("X-Webhook-Timestamp"))
+		}
+		if got, want := d.header.Get("X-Webhook-Signature"), signWebhook("hook-secret", timestamp, d.body); got != want {
+			t.Errorf("delivery %d: signature %q, want %q", i, got, want)
+		}
+		if d.header.Get("X-Job-ID") != id {
+			t.Errorf("delivery %d: X-Job-ID %q, want %q", i, d.header.Get("X-Job-ID"), id)
+		}
+		if !strings.Contains(string(d.body), `"status":"done"`) {
+			t.Errorf("delivery %d: body is not the finished job: %s", i, d.body)
+		}
+	}
+}
+
+func TestCallbackURLRejectsInternalAddresses(t *testing.T) {
+	setupServer(t, func(c *Config) { c.WebhookSecret = "hook-secret" })
+	for _, target := range []string{
+		"http://127.0.0.1:9000/hook",
+		"http://localhost/hook",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[fe80::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"ftp://example.com/hook",
+	} {
+		if err := validateCallbackURL(context.Background(), target); err == nil {
+			t.Errorf("%s: accepted", target)
+		}
+	}
+	if err := validateCallbackURL(context.Background(), "https://93.184.215.14/hook"); err != nil {
+		t.Errorf("public address refused: %v", err)
+	}
+}
+
+func TestCallbackDialRefusesInternalAddresses(t *testing.T) {
+	setupServer(t, func(c *Config) { c.WebhookSecret = "hook-secret" })
+	var called bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	defer receiver.Close()
+
+	// As if the host had resolved to a public address when the job was
+	// submitted and was rebound to loopback since
+	err := postCallback(context.Background(), receiver.URL, "job", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "not a public address") {
+		t.Errorf("dial to loopback: err %v, want a refusal", err)
+	}
+	if called {
+		t.Error("the receiver was reached")
+	}
+}
+
+func TestCallbackRetriesStopOnShutdown(t *testing.T) {
+	setupServer(t, func(c *Config) {
+		c.WebhookSecret = "hook-secret"
+		c.WebhookAllowPrivate = true
+		c.WebhookRetryBackoff = Duration{time.Hour}
+	})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	job := jobs.create(receiver.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		deliverCallback(ctx, job)
+		close(done)
+	}()
+	for {
+		if j, _ := jobs.get(job.ID); j.Callback != nil && j.Callback.Attempts == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery kept waiting to retry after shutdown")
+	}
+	if j, _ := jobs.get(job.ID); j.Callback.Status != "failed" {
+		t.Errorf("callback status %q after shutdown, want failed", j.Callback.Status)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal RFC 6455 server side: the upgrade handshake and unfragmented or
+// fragmented text messages, with ping/pong and close handled internally.
+// Extensions and subprotocols are not negotiated.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close status codes used by the server
+const (
+	closeNormal   = 1000
+	closeProtocol = 1002
+	closeTooBig   = 1009
+	closeInternal = 1011
+)
+
+// Largest message accepted from a client
+const maxWebSocketMessage = 1 << 20
+
+const websocketWriteTimeout = 10 * time.Second
+
+var errWebSocketClosed = errors.New("websocket closed")
+
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+	// Set once a close frame has been sent; nothing may follow it
+	closeSent bool
+	// How long to wait for the next frame, pongs included, before giving up
+	readTimeout time.Duration
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// originAllowed reports whether a handshake may proceed from the page that
+// started it. Browsers send any page's cookies and credentials with a
+// WebSocket handshake, so one from another site is refused unless its origin
+// is listed; clients other than browsers send no Origin at all.
+func originAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket performs the opening handshake and takes over the
+// connection. Errors are returned before anything is written, so the caller
+// can still answer with a normal HTTP error.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, readTimeout time.Duration, allowedOrigins []string) (*wsConn, error) {
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return nil, newAPIError(http.StatusUpgradeRequired, "This endpoint only accepts WebSocket connections").
+			withCode("websocket_required")
+	}
+	if !originAllowed(r, allowedOrigins) {
+		return nil, newAPIError(http.StatusForbidden, "WebSocket connections from origin %q are not allowed", r.Header.Get("Origin")).
+			withCode("origin_forbidden")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, newAPIError(http.StatusBadRequest, "Unsupported WebSocket version, expected 13")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, newAPIError(http.StatusBadRequest, "Missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, newAPIError(http.StatusInternalServerError, "WebSocket upgrade not supported")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, newAPIError(http.StatusInternalServerError, "WebSocket upgrade failed: %v", err)
+	}
+	// The server's read and write timeouts do not apply to a long-lived socket
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: rw.Reader, readTimeout: readTimeout}, nil
+}
+
+// readFrame reads one frame and unmasks its payload
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	var head [2]byte
+	if _, err = io.ReadFull(c.reader, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(closeProtocol, "reserved bits set")
+	}
+	if head[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(closeProtocol, "client frames must be masked")
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWebSocketMessage {
+		return false, 0, nil, c.fail(closeTooBig, "message too large")
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// readMessage returns the next data message, answering pings and closes
+// along the way. It returns errWebSocketClosed once the client closes.
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var (
+		message []byte
+		opcode  byte
+	)
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case opPing:
+			c.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return 0, nil, errWebSocketClosed
+		case opContinuation:
+			if opcode == 0 {
+				return 0, nil, c.fail(closeProtocol, "unexpected continuation frame")
+			}
+		case opText, opBinary:
+			if opcode != 0 {
+				return 0, nil, c.fail(closeProtocol, "expected continuation frame")
+			}
+			opcode = op
+		default:
+			return 0, nil, c.fail(closeProtocol, "unknown opcode")
+		}
+		message = append(message, payload...)
+		if len(message) > maxWebSocketMessage {
+			return 0, nil, c.fail(closeTooBig, "message too large")
+		}
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// writeFrame sends a single unmasked frame; safe for concurrent use
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return errWebSocketClosed
+	}
+	c.closeSent = opcode == opClose
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// close sends a close frame with the given status and closes the connection
+func (c *wsConn) close(code uint16, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	c.writeFrame(opClose, append(payload, reason...))
+	c.conn.Close()
+}
+
+// fail closes the connection for a protocol violation
+func (c *wsConn) fail(code uint16, reason string) error {
+	c.close(code, reason)
+	return fmt.Errorf("websocket: %s", reason)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startSessionServer sets the server up as setupServer does and serves it
+// over a real listener, as a hijacked WebSocket needs one
+func startSessionServer(t *testing.T, configure func(c *Config)) *httptest.Server {
+	t.Helper()
+	setupServer(t, configure)
+	// Cleanups run last first, so this waits for the sessions the test
+	// opened to end before the configuration they read is replaced
+	t.Cleanup(func() {
+		for sessions.size() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	srv := httptest.NewServer(newRouter())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dialSession opens a raw TCP connection to srv and sends a /ws handshake
+// with the given Origin, returning the connection and the server's response
+func dialSession(t *testing.T, srv *httptest.Server, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	handshake := "GET /ws HTTP/1.1\r\nHost: " + srv.Listener.Addr().String() + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	if origin != "" {
+		handshake += "Origin: " + origin + "\r\n"
+	}
+	if _, err := io.WriteString(conn, handshake+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, resp
+}
+
+// writeClientText sends a single masked text frame, as RFC 6455 requires of
+// clients
+func writeClientText(t *testing.T, conn net.Conn, payload []byte) {
+	t.Helper()
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame := []byte{0x80 | opText}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readServerText reads one unmasked text frame from the server
+func readServerText(t *testing.T, reader *bufio.Reader) []byte {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(reader, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[0] != 0x80|opText {
+		t.Fatalf("first byte %#x, want a final text frame", head[0])
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	length := int(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(reader, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(reader, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestWebSocketHandshakeAndFraming(t *testing.T) {
+	srv := startSessionServer(t, nil)
+
+	conn, reader, resp := dialSession(t, srv, "")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status %d, want 101", resp.StatusCode)
+	}
+	// The accept value of the key in RFC 6455's own example
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept %q", got)
+	}
+
+	bind, _ := json.Marshal(map[string]any{"op": "bind", "control_points": testRig()})
+	writeClientText(t, conn, bind)
+	var msg SessionMessage
+	if err := json.Unmarshal(readServerText(t, reader), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "bound" || msg.Points != len(testRig()) {
+		t.Errorf("reply %+v, want bound with %d points", msg, len(testRig()))
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	srv := startSessionServer(t, func(c *Config) {
+		c.WebSocketAllowedOrigins = []string{"https://editor.example.com"}
+	})
+
+	for _, tc := range []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://" + srv.Listener.Addr().String(), http.StatusSwitchingProtocols},
+		{"https://editor.example.com", http.StatusSwitchingProtocols},
+		{"https://EDITOR.example.com", http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	} {
+		_, _, resp := dialSession(t, srv, tc.origin)
+		if resp.StatusCode != tc.want {
+			t.Errorf("origin %q: status %d, want %d", tc.origin, resp.StatusCode, tc.want)
+			continue
+		}
+		if tc.want != http.StatusForbidden {
+			continue
+		}
+		var body errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Error.Code != "origin_forbidden" || !strings.Contains(body.Error.Message, tc.origin) {
+			t.Errorf("origin %q: error %+v, want origin_forbidden naming the origin", tc.origin, body.Error)
+		}
+	}
+}
+
+func TestWebSocketAnyOrigin(t *testing.T) {
+	srv := startSessionServer(t, func(c *Config) {
+		c.WebSocketAllowedOrigins = []string{"*"}
+	})
+	if _, _, resp := dialSession(t, srv, "https://evil.example.com"); resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("with *: status %d, want 101", resp.StatusCode)
+	}
+}